@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth/tracing"
+)
+
+// DefaultDeletionGracePeriod is how long a deleted account can be reactivated before it is purged
+const DefaultDeletionGracePeriod = 30 * 24 * time.Hour
+
+func (c Usecases) deletionGracePeriod() time.Duration {
+	if c.DeletionGracePeriod == 0 {
+		return DefaultDeletionGracePeriod
+	}
+	return c.DeletionGracePeriod
+}
+
+// DeactivateAccount deactivates the account of a user after checking their password. Deactivated users can't log in
+// and their sessions are revoked, until they reactivate the account with ReactivateAccount. It publishes a UserDeactivatedEvent
+func (c Usecases) DeactivateAccount(ctx context.Context, userID int, pwd string) (err error) {
+	defer c.observe("deactivate_account", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.DeactivateAccount")
+	defer tracing.EndSpan(span, &err)
+
+	user, err := c.reauthenticate(ctx, userID, pwd)
+	if err != nil {
+		return err
+	}
+	if !user.DeactivatedAt.IsZero() {
+		return fmt.Errorf("%w: account is already deactivated", ErrInvalidInput)
+	}
+	return c.deactivate(ctx, userID, time.Time{})
+}
+
+// DeleteAccount schedules the deletion of the account after checking the password of the user.
+// The account is deactivated until it is purged by PurgeDeletedAccounts after DeletionGracePeriod, and the deletion can be
+// cancelled with ReactivateAccount until then. It publishes a UserDeactivatedEvent with the DeleteAfter
+func (c Usecases) DeleteAccount(ctx context.Context, userID int, pwd string) (err error) {
+	defer c.observe("delete_account", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.DeleteAccount")
+	defer tracing.EndSpan(span, &err)
+
+	user, err := c.reauthenticate(ctx, userID, pwd)
+	if err != nil {
+		return err
+	}
+	if !user.DeleteAfter.IsZero() {
+		return fmt.Errorf("%w: account is already scheduled for deletion", ErrInvalidInput)
+	}
+	return c.deactivate(ctx, userID, c.now().Add(c.deletionGracePeriod()))
+}
+
+func (c Usecases) deactivate(ctx context.Context, userID int, deleteAfter time.Time) error {
+	now := c.now()
+	if err := c.Storage.SetDeactivation(ctx, userID, now, deleteAfter); err != nil {
+		return err
+	}
+	if err := c.Storage.RevokeSessions(ctx, userID); err != nil {
+		return err
+	}
+	return c.events().PublishUserDeactivatedEvent(ctx, UserDeactivatedEvent{
+		UserID:        userID,
+		DeactivatedAt: now,
+		DeleteAfter:   deleteAfter,
+	})
+}
+
+// ReactivateAccount reactivates a deactivated account and cancels its scheduled deletion.
+// The user can't log in while deactivated, so they are authenticated with their username and password like Login.
+// It publishes a UserReactivatedEvent, and returns ErrInvalidInput if the account is not deactivated
+func (c Usecases) ReactivateAccount(ctx context.Context, usnm, pwd string) (err error) {
+	defer c.observe("reactivate_account", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.ReactivateAccount")
+	defer tracing.EndSpan(span, &err)
+
+	user, err := c.authenticate(ctx, usnm, pwd)
+	if err != nil {
+		return err
+	}
+	if user.DeactivatedAt.IsZero() {
+		return fmt.Errorf("%w: account is not deactivated", ErrInvalidInput)
+	}
+	if err := c.Storage.SetDeactivation(ctx, user.ID, time.Time{}, time.Time{}); err != nil {
+		return err
+	}
+	return c.events().PublishUserReactivatedEvent(ctx, UserReactivatedEvent{
+		UserID:        user.ID,
+		ReactivatedAt: c.now(),
+	})
+}
+
+// PurgeDeletedAccounts deletes up to limit accounts whose grace period is over, and returns how many are deleted.
+// A UserDeletedEvent is published before each deletion, so a failed run is retried with the event published again
+// rather than a deleted user without an event. Accounts reactivated since they were listed are skipped without an event.
+// It is meant to be run periodically, see the purge command of the auth binary
+func (c Usecases) PurgeDeletedAccounts(ctx context.Context, limit int) (purged int, err error) {
+	defer c.observe("purge_deleted_accounts", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.PurgeDeletedAccounts")
+	defer tracing.EndSpan(span, &err)
+
+	now := c.now()
+	users, err := c.Storage.UsersToPurge(ctx, now, limit)
+	if err != nil {
+		return 0, err
+	}
+	for _, user := range users {
+		userID := user.ID
+		err := c.Storage.PurgeUser(ctx, userID, now, func(ctx context.Context) error {
+			err := c.events().PublishUserDeletedEvent(ctx, UserDeletedEvent{
+				UserID:    userID,
+				DeletedAt: now,
+			})
+			if err != nil {
+				return err
+			}
+			return c.deleteDataExports(ctx, userID)
+		})
+		if errors.Is(err, ErrUserNotFound) {
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
@@ -0,0 +1,122 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/mailer"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountDeletion(t *testing.T) {
+	setup := func(t *testing.T) (auth.Usecases, *inmemory.EventStreamer, *fakeClock, auth.User) {
+		events := inmemory.New()
+		clock := &fakeClock{now: time.Date(2021, 7, 19, 13, 11, 26, 0, time.UTC)}
+		uc := test_helpers.NewUsecases(events, mailer.NewInMemory())
+		uc.Now = clock.Now
+		uc.DeletionGracePeriod = 7 * 24 * time.Hour
+		user := test_helpers.HopefullyUniqueUser()
+		created, err := uc.SignUpUser(context.Background(), user)
+		require.Nil(t, err)
+		created.Password = user.Password
+		return uc, events, clock, created
+	}
+	lastEvent := func(events *inmemory.EventStreamer) interface{} {
+		published := events.Published()
+		return published[len(published)-1]
+	}
+
+	t.Run(`#DeactivateAccount blocks login and revokes sessions until #ReactivateAccount`, func(t *testing.T) {
+		uc, events, clock, user := setup(t)
+		token, err := uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+
+		require.ErrorIs(t, uc.DeactivateAccount(context.Background(), user.ID, "wrong-password"), auth.ErrInvalidCredentials)
+		require.Nil(t, uc.DeactivateAccount(context.Background(), user.ID, user.Password))
+		require.Equal(t, auth.UserDeactivatedEvent{UserID: user.ID, DeactivatedAt: clock.Now()}, lastEvent(events))
+		_, err = uc.VerifyToken(context.Background(), token)
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+		_, err = uc.Login(context.Background(), user.Username, user.Password)
+		require.ErrorIs(t, err, auth.ErrAccountDeactivated)
+		_, err = uc.Login(context.Background(), user.Username, "wrong-password")
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials, "status of the account should not be revealed without the password")
+
+		require.ErrorIs(t, uc.ReactivateAccount(context.Background(), user.Username, "wrong-password"), auth.ErrInvalidCredentials)
+		require.Nil(t, uc.ReactivateAccount(context.Background(), user.Username, user.Password))
+		require.Equal(t, auth.UserReactivatedEvent{UserID: user.ID, ReactivatedAt: clock.Now()}, lastEvent(events))
+		_, err = uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+		require.ErrorIs(t, uc.ReactivateAccount(context.Background(), user.Username, user.Password), auth.ErrInvalidInput)
+	})
+
+	t.Run(`#DeleteAccount purges the account after the grace period`, func(t *testing.T) {
+		uc, events, clock, user := setup(t)
+		require.ErrorIs(t, uc.DeleteAccount(context.Background(), user.ID, "wrong-password"), auth.ErrInvalidCredentials)
+		require.Nil(t, uc.DeleteAccount(context.Background(), user.ID, user.Password))
+		deleteAfter := clock.Now().Add(7 * 24 * time.Hour)
+		require.Equal(t, auth.UserDeactivatedEvent{UserID: user.ID, DeactivatedAt: clock.Now(), DeleteAfter: deleteAfter}, lastEvent(events))
+		_, err := uc.Login(context.Background(), user.Username, user.Password)
+		require.ErrorIs(t, err, auth.ErrAccountDeactivated)
+
+		clock.Advance(7*24*time.Hour - time.Second)
+		purged, err := uc.PurgeDeletedAccounts(context.Background(), 100)
+		require.Nil(t, err)
+		require.Equal(t, 0, purged)
+
+		clock.Advance(time.Second)
+		purged, err = uc.PurgeDeletedAccounts(context.Background(), 100)
+		require.Nil(t, err)
+		require.Equal(t, 1, purged)
+		require.Equal(t, auth.UserDeletedEvent{UserID: user.ID, DeletedAt: clock.Now()}, lastEvent(events))
+		_, err = uc.Login(context.Background(), user.Username, user.Password)
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	})
+
+	t.Run(`#ReactivateAccount cancels the deletion`, func(t *testing.T) {
+		uc, _, clock, user := setup(t)
+		require.Nil(t, uc.DeleteAccount(context.Background(), user.ID, user.Password))
+		require.Nil(t, uc.ReactivateAccount(context.Background(), user.Username, user.Password))
+
+		clock.Advance(8 * 24 * time.Hour)
+		purged, err := uc.PurgeDeletedAccounts(context.Background(), 100)
+		require.Nil(t, err)
+		require.Equal(t, 0, purged)
+		_, err = uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+	})
+
+	t.Run(`accounts reactivated after #PurgeDeletedAccounts listed them are neither announced nor deleted`, func(t *testing.T) {
+		uc, events, clock, user := setup(t)
+		require.Nil(t, uc.DeleteAccount(context.Background(), user.ID, user.Password))
+		clock.Advance(8 * 24 * time.Hour)
+		reactivating := uc
+		uc.Storage = reactivatingStorage{Storage: uc.Storage, reactivate: func() {
+			require.Nil(t, reactivating.ReactivateAccount(context.Background(), user.Username, user.Password))
+		}}
+		published := len(events.Published())
+
+		purged, err := uc.PurgeDeletedAccounts(context.Background(), 100)
+		require.Nil(t, err)
+		require.Equal(t, 0, purged)
+		require.Len(t, events.Published(), published+1, "only the UserReactivatedEvent should be published")
+		require.IsType(t, auth.UserReactivatedEvent{}, lastEvent(events))
+		_, err = uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+	})
+}
+
+// reactivatingStorage reactivates the user after listing the users to purge, like a concurrent ReactivateAccount would
+type reactivatingStorage struct {
+	auth.Storage
+	reactivate func()
+}
+
+func (s reactivatingStorage) UsersToPurge(ctx context.Context, now time.Time, limit int) ([]auth.User, error) {
+	users, err := s.Storage.UsersToPurge(ctx, now, limit)
+	s.reactivate()
+	return users, err
+}
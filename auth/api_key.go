@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// APIKey is a long-lived credential of a user for bots and integrations. The key can use only the permissions of the user's role
+// that are in its Scopes, the account of the user can't be managed with it. Changing the password revokes the keys
+type APIKey struct {
+	ID     string
+	UserID int
+	Name   string
+	// Prefix is the beginning of the key, so the user can tell their keys apart without the key
+	Prefix string
+	// Hash is the hex encoded sha256 of the key. The key has enough entropy, so a slow hash is not needed
+	Hash      string
+	Scopes    []Permission
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// LastUsedAt is zero until the key is used, it is updated at most once per apiKeyLastUsedPrecision
+	LastUsedAt time.Time
+	// RevokedAt is zero unless the key is revoked by RevokeAPIKey
+	RevokedAt time.Time
+}
+
+const (
+	// APIKeyPrefix starts every API key, so they can be told apart from the tokens of Login
+	APIKeyPrefix = "twk_"
+	// MaxAPIKeyLifetime is how far in the future an API key can expire
+	MaxAPIKeyLifetime = 365 * 24 * time.Hour
+
+	maxAPIKeyNameLength = 100
+	// apiKeyLastUsedPrecision limits the writes of busy keys
+	apiKeyLastUsedPrecision = time.Minute
+)
+
+// IsAPIKey reports whether the bearer token is an API key rather than a token of Login
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// newAPIKey returns a random key for the user and its APIKey to store
+func newAPIKey(userID int, name string, scopes []Permission, createdAt, expiresAt time.Time) (string, APIKey, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", APIKey{}, err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", APIKey{}, err
+	}
+	key := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	return key, APIKey{
+		ID:        hex.EncodeToString(id),
+		UserID:    userID,
+		Name:      name,
+		Prefix:    key[:len(APIKeyPrefix)+6],
+		Hash:      hashOneTimeToken(key),
+		Scopes:    scopes,
+		CreatedAt: createdAt,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// CreateAPIKey creates a key for the user that expires at expiresAt, at most MaxAPIKeyLifetime later.
+// The scopes must be permissions of the user's role, ErrForbidden is returned otherwise.
+// The key is returned only once, just its hash is stored
+func (c Usecases) CreateAPIKey(ctx context.Context, userID int, name string, scopes []Permission, expiresAt time.Time) (_ string, _ APIKey, err error) {
+	defer c.observe("create_api_key", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.CreateAPIKey")
+	defer tracing.EndSpan(span, &err)
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxAPIKeyNameLength {
+		return "", APIKey{}, fmt.Errorf("%w: name must be between 1 and %d characters", ErrInvalidInput, maxAPIKeyNameLength)
+	}
+	now := c.now()
+	if !expiresAt.After(now) || expiresAt.After(now.Add(MaxAPIKeyLifetime)) {
+		return "", APIKey{}, fmt.Errorf("%w: expiry must be in the next %s", ErrInvalidInput, MaxAPIKeyLifetime)
+	}
+	user, err := c.Storage.FindUserByID(ctx, userID)
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	granted, err := c.Storage.RolePermissions(ctx, roleOf(user))
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	var unique []Permission
+	for _, scope := range scopes {
+		if hasPermission(unique, scope) {
+			continue
+		}
+		if !hasPermission(granted, scope) {
+			return "", APIKey{}, fmt.Errorf("%w: %s is not granted to the user", ErrForbidden, scope)
+		}
+		unique = append(unique, scope)
+	}
+
+	key, apiKey, err := newAPIKey(userID, name, unique, now, expiresAt.UTC())
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	if err := c.Storage.CreateAPIKey(ctx, apiKey); err != nil {
+		return "", APIKey{}, err
+	}
+	apiKey.Hash = ""
+	return key, apiKey, nil
+}
+
+// ListAPIKeys returns the keys of the user that are not revoked, the newest first. Expired keys are listed too
+func (c Usecases) ListAPIKeys(ctx context.Context, userID int) (_ []APIKey, err error) {
+	defer c.observe("list_api_keys", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.ListAPIKeys")
+	defer tracing.EndSpan(span, &err)
+
+	keys, err := c.Storage.ListAPIKeys(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		keys[i].Hash = ""
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey invalidates a key of the user. It returns ErrAPIKeyNotFound if the key doesn't belong to the user or is already revoked
+func (c Usecases) RevokeAPIKey(ctx context.Context, userID int, keyID string) (err error) {
+	defer c.observe("revoke_api_key", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.RevokeAPIKey")
+	defer tracing.EndSpan(span, &err)
+	return c.Storage.RevokeAPIKey(ctx, userID, keyID, c.now())
+}
+
+// revokeAPIKeys revokes all keys of the user. Suspending, deactivating the user or changing their role doesn't need it,
+// VerifyAPIKey checks them
+func (c Usecases) revokeAPIKeys(ctx context.Context, userID int) error {
+	keys, err := c.Storage.ListAPIKeys(ctx, userID)
+	if err != nil {
+		return err
+	}
+	now := c.now()
+	for _, key := range keys {
+		// a concurrent RevokeAPIKey is not an error
+		if err := c.Storage.RevokeAPIKey(ctx, userID, key.ID, now); err != nil && !errors.Is(err, ErrAPIKeyNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyAPIKey checks that the key exists and is neither expired nor revoked, and returns the claims of its user like VerifyToken.
+// The permissions of the claims are the scopes of the key that the user's role still grants.
+// It returns ErrInvalidToken otherwise
+func (c Usecases) VerifyAPIKey(ctx context.Context, key string) (_ TokenClaims, err error) {
+	defer c.observe("verify_api_key", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.VerifyAPIKey")
+	defer tracing.EndSpan(span, &err)
+
+	if !IsAPIKey(key) {
+		return TokenClaims{}, fmt.Errorf("%w: not an API key", ErrInvalidToken)
+	}
+	apiKey, err := c.Storage.FindAPIKeyByHash(ctx, hashOneTimeToken(key))
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return TokenClaims{}, fmt.Errorf("%w: API key doesn't exist", ErrInvalidToken)
+	}
+	if err != nil {
+		return TokenClaims{}, err
+	}
+	now := c.now()
+	if !apiKey.RevokedAt.IsZero() {
+		return TokenClaims{}, fmt.Errorf("%w: API key is revoked", ErrInvalidToken)
+	}
+	if !apiKey.ExpiresAt.After(now) {
+		return TokenClaims{}, fmt.Errorf("%w: API key is expired", ErrInvalidToken)
+	}
+	user, err := c.Storage.FindUserByID(ctx, apiKey.UserID)
+	if errors.Is(err, ErrUserNotFound) {
+		return TokenClaims{}, fmt.Errorf("%w: user doesn't exist", ErrInvalidToken)
+	}
+	if err != nil {
+		return TokenClaims{}, err
+	}
+	if !user.DeactivatedAt.IsZero() {
+		return TokenClaims{}, fmt.Errorf("%w: account is deactivated", ErrInvalidToken)
+	}
+	if !user.SuspendedAt.IsZero() {
+		return TokenClaims{}, fmt.Errorf("%w: account is suspended", ErrInvalidToken)
+	}
+	granted, err := c.Storage.RolePermissions(ctx, roleOf(user))
+	if err != nil {
+		return TokenClaims{}, err
+	}
+	var permissions []Permission
+	for _, scope := range apiKey.Scopes {
+		if hasPermission(granted, scope) {
+			permissions = append(permissions, scope)
+		}
+	}
+	if now.Sub(apiKey.LastUsedAt) >= apiKeyLastUsedPrecision {
+		// a failure shouldn't fail the request, the key is valid
+		if err := c.Storage.TouchAPIKey(ctx, apiKey.ID, now); err != nil {
+			trace.SpanFromContext(ctx).RecordError(err)
+		}
+	}
+	return TokenClaims{
+		UserID:        user.ID,
+		APIKeyID:      apiKey.ID,
+		Role:          roleOf(user),
+		Permissions:   permissions,
+		IssuedAt:      apiKey.CreatedAt,
+		ExpiresAt:     apiKey.ExpiresAt,
+		EmailVerified: !user.EmailVerifiedAt.IsZero(),
+	}, nil
+}
+
+func hasPermission(permissions []Permission, p Permission) bool {
+	for _, granted := range permissions {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package auth_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/mailer"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeys(t *testing.T) {
+	setup := func(t *testing.T) (auth.Usecases, *fakeClock, auth.User) {
+		clock := &fakeClock{now: time.Date(2021, 7, 19, 13, 11, 26, 0, time.UTC)}
+		uc := test_helpers.NewUsecases(inmemory.New(), mailer.NewInMemory())
+		uc.Now = clock.Now
+		user, err := uc.SignUpUser(context.Background(), test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		return uc, clock, user
+	}
+
+	t.Run(`#CreateAPIKey returns a key that #VerifyAPIKey accepts until it expires`, func(t *testing.T) {
+		uc, clock, user := setup(t)
+		key, created, err := uc.CreateAPIKey(context.Background(), user.ID, " ci bot ", nil, clock.Now().Add(time.Hour))
+		require.Nil(t, err)
+		require.True(t, auth.IsAPIKey(key))
+		require.True(t, strings.HasPrefix(key, created.Prefix))
+		require.Equal(t, "ci bot", created.Name)
+		require.Empty(t, created.Hash, "the hash should not be returned")
+
+		claims, err := uc.VerifyAPIKey(context.Background(), key)
+		require.Nil(t, err)
+		require.Equal(t, user.ID, claims.UserID)
+		require.Equal(t, created.ID, claims.APIKeyID)
+		require.Equal(t, auth.RoleUser, claims.Role)
+		require.Equal(t, clock.Now().Add(time.Hour), claims.ExpiresAt)
+
+		_, err = uc.VerifyAPIKey(context.Background(), key+"x")
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+		_, err = uc.VerifyToken(context.Background(), key)
+		require.ErrorIs(t, err, auth.ErrInvalidToken, "API keys are not tokens")
+		clock.Advance(time.Hour)
+		_, err = uc.VerifyAPIKey(context.Background(), key)
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+
+	t.Run(`#CreateAPIKey validates the name, the expiry and the scopes`, func(t *testing.T) {
+		uc, clock, user := setup(t)
+		expiresAt := clock.Now().Add(time.Hour)
+		_, _, err := uc.CreateAPIKey(context.Background(), user.ID, " ", nil, expiresAt)
+		require.ErrorIs(t, err, auth.ErrInvalidInput)
+		_, _, err = uc.CreateAPIKey(context.Background(), user.ID, "bot", nil, clock.Now())
+		require.ErrorIs(t, err, auth.ErrInvalidInput)
+		_, _, err = uc.CreateAPIKey(context.Background(), user.ID, "bot", nil, clock.Now().Add(auth.MaxAPIKeyLifetime+time.Second))
+		require.ErrorIs(t, err, auth.ErrInvalidInput)
+		_, _, err = uc.CreateAPIKey(context.Background(), user.ID, "bot", []auth.Permission{auth.PermissionReadUsers}, expiresAt)
+		require.ErrorIs(t, err, auth.ErrForbidden, "keys should not have the permissions the user doesn't have")
+	})
+
+	t.Run(`keys can use only their scopes, while the role of the user grants them`, func(t *testing.T) {
+		uc, clock, user := setup(t)
+		require.Nil(t, uc.Storage.SetRole(context.Background(), user.ID, auth.RoleModerator))
+		key, _, err := uc.CreateAPIKey(context.Background(), user.ID, "bot",
+			[]auth.Permission{auth.PermissionReadUsers, auth.PermissionReadUsers}, clock.Now().Add(time.Hour))
+		require.Nil(t, err)
+		claims, err := uc.VerifyAPIKey(context.Background(), key)
+		require.Nil(t, err)
+		require.Equal(t, []auth.Permission{auth.PermissionReadUsers}, claims.Permissions)
+
+		require.Nil(t, uc.Storage.SetRole(context.Background(), user.ID, auth.RoleUser))
+		claims, err = uc.VerifyAPIKey(context.Background(), key)
+		require.Nil(t, err)
+		require.Empty(t, claims.Permissions)
+
+		require.Nil(t, uc.Storage.SetSuspension(context.Background(), user.ID, clock.Now(), "spam"))
+		_, err = uc.VerifyAPIKey(context.Background(), key)
+		require.ErrorIs(t, err, auth.ErrInvalidToken, "keys of suspended users should not be accepted")
+	})
+
+	t.Run(`#ListAPIKeys shows when the keys were last used, and #RevokeAPIKey invalidates them`, func(t *testing.T) {
+		uc, clock, user := setup(t)
+		first, firstKey, err := uc.CreateAPIKey(context.Background(), user.ID, "first", nil, clock.Now().Add(time.Hour))
+		require.Nil(t, err)
+		clock.Advance(time.Minute)
+		second, _, err := uc.CreateAPIKey(context.Background(), user.ID, "second", nil, clock.Now().Add(time.Hour))
+		require.Nil(t, err)
+		_, err = uc.VerifyAPIKey(context.Background(), first)
+		require.Nil(t, err)
+
+		keys, err := uc.ListAPIKeys(context.Background(), user.ID)
+		require.Nil(t, err)
+		require.Len(t, keys, 2)
+		require.Equal(t, "second", keys[0].Name, "newest key should be first")
+		require.True(t, keys[0].LastUsedAt.IsZero())
+		require.Equal(t, clock.Now(), keys[1].LastUsedAt)
+		require.Empty(t, keys[1].Hash)
+
+		other, err := uc.SignUpUser(context.Background(), test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		require.ErrorIs(t, uc.RevokeAPIKey(context.Background(), other.ID, firstKey.ID), auth.ErrAPIKeyNotFound)
+
+		require.Nil(t, uc.RevokeAPIKey(context.Background(), user.ID, firstKey.ID))
+		_, err = uc.VerifyAPIKey(context.Background(), first)
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+		_, err = uc.VerifyAPIKey(context.Background(), second)
+		require.Nil(t, err, "other keys should stay valid")
+		keys, err = uc.ListAPIKeys(context.Background(), user.ID)
+		require.Nil(t, err)
+		require.Len(t, keys, 1)
+	})
+
+	t.Run(`changing the password revokes the keys`, func(t *testing.T) {
+		uc, clock, _ := setup(t)
+		user := test_helpers.HopefullyUniqueUser()
+		created, err := uc.SignUpUser(context.Background(), user)
+		require.Nil(t, err)
+		key, _, err := uc.CreateAPIKey(context.Background(), created.ID, "ci", nil, clock.Now().Add(time.Hour))
+		require.Nil(t, err)
+
+		require.Nil(t, uc.ChangePassword(context.Background(), created.ID, user.Password, "a-new-long-password"))
+		_, err = uc.VerifyAPIKey(context.Background(), key)
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+		keys, err := uc.ListAPIKeys(context.Background(), created.ID)
+		require.Nil(t, err)
+		require.Empty(t, keys)
+	})
+}
@@ -4,20 +4,156 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
+	"strconv"
 	"time"
 
+	"github.com/davudsafarli/twitter/auth/logging"
+	"github.com/davudsafarli/twitter/auth/tracing"
 	"github.com/golang-jwt/jwt"
-	"golang.org/x/crypto/bcrypt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Storage interface {
 	CreateUser(ctx context.Context, u User) (User, error)
 	FindUser(ctx context.Context, usnm string) (User, error)
+	FindUserByID(ctx context.Context, ID int) (User, error)
+	FindUserByEmail(ctx context.Context, email string) (User, error)
+	// UpdateProfile sets the non-nil fields of the update and the UpdatedAt of the user
+	UpdateProfile(ctx context.Context, ID int, update ProfileUpdate) (User, error)
+	UpdatePassword(ctx context.Context, ID int, hashedPwd string) error
+	// UpdateEmail sets the email and its verification time. It returns ErrUserAlreadyExists if the email is taken
+	UpdateEmail(ctx context.Context, ID int, email string, verifiedAt time.Time) error
+	// SetEmailVerified sets the EmailVerifiedAt of the user if their email is still the given one, returns ErrUserNotFound otherwise
+	SetEmailVerified(ctx context.Context, ID int, email string, at time.Time) error
+	// SetDeactivation sets the DeactivatedAt and the DeleteAfter of the user, zero values clear them
+	SetDeactivation(ctx context.Context, ID int, deactivatedAt, deleteAfter time.Time) error
+	// UsersToPurge returns up to limit users whose DeleteAfter is before now
+	UsersToPurge(ctx context.Context, now time.Time, limit int) ([]User, error)
+	// DeleteUser removes the user and everything that references them. Deleting a missing user is not an error
+	DeleteUser(ctx context.Context, ID int) error
+	// PurgeUser deletes the user like DeleteUser if their DeleteAfter is not after now, once beforeDelete succeeds.
+	// The user can't be reactivated meanwhile. It returns ErrUserNotFound without calling beforeDelete if the user
+	// doesn't exist or isn't due anymore, and keeps the user if beforeDelete fails
+	PurgeUser(ctx context.Context, ID int, now time.Time, beforeDelete func(ctx context.Context) error) error
+	// SetSuspension sets the SuspendedAt and the SuspensionReason of the user, zero values clear them
+	SetSuspension(ctx context.Context, ID int, suspendedAt time.Time, reason string) error
+	// SetRole returns ErrInvalidInput if the role doesn't exist
+	SetRole(ctx context.Context, ID int, role Role) error
+	// RolePermissions returns the permissions of the role, none for an unknown role
+	RolePermissions(ctx context.Context, role Role) ([]Permission, error)
+	ListUsers(ctx context.Context, query UserQuery) ([]User, error)
+	// RevokeSessions increments the TokenVersion of the user and revokes their sessions, so the tokens issued before are not valid anymore
+	RevokeSessions(ctx context.Context, ID int) error
+
+	CreateSession(ctx context.Context, session Session) error
+	// FindSession returns ErrSessionNotFound if the session doesn't exist
+	FindSession(ctx context.Context, ID string) (Session, error)
+	// ListSessions returns the sessions of the user that are neither revoked nor expired at now, the newest first
+	ListSessions(ctx context.Context, userID int, now time.Time) ([]Session, error)
+	// RevokeSession sets the RevokedAt of the session, it returns ErrSessionNotFound if the session doesn't belong to the user or is already revoked
+	RevokeSession(ctx context.Context, userID int, ID string, at time.Time) error
+	RecordLoginEvent(ctx context.Context, event LoginEvent) error
+	// ListLoginEvents returns up to limit login events of the user, the newest first. All of them are returned if limit is 0
+	ListLoginEvents(ctx context.Context, userID int, limit int) ([]LoginEvent, error)
+
+	CreateAPIKey(ctx context.Context, key APIKey) error
+	// FindAPIKeyByHash returns ErrAPIKeyNotFound if no key has the hash
+	FindAPIKeyByHash(ctx context.Context, hash string) (APIKey, error)
+	// ListAPIKeys returns the keys of the user that are not revoked, the newest first
+	ListAPIKeys(ctx context.Context, userID int) ([]APIKey, error)
+	// RevokeAPIKey sets the RevokedAt of the key, it returns ErrAPIKeyNotFound if the key doesn't belong to the user or is already revoked
+	RevokeAPIKey(ctx context.Context, userID int, ID string, at time.Time) error
+	// TouchAPIKey sets the LastUsedAt of the key
+	TouchAPIKey(ctx context.Context, ID string, at time.Time) error
+
+	// CreateOIDCState saves the state of a flow. It also deletes the expired states, abandoned flows would pile up otherwise
+	CreateOIDCState(ctx context.Context, state OIDCState) error
+	// ConsumeOIDCState deletes the state and returns it. It returns ErrInvalidToken if the state doesn't exist or is expired at now
+	ConsumeOIDCState(ctx context.Context, hash string, now time.Time) (OIDCState, error)
+	// CreateIdentity returns ErrUserAlreadyExists if the subject is linked already, or the user has an identity of the provider
+	CreateIdentity(ctx context.Context, identity Identity) error
+	// FindIdentity returns ErrIdentityNotFound if the subject isn't linked to a user
+	FindIdentity(ctx context.Context, provider, subject string) (Identity, error)
+	// ListIdentities returns the identities of the user ordered by provider
+	ListIdentities(ctx context.Context, userID int) ([]Identity, error)
+	// DeleteIdentity returns ErrIdentityNotFound if the user has no identity of the provider
+	DeleteIdentity(ctx context.Context, userID int, provider string) error
+
+	CreateOneTimeToken(ctx context.Context, token OneTimeToken) error
+	// ConsumeOneTimeToken marks the token as used and returns it.
+	// It returns ErrInvalidToken if the token doesn't exist, is already used or is expired at now
+	ConsumeOneTimeToken(ctx context.Context, purpose TokenPurpose, hash string, now time.Time) (OneTimeToken, error)
+
+	// SaveTOTP replaces the TOTP enrolment and the recovery codes of the user
+	SaveTOTP(ctx context.Context, totp TOTP, recoveryCodeHashes []string) error
+	// FindTOTP returns ErrMFANotEnrolled if the user has no enrolment
+	FindTOTP(ctx context.Context, userID int) (TOTP, error)
+	// ConfirmTOTP sets the ConfirmedAt of the enrolment, it returns ErrMFANotEnrolled if the user has no enrolment
+	ConfirmTOTP(ctx context.Context, userID int, at time.Time) error
+	// UseTOTPStep sets the LastUsedStep of the enrolment if it is before step, returns ErrInvalidCredentials otherwise
+	UseTOTPStep(ctx context.Context, userID int, step int64) error
+	// UseRecoveryCode marks the recovery code as used, it returns ErrInvalidCredentials if the code doesn't exist or is already used
+	UseRecoveryCode(ctx context.Context, userID int, hash string, at time.Time) error
+	// DeleteTOTP removes the enrolment and the recovery codes of the user
+	DeleteTOTP(ctx context.Context, userID int) error
+
+	// AppendUserEvent records an event published about the user
+	AppendUserEvent(ctx context.Context, record UserEventRecord) error
+	// ListUserEvents returns the recorded events of the user in the order they are published
+	ListUserEvents(ctx context.Context, userID int) ([]UserEventRecord, error)
+
+	CreateDataExport(ctx context.Context, export DataExport) error
+	// ClaimDataExport marks the oldest pending export, or an export building since before staleBefore, as building at now and returns it.
+	// Concurrent calls never claim the same export. It returns ErrDataExportNotFound if there is nothing to claim
+	ClaimDataExport(ctx context.Context, now, staleBefore time.Time) (DataExport, error)
+	// UpdateDataExport saves the status, the blob key, the error and the times of the export
+	UpdateDataExport(ctx context.Context, export DataExport) error
+	// FindDataExport returns ErrDataExportNotFound if the export doesn't exist
+	FindDataExport(ctx context.Context, ID string) (DataExport, error)
+	ListDataExports(ctx context.Context, userID int) ([]DataExport, error)
+	// ExpiredDataExports returns up to limit exports whose ExpiresAt is not after now
+	ExpiredDataExports(ctx context.Context, now time.Time, limit int) ([]DataExport, error)
+	// DeleteDataExport removes the export. Deleting a missing export is not an error
+	DeleteDataExport(ctx context.Context, ID string) error
 }
 
 type Usecases struct {
 	Storage  Storage
 	Publiser EventProducerConsumer
+	// Metrics is optional, usecases are not observed if it is nil
+	Metrics Metrics
+	// TracerProvider creates the spans of usecases. The global TracerProvider is used if it is nil
+	TracerProvider trace.TracerProvider
+	// Mailer sends the emails of usecases, e.g. the confirmation of ChangeEmail.
+	// SignUpUser doesn't send the verification email if it is nil, the usecases that can't work without emails fail
+	Mailer Mailer
+	// Logger logs the failures that don't fail the usecases, e.g. of the verification email of SignUpUser.
+	// Defaults to INFO level logs to stderr, use logging.Nop() to silence it
+	Logger logging.Logger
+	// EmailVerification decides whether users can log in before verifying their email
+	EmailVerification EmailVerificationPolicy
+	// LoginThrottling protects Login and the password checks of the account changes against brute-force attacks.
+	// Nothing is throttled if its Store is nil
+	LoginThrottling LoginThrottling
+	// PasswordPolicy is checked when a password is set. DefaultPasswordPolicy is used if it is the zero value
+	PasswordPolicy PasswordPolicy
+	// PasswordHasher hashes the new passwords, DefaultPasswordHasher is used if it is nil.
+	// Passwords hashed with other algorithms or parameters are rehashed on Login
+	PasswordHasher PasswordHasher
+	// DeletionGracePeriod is how long DeleteAccount can be cancelled, DefaultDeletionGracePeriod is used if it is zero
+	DeletionGracePeriod time.Duration
+	// Tokens configures the signing keys and the TTL of the tokens
+	Tokens TokenOptions
+	// MFA configures TOTP. Users can't enrol TOTP unless its EncryptionKey is set
+	MFA MFAOptions
+	// DataExports configures ExportUserData. Users can't export their data unless its Blobs is set
+	DataExports DataExportOptions
+	// OIDC configures the sign in with OIDC providers, it is disabled without Providers
+	OIDC OIDCOptions
+	// Now returns the current time, time.Now is used if it is nil
+	Now func() time.Time
 }
 
 func NewUsecases(s Storage, publisher EventProducerConsumer) Usecases {
@@ -27,14 +163,67 @@ func NewUsecases(s Storage, publisher EventProducerConsumer) Usecases {
 	}
 }
 
-// TODO: Read from config
-const JWT_SECRET = "jwt_secret"
+func (c Usecases) metrics() Metrics {
+	if c.Metrics == nil {
+		return nopMetrics{}
+	}
+	return c.Metrics
+}
+
+func (c Usecases) now() time.Time {
+	if c.Now == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+func (c Usecases) passwordPolicy() PasswordPolicy {
+	if c.PasswordPolicy == (PasswordPolicy{}) {
+		return DefaultPasswordPolicy
+	}
+	return c.PasswordPolicy
+}
+
+func (c Usecases) mailer() Mailer {
+	if c.Mailer == nil {
+		return noMailer{}
+	}
+	return c.Mailer
+}
+
+func (c Usecases) logger() logging.Logger {
+	if c.Logger == nil {
+		return defaultLogger
+	}
+	return c.Logger
+}
+
+var defaultLogger = logging.New(os.Stderr, logging.LevelInfo)
+
+type noMailer struct{}
+
+func (noMailer) Send(context.Context, Email) error {
+	return errors.New("Usecases.Mailer is not set")
+}
+
+// observe reports the duration and the outcome of a usecase. It is meant to be deferred with a pointer to the named error result
+func (c Usecases) observe(usecase string, start time.Time, err *error) {
+	c.metrics().ObserveUsecase(usecase, OutcomeOf(*err), time.Since(start))
+}
 
 // SignUpUser registers a new user if the username and email don't exist already.
 // It hashes the password before saving.
-// It also publishes a UserEvent about the Signup process
-func (c Usecases) SignUpUser(ctx context.Context, user User) (User, error) {
-	hashedPwd, err := hashPassword(user.Password)
+// It also publishes a UserEvent about the Signup process, and sends a verification token to the email of the user.
+// The created user is returned with the error if publishing fails. Failing to send the email only logs it,
+// the user exists already and ResendVerification can send another
+func (c Usecases) SignUpUser(ctx context.Context, user User) (_ User, err error) {
+	defer c.observe("signup", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.SignUpUser")
+	defer tracing.EndSpan(span, &err)
+	if err := c.passwordPolicy().Validate(user.Password); err != nil {
+		return User{}, err
+	}
+	hashedPwd, err := c.hashPassword(ctx, user.Password)
 	if err != nil {
 		return User{}, err
 	}
@@ -43,49 +232,270 @@ func (c Usecases) SignUpUser(ctx context.Context, user User) (User, error) {
 	if err != nil {
 		return User{}, err
 	}
-	err = c.Publiser.PublishUserSignupEvent(ctx, SignupEvent{
-		User: user,
-	})
-
-	return user, err
+	err = c.events().PublishUserSignupEvent(ctx, signupEventOf(user))
+	if err != nil {
+		return user, err
+	}
+	c.sendSignupVerificationEmail(ctx, user)
+	return user, nil
 }
 
 // Login creates and retunrs a token for an existing user.
+// It returns ErrInvalidCredentials both for unknown usernames and wrong passwords.
+// If the user enabled MFA, it returns a MFARequiredError with a challenge for CompleteMFA instead of the token.
+// If LoginThrottling is enabled, it returns a TooManyAttemptsError without checking the password while the account
+// or the IP of the client (see WithClientInfo) is throttled
 func (c Usecases) Login(ctx context.Context, usnm, pwd string) (token string, err error) {
-	user, err := c.Storage.FindUser(ctx, usnm)
-	// TODO: return user doesn't exist error
+	defer c.observe("login", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.Login")
+	defer tracing.EndSpan(span, &err)
+
+	var user User
+	defer func() { c.recordLoginEvent(ctx, user.ID, err) }()
+	user, err = c.authenticate(ctx, usnm, pwd)
 	if err != nil {
 		return "", err
 	}
+	// checked after the password, so the status of an account isn't revealed to others
+	if !user.SuspendedAt.IsZero() {
+		return "", ErrAccountSuspended
+	}
+	if !user.DeactivatedAt.IsZero() {
+		return "", ErrAccountDeactivated
+	}
+	if c.EmailVerification == EmailVerificationRequired && user.EmailVerifiedAt.IsZero() {
+		return "", ErrEmailNotVerified
+	}
+	_, err = c.mfaEnabled(ctx, user.ID)
+	if err == nil {
+		return "", c.mfaChallenge(ctx, user.ID)
+	}
+	if !errors.Is(err, ErrMFANotEnrolled) {
+		return "", err
+	}
+	return c.issueToken(ctx, user)
+}
+
+// authenticate checks the username and the password with LoginThrottling, and rehashes the password if needed
+func (c Usecases) authenticate(ctx context.Context, usnm, pwd string) (User, error) {
+	keys := c.LoginThrottling.withDefaults().keys(ctx, usnm)
+	if err := c.checkLoginThrottling(ctx, keys); err != nil {
+		return User{}, err
+	}
+	user, err := c.Storage.FindUser(ctx, usnm)
+	if errors.Is(err, ErrUserNotFound) {
+		// unknown usernames take as long as wrong passwords and get the same error, so usernames can't be enumerated
+		dummy, err := dummyHash(c.passwordHasher())
+		if err != nil {
+			return User{}, err
+		}
+		c.checkPasswordAndHashEquality(ctx, pwd, dummy)
+		if err := c.recordLoginFailure(ctx, keys, User{}); err != nil {
+			return User{}, err
+		}
+		return User{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return User{}, err
+	}
 
-	correct := checkPasswordAndHashEquality(pwd, user.Password)
+	correct := c.checkPasswordAndHashEquality(ctx, pwd, user.Password)
 	if !correct {
-		return "", errors.New("wrong password")
+		if err := c.recordLoginFailure(ctx, keys, user); err != nil {
+			return User{}, err
+		}
+		// only the ID is returned, so the failure can be recorded to the login history of the user
+		return User{ID: user.ID}, ErrInvalidCredentials
+	}
+	if err := c.resetLoginThrottling(ctx, keys); err != nil {
+		return User{}, err
+	}
+	if c.passwordHasher().NeedsRehash(user.Password) {
+		// the user can log in with the old hash anyway, so a failure is only recorded
+		if err := c.rehashPassword(ctx, user.ID, pwd); err != nil {
+			trace.SpanFromContext(ctx).RecordError(err)
+		}
 	}
-	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	return user, nil
+}
+
+// issueToken starts a session and returns its token, for a user who passed all the steps of Login
+func (c Usecases) issueToken(ctx context.Context, user User) (string, error) {
+	return c.issueSessionToken(ctx, user, c.now())
+}
+
+// issueSessionToken issues the token of a new session continuing the login at startedAt.
+// The token expires at Tokens.MaxSessionAge after the login at the latest
+func (c Usecases) issueSessionToken(ctx context.Context, user User, startedAt time.Time) (string, error) {
+	now := c.now()
+	options := c.Tokens.withDefaults()
+	expiresAt := now.Add(options.TTL)
+	if maxExpiresAt := startedAt.Add(options.MaxSessionAge); expiresAt.After(maxExpiresAt) {
+		expiresAt = maxExpiresAt
+	}
+	session, err := c.newSession(ctx, user.ID, startedAt, expiresAt)
+	if err != nil {
+		return "", err
+	}
+	permissions, err := c.Storage.RolePermissions(ctx, roleOf(user))
+	if err != nil {
+		return "", err
+	}
+	return c.signToken(jwt.MapClaims{
 		"ID":  fmt.Sprint(user.ID),
-		"nbf": time.Date(2015, 10, 10, 12, 0, 0, 0, time.UTC).Unix(),
+		"sid": session.ID,
+		// role and perms let other services authorize the user without calling this one
+		"role":  string(roleOf(user)),
+		"perms": permissions,
+		"nbf":   time.Date(2015, 10, 10, 12, 0, 0, 0, time.UTC).Unix(),
+		"iat":   now.Unix(),
+		"exp":   expiresAt.Unix(),
+		// tv is compared with the TokenVersion of the user in VerifyToken, RevokeSessions increments it
+		"tv": user.TokenVersion,
+		// services can limit what unverified users can do with it when EmailVerificationOptional is the policy
+		"email_verified": !user.EmailVerifiedAt.IsZero(),
 	})
+}
 
-	token, err = jwtToken.SignedString([]byte(JWT_SECRET))
+// TokenClaims are the verified claims of a token created by Login
+type TokenClaims struct {
+	UserID int
+	// SessionID is empty for the tokens issued before sessions were introduced, they can only be revoked with RevokeSessions
+	SessionID string
+	// APIKeyID is set instead of SessionID if the claims are of an API key, see VerifyAPIKey
+	APIKeyID      string
+	Role          Role
+	Permissions   []Permission
+	IssuedAt      time.Time
+	ExpiresAt     time.Time
+	EmailVerified bool
+}
 
-	return token, err
+// VerifyToken checks the signature and the expiry of a token created by Login, and that neither its session nor all the sessions
+// of the user were revoked since.
+// It returns ErrInvalidToken otherwise
+func (c Usecases) VerifyToken(ctx context.Context, token string) (_ TokenClaims, err error) {
+	defer c.observe("verify_token", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.VerifyToken")
+	defer tracing.EndSpan(span, &err)
+
+	// the expiry is checked below with c.now(), the parser would use the wall clock
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	parsed, err := parser.Parse(token, c.verificationKey)
+	if err != nil {
+		return TokenClaims{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	claims, _ := parsed.Claims.(jwt.MapClaims)
+	expiresAt, _ := claims["exp"].(float64)
+	// tokens without exp are rejected too
+	if int64(expiresAt) <= c.now().Unix() {
+		return TokenClaims{}, fmt.Errorf("%w: token is expired", ErrInvalidToken)
+	}
+	rawID, _ := claims["ID"].(string)
+	userID, err := strconv.Atoi(rawID)
+	if err != nil {
+		return TokenClaims{}, fmt.Errorf("%w: invalid ID claim", ErrInvalidToken)
+	}
+	// numbers of MapClaims are decoded as float64
+	version, _ := claims["tv"].(float64)
+	issuedAt, _ := claims["iat"].(float64)
+	emailVerified, _ := claims["email_verified"].(bool)
+
+	user, err := c.Storage.FindUserByID(ctx, userID)
+	if errors.Is(err, ErrUserNotFound) {
+		return TokenClaims{}, fmt.Errorf("%w: user doesn't exist", ErrInvalidToken)
+	}
+	if err != nil {
+		return TokenClaims{}, err
+	}
+	if int(version) != user.TokenVersion {
+		return TokenClaims{}, fmt.Errorf("%w: session is revoked", ErrInvalidToken)
+	}
+	if !user.DeactivatedAt.IsZero() {
+		return TokenClaims{}, fmt.Errorf("%w: account is deactivated", ErrInvalidToken)
+	}
+	if !user.SuspendedAt.IsZero() {
+		return TokenClaims{}, fmt.Errorf("%w: account is suspended", ErrInvalidToken)
+	}
+	sessionID, _ := claims["sid"].(string)
+	if sessionID != "" {
+		session, err := c.Storage.FindSession(ctx, sessionID)
+		if errors.Is(err, ErrSessionNotFound) {
+			return TokenClaims{}, fmt.Errorf("%w: session doesn't exist", ErrInvalidToken)
+		}
+		if err != nil {
+			return TokenClaims{}, err
+		}
+		if session.UserID != userID || !session.RevokedAt.IsZero() {
+			return TokenClaims{}, fmt.Errorf("%w: session is revoked", ErrInvalidToken)
+		}
+	}
+	return TokenClaims{
+		UserID:        userID,
+		SessionID:     sessionID,
+		Role:          roleClaim(claims),
+		Permissions:   permissionsClaim(claims),
+		IssuedAt:      time.Unix(int64(issuedAt), 0).UTC(),
+		ExpiresAt:     time.Unix(int64(expiresAt), 0).UTC(),
+		EmailVerified: emailVerified,
+	}, nil
+}
+
+func (c Usecases) passwordHasher() PasswordHasher {
+	if c.PasswordHasher == nil {
+		return DefaultPasswordHasher
+	}
+	return c.PasswordHasher
 }
 
-func hashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+func (c Usecases) hashPassword(ctx context.Context, password string) (string, error) {
+	h := c.passwordHasher()
+	_, span := c.tracer().Start(ctx, "PasswordHasher.Hash", trace.WithAttributes(
+		attribute.String("password_hasher.algorithm", h.Algorithm()),
+		attribute.String("password_hasher.params", h.Params()),
+	))
+	defer span.End()
+	start := time.Now()
+	hash, err := h.Hash(password)
+	c.metrics().ObservePasswordHashing(h.Algorithm(), h.Params(), time.Since(start))
+	return hash, err
 }
 
-func checkPasswordAndHashEquality(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+// checkPasswordAndHashEquality verifies the password with the configured hasher if the hash is created by it,
+// or with the built-in hasher of the hash's algorithm otherwise, so the hashes of the previous hashers are still valid
+func (c Usecases) checkPasswordAndHashEquality(ctx context.Context, password, hash string) bool {
+	_, span := c.tracer().Start(ctx, "PasswordHasher.Verify")
+	defer span.End()
+	h := c.passwordHasher()
+	if h.NeedsRehash(hash) {
+		var err error
+		if h, err = hasherOf(hash); err != nil {
+			span.RecordError(err)
+			return false
+		}
+	}
+	correct, err := h.Verify(password, hash)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return correct
+}
+
+// rehashPassword saves the password hashed with the current hasher. Unlike setPassword, sessions are kept and no event is published,
+// since the password doesn't change
+func (c Usecases) rehashPassword(ctx context.Context, userID int, password string) error {
+	hash, err := c.hashPassword(ctx, password)
+	if err != nil {
+		return err
+	}
+	return c.Storage.UpdatePassword(ctx, userID, hash)
 }
 
 /*
 
 topic: users
-event types: Signup, ProfileUpdated
+event types: Signup, ProfileUpdated, PasswordChanged, EmailChanged, EmailVerified, AccountLocked,
+	UserDeactivated, UserReactivated, UserDeleted, Audit
 
 topic: social
 event types: FriendRequestSended, FriendRequestAccepted, FriendRequestRejected,
@@ -2,46 +2,24 @@ package auth_test
 
 import (
 	"context"
-	"fmt"
-	"io"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/adamluzsi/testcase"
 	"github.com/davudsafarli/twitter/auth"
-	"github.com/davudsafarli/twitter/auth/storage"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/mailer"
 	"github.com/davudsafarli/twitter/auth/test_helpers"
 	"github.com/stretchr/testify/require"
 )
 
-// FakeEventProducerConsumer is an Empty supplier for now.
-// Todo(davud): Make it real inmemory fake and move to event_streamer package later
-type FakeEventProducerConsumer struct {
-	returnErr bool
-}
-
-func (m FakeEventProducerConsumer) PublishUserSignupEvent(ctx context.Context, event auth.SignupEvent) error {
-	if m.returnErr {
-		return fmt.Errorf("err")
-	}
-	return nil
-}
-
-func (m FakeEventProducerConsumer) RegisterUserSignupEventConsumer(ctx context.Context, Handler func(event auth.ConsumedSignupEvent)) {
-}
-
-func (m FakeEventProducerConsumer) StartConsumer(ctx context.Context) io.Closer {
-	return io.NopCloser(nil)
-}
-
 func TestUsecases(t *testing.T) {
-	pg, err := storage.NewPostgres(test_helpers.DB_CONN_STR)
-	_ = pg
-	require.Nil(t, err)
+	pg := test_helpers.NewPostgres(t)
 	t.Run(`User can #Login after #Signup`, func(t *testing.T) {
 		user := test_helpers.HopefullyUniqueUser()
-		fake := FakeEventProducerConsumer{}
-		uc := auth.NewUsecases(pg, fake)
+		uc := auth.NewUsecases(pg, inmemory.New())
 		// Sign up a user
 		createdUser, err := uc.SignUpUser(context.Background(), user)
 		require.Nil(t, err)
@@ -57,6 +35,34 @@ func TestUsecases(t *testing.T) {
 		require.NotEmpty(t, token)
 	})
 
+	t.Run(`User can #Login only after #VerifyEmail if the verification is required`, func(t *testing.T) {
+		t.Parallel()
+		user := test_helpers.HopefullyUniqueUser()
+		mails := mailer.NewInMemory()
+		uc := auth.NewUsecases(pg, inmemory.New())
+		uc.Mailer = mails
+		uc.EmailVerification = auth.EmailVerificationRequired
+		createdUser, err := uc.SignUpUser(context.Background(), user)
+		require.Nil(t, err)
+		t.Cleanup(func() {
+			require.Nil(t, pg.DeleteUser(context.Background(), createdUser.ID))
+		})
+
+		_, err = uc.Login(context.Background(), user.Username, user.Password)
+		require.ErrorIs(t, err, auth.ErrEmailNotVerified)
+
+		email, ok := mails.Last(user.Email)
+		require.True(t, ok)
+		lines := strings.Split(strings.TrimSpace(email.Body), "\n")
+		require.Nil(t, uc.VerifyEmail(context.Background(), lines[len(lines)-1]))
+
+		token, err := uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+		claims, err := uc.VerifyToken(context.Background(), token)
+		require.Nil(t, err)
+		require.True(t, claims.EmailVerified)
+	})
+
 	t.Run(`When new user #Signup, it should be published and Consumer should see that event`, func(t *testing.T) {
 		t.Parallel()
 		user := test_helpers.HopefullyUniqueUser()
@@ -81,7 +87,11 @@ func TestUsecases(t *testing.T) {
 		})
 
 		expected := auth.SignupEvent{
-			User: createdUser,
+			ID:        createdUser.ID,
+			Email:     createdUser.Email,
+			Username:  createdUser.Username,
+			CreatedAt: createdUser.CreatedAt,
+			UpdatedAt: createdUser.UpdatedAt,
 		}
 		r := testcase.Retry{Strategy: testcase.Waiter{WaitTimeout: 2 * time.Second, WaitDuration: time.Second / 3}}
 		r.Assert(t, func(tb testing.TB) {
@@ -93,4 +103,57 @@ func TestUsecases(t *testing.T) {
 			require.InDelta(t, time.Now().Second(), consumedEvent.Timestamp().Second(), float64(5*time.Second))
 		})
 	})
+
+	t.Run(`When user #UpdateProfile, only the changed fields are saved and published`, func(t *testing.T) {
+		t.Parallel()
+		k := test_helpers.GetEventProducerConsumer(t)
+		var mu sync.Mutex
+		var consumedEvent auth.ConsumedProfileUpdatedEvent
+		k.RegisterProfileUpdatedEventConsumer(context.Background(), func(event auth.ConsumedProfileUpdatedEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			consumedEvent = event
+		})
+		consumer := k.StartConsume(context.Background())
+		t.Cleanup(func() {
+			require.Nil(t, consumer.Close())
+		})
+
+		user := test_helpers.HopefullyUniqueUser()
+		user.Bio = "old bio"
+		createdUser, err := pg.CreateUser(context.Background(), user)
+		require.Nil(t, err)
+		t.Cleanup(func() {
+			require.Nil(t, pg.DeleteUser(context.Background(), createdUser.ID))
+		})
+
+		uc := auth.NewUsecases(pg, k)
+		oldBio, newName := "old bio", "Davud"
+		updatedUser, err := uc.UpdateProfile(context.Background(), createdUser.ID, auth.ProfileUpdate{
+			Bio:         &oldBio,
+			DisplayName: &newName,
+		})
+		require.Nil(t, err)
+		require.Equal(t, "Davud", updatedUser.DisplayName)
+
+		foundUser, err := pg.FindUserByID(context.Background(), createdUser.ID)
+		require.Nil(t, err)
+		require.Equal(t, updatedUser, foundUser)
+
+		expected := auth.ProfileUpdatedEvent{
+			UserID:    createdUser.ID,
+			Changes:   auth.ProfileUpdate{DisplayName: &newName},
+			UpdatedAt: updatedUser.UpdatedAt,
+		}
+		r := testcase.Retry{Strategy: testcase.Waiter{WaitTimeout: 2 * time.Second, WaitDuration: time.Second / 3}}
+		r.Assert(t, func(tb testing.TB) {
+			mu.Lock()
+			defer mu.Unlock()
+			if consumedEvent == nil {
+				tb.Fail()
+				return
+			}
+			require.Equal(tb, expected, consumedEvent.ProfileUpdatedEvent())
+		})
+	})
 }
@@ -0,0 +1,20 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrBlobNotFound is returned by BlobStore when the requested blob doesn't exist
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore keeps large files, e.g. the archives of ExportUserData. See blobstore package for the implementations
+type BlobStore interface {
+	// Put stores the content of r under the key, replacing the existing blob. A failed Put leaves no partial blob
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Open returns ErrBlobNotFound if the key doesn't exist
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob. Deleting a missing blob is not an error
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,46 @@
+package blobstore_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/blobstore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilesystem(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "blobs")
+	s, err := blobstore.NewFilesystem(dir)
+	require.Nil(t, err)
+	ctx := context.Background()
+
+	require.Nil(t, s.Put(ctx, "exports/a.zip", strings.NewReader("first")))
+	require.Nil(t, s.Put(ctx, "exports/a.zip", strings.NewReader("second")))
+	r, err := s.Open(ctx, "exports/a.zip")
+	require.Nil(t, err)
+	content, err := ioutil.ReadAll(r)
+	require.Nil(t, err)
+	require.Nil(t, r.Close())
+	require.Equal(t, "second", string(content))
+
+	info, err := os.Stat(filepath.Join(dir, "exports", "a.zip"))
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	files, err := ioutil.ReadDir(filepath.Join(dir, "exports"))
+	require.Nil(t, err)
+	require.Len(t, files, 1, "temporary files should be removed")
+
+	require.Nil(t, s.Delete(ctx, "exports/a.zip"))
+	require.Nil(t, s.Delete(ctx, "exports/a.zip"), "deleting a missing blob should not fail")
+	_, err = s.Open(ctx, "exports/a.zip")
+	require.ErrorIs(t, err, auth.ErrBlobNotFound)
+
+	for _, key := range []string{"../escape", "exports/../../escape", "/absolute", ""} {
+		require.NotNil(t, s.Put(ctx, key, strings.NewReader("x")), key)
+	}
+}
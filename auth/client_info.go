@@ -0,0 +1,23 @@
+package auth
+
+import "context"
+
+// ClientInfo describes the client calling a usecase, e.g. to throttle logins per IP.
+// Transports put it to the context with WithClientInfo
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+type clientInfoKey struct{}
+
+// WithClientInfo returns a copy of ctx carrying the info
+func WithClientInfo(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, info)
+}
+
+// ClientInfoFrom returns the ClientInfo of ctx, the zero value if it doesn't have one
+func ClientInfoFrom(ctx context.Context) ClientInfo {
+	info, _ := ctx.Value(clientInfoKey{}).(ClientInfo)
+	return info
+}
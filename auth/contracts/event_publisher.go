@@ -3,6 +3,7 @@ package contracts
 import (
 	"context"
 	"io"
+	"sync"
 	"testing"
 	"time"
 
@@ -21,24 +22,135 @@ type EventProducerConsumerContract struct {
 }
 
 func (c EventProducerConsumerContract) Test(t *testing.T) {
-	t.Run(`Published event will eventually be consumed by Consumer`, func(t *testing.T) {
+	// Consumers can be started only once, so all event types are tested together
+	t.Run(`Published events will eventually be consumed by Consumer`, func(t *testing.T) {
 		pubSignupEvent := auth.SignupEvent{
-			User: auth.User{
-				ID:       1,
-				Email:    "email",
-				Username: "uname",
-				Password: "pwd",
-			},
+			ID:        1,
+			Email:     "email",
+			Username:  "uname",
+			CreatedAt: time.Date(2021, 7, 19, 13, 11, 26, 0, time.UTC),
+			UpdatedAt: time.Date(2021, 7, 19, 13, 11, 26, 0, time.UTC),
+		}
+		bio := "new bio"
+		pubProfileUpdatedEvent := auth.ProfileUpdatedEvent{
+			UserID:    1,
+			Changes:   auth.ProfileUpdate{Bio: &bio},
+			UpdatedAt: time.Date(2021, 7, 20, 13, 11, 26, 0, time.UTC),
+		}
+		pubPasswordChangedEvent := auth.PasswordChangedEvent{
+			UserID:    1,
+			ChangedAt: time.Date(2021, 7, 21, 13, 11, 26, 0, time.UTC),
+		}
+		pubEmailChangedEvent := auth.EmailChangedEvent{
+			UserID:    1,
+			Email:     "new-email",
+			ChangedAt: time.Date(2021, 7, 22, 13, 11, 26, 0, time.UTC),
+		}
+		pubEmailVerifiedEvent := auth.EmailVerifiedEvent{
+			UserID:     1,
+			Email:      "new-email",
+			VerifiedAt: time.Date(2021, 7, 23, 13, 11, 26, 0, time.UTC),
+		}
+		pubAccountLockedEvent := auth.AccountLockedEvent{
+			UserID:      1,
+			Failures:    5,
+			LockedUntil: time.Date(2021, 7, 24, 13, 11, 26, 0, time.UTC),
+		}
+		pubUserDeactivatedEvent := auth.UserDeactivatedEvent{
+			UserID:        1,
+			DeactivatedAt: time.Date(2021, 7, 25, 13, 11, 26, 0, time.UTC),
+			DeleteAfter:   time.Date(2021, 8, 24, 13, 11, 26, 0, time.UTC),
+		}
+		pubUserReactivatedEvent := auth.UserReactivatedEvent{
+			UserID:        1,
+			ReactivatedAt: time.Date(2021, 7, 26, 13, 11, 26, 0, time.UTC),
+		}
+		pubUserDeletedEvent := auth.UserDeletedEvent{
+			UserID:    1,
+			DeletedAt: time.Date(2021, 8, 24, 13, 11, 26, 0, time.UTC),
+		}
+		pubAuditEvent := auth.AuditEvent{
+			ActorID:    2,
+			Action:     auth.AuditActionSuspendUser,
+			UserID:     1,
+			Details:    "spam",
+			OccurredAt: time.Date(2021, 7, 27, 13, 11, 26, 0, time.UTC),
 		}
 		now := time.Now()
-		// publish event
+		// publish events
 		require.Nil(t, c.Subject.PublishUserSignupEvent(context.Background(), pubSignupEvent))
+		require.Nil(t, c.Subject.PublishProfileUpdatedEvent(context.Background(), pubProfileUpdatedEvent))
+		require.Nil(t, c.Subject.PublishPasswordChangedEvent(context.Background(), pubPasswordChangedEvent))
+		require.Nil(t, c.Subject.PublishEmailChangedEvent(context.Background(), pubEmailChangedEvent))
+		require.Nil(t, c.Subject.PublishEmailVerifiedEvent(context.Background(), pubEmailVerifiedEvent))
+		require.Nil(t, c.Subject.PublishAccountLockedEvent(context.Background(), pubAccountLockedEvent))
+		require.Nil(t, c.Subject.PublishUserDeactivatedEvent(context.Background(), pubUserDeactivatedEvent))
+		require.Nil(t, c.Subject.PublishUserReactivatedEvent(context.Background(), pubUserReactivatedEvent))
+		require.Nil(t, c.Subject.PublishUserDeletedEvent(context.Background(), pubUserDeletedEvent))
+		require.Nil(t, c.Subject.PublishAuditEvent(context.Background(), pubAuditEvent))
 
+		var mu sync.Mutex
 		var consumedEvent auth.ConsumedSignupEvent
+		var consumedProfileUpdatedEvent auth.ConsumedProfileUpdatedEvent
+		var consumedPasswordChangedEvent auth.ConsumedPasswordChangedEvent
+		var consumedEmailChangedEvent auth.ConsumedEmailChangedEvent
 		// start consumer
 		c.Subject.RegisterUserSignupEventConsumer(context.Background(), func(event auth.ConsumedSignupEvent) {
+			mu.Lock()
+			defer mu.Unlock()
 			consumedEvent = event
 		})
+		c.Subject.RegisterProfileUpdatedEventConsumer(context.Background(), func(event auth.ConsumedProfileUpdatedEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			consumedProfileUpdatedEvent = event
+		})
+		c.Subject.RegisterPasswordChangedEventConsumer(context.Background(), func(event auth.ConsumedPasswordChangedEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			consumedPasswordChangedEvent = event
+		})
+		c.Subject.RegisterEmailChangedEventConsumer(context.Background(), func(event auth.ConsumedEmailChangedEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			consumedEmailChangedEvent = event
+		})
+		var consumedEmailVerifiedEvent auth.ConsumedEmailVerifiedEvent
+		c.Subject.RegisterEmailVerifiedEventConsumer(context.Background(), func(event auth.ConsumedEmailVerifiedEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			consumedEmailVerifiedEvent = event
+		})
+		var consumedAccountLockedEvent auth.ConsumedAccountLockedEvent
+		c.Subject.RegisterAccountLockedEventConsumer(context.Background(), func(event auth.ConsumedAccountLockedEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			consumedAccountLockedEvent = event
+		})
+		var consumedUserDeactivatedEvent auth.ConsumedUserDeactivatedEvent
+		c.Subject.RegisterUserDeactivatedEventConsumer(context.Background(), func(event auth.ConsumedUserDeactivatedEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			consumedUserDeactivatedEvent = event
+		})
+		var consumedUserReactivatedEvent auth.ConsumedUserReactivatedEvent
+		c.Subject.RegisterUserReactivatedEventConsumer(context.Background(), func(event auth.ConsumedUserReactivatedEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			consumedUserReactivatedEvent = event
+		})
+		var consumedUserDeletedEvent auth.ConsumedUserDeletedEvent
+		c.Subject.RegisterUserDeletedEventConsumer(context.Background(), func(event auth.ConsumedUserDeletedEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			consumedUserDeletedEvent = event
+		})
+		var consumedAuditEvent auth.ConsumedAuditEvent
+		c.Subject.RegisterAuditEventConsumer(context.Background(), func(event auth.ConsumedAuditEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			consumedAuditEvent = event
+		})
 		consumer := c.Subject.StartConsume(context.Background())
 		t.Cleanup(func() {
 			require.Nil(t, consumer.Close())
@@ -46,12 +158,31 @@ func (c EventProducerConsumerContract) Test(t *testing.T) {
 
 		r := testcase.Retry{Strategy: testcase.Waiter{WaitTimeout: 10 * time.Second, WaitDuration: time.Second}}
 		r.Assert(t, func(tb testing.TB) {
-			if consumedEvent == nil {
+			mu.Lock()
+			defer mu.Unlock()
+			if consumedEvent == nil || consumedProfileUpdatedEvent == nil ||
+				consumedPasswordChangedEvent == nil || consumedEmailChangedEvent == nil ||
+				consumedEmailVerifiedEvent == nil ||
+				consumedAccountLockedEvent == nil ||
+				consumedUserDeactivatedEvent == nil ||
+				consumedUserReactivatedEvent == nil ||
+				consumedUserDeletedEvent == nil ||
+				consumedAuditEvent == nil {
 				tb.Fail()
 				return
 			}
 			require.Equal(tb, pubSignupEvent, consumedEvent.SignupEvent())
 			require.InDelta(t, now.Second(), consumedEvent.Timestamp().Second(), float64(5*time.Second))
+			require.Equal(tb, pubProfileUpdatedEvent, consumedProfileUpdatedEvent.ProfileUpdatedEvent())
+			require.InDelta(t, now.Second(), consumedProfileUpdatedEvent.Timestamp().Second(), float64(5*time.Second))
+			require.Equal(tb, pubPasswordChangedEvent, consumedPasswordChangedEvent.PasswordChangedEvent())
+			require.Equal(tb, pubEmailChangedEvent, consumedEmailChangedEvent.EmailChangedEvent())
+			require.Equal(tb, pubEmailVerifiedEvent, consumedEmailVerifiedEvent.EmailVerifiedEvent())
+			require.Equal(tb, pubAccountLockedEvent, consumedAccountLockedEvent.AccountLockedEvent())
+			require.Equal(tb, pubUserDeactivatedEvent, consumedUserDeactivatedEvent.UserDeactivatedEvent())
+			require.Equal(tb, pubUserReactivatedEvent, consumedUserReactivatedEvent.UserReactivatedEvent())
+			require.Equal(tb, pubUserDeletedEvent, consumedUserDeletedEvent.UserDeletedEvent())
+			require.Equal(tb, pubAuditEvent, consumedAuditEvent.AuditEvent())
 		})
 	})
 }
@@ -0,0 +1,90 @@
+package contracts
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/stretchr/testify/require"
+)
+
+type LoginAttemptStoreContract struct {
+	Subject auth.LoginAttemptStore
+	// SetClock is set for the stores that expire the keys with their own clock, e.g. redis.
+	// The expiry tests set it to the time of their calls, and don't run in parallel with the other tests
+	SetClock func(now time.Time)
+}
+
+func (c LoginAttemptStoreContract) Test(t *testing.T) {
+	uniqueKey := func() string {
+		return fmt.Sprintf("test:%X", rand.Int63())
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	t.Run(`#RecordLoginFailure counts the failures, and #LoginAttempts returns them`, func(t *testing.T) {
+		t.Parallel()
+		key := uniqueKey()
+		attempts, err := c.Subject.LoginAttempts(context.Background(), key, now)
+		require.Nil(t, err)
+		require.Equal(t, auth.LoginAttempts{}, attempts)
+
+		_, err = c.Subject.RecordLoginFailure(context.Background(), key, now, time.Hour)
+		require.Nil(t, err)
+		attempts, err = c.Subject.RecordLoginFailure(context.Background(), key, now.Add(time.Second), time.Hour)
+		require.Nil(t, err)
+		require.Equal(t, auth.LoginAttempts{Failures: 2, LastFailureAt: now.Add(time.Second)}, attempts)
+
+		found, err := c.Subject.LoginAttempts(context.Background(), key, now.Add(time.Second))
+		require.Nil(t, err)
+		require.Equal(t, attempts, found)
+	})
+
+	t.Run(`#LockLogin sets LockedUntil, and #ResetLoginAttempts forgets everything`, func(t *testing.T) {
+		t.Parallel()
+		key := uniqueKey()
+		_, err := c.Subject.RecordLoginFailure(context.Background(), key, now, time.Hour)
+		require.Nil(t, err)
+		require.Nil(t, c.Subject.LockLogin(context.Background(), key, now.Add(2*time.Hour)))
+
+		attempts, err := c.Subject.LoginAttempts(context.Background(), key, now)
+		require.Nil(t, err)
+		require.Equal(t, now.Add(2*time.Hour), attempts.LockedUntil)
+
+		require.Nil(t, c.Subject.ResetLoginAttempts(context.Background(), key))
+		attempts, err = c.Subject.LoginAttempts(context.Background(), key, now)
+		require.Nil(t, err)
+		require.Equal(t, auth.LoginAttempts{}, attempts)
+	})
+
+	t.Run(`keys expire after the ttl of the last failure, or when they are unlocked`, func(t *testing.T) {
+		at := func(now time.Time) time.Time {
+			if c.SetClock != nil {
+				c.SetClock(now)
+			}
+			return now
+		}
+		if c.SetClock == nil {
+			t.Parallel()
+		} else {
+			defer at(now)
+		}
+		key := uniqueKey()
+		_, err := c.Subject.RecordLoginFailure(context.Background(), key, at(now), time.Hour)
+		require.Nil(t, err)
+		require.Nil(t, c.Subject.LockLogin(context.Background(), key, now.Add(2*time.Hour)))
+
+		attempts, err := c.Subject.LoginAttempts(context.Background(), key, at(now.Add(90*time.Minute)))
+		require.Nil(t, err)
+		require.Equal(t, 1, attempts.Failures, "lock should extend the expiry")
+		attempts, err = c.Subject.LoginAttempts(context.Background(), key, at(now.Add(2*time.Hour)))
+		require.Nil(t, err)
+		require.Equal(t, auth.LoginAttempts{}, attempts)
+
+		attempts, err = c.Subject.RecordLoginFailure(context.Background(), key, at(now.Add(3*time.Hour)), time.Hour)
+		require.Nil(t, err)
+		require.Equal(t, auth.LoginAttempts{Failures: 1, LastFailureAt: now.Add(3 * time.Hour)}, attempts, "expired failures should not be counted")
+	})
+}
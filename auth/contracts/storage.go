@@ -2,7 +2,11 @@ package contracts
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/davudsafarli/twitter/auth"
 	"github.com/davudsafarli/twitter/auth/test_helpers"
@@ -35,13 +39,641 @@ func (c AuthStorageContract) Test(t *testing.T) {
 		require.Nil(t, err)
 		require.Equal(t, foundUser, createdUser)
 
+		foundUser, err = c.Subject.FindUserByEmail(context.Background(), createdUser.Email)
+		require.Nil(t, err)
+		require.Equal(t, foundUser, createdUser)
+
 	})
 
 	t.Run(`#FindUser returns error if such user doesn't exist`, func(t *testing.T) {
 		t.Parallel()
 		foundUser, err := c.Subject.FindUser(context.Background(), `username-that-hopefully-doesnt-exist`)
-		require.NotNil(t, err)
+		require.ErrorIs(t, err, auth.ErrUserNotFound)
 		require.Equal(t, foundUser, auth.User{})
+		_, err = c.Subject.FindUserByEmail(context.Background(), `email-that-hopefully-doesnt-exist`)
+		require.ErrorIs(t, err, auth.ErrUserNotFound)
+	})
+
+	t.Run(`#UpdateProfile changes only the given fields, and #FindUserByID returns them`, func(t *testing.T) {
+		t.Parallel()
+		user := test_helpers.HopefullyUniqueUser()
+		user.Location = "Baku"
+		createdUser, err := c.Subject.CreateUser(context.Background(), user)
+		require.Nil(t, err)
+		defer func() {
+			require.Nil(t, c.Subject.DeleteUser(context.Background(), createdUser.ID))
+		}()
+		require.Equal(t, "Baku", createdUser.Location)
+		require.False(t, createdUser.CreatedAt.IsZero())
 
+		bio, website := "bio", "https://example.com"
+		updatedUser, err := c.Subject.UpdateProfile(context.Background(), createdUser.ID, auth.ProfileUpdate{
+			Bio:     &bio,
+			Website: &website,
+		})
+		require.Nil(t, err)
+		require.Equal(t, "bio", updatedUser.Bio)
+		require.Equal(t, "https://example.com", updatedUser.Website)
+		require.Equal(t, "Baku", updatedUser.Location, "nil fields should not change")
+		require.False(t, updatedUser.UpdatedAt.Before(createdUser.UpdatedAt))
+
+		foundUser, err := c.Subject.FindUserByID(context.Background(), createdUser.ID)
+		require.Nil(t, err)
+		require.Equal(t, updatedUser, foundUser)
+	})
+
+	t.Run(`#FindUserByID and #UpdateProfile return ErrUserNotFound if such user doesn't exist`, func(t *testing.T) {
+		t.Parallel()
+		_, err := c.Subject.FindUserByID(context.Background(), -1)
+		require.ErrorIs(t, err, auth.ErrUserNotFound)
+		bio := "bio"
+		_, err = c.Subject.UpdateProfile(context.Background(), -1, auth.ProfileUpdate{Bio: &bio})
+		require.ErrorIs(t, err, auth.ErrUserNotFound)
 	})
+
+	t.Run(`#CreateUser returns ErrUserAlreadyExists if the username is taken`, func(t *testing.T) {
+		t.Parallel()
+		user := test_helpers.HopefullyUniqueUser()
+		createdUser, err := c.Subject.CreateUser(context.Background(), user)
+		require.Nil(t, err)
+		defer func() {
+			require.Nil(t, c.Subject.DeleteUser(context.Background(), createdUser.ID))
+		}()
+
+		sameUsername := test_helpers.HopefullyUniqueUser()
+		sameUsername.Username = user.Username
+		_, err = c.Subject.CreateUser(context.Background(), sameUsername)
+		require.ErrorIs(t, err, auth.ErrUserAlreadyExists)
+
+	})
+
+	t.Run(`#UpdatePassword, #UpdateEmail and #RevokeSessions change the user`, func(t *testing.T) {
+		t.Parallel()
+		createdUser, err := c.Subject.CreateUser(context.Background(), test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		defer func() {
+			require.Nil(t, c.Subject.DeleteUser(context.Background(), createdUser.ID))
+		}()
+		other, err := c.Subject.CreateUser(context.Background(), test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		defer func() {
+			require.Nil(t, c.Subject.DeleteUser(context.Background(), other.ID))
+		}()
+
+		require.Nil(t, c.Subject.UpdatePassword(context.Background(), createdUser.ID, "new-hash"))
+		newEmail := test_helpers.HopefullyUniqueUser().Email
+		verifiedAt := time.Now().UTC().Truncate(time.Millisecond)
+		require.Nil(t, c.Subject.UpdateEmail(context.Background(), createdUser.ID, newEmail, verifiedAt))
+		require.ErrorIs(t, c.Subject.UpdateEmail(context.Background(), createdUser.ID, other.Email, time.Time{}), auth.ErrUserAlreadyExists)
+		require.Nil(t, c.Subject.RevokeSessions(context.Background(), createdUser.ID))
+
+		foundUser, err := c.Subject.FindUserByID(context.Background(), createdUser.ID)
+		require.Nil(t, err)
+		require.Equal(t, "new-hash", foundUser.Password)
+		require.Equal(t, newEmail, foundUser.Email)
+		require.Equal(t, verifiedAt, foundUser.EmailVerifiedAt)
+		require.Equal(t, createdUser.TokenVersion+1, foundUser.TokenVersion)
+
+		require.ErrorIs(t, c.Subject.UpdatePassword(context.Background(), -1, "hash"), auth.ErrUserNotFound)
+		require.ErrorIs(t, c.Subject.RevokeSessions(context.Background(), -1), auth.ErrUserNotFound)
+	})
+
+	t.Run(`#SetEmailVerified verifies only the current email of the user`, func(t *testing.T) {
+		t.Parallel()
+		createdUser, err := c.Subject.CreateUser(context.Background(), test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		defer func() {
+			require.Nil(t, c.Subject.DeleteUser(context.Background(), createdUser.ID))
+		}()
+		require.True(t, createdUser.EmailVerifiedAt.IsZero(), "new users should not be verified")
+
+		at := time.Now().UTC().Truncate(time.Millisecond)
+		err = c.Subject.SetEmailVerified(context.Background(), createdUser.ID, "old@example.com", at)
+		require.ErrorIs(t, err, auth.ErrUserNotFound)
+		require.Nil(t, c.Subject.SetEmailVerified(context.Background(), createdUser.ID, createdUser.Email, at))
+
+		foundUser, err := c.Subject.FindUserByID(context.Background(), createdUser.ID)
+		require.Nil(t, err)
+		require.Equal(t, at, foundUser.EmailVerifiedAt)
+	})
+
+	t.Run(`#ConsumeOneTimeToken returns a token only once, before it expires`, func(t *testing.T) {
+		t.Parallel()
+		createdUser, err := c.Subject.CreateUser(context.Background(), test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		defer func() {
+			require.Nil(t, c.Subject.DeleteUser(context.Background(), createdUser.ID))
+		}()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		token := auth.OneTimeToken{
+			Hash:      fmt.Sprintf("%064x", createdUser.ID),
+			Purpose:   auth.TokenPurposeEmailChange,
+			UserID:    createdUser.ID,
+			Data:      "new@example.com",
+			ExpiresAt: now.Add(time.Hour),
+		}
+		require.Nil(t, c.Subject.CreateOneTimeToken(context.Background(), token))
+
+		_, err = c.Subject.ConsumeOneTimeToken(context.Background(), "other_purpose", token.Hash, now)
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+		_, err = c.Subject.ConsumeOneTimeToken(context.Background(), token.Purpose, token.Hash, token.ExpiresAt)
+		require.ErrorIs(t, err, auth.ErrInvalidToken, "expired token should not be consumed")
+
+		consumed, err := c.Subject.ConsumeOneTimeToken(context.Background(), token.Purpose, token.Hash, now)
+		require.Nil(t, err)
+		require.Equal(t, token, consumed)
+		_, err = c.Subject.ConsumeOneTimeToken(context.Background(), token.Purpose, token.Hash, now)
+		require.ErrorIs(t, err, auth.ErrInvalidToken, "token should be consumed only once")
+	})
+	t.Run(`#SaveTOTP + #FindTOTP: steps and recovery codes can be used only once, until the enrolment is deleted`, func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		createdUser, err := c.Subject.CreateUser(ctx, test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		defer func() {
+			require.Nil(t, c.Subject.DeleteUser(ctx, createdUser.ID))
+		}()
+		_, err = c.Subject.FindTOTP(ctx, createdUser.ID)
+		require.ErrorIs(t, err, auth.ErrMFANotEnrolled)
+		require.ErrorIs(t, c.Subject.ConfirmTOTP(ctx, createdUser.ID, time.Now()), auth.ErrMFANotEnrolled)
+
+		codes := []string{fmt.Sprintf("%064x", 1), fmt.Sprintf("%064x", 2)}
+		totp := auth.TOTP{UserID: createdUser.ID, EncryptedSecret: []byte("old secret")}
+		require.Nil(t, c.Subject.SaveTOTP(ctx, totp, []string{fmt.Sprintf("%064x", 3)}))
+		totp.EncryptedSecret = []byte("secret")
+		require.Nil(t, c.Subject.SaveTOTP(ctx, totp, codes))
+		found, err := c.Subject.FindTOTP(ctx, createdUser.ID)
+		require.Nil(t, err)
+		require.Equal(t, totp, found)
+
+		confirmedAt := time.Now().UTC().Truncate(time.Millisecond)
+		require.Nil(t, c.Subject.ConfirmTOTP(ctx, createdUser.ID, confirmedAt))
+		require.Nil(t, c.Subject.UseTOTPStep(ctx, createdUser.ID, 42))
+		require.ErrorIs(t, c.Subject.UseTOTPStep(ctx, createdUser.ID, 42), auth.ErrInvalidCredentials, "step should be used only once")
+		require.ErrorIs(t, c.Subject.UseTOTPStep(ctx, createdUser.ID, 41), auth.ErrInvalidCredentials, "earlier steps should be rejected")
+		found, err = c.Subject.FindTOTP(ctx, createdUser.ID)
+		require.Nil(t, err)
+		require.True(t, confirmedAt.Equal(found.ConfirmedAt))
+		require.Equal(t, int64(42), found.LastUsedStep)
+
+		require.ErrorIs(t, c.Subject.UseRecoveryCode(ctx, createdUser.ID, fmt.Sprintf("%064x", 3), confirmedAt), auth.ErrInvalidCredentials, "codes of the replaced enrolment should be removed")
+		require.Nil(t, c.Subject.UseRecoveryCode(ctx, createdUser.ID, codes[0], confirmedAt))
+		require.ErrorIs(t, c.Subject.UseRecoveryCode(ctx, createdUser.ID, codes[0], confirmedAt), auth.ErrInvalidCredentials, "code should be used only once")
+
+		require.Nil(t, c.Subject.DeleteTOTP(ctx, createdUser.ID))
+		_, err = c.Subject.FindTOTP(ctx, createdUser.ID)
+		require.ErrorIs(t, err, auth.ErrMFANotEnrolled)
+		require.ErrorIs(t, c.Subject.UseRecoveryCode(ctx, createdUser.ID, codes[1], confirmedAt), auth.ErrInvalidCredentials)
+	})
+	t.Run(`#SetDeactivation + #UsersToPurge: users are purged after their DeleteAfter`, func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		var users []auth.User
+		for _, deleteAfter := range []time.Time{now, now.Add(time.Hour), {}} {
+			createdUser, err := c.Subject.CreateUser(ctx, test_helpers.HopefullyUniqueUser())
+			require.Nil(t, err)
+			defer func() {
+				require.Nil(t, c.Subject.DeleteUser(ctx, createdUser.ID))
+			}()
+			require.Nil(t, c.Subject.SetDeactivation(ctx, createdUser.ID, now, deleteAfter))
+			users = append(users, createdUser)
+		}
+		found, err := c.Subject.FindUserByID(ctx, users[0].ID)
+		require.Nil(t, err)
+		require.True(t, now.Equal(found.DeactivatedAt))
+		require.True(t, now.Equal(found.DeleteAfter))
+
+		purged, err := c.Subject.UsersToPurge(ctx, now, 1000)
+		require.Nil(t, err)
+		ids := map[int]bool{}
+		for _, u := range purged {
+			ids[u.ID] = true
+		}
+		require.True(t, ids[users[0].ID])
+		require.False(t, ids[users[1].ID], "user should be purged after DeleteAfter")
+		require.False(t, ids[users[2].ID], "deactivated users should not be purged")
+
+		require.Nil(t, c.Subject.SetDeactivation(ctx, users[0].ID, time.Time{}, time.Time{}))
+		found, err = c.Subject.FindUserByID(ctx, users[0].ID)
+		require.Nil(t, err)
+		require.True(t, found.DeactivatedAt.IsZero())
+		require.True(t, found.DeleteAfter.IsZero())
+		require.ErrorIs(t, c.Subject.SetDeactivation(ctx, 0, now, now), auth.ErrUserNotFound)
+	})
+	t.Run(`#PurgeUser deletes only the due users, and only if beforeDelete succeeds`, func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		createdUser, err := c.Subject.CreateUser(ctx, test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		defer func() {
+			require.Nil(t, c.Subject.DeleteUser(ctx, createdUser.ID))
+		}()
+		called := 0
+		beforeDelete := func(context.Context) error {
+			called++
+			return nil
+		}
+
+		require.ErrorIs(t, c.Subject.PurgeUser(ctx, createdUser.ID, now, beforeDelete), auth.ErrUserNotFound)
+		require.Nil(t, c.Subject.SetDeactivation(ctx, createdUser.ID, now, now.Add(time.Hour)))
+		require.ErrorIs(t, c.Subject.PurgeUser(ctx, createdUser.ID, now, beforeDelete), auth.ErrUserNotFound)
+		require.Equal(t, 0, called, "beforeDelete should not be called for the users that aren't due")
+
+		later := now.Add(time.Hour)
+		failure := errors.New("publishing failed")
+		err = c.Subject.PurgeUser(ctx, createdUser.ID, later, func(context.Context) error { return failure })
+		require.ErrorIs(t, err, failure)
+		_, err = c.Subject.FindUserByID(ctx, createdUser.ID)
+		require.Nil(t, err, "the user should be kept if beforeDelete fails")
+
+		require.Nil(t, c.Subject.PurgeUser(ctx, createdUser.ID, later, beforeDelete))
+		require.Equal(t, 1, called)
+		_, err = c.Subject.FindUserByID(ctx, createdUser.ID)
+		require.ErrorIs(t, err, auth.ErrUserNotFound)
+	})
+	t.Run(`#AppendUserEvent + #ListUserEvents: events are listed in order, and deleted with the user`, func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		createdUser, err := c.Subject.CreateUser(ctx, test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		records := []auth.UserEventRecord{
+			{UserID: createdUser.ID, Type: "Signup", Payload: []byte(`{"ID":1}`), PublishedAt: now},
+			{UserID: createdUser.ID, Type: "EmailVerified", Payload: []byte(`{"UserID":1}`), PublishedAt: now.Add(time.Second)},
+		}
+		for _, r := range records {
+			require.Nil(t, c.Subject.AppendUserEvent(ctx, r))
+		}
+		require.ErrorIs(t, c.Subject.AppendUserEvent(ctx, auth.UserEventRecord{Type: "Signup", Payload: []byte(`{}`), PublishedAt: now}), auth.ErrUserNotFound)
+
+		found, err := c.Subject.ListUserEvents(ctx, createdUser.ID)
+		require.Nil(t, err)
+		require.Len(t, found, len(records))
+		for i, r := range records {
+			require.Equal(t, r.Type, found[i].Type)
+			require.JSONEq(t, string(r.Payload), string(found[i].Payload))
+			require.True(t, r.PublishedAt.Equal(found[i].PublishedAt))
+		}
+
+		require.Nil(t, c.Subject.DeleteUser(ctx, createdUser.ID))
+		found, err = c.Subject.ListUserEvents(ctx, createdUser.ID)
+		require.Nil(t, err)
+		require.Empty(t, found)
+	})
+	t.Run(`#CreateDataExport + #ClaimDataExport: an export is claimed once, unless its build is stale`, func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		createdUser, err := c.Subject.CreateUser(ctx, test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		defer func() {
+			require.Nil(t, c.Subject.DeleteUser(ctx, createdUser.ID))
+		}()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		export := auth.DataExport{
+			ID:        fmt.Sprintf("%032x", time.Now().UnixNano()),
+			UserID:    createdUser.ID,
+			Status:    auth.DataExportPending,
+			CreatedAt: now,
+		}
+		require.Nil(t, c.Subject.CreateDataExport(ctx, export))
+		require.ErrorIs(t, c.Subject.CreateDataExport(ctx, auth.DataExport{ID: "missing-user", Status: auth.DataExportPending, CreatedAt: now}), auth.ErrUserNotFound)
+		_, err = c.Subject.FindDataExport(ctx, "missing")
+		require.ErrorIs(t, err, auth.ErrDataExportNotFound)
+
+		// other exports may be pending in a shared database, so claim until this one is found
+		claim := func(now, staleBefore time.Time) (auth.DataExport, bool) {
+			for {
+				claimed, err := c.Subject.ClaimDataExport(ctx, now, staleBefore)
+				if errors.Is(err, auth.ErrDataExportNotFound) {
+					return auth.DataExport{}, false
+				}
+				require.Nil(t, err)
+				if claimed.ID == export.ID {
+					return claimed, true
+				}
+			}
+		}
+		claimed, ok := claim(now, now.Add(-time.Hour))
+		require.True(t, ok)
+		require.Equal(t, auth.DataExportBuilding, claimed.Status)
+		require.True(t, now.Equal(claimed.StartedAt))
+		_, ok = claim(now, now.Add(-time.Hour))
+		require.False(t, ok, "export should be claimed only once")
+		claimed, ok = claim(now.Add(time.Hour), now.Add(time.Millisecond))
+		require.True(t, ok, "stale build should be claimed again")
+
+		claimed.Status = auth.DataExportReady
+		claimed.BlobKey = "exports/" + export.ID + ".zip"
+		claimed.CompletedAt = now
+		claimed.ExpiresAt = now.Add(time.Hour)
+		require.Nil(t, c.Subject.UpdateDataExport(ctx, claimed))
+		found, err := c.Subject.FindDataExport(ctx, export.ID)
+		require.Nil(t, err)
+		require.Equal(t, claimed.BlobKey, found.BlobKey)
+		require.Equal(t, auth.DataExportReady, found.Status)
+		require.True(t, claimed.ExpiresAt.Equal(found.ExpiresAt))
+		_, ok = claim(now.Add(2*time.Hour), now.Add(2*time.Hour))
+		require.False(t, ok, "ready export should not be claimed")
+
+		listed, err := c.Subject.ListDataExports(ctx, createdUser.ID)
+		require.Nil(t, err)
+		require.Len(t, listed, 1)
+		expired, err := c.Subject.ExpiredDataExports(ctx, now.Add(time.Hour), 1000)
+		require.Nil(t, err)
+		ids := map[string]bool{}
+		for _, e := range expired {
+			ids[e.ID] = true
+		}
+		require.True(t, ids[export.ID])
+
+		require.Nil(t, c.Subject.DeleteDataExport(ctx, export.ID))
+		require.ErrorIs(t, c.Subject.UpdateDataExport(ctx, claimed), auth.ErrDataExportNotFound)
+	})
+	t.Run(`#CreateSession + #RevokeSession: revoked and expired sessions are not listed`, func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		createdUser, err := c.Subject.CreateUser(ctx, test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		defer func() {
+			require.Nil(t, c.Subject.DeleteUser(ctx, createdUser.ID))
+		}()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		var sessions []auth.Session
+		for i, expiresAt := range []time.Time{now.Add(time.Hour), now.Add(time.Hour), now} {
+			session := auth.Session{
+				ID:        fmt.Sprintf("%016x%016x", createdUser.ID, i),
+				UserID:    createdUser.ID,
+				IP:        "203.0.113.7",
+				UserAgent: "test-agent",
+				CreatedAt: now.Add(time.Duration(i) * time.Second),
+				StartedAt: now.Add(-time.Hour),
+				ExpiresAt: expiresAt,
+			}
+			require.Nil(t, c.Subject.CreateSession(ctx, session))
+			sessions = append(sessions, session)
+		}
+		require.ErrorIs(t, c.Subject.CreateSession(ctx, auth.Session{ID: "missing-user", CreatedAt: now, ExpiresAt: now}), auth.ErrUserNotFound)
+		found, err := c.Subject.FindSession(ctx, sessions[0].ID)
+		require.Nil(t, err)
+		require.Equal(t, sessions[0], found)
+		_, err = c.Subject.FindSession(ctx, "missing")
+		require.ErrorIs(t, err, auth.ErrSessionNotFound)
+
+		listed, err := c.Subject.ListSessions(ctx, createdUser.ID, now)
+		require.Nil(t, err)
+		require.Equal(t, []auth.Session{sessions[1], sessions[0]}, listed, "expired session should not be listed")
+
+		require.ErrorIs(t, c.Subject.RevokeSession(ctx, createdUser.ID+1, sessions[0].ID, now), auth.ErrSessionNotFound, "sessions of others should not be revoked")
+		require.Nil(t, c.Subject.RevokeSession(ctx, createdUser.ID, sessions[0].ID, now))
+		require.ErrorIs(t, c.Subject.RevokeSession(ctx, createdUser.ID, sessions[0].ID, now), auth.ErrSessionNotFound)
+		found, err = c.Subject.FindSession(ctx, sessions[0].ID)
+		require.Nil(t, err)
+		require.True(t, now.Equal(found.RevokedAt))
+		listed, err = c.Subject.ListSessions(ctx, createdUser.ID, now)
+		require.Nil(t, err)
+		require.Equal(t, []auth.Session{sessions[1]}, listed)
+
+		require.Nil(t, c.Subject.RevokeSessions(ctx, createdUser.ID))
+		listed, err = c.Subject.ListSessions(ctx, createdUser.ID, now)
+		require.Nil(t, err)
+		require.Empty(t, listed, "#RevokeSessions should revoke all sessions")
+	})
+	t.Run(`#RecordLoginEvent + #ListLoginEvents: events are listed from the newest`, func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		createdUser, err := c.Subject.CreateUser(ctx, test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		defer func() {
+			require.Nil(t, c.Subject.DeleteUser(ctx, createdUser.ID))
+		}()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		var events []auth.LoginEvent
+		for i, outcome := range []auth.Outcome{auth.OutcomeInvalidCredentials, auth.OutcomeMFARequired, auth.OutcomeSuccess} {
+			event := auth.LoginEvent{
+				UserID:    createdUser.ID,
+				IP:        "203.0.113.7",
+				UserAgent: "test-agent",
+				Outcome:   outcome,
+				CreatedAt: now.Add(time.Duration(i) * time.Second),
+			}
+			require.Nil(t, c.Subject.RecordLoginEvent(ctx, event))
+			events = append(events, event)
+		}
+		require.ErrorIs(t, c.Subject.RecordLoginEvent(ctx, auth.LoginEvent{Outcome: auth.OutcomeSuccess, CreatedAt: now}), auth.ErrUserNotFound)
+
+		listed, err := c.Subject.ListLoginEvents(ctx, createdUser.ID, 2)
+		require.Nil(t, err)
+		require.Equal(t, []auth.LoginEvent{events[2], events[1]}, listed)
+		listed, err = c.Subject.ListLoginEvents(ctx, createdUser.ID, 0)
+		require.Nil(t, err)
+		require.Len(t, listed, 3)
+	})
+	t.Run(`#CreateAPIKey + #RevokeAPIKey: keys are found by their hash, and revoked keys are not listed`, func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		createdUser, err := c.Subject.CreateUser(ctx, test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		defer func() {
+			require.Nil(t, c.Subject.DeleteUser(ctx, createdUser.ID))
+		}()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		var keys []auth.APIKey
+		for i, scopes := range [][]auth.Permission{nil, {auth.PermissionReadUsers, auth.PermissionSuspendUsers}} {
+			key := auth.APIKey{
+				ID:        fmt.Sprintf("%016x%016x", createdUser.ID, i),
+				UserID:    createdUser.ID,
+				Name:      fmt.Sprintf("key %d", i),
+				Prefix:    "twk_abcdef",
+				Hash:      fmt.Sprintf("%032x%032x", createdUser.ID, i),
+				Scopes:    scopes,
+				CreatedAt: now.Add(time.Duration(i) * time.Second),
+				ExpiresAt: now.Add(time.Hour),
+			}
+			require.Nil(t, c.Subject.CreateAPIKey(ctx, key))
+			keys = append(keys, key)
+		}
+		require.ErrorIs(t, c.Subject.CreateAPIKey(ctx, auth.APIKey{ID: "missing-user", Hash: "missing-user", CreatedAt: now, ExpiresAt: now}), auth.ErrUserNotFound)
+		found, err := c.Subject.FindAPIKeyByHash(ctx, keys[1].Hash)
+		require.Nil(t, err)
+		require.Equal(t, keys[1], found)
+		_, err = c.Subject.FindAPIKeyByHash(ctx, "missing")
+		require.ErrorIs(t, err, auth.ErrAPIKeyNotFound)
+
+		require.Nil(t, c.Subject.TouchAPIKey(ctx, keys[0].ID, now.Add(time.Minute)))
+		keys[0].LastUsedAt = now.Add(time.Minute)
+		listed, err := c.Subject.ListAPIKeys(ctx, createdUser.ID)
+		require.Nil(t, err)
+		require.Equal(t, []auth.APIKey{keys[1], keys[0]}, listed)
+
+		require.ErrorIs(t, c.Subject.RevokeAPIKey(ctx, createdUser.ID+1, keys[0].ID, now), auth.ErrAPIKeyNotFound, "keys of others should not be revoked")
+		require.Nil(t, c.Subject.RevokeAPIKey(ctx, createdUser.ID, keys[0].ID, now))
+		require.ErrorIs(t, c.Subject.RevokeAPIKey(ctx, createdUser.ID, keys[0].ID, now), auth.ErrAPIKeyNotFound)
+		found, err = c.Subject.FindAPIKeyByHash(ctx, keys[0].Hash)
+		require.Nil(t, err)
+		require.True(t, now.Equal(found.RevokedAt))
+		listed, err = c.Subject.ListAPIKeys(ctx, createdUser.ID)
+		require.Nil(t, err)
+		require.Equal(t, []auth.APIKey{keys[1]}, listed)
+	})
+	t.Run(`#CreateOIDCState + #ConsumeOIDCState: a state is consumed once, before it expires`, func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		createdUser, err := c.Subject.CreateUser(ctx, test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		defer func() {
+			require.Nil(t, c.Subject.DeleteUser(ctx, createdUser.ID))
+		}()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		login := auth.OIDCState{
+			Hash:      fmt.Sprintf("%032x%032x", createdUser.ID, 1),
+			Provider:  "fake",
+			Verifier:  "verifier",
+			Nonce:     "nonce",
+			ExpiresAt: now.Add(time.Minute),
+		}
+		link := login
+		link.Hash = fmt.Sprintf("%032x%032x", createdUser.ID, 2)
+		link.UserID = createdUser.ID
+		expired := login
+		expired.Hash = fmt.Sprintf("%032x%032x", createdUser.ID, 3)
+		expired.ExpiresAt = now.Add(time.Second)
+		for _, state := range []auth.OIDCState{login, link, expired} {
+			require.Nil(t, c.Subject.CreateOIDCState(ctx, state))
+		}
+		missingUser := login
+		missingUser.Hash = fmt.Sprintf("%032x%032x", createdUser.ID, 4)
+		missingUser.UserID = createdUser.ID + 1000000
+		require.ErrorIs(t, c.Subject.CreateOIDCState(ctx, missingUser), auth.ErrUserNotFound)
+
+		consumed, err := c.Subject.ConsumeOIDCState(ctx, login.Hash, now)
+		require.Nil(t, err)
+		require.Equal(t, login, consumed)
+		_, err = c.Subject.ConsumeOIDCState(ctx, login.Hash, now)
+		require.ErrorIs(t, err, auth.ErrInvalidToken, "a state should be consumed only once")
+		consumed, err = c.Subject.ConsumeOIDCState(ctx, link.Hash, now)
+		require.Nil(t, err)
+		require.Equal(t, link, consumed)
+		_, err = c.Subject.ConsumeOIDCState(ctx, expired.Hash, now.Add(time.Second))
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+	t.Run(`#CreateIdentity + #FindIdentity: a subject is linked to one user, who has one identity per provider`, func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		createdUser, err := c.Subject.CreateUser(ctx, test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		otherUser, err := c.Subject.CreateUser(ctx, test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		defer func() {
+			require.Nil(t, c.Subject.DeleteUser(ctx, createdUser.ID))
+			require.Nil(t, c.Subject.DeleteUser(ctx, otherUser.ID))
+		}()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		subject := fmt.Sprintf("subject-%d", createdUser.ID)
+		github := auth.Identity{UserID: createdUser.ID, Provider: "github", Subject: subject, Email: createdUser.Email, CreatedAt: now}
+		google := auth.Identity{UserID: createdUser.ID, Provider: "google", Subject: subject, Email: createdUser.Email, CreatedAt: now}
+		require.Nil(t, c.Subject.CreateIdentity(ctx, google))
+		require.Nil(t, c.Subject.CreateIdentity(ctx, github))
+		require.ErrorIs(t, c.Subject.CreateIdentity(ctx, auth.Identity{UserID: otherUser.ID, Provider: "google", Subject: subject, CreatedAt: now}),
+			auth.ErrUserAlreadyExists, "a subject should be linked to one user")
+		require.ErrorIs(t, c.Subject.CreateIdentity(ctx, auth.Identity{UserID: createdUser.ID, Provider: "google", Subject: subject + "-2", CreatedAt: now}),
+			auth.ErrUserAlreadyExists, "a user should have one identity per provider")
+		require.ErrorIs(t, c.Subject.CreateIdentity(ctx, auth.Identity{UserID: createdUser.ID + 1000000, Provider: "google", Subject: subject + "-3", CreatedAt: now}),
+			auth.ErrUserNotFound)
+
+		found, err := c.Subject.FindIdentity(ctx, "google", subject)
+		require.Nil(t, err)
+		require.Equal(t, google, found)
+		_, err = c.Subject.FindIdentity(ctx, "google", "missing")
+		require.ErrorIs(t, err, auth.ErrIdentityNotFound)
+		listed, err := c.Subject.ListIdentities(ctx, createdUser.ID)
+		require.Nil(t, err)
+		require.Equal(t, []auth.Identity{github, google}, listed)
+
+		require.ErrorIs(t, c.Subject.DeleteIdentity(ctx, otherUser.ID, "google"), auth.ErrIdentityNotFound)
+		require.Nil(t, c.Subject.DeleteIdentity(ctx, createdUser.ID, "google"))
+		require.ErrorIs(t, c.Subject.DeleteIdentity(ctx, createdUser.ID, "google"), auth.ErrIdentityNotFound)
+		_, err = c.Subject.FindIdentity(ctx, "google", subject)
+		require.ErrorIs(t, err, auth.ErrIdentityNotFound)
+	})
+	t.Run(`#SetRole + #RolePermissions: users have the permissions of their role`, func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		createdUser, err := c.Subject.CreateUser(ctx, test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		defer func() {
+			require.Nil(t, c.Subject.DeleteUser(ctx, createdUser.ID))
+		}()
+		require.Equal(t, auth.RoleUser, createdUser.Role)
+		for role, expected := range auth.DefaultRolePermissions {
+			permissions, err := c.Subject.RolePermissions(ctx, role)
+			require.Nil(t, err)
+			require.ElementsMatch(t, expected, permissions, "permissions of %s", role)
+		}
+		permissions, err := c.Subject.RolePermissions(ctx, "unknown")
+		require.Nil(t, err)
+		require.Empty(t, permissions)
+
+		require.Nil(t, c.Subject.SetRole(ctx, createdUser.ID, auth.RoleModerator))
+		found, err := c.Subject.FindUserByID(ctx, createdUser.ID)
+		require.Nil(t, err)
+		require.Equal(t, auth.RoleModerator, found.Role)
+		require.ErrorIs(t, c.Subject.SetRole(ctx, createdUser.ID, "unknown"), auth.ErrInvalidInput)
+		require.ErrorIs(t, c.Subject.SetRole(ctx, 0, auth.RoleAdmin), auth.ErrUserNotFound)
+	})
+	t.Run(`#SetSuspension + #ListUsers: users are searched by username, email and display name`, func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		tag := fmt.Sprintf("%X", time.Now().UnixNano())
+		var users []auth.User
+		for _, u := range []auth.User{
+			{Email: "a-" + tag + "@example.com", Username: "a-" + tag},
+			{Email: "b-" + tag + "@example.com", Username: "b-" + tag},
+			{Email: "c-" + tag + "@example.com", Username: "c-" + tag},
+		} {
+			createdUser, err := c.Subject.CreateUser(ctx, u)
+			require.Nil(t, err)
+			defer func() {
+				require.Nil(t, c.Subject.DeleteUser(ctx, createdUser.ID))
+			}()
+			users = append(users, createdUser)
+		}
+
+		found, err := c.Subject.ListUsers(ctx, auth.UserQuery{Search: strings.ToLower(tag), Limit: 2})
+		require.Nil(t, err)
+		require.Equal(t, []int{users[0].ID, users[1].ID}, userIDs(found))
+		found, err = c.Subject.ListUsers(ctx, auth.UserQuery{Search: tag, AfterID: users[1].ID, Limit: 2})
+		require.Nil(t, err)
+		require.Equal(t, []int{users[2].ID}, userIDs(found))
+		found, err = c.Subject.ListUsers(ctx, auth.UserQuery{Search: "b-" + tag + "@", Limit: 10})
+		require.Nil(t, err)
+		require.Equal(t, []int{users[1].ID}, userIDs(found))
+		found, err = c.Subject.ListUsers(ctx, auth.UserQuery{Search: "%" + tag, Limit: 10})
+		require.Nil(t, err)
+		require.Empty(t, found, "wildcards should match literally")
+		found, err = c.Subject.ListUsers(ctx, auth.UserQuery{AfterID: users[0].ID - 1, Limit: 1})
+		require.Nil(t, err)
+		require.Equal(t, []int{users[0].ID}, userIDs(found))
+
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		require.Nil(t, c.Subject.SetSuspension(ctx, users[0].ID, now, "spam"))
+		suspended, err := c.Subject.FindUserByID(ctx, users[0].ID)
+		require.Nil(t, err)
+		require.True(t, now.Equal(suspended.SuspendedAt))
+		require.Equal(t, "spam", suspended.SuspensionReason)
+		require.Nil(t, c.Subject.SetSuspension(ctx, users[0].ID, time.Time{}, ""))
+		suspended, err = c.Subject.FindUserByID(ctx, users[0].ID)
+		require.Nil(t, err)
+		require.True(t, suspended.SuspendedAt.IsZero())
+		require.ErrorIs(t, c.Subject.SetSuspension(ctx, 0, now, "spam"), auth.ErrUserNotFound)
+	})
+}
+
+func userIDs(users []auth.User) []int {
+	ids := make([]int, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	return ids
 }
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth/tracing"
+)
+
+// EmailChangeTokenTTL is how long the confirmation token of ChangeEmail is valid
+const EmailChangeTokenTTL = 24 * time.Hour
+
+// ChangePassword replaces the password of a user after checking the current one.
+// The new password must satisfy the PasswordPolicy. All existing sessions and API keys of the user are revoked,
+// so the tokens issued before the change can't be used anymore. It publishes a PasswordChangedEvent
+func (c Usecases) ChangePassword(ctx context.Context, userID int, oldPwd, newPwd string) (err error) {
+	defer c.observe("change_password", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.ChangePassword")
+	defer tracing.EndSpan(span, &err)
+
+	if err := c.passwordPolicy().Validate(newPwd); err != nil {
+		return err
+	}
+	if _, err := c.reauthenticate(ctx, userID, oldPwd); err != nil {
+		return err
+	}
+	return c.setPassword(ctx, userID, newPwd)
+}
+
+// setPassword saves the hash of an already validated password, revokes the sessions and the API keys of the user
+// and publishes a PasswordChangedEvent. The password may have leaked, and the keys with it
+func (c Usecases) setPassword(ctx context.Context, userID int, pwd string) error {
+	hashedPwd, err := c.hashPassword(ctx, pwd)
+	if err != nil {
+		return err
+	}
+	if err := c.Storage.UpdatePassword(ctx, userID, hashedPwd); err != nil {
+		return err
+	}
+	if err := c.Storage.RevokeSessions(ctx, userID); err != nil {
+		return err
+	}
+	if err := c.revokeAPIKeys(ctx, userID); err != nil {
+		return err
+	}
+	return c.events().PublishPasswordChangedEvent(ctx, PasswordChangedEvent{
+		UserID:    userID,
+		ChangedAt: c.now(),
+	})
+}
+
+// ChangeEmail starts changing the email of a user after checking their password.
+// The email is not changed until the token sent to the new address is passed to ConfirmEmailChange
+func (c Usecases) ChangeEmail(ctx context.Context, userID int, pwd, newEmail string) (err error) {
+	defer c.observe("change_email", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.ChangeEmail")
+	defer tracing.EndSpan(span, &err)
+
+	if err := validateEmail(newEmail); err != nil {
+		return err
+	}
+	user, err := c.reauthenticate(ctx, userID, pwd)
+	if err != nil {
+		return err
+	}
+	if user.Email == newEmail {
+		return fmt.Errorf("%w: new email is the same as the current one", ErrInvalidInput)
+	}
+	secret, token, err := newOneTimeToken(TokenPurposeEmailChange, userID, newEmail, c.now().Add(EmailChangeTokenTTL))
+	if err != nil {
+		return err
+	}
+	if err := c.Storage.CreateOneTimeToken(ctx, token); err != nil {
+		return err
+	}
+	return c.mailer().Send(ctx, Email{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the code below to confirm your new email address. It expires in %v.\n\n%s\n",
+			user.Username, EmailChangeTokenTTL, secret),
+	})
+}
+
+// ConfirmEmailChange sets the email of the user to the address the token was sent to, and marks it as verified.
+// Tokens can be used only once.
+// It returns ErrUserAlreadyExists if the address is taken in the meantime, and publishes an EmailChangedEvent otherwise
+func (c Usecases) ConfirmEmailChange(ctx context.Context, secret string) (err error) {
+	defer c.observe("confirm_email_change", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.ConfirmEmailChange")
+	defer tracing.EndSpan(span, &err)
+
+	token, err := c.Storage.ConsumeOneTimeToken(ctx, TokenPurposeEmailChange, hashOneTimeToken(secret), c.now())
+	if err != nil {
+		return err
+	}
+	// the new address is verified, since the token was sent to it
+	if err := c.Storage.UpdateEmail(ctx, token.UserID, token.Data, c.now()); err != nil {
+		return err
+	}
+	return c.events().PublishEmailChangedEvent(ctx, EmailChangedEvent{
+		UserID:    token.UserID,
+		Email:     token.Data,
+		ChangedAt: c.now(),
+	})
+}
+
+// reauthenticate checks the password of an already authenticated user before a sensitive change.
+// The failures count against the LoginThrottling of the account and the IP like the ones of Login,
+// so a stolen token can't be used to guess the password
+func (c Usecases) reauthenticate(ctx context.Context, userID int, pwd string) (User, error) {
+	user, err := c.Storage.FindUserByID(ctx, userID)
+	if err != nil {
+		return User{}, err
+	}
+	keys := c.LoginThrottling.withDefaults().keys(ctx, user.Username)
+	if err := c.checkLoginThrottling(ctx, keys); err != nil {
+		return User{}, err
+	}
+	if !c.checkPasswordAndHashEquality(ctx, pwd, user.Password) {
+		if err := c.recordLoginFailure(ctx, keys, user); err != nil {
+			return User{}, err
+		}
+		return User{}, ErrInvalidCredentials
+	}
+	if err := c.resetLoginThrottling(ctx, keys); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+func validateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	// ParseAddress accepts names too, e.g. "Davud <davud@example.com>", only plain addresses are valid
+	if err != nil || addr.Address != email {
+		return fmt.Errorf("%w: %q is not a valid email address", ErrInvalidInput, email)
+	}
+	return nil
+}
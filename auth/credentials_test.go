@@ -0,0 +1,109 @@
+package auth_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/mailer"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeCredentials(t *testing.T) {
+	setup := func(t *testing.T) (auth.Usecases, *inmemory.EventStreamer, *mailer.InMemory, auth.User) {
+		events := inmemory.New()
+		mails := mailer.NewInMemory()
+		uc := test_helpers.NewUsecases(events, nil)
+		user := test_helpers.HopefullyUniqueUser()
+		created, err := uc.SignUpUser(context.Background(), user)
+		require.Nil(t, err)
+		uc.Mailer = mails
+		created.Password = user.Password
+		return uc, events, mails, created
+	}
+	// the token is the last line of the email
+	tokenOf := func(email auth.Email) string {
+		lines := strings.Split(strings.TrimSpace(email.Body), "\n")
+		return lines[len(lines)-1]
+	}
+
+	t.Run(`#ChangePassword revokes the existing sessions`, func(t *testing.T) {
+		uc, events, _, user := setup(t)
+		oldToken, err := uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+		_, err = uc.VerifyToken(context.Background(), oldToken)
+		require.Nil(t, err)
+
+		require.Nil(t, uc.ChangePassword(context.Background(), user.ID, user.Password, "new-password"))
+
+		_, err = uc.VerifyToken(context.Background(), oldToken)
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+		_, err = uc.Login(context.Background(), user.Username, user.Password)
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+		newToken, err := uc.Login(context.Background(), user.Username, "new-password")
+		require.Nil(t, err)
+		claims, err := uc.VerifyToken(context.Background(), newToken)
+		require.Nil(t, err)
+		require.Equal(t, user.ID, claims.UserID)
+
+		published := events.Published()
+		require.IsType(t, auth.PasswordChangedEvent{}, published[len(published)-1])
+		require.Equal(t, user.ID, published[len(published)-1].(auth.PasswordChangedEvent).UserID)
+	})
+
+	t.Run(`#ChangePassword requires the current password and a valid new one`, func(t *testing.T) {
+		uc, events, _, user := setup(t)
+		err := uc.ChangePassword(context.Background(), user.ID, "wrong-password", "new-password")
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+		err = uc.ChangePassword(context.Background(), user.ID, user.Password, "short")
+		require.ErrorIs(t, err, auth.ErrInvalidInput)
+		require.Len(t, events.Published(), 1, "only the signup event should be published")
+	})
+
+	t.Run(`#ChangeEmail changes the email once the token sent to the new address is confirmed`, func(t *testing.T) {
+		uc, events, mails, user := setup(t)
+		require.Nil(t, uc.ChangeEmail(context.Background(), user.ID, user.Password, "new@example.com"))
+		_, sentToOld := mails.Last(user.Email)
+		require.False(t, sentToOld)
+		email, ok := mails.Last("new@example.com")
+		require.True(t, ok)
+		unchanged, err := uc.Storage.FindUserByID(context.Background(), user.ID)
+		require.Nil(t, err)
+		require.Equal(t, user.Email, unchanged.Email, "email should not change before confirmation")
+
+		require.Nil(t, uc.ConfirmEmailChange(context.Background(), tokenOf(email)))
+		changed, err := uc.Storage.FindUserByID(context.Background(), user.ID)
+		require.Nil(t, err)
+		require.Equal(t, "new@example.com", changed.Email)
+		published := events.Published()
+		event := published[len(published)-1].(auth.EmailChangedEvent)
+		require.Equal(t, user.ID, event.UserID)
+		require.Equal(t, "new@example.com", event.Email)
+
+		err = uc.ConfirmEmailChange(context.Background(), tokenOf(email))
+		require.ErrorIs(t, err, auth.ErrInvalidToken, "tokens can be used only once")
+	})
+
+	t.Run(`#ChangeEmail requires the current password and a valid address`, func(t *testing.T) {
+		uc, _, mails, user := setup(t)
+		err := uc.ChangeEmail(context.Background(), user.ID, "wrong-password", "new@example.com")
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+		err = uc.ChangeEmail(context.Background(), user.ID, user.Password, "Davud <new@example.com>")
+		require.ErrorIs(t, err, auth.ErrInvalidInput)
+		require.Empty(t, mails.Sent())
+	})
+
+	t.Run(`#ConfirmEmailChange rejects expired tokens`, func(t *testing.T) {
+		uc, _, mails, user := setup(t)
+		require.Nil(t, uc.ChangeEmail(context.Background(), user.ID, user.Password, "new@example.com"))
+		email, _ := mails.Last("new@example.com")
+
+		uc.Now = func() time.Time { return time.Now().Add(auth.EmailChangeTokenTTL + time.Minute) }
+		err := uc.ConfirmEmailChange(context.Background(), tokenOf(email))
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+}
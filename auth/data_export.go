@@ -0,0 +1,422 @@
+package auth
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth/tracing"
+)
+
+type DataExportStatus string
+
+const (
+	DataExportPending  DataExportStatus = "pending"
+	DataExportBuilding DataExportStatus = "building"
+	DataExportReady    DataExportStatus = "ready"
+	DataExportFailed   DataExportStatus = "failed"
+)
+
+// DataExport is an archive of the data of a user, requested with ExportUserData and built by BuildDataExports
+type DataExport struct {
+	ID     string
+	UserID int
+	Status DataExportStatus
+	// BlobKey is the key of the archive in DataExportOptions.Blobs, empty until the export is ready
+	BlobKey string
+	// Error is the reason of a failed export. It is not shown to the user
+	Error     string
+	CreatedAt time.Time
+	// StartedAt is when the export is claimed by BuildDataExports
+	StartedAt   time.Time
+	CompletedAt time.Time
+	// ExpiresAt is when the archive is deleted, zero until the export is ready
+	ExpiresAt time.Time
+}
+
+// DataExportOptions configures the data exports. Zero values are replaced with the defaults below
+type DataExportOptions struct {
+	// Blobs stores the archives, ExportUserData fails if it is nil
+	Blobs BlobStore
+	// TTL is how long an archive can be downloaded. Defaults to 7 days
+	TTL time.Duration
+	// BuildTimeout is how long an export can be building before it is claimed again, e.g. after the instance building it crashed.
+	// Defaults to 10 minutes
+	BuildTimeout time.Duration
+}
+
+func (o DataExportOptions) withDefaults() DataExportOptions {
+	if o.TTL == 0 {
+		o.TTL = 7 * 24 * time.Hour
+	}
+	if o.BuildTimeout == 0 {
+		o.BuildTimeout = 10 * time.Minute
+	}
+	return o
+}
+
+// ExportUserData requests an archive of everything the service stores about the user. The archive is built asynchronously
+// by BuildDataExports, the returned export is pending until then. See FindDataExport and OpenDataExport for the progress and the archive
+func (c Usecases) ExportUserData(ctx context.Context, userID int) (_ DataExport, err error) {
+	defer c.observe("export_user_data", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.ExportUserData")
+	defer tracing.EndSpan(span, &err)
+
+	if c.DataExports.Blobs == nil {
+		return DataExport{}, errors.New("DataExportOptions.Blobs is not set")
+	}
+	if _, err := c.Storage.FindUserByID(ctx, userID); err != nil {
+		return DataExport{}, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return DataExport{}, err
+	}
+	export := DataExport{
+		ID:        hex.EncodeToString(id),
+		UserID:    userID,
+		Status:    DataExportPending,
+		CreatedAt: c.now(),
+	}
+	return export, c.Storage.CreateDataExport(ctx, export)
+}
+
+// FindDataExport returns an export of the user, ErrDataExportNotFound if it doesn't exist or belongs to another user
+func (c Usecases) FindDataExport(ctx context.Context, userID int, exportID string) (_ DataExport, err error) {
+	defer c.observe("find_data_export", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.FindDataExport")
+	defer tracing.EndSpan(span, &err)
+	return c.findDataExport(ctx, userID, exportID)
+}
+
+func (c Usecases) findDataExport(ctx context.Context, userID int, exportID string) (DataExport, error) {
+	export, err := c.Storage.FindDataExport(ctx, exportID)
+	if err != nil {
+		return DataExport{}, err
+	}
+	if export.UserID != userID {
+		return DataExport{}, ErrDataExportNotFound
+	}
+	return export, nil
+}
+
+// OpenDataExport returns the zip archive of a ready export of the user. The caller must close it.
+// It returns ErrDataExportNotFound if the export is expired, and ErrInvalidInput if it isn't ready
+func (c Usecases) OpenDataExport(ctx context.Context, userID int, exportID string) (_ io.ReadCloser, err error) {
+	defer c.observe("open_data_export", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.OpenDataExport")
+	defer tracing.EndSpan(span, &err)
+
+	export, err := c.findDataExport(ctx, userID, exportID)
+	if err != nil {
+		return nil, err
+	}
+	if export.Status != DataExportReady {
+		return nil, fmt.Errorf("%w: export is %s", ErrInvalidInput, export.Status)
+	}
+	if !export.ExpiresAt.After(c.now()) {
+		return nil, fmt.Errorf("%w: export is expired", ErrDataExportNotFound)
+	}
+	r, err := c.DataExports.Blobs.Open(ctx, export.BlobKey)
+	if errors.Is(err, ErrBlobNotFound) {
+		return nil, fmt.Errorf("%w: archive is deleted", ErrDataExportNotFound)
+	}
+	return r, err
+}
+
+// BuildDataExports builds up to limit pending exports, and returns how many are built. Exports that fail to build are marked
+// as failed instead of returning an error. It is meant to be run in the background, see the serve command of the auth binary
+func (c Usecases) BuildDataExports(ctx context.Context, limit int) (built int, err error) {
+	defer c.observe("build_data_exports", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.BuildDataExports")
+	defer tracing.EndSpan(span, &err)
+
+	options := c.DataExports.withDefaults()
+	for built < limit {
+		now := c.now()
+		export, err := c.Storage.ClaimDataExport(ctx, now, now.Add(-options.BuildTimeout))
+		if errors.Is(err, ErrDataExportNotFound) {
+			return built, nil
+		}
+		if err != nil {
+			return built, err
+		}
+		if buildErr := c.buildDataExport(ctx, &export); buildErr != nil {
+			span.RecordError(buildErr)
+			export.Status = DataExportFailed
+			export.Error = buildErr.Error()
+		}
+		export.CompletedAt = c.now()
+		if err := c.Storage.UpdateDataExport(ctx, export); err != nil {
+			return built, err
+		}
+		built++
+	}
+	return built, nil
+}
+
+func (c Usecases) buildDataExport(ctx context.Context, export *DataExport) error {
+	options := c.DataExports.withDefaults()
+	archive, err := c.dataExportArchive(ctx, export.UserID)
+	if err != nil {
+		return err
+	}
+	key := "exports/" + export.ID + ".zip"
+	if err := options.Blobs.Put(ctx, key, archive); err != nil {
+		return err
+	}
+	export.Status = DataExportReady
+	export.BlobKey = key
+	export.ExpiresAt = c.now().Add(options.TTL)
+	return nil
+}
+
+// dataExportArchive returns the zip of the JSON files below:
+//
+//	profile.json        the user without the password hash
+//	security.json       whether MFA is enabled
+//	sessions.json       the active sessions
+//	login_history.json  the login attempts
+//	api_keys.json       the API keys that are not revoked, without the hashes
+//	identities.json     the linked OIDC providers
+//	events.json         the events published about the user
+func (c Usecases) dataExportArchive(ctx context.Context, userID int) (io.Reader, error) {
+	user, err := c.Storage.FindUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	security := exportedSecurity{}
+	totp, err := c.mfaEnabled(ctx, userID)
+	if err == nil {
+		security.MFAEnabled = true
+		security.MFAEnabledAt = optionalTime(totp.ConfirmedAt)
+	} else if !errors.Is(err, ErrMFANotEnrolled) {
+		return nil, err
+	}
+	sessions, err := c.Storage.ListSessions(ctx, userID, c.now())
+	if err != nil {
+		return nil, err
+	}
+	exportedSessions := make([]exportedSession, 0, len(sessions))
+	for _, s := range sessions {
+		exportedSessions = append(exportedSessions, exportedSession{
+			ID: s.ID, IP: s.IP, UserAgent: s.UserAgent, CreatedAt: s.CreatedAt, ExpiresAt: s.ExpiresAt,
+		})
+	}
+	apiKeys, err := c.Storage.ListAPIKeys(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	exportedKeys := make([]exportedAPIKey, 0, len(apiKeys))
+	for _, k := range apiKeys {
+		exportedKeys = append(exportedKeys, exportedAPIKey{
+			ID: k.ID, Name: k.Name, Prefix: k.Prefix, Scopes: k.Scopes, CreatedAt: k.CreatedAt, ExpiresAt: k.ExpiresAt,
+			LastUsedAt: optionalTime(k.LastUsedAt),
+		})
+	}
+	identities, err := c.Storage.ListIdentities(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	exportedIdentities := make([]exportedIdentity, 0, len(identities))
+	for _, i := range identities {
+		exportedIdentities = append(exportedIdentities, exportedIdentity{
+			Provider: i.Provider, Subject: i.Subject, Email: i.Email, CreatedAt: i.CreatedAt,
+		})
+	}
+	logins, err := c.Storage.ListLoginEvents(ctx, userID, 0)
+	if err != nil {
+		return nil, err
+	}
+	exportedLogins := make([]exportedLoginEvent, 0, len(logins))
+	for _, l := range logins {
+		exportedLogins = append(exportedLogins, exportedLoginEvent{
+			IP: l.IP, UserAgent: l.UserAgent, Outcome: string(l.Outcome), CreatedAt: l.CreatedAt,
+		})
+	}
+	records, err := c.Storage.ListUserEvents(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]exportedEvent, 0, len(records))
+	for _, r := range records {
+		events = append(events, exportedEvent{Type: r.Type, PublishedAt: r.PublishedAt, Payload: r.Payload})
+	}
+
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	files := []struct {
+		name    string
+		content interface{}
+	}{
+		{"profile.json", exportedProfileOf(user)},
+		{"security.json", security},
+		{"sessions.json", exportedSessions},
+		{"login_history.json", exportedLogins},
+		{"api_keys.json", exportedKeys},
+		{"identities.json", exportedIdentities},
+		{"events.json", events},
+	}
+	for _, f := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: c.now()})
+		if err != nil {
+			return nil, err
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// PurgeExpiredDataExports deletes up to limit expired exports with their archives, and returns how many are deleted
+func (c Usecases) PurgeExpiredDataExports(ctx context.Context, limit int) (purged int, err error) {
+	defer c.observe("purge_expired_data_exports", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.PurgeExpiredDataExports")
+	defer tracing.EndSpan(span, &err)
+
+	exports, err := c.Storage.ExpiredDataExports(ctx, c.now(), limit)
+	if err != nil {
+		return 0, err
+	}
+	for _, export := range exports {
+		if err := c.deleteDataExport(ctx, export); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// deleteDataExports deletes the archives of a user before the user is deleted, the exports are deleted with the user
+func (c Usecases) deleteDataExports(ctx context.Context, userID int) error {
+	exports, err := c.Storage.ListDataExports(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, export := range exports {
+		if err := c.deleteDataExport(ctx, export); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteDataExport deletes the archive first, so an export is never deleted with its archive left behind
+func (c Usecases) deleteDataExport(ctx context.Context, export DataExport) error {
+	if export.BlobKey != "" {
+		if c.DataExports.Blobs == nil {
+			return errors.New("DataExportOptions.Blobs is not set")
+		}
+		if err := c.DataExports.Blobs.Delete(ctx, export.BlobKey); err != nil {
+			return err
+		}
+	}
+	return c.Storage.DeleteDataExport(ctx, export.ID)
+}
+
+// The types below are the content of the archive, their JSON is the format users get
+
+type exportedProfile struct {
+	ID              int        `json:"id"`
+	Email           string     `json:"email"`
+	Username        string     `json:"username"`
+	DisplayName     string     `json:"display_name"`
+	Bio             string     `json:"bio"`
+	Location        string     `json:"location"`
+	Website         string     `json:"website"`
+	AvatarURL       string     `json:"avatar_url"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	DeactivatedAt   *time.Time `json:"deactivated_at"`
+	DeleteAfter     *time.Time `json:"delete_after"`
+	Role            string     `json:"role"`
+	SuspendedAt     *time.Time `json:"suspended_at"`
+	// SuspensionReason is shown to the user anyway, e.g. when they contest the suspension
+	SuspensionReason string `json:"suspension_reason"`
+}
+
+func exportedProfileOf(u User) exportedProfile {
+	return exportedProfile{
+		ID:               u.ID,
+		Email:            u.Email,
+		Username:         u.Username,
+		DisplayName:      u.DisplayName,
+		Bio:              u.Bio,
+		Location:         u.Location,
+		Website:          u.Website,
+		AvatarURL:        u.AvatarURL,
+		CreatedAt:        u.CreatedAt,
+		UpdatedAt:        u.UpdatedAt,
+		EmailVerifiedAt:  optionalTime(u.EmailVerifiedAt),
+		DeactivatedAt:    optionalTime(u.DeactivatedAt),
+		DeleteAfter:      optionalTime(u.DeleteAfter),
+		Role:             string(roleOf(u)),
+		SuspendedAt:      optionalTime(u.SuspendedAt),
+		SuspensionReason: u.SuspensionReason,
+	}
+}
+
+type exportedSecurity struct {
+	MFAEnabled   bool       `json:"mfa_enabled"`
+	MFAEnabledAt *time.Time `json:"mfa_enabled_at"`
+}
+
+type exportedSession struct {
+	ID        string    `json:"id"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type exportedAPIKey struct {
+	ID         string       `json:"id"`
+	Name       string       `json:"name"`
+	Prefix     string       `json:"prefix"`
+	Scopes     []Permission `json:"scopes"`
+	CreatedAt  time.Time    `json:"created_at"`
+	ExpiresAt  time.Time    `json:"expires_at"`
+	LastUsedAt *time.Time   `json:"last_used_at"`
+}
+
+type exportedIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportedLoginEvent struct {
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Outcome   string    `json:"outcome"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportedEvent struct {
+	Type        string          `json:"type"`
+	PublishedAt time.Time       `json:"published_at"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+// optionalTime returns nil for the zero time, so it is exported as null
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
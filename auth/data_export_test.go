@@ -0,0 +1,220 @@
+package auth_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/blobstore"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/logging"
+	"github.com/davudsafarli/twitter/auth/mailer"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataExport(t *testing.T) {
+	setup := func(t *testing.T) (auth.Usecases, *fakeClock, auth.User) {
+		blobs, err := blobstore.NewFilesystem(t.TempDir())
+		require.Nil(t, err)
+		clock := &fakeClock{now: time.Date(2021, 7, 19, 13, 11, 26, 0, time.UTC)}
+		uc := test_helpers.NewUsecases(inmemory.New(), mailer.NewInMemory())
+		uc.Now = clock.Now
+		uc.DataExports = auth.DataExportOptions{Blobs: blobs, TTL: 24 * time.Hour}
+		user := test_helpers.HopefullyUniqueUser()
+		created, err := uc.SignUpUser(context.Background(), user)
+		require.Nil(t, err)
+		created.Password = user.Password
+		return uc, clock, created
+	}
+	readArchive := func(t *testing.T, uc auth.Usecases, userID int, exportID string) map[string][]byte {
+		r, err := uc.OpenDataExport(context.Background(), userID, exportID)
+		require.Nil(t, err)
+		defer r.Close()
+		content, err := ioutil.ReadAll(r)
+		require.Nil(t, err)
+		zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+		require.Nil(t, err)
+		files := map[string][]byte{}
+		for _, f := range zr.File {
+			fr, err := f.Open()
+			require.Nil(t, err)
+			files[f.Name], err = ioutil.ReadAll(fr)
+			require.Nil(t, err)
+			fr.Close()
+		}
+		return files
+	}
+
+	t.Run(`#ExportUserData is built by #BuildDataExports, and contains the profile and the events without the password`, func(t *testing.T) {
+		uc, _, user := setup(t)
+		ctx := auth.WithClientInfo(context.Background(), auth.ClientInfo{IP: "203.0.113.7", UserAgent: "test-agent"})
+		_, err := uc.Login(ctx, user.Username, "wrong-password")
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+		require.Nil(t, uc.ChangePassword(context.Background(), user.ID, user.Password, "a-new-long-password"))
+		_, err = uc.Login(ctx, user.Username, "a-new-long-password")
+		require.Nil(t, err)
+
+		export, err := uc.ExportUserData(context.Background(), user.ID)
+		require.Nil(t, err)
+		require.Equal(t, auth.DataExportPending, export.Status)
+		_, err = uc.OpenDataExport(context.Background(), user.ID, export.ID)
+		require.ErrorIs(t, err, auth.ErrInvalidInput, "pending export should not be downloaded")
+
+		built, err := uc.BuildDataExports(context.Background(), 10)
+		require.Nil(t, err)
+		require.Equal(t, 1, built)
+		export, err = uc.FindDataExport(context.Background(), user.ID, export.ID)
+		require.Nil(t, err)
+		require.Equal(t, auth.DataExportReady, export.Status)
+
+		files := readArchive(t, uc, user.ID, export.ID)
+		require.Len(t, files, 7)
+		stored, err := uc.Storage.FindUserByID(context.Background(), user.ID)
+		require.Nil(t, err)
+		for name, content := range files {
+			require.NotContains(t, string(content), stored.Password, "%s should not contain the password hash", name)
+		}
+		profile := map[string]interface{}{}
+		require.Nil(t, json.Unmarshal(files["profile.json"], &profile))
+		require.Equal(t, user.Username, profile["username"])
+		require.Equal(t, user.Email, profile["email"])
+		require.NotContains(t, profile, "password")
+
+		var events []struct {
+			Type    string
+			Payload map[string]interface{}
+		}
+		require.Nil(t, json.Unmarshal(files["events.json"], &events))
+		require.Len(t, events, 2)
+		require.Equal(t, "Signup", events[0].Type)
+		require.Equal(t, user.Username, events[0].Payload["Username"])
+		require.NotContains(t, events[0].Payload, "Password")
+		require.NotContains(t, events[0].Payload, "Role")
+		require.NotContains(t, events[0].Payload, "SuspensionReason")
+		require.Equal(t, "PasswordChanged", events[1].Type)
+		require.JSONEq(t, `{"mfa_enabled": false, "mfa_enabled_at": null}`, string(files["security.json"]))
+
+		var sessions []map[string]interface{}
+		require.Nil(t, json.Unmarshal(files["sessions.json"], &sessions))
+		require.Len(t, sessions, 1)
+		require.Equal(t, "203.0.113.7", sessions[0]["ip"])
+		var logins []map[string]interface{}
+		require.Nil(t, json.Unmarshal(files["login_history.json"], &logins))
+		require.Len(t, logins, 2)
+		require.Equal(t, "success", logins[0]["outcome"])
+		require.Equal(t, "invalid_credentials", logins[1]["outcome"])
+		require.Equal(t, "test-agent", logins[1]["user_agent"])
+		require.JSONEq(t, `[]`, string(files["api_keys.json"]))
+		require.JSONEq(t, `[]`, string(files["identities.json"]))
+	})
+
+	t.Run(`exports of other users are not found`, func(t *testing.T) {
+		uc, _, user := setup(t)
+		other, err := uc.SignUpUser(context.Background(), test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		export, err := uc.ExportUserData(context.Background(), user.ID)
+		require.Nil(t, err)
+		_, err = uc.BuildDataExports(context.Background(), 10)
+		require.Nil(t, err)
+
+		_, err = uc.FindDataExport(context.Background(), other.ID, export.ID)
+		require.ErrorIs(t, err, auth.ErrDataExportNotFound)
+		_, err = uc.OpenDataExport(context.Background(), other.ID, export.ID)
+		require.ErrorIs(t, err, auth.ErrDataExportNotFound)
+	})
+
+	t.Run(`the events don't reveal the moderators who acted on the user`, func(t *testing.T) {
+		uc, _, user := setup(t)
+		moderator, err := uc.SignUpUser(context.Background(), test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		require.Nil(t, uc.Storage.SetRole(context.Background(), moderator.ID, auth.RoleModerator))
+		require.Nil(t, uc.SuspendUser(context.Background(), moderator.ID, user.ID, "spam"))
+		require.Nil(t, uc.UnsuspendUser(context.Background(), moderator.ID, user.ID))
+
+		export, err := uc.ExportUserData(context.Background(), user.ID)
+		require.Nil(t, err)
+		_, err = uc.BuildDataExports(context.Background(), 10)
+		require.Nil(t, err)
+		files := readArchive(t, uc, user.ID, export.ID)
+		var events []struct{ Type string }
+		require.Nil(t, json.Unmarshal(files["events.json"], &events))
+		require.Len(t, events, 1)
+		require.Equal(t, "Signup", events[0].Type)
+	})
+
+	t.Run(`a failure to record a published event doesn't fail the usecase`, func(t *testing.T) {
+		uc, _, user := setup(t)
+		events := inmemory.New()
+		uc.Publiser = events
+		uc.Storage = failingEventStorage{Storage: uc.Storage}
+		uc.Logger = logging.Nop()
+		require.Nil(t, uc.ChangePassword(context.Background(), user.ID, user.Password, "a-new-long-password"))
+		require.Len(t, events.Published(), 1, "a retry would publish the event again")
+	})
+
+	t.Run(`#PurgeExpiredDataExports deletes the archives after the TTL`, func(t *testing.T) {
+		uc, clock, user := setup(t)
+		export, err := uc.ExportUserData(context.Background(), user.ID)
+		require.Nil(t, err)
+		_, err = uc.BuildDataExports(context.Background(), 10)
+		require.Nil(t, err)
+		export, err = uc.FindDataExport(context.Background(), user.ID, export.ID)
+		require.Nil(t, err)
+
+		clock.Advance(24*time.Hour - time.Second)
+		purged, err := uc.PurgeExpiredDataExports(context.Background(), 10)
+		require.Nil(t, err)
+		require.Equal(t, 0, purged)
+		readArchive(t, uc, user.ID, export.ID)
+
+		clock.Advance(time.Second)
+		_, err = uc.OpenDataExport(context.Background(), user.ID, export.ID)
+		require.ErrorIs(t, err, auth.ErrDataExportNotFound)
+		purged, err = uc.PurgeExpiredDataExports(context.Background(), 10)
+		require.Nil(t, err)
+		require.Equal(t, 1, purged)
+		_, err = uc.DataExports.Blobs.Open(context.Background(), export.BlobKey)
+		require.ErrorIs(t, err, auth.ErrBlobNotFound)
+	})
+
+	t.Run(`#PurgeDeletedAccounts deletes the archives of the user`, func(t *testing.T) {
+		uc, clock, user := setup(t)
+		export, err := uc.ExportUserData(context.Background(), user.ID)
+		require.Nil(t, err)
+		_, err = uc.BuildDataExports(context.Background(), 10)
+		require.Nil(t, err)
+		export, err = uc.FindDataExport(context.Background(), user.ID, export.ID)
+		require.Nil(t, err)
+
+		require.Nil(t, uc.DeleteAccount(context.Background(), user.ID, user.Password))
+		clock.Advance(auth.DefaultDeletionGracePeriod)
+		purged, err := uc.PurgeDeletedAccounts(context.Background(), 10)
+		require.Nil(t, err)
+		require.Equal(t, 1, purged)
+		_, err = uc.DataExports.Blobs.Open(context.Background(), export.BlobKey)
+		require.ErrorIs(t, err, auth.ErrBlobNotFound)
+	})
+
+	t.Run(`#ExportUserData fails without a blob store`, func(t *testing.T) {
+		uc, _, user := setup(t)
+		uc.DataExports.Blobs = nil
+		_, err := uc.ExportUserData(context.Background(), user.ID)
+		require.NotNil(t, err)
+	})
+}
+
+// failingEventStorage fails to record the events
+type failingEventStorage struct {
+	auth.Storage
+}
+
+func (failingEventStorage) AppendUserEvent(context.Context, auth.UserEventRecord) error {
+	return errors.New("postgres is down")
+}
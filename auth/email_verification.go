@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// EmailVerificationPolicy decides what users can do before verifying their email
+type EmailVerificationPolicy int
+
+const (
+	// EmailVerificationOptional lets unverified users log in. Their tokens have a false email_verified claim,
+	// so services can limit what they can do
+	EmailVerificationOptional EmailVerificationPolicy = iota
+	// EmailVerificationRequired makes Login return ErrEmailNotVerified until the user verifies their email
+	EmailVerificationRequired
+)
+
+// EmailVerificationTokenTTL is how long the verification token sent after signup is valid
+const EmailVerificationTokenTTL = 48 * time.Hour
+
+// VerifyEmail marks the email of the user the token was sent to as verified, and publishes an EmailVerifiedEvent.
+// Tokens can be used only once, and become invalid if the user changes their email in the meantime
+func (c Usecases) VerifyEmail(ctx context.Context, secret string) (err error) {
+	defer c.observe("verify_email", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.VerifyEmail")
+	defer tracing.EndSpan(span, &err)
+
+	token, err := c.Storage.ConsumeOneTimeToken(ctx, TokenPurposeEmailVerification, hashOneTimeToken(secret), c.now())
+	if err != nil {
+		return err
+	}
+	verifiedAt := c.now()
+	err = c.Storage.SetEmailVerified(ctx, token.UserID, token.Data, verifiedAt)
+	if errors.Is(err, ErrUserNotFound) {
+		return fmt.Errorf("%w: the email of the user is changed", ErrInvalidToken)
+	}
+	if err != nil {
+		return err
+	}
+	return c.events().PublishEmailVerifiedEvent(ctx, EmailVerifiedEvent{
+		UserID:     token.UserID,
+		Email:      token.Data,
+		VerifiedAt: verifiedAt,
+	})
+}
+
+// ResendVerification sends a new verification token to the email if it belongs to an unverified user.
+// Like RequestPasswordReset it succeeds for unknown emails too, so it can't be used to find out which emails are registered,
+// and it sends the token in the background and logs the failures of sending it
+func (c Usecases) ResendVerification(ctx context.Context, email string) (err error) {
+	defer c.observe("resend_verification", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.ResendVerification")
+	defer tracing.EndSpan(span, &err)
+
+	user, err := c.Storage.FindUserByEmail(ctx, email)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !user.EmailVerifiedAt.IsZero() {
+		return nil
+	}
+	c.sendInBackground(ctx, "sending the verification email failed", user, c.sendVerificationEmail)
+	return nil
+}
+
+// sendSignupVerificationEmail sends the verification email of a new user if Mailer is set.
+// Failures are logged only, failing the signup of a created user would make its retries fail with ErrUserAlreadyExists
+func (c Usecases) sendSignupVerificationEmail(ctx context.Context, user User) {
+	if c.Mailer == nil {
+		return
+	}
+	if err := c.sendVerificationEmail(ctx, user); err != nil {
+		trace.SpanFromContext(ctx).RecordError(err)
+		c.logger().Error("sending the verification email failed", "user_id", user.ID, "err", err)
+	}
+}
+
+func (c Usecases) sendVerificationEmail(ctx context.Context, user User) error {
+	secret, token, err := newOneTimeToken(TokenPurposeEmailVerification, user.ID, user.Email, c.now().Add(EmailVerificationTokenTTL))
+	if err != nil {
+		return err
+	}
+	if err := c.Storage.CreateOneTimeToken(ctx, token); err != nil {
+		return err
+	}
+	return c.mailer().Send(ctx, Email{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the code below to verify your email address. It expires in %v.\n\n%s\n",
+			user.Username, EmailVerificationTokenTTL, secret),
+	})
+}
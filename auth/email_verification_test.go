@@ -0,0 +1,121 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/logging"
+	"github.com/davudsafarli/twitter/auth/mailer"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmailVerification(t *testing.T) {
+	setup := func(t *testing.T, policy auth.EmailVerificationPolicy) (auth.Usecases, *inmemory.EventStreamer, *mailer.InMemory, auth.User) {
+		events := inmemory.New()
+		mails := mailer.NewInMemory()
+		uc := test_helpers.NewUsecases(events, mails)
+		uc.EmailVerification = policy
+		user := test_helpers.HopefullyUniqueUser()
+		created, err := uc.SignUpUser(context.Background(), user)
+		require.Nil(t, err)
+		created.Password = user.Password
+		return uc, events, mails, created
+	}
+	lastToken := func(t *testing.T, mails *mailer.InMemory, to string) string {
+		email, ok := mails.Last(to)
+		require.True(t, ok)
+		lines := strings.Split(strings.TrimSpace(email.Body), "\n")
+		return lines[len(lines)-1]
+	}
+
+	t.Run(`#Login is blocked until #VerifyEmail if verification is required`, func(t *testing.T) {
+		uc, events, mails, user := setup(t, auth.EmailVerificationRequired)
+		_, err := uc.Login(context.Background(), user.Username, user.Password)
+		require.ErrorIs(t, err, auth.ErrEmailNotVerified)
+		_, err = uc.Login(context.Background(), user.Username, "wrong-password")
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials, "verification status should not be revealed without the password")
+
+		require.Nil(t, uc.VerifyEmail(context.Background(), lastToken(t, mails, user.Email)))
+		_, err = uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+
+		published := events.Published()
+		event := published[len(published)-1].(auth.EmailVerifiedEvent)
+		require.Equal(t, user.ID, event.UserID)
+		require.Equal(t, user.Email, event.Email)
+		require.False(t, event.VerifiedAt.IsZero())
+	})
+
+	t.Run(`unverified users can #Login if verification is optional, but their tokens tell it`, func(t *testing.T) {
+		uc, _, mails, user := setup(t, auth.EmailVerificationOptional)
+		token, err := uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+		claims, err := uc.VerifyToken(context.Background(), token)
+		require.Nil(t, err)
+		require.False(t, claims.EmailVerified)
+
+		require.Nil(t, uc.VerifyEmail(context.Background(), lastToken(t, mails, user.Email)))
+		token, err = uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+		claims, err = uc.VerifyToken(context.Background(), token)
+		require.Nil(t, err)
+		require.True(t, claims.EmailVerified)
+	})
+
+	t.Run(`#ResendVerification sends a new token only to unverified users`, func(t *testing.T) {
+		uc, _, mails, user := setup(t, auth.EmailVerificationRequired)
+		require.Nil(t, uc.ResendVerification(context.Background(), "unknown@example.com"))
+		require.Len(t, mails.Sent(), 1)
+
+		require.Nil(t, uc.ResendVerification(context.Background(), user.Email))
+		require.Eventually(t, func() bool { return len(mails.Sent()) == 2 }, time.Second, time.Millisecond,
+			"the token should be sent in the background")
+		token := lastToken(t, mails, user.Email)
+		require.Nil(t, uc.VerifyEmail(context.Background(), token))
+		require.ErrorIs(t, uc.VerifyEmail(context.Background(), token), auth.ErrInvalidToken)
+
+		require.Nil(t, uc.ResendVerification(context.Background(), user.Email))
+		require.Len(t, mails.Sent(), 2, "verified users should not get another token")
+	})
+
+	t.Run(`#ResendVerification logs instead of returning the failures of sending the token`, func(t *testing.T) {
+		uc, _, _, user := setup(t, auth.EmailVerificationRequired)
+		uc.Logger = logging.Nop()
+		uc.Mailer = failingMailer{}
+		require.Nil(t, uc.ResendVerification(context.Background(), user.Email))
+	})
+
+	t.Run(`tokens sent to the old email can't verify the new one`, func(t *testing.T) {
+		uc, _, mails, user := setup(t, auth.EmailVerificationRequired)
+		oldToken := lastToken(t, mails, user.Email)
+		require.Nil(t, uc.ChangeEmail(context.Background(), user.ID, user.Password, "new@example.com"))
+		require.Nil(t, uc.ConfirmEmailChange(context.Background(), lastToken(t, mails, "new@example.com")))
+
+		require.ErrorIs(t, uc.VerifyEmail(context.Background(), oldToken), auth.ErrInvalidToken)
+		changed, err := uc.Storage.FindUserByID(context.Background(), user.ID)
+		require.Nil(t, err)
+		require.False(t, changed.EmailVerifiedAt.IsZero(), "confirming the email change should verify the new email")
+	})
+
+	t.Run(`#SignUpUser succeeds without a Mailer, and when sending the email fails`, func(t *testing.T) {
+		for name, m := range map[string]auth.Mailer{"nil": nil, "failing": failingMailer{}} {
+			uc := test_helpers.NewUsecases(inmemory.New(), m)
+			uc.Logger = logging.Nop()
+			created, err := uc.SignUpUser(context.Background(), test_helpers.HopefullyUniqueUser())
+			require.Nil(t, err, name)
+			require.NotZero(t, created.ID, name)
+		}
+	})
+}
+
+type failingMailer struct{}
+
+func (failingMailer) Send(context.Context, auth.Email) error {
+	return errors.New("smtp is down")
+}
@@ -0,0 +1,38 @@
+package auth
+
+import "errors"
+
+var (
+	// ErrUserNotFound is returned by Storage when the requested user doesn't exist
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserAlreadyExists is returned by Storage when the username or email of a new user is already taken
+	ErrUserAlreadyExists = errors.New("user already exists")
+	// ErrInvalidCredentials is returned when the given password doesn't match the user's password. Login returns it for unknown usernames too
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrInvalidInput wraps the validation errors of usecase inputs
+	ErrInvalidInput = errors.New("invalid input")
+	// ErrEmailNotVerified is returned by Login when EmailVerificationRequired is the policy and the user didn't verify their email
+	ErrEmailNotVerified = errors.New("email is not verified")
+	// ErrTooManyAttempts is wrapped by TooManyAttemptsError, which Login returns when the account or the IP is throttled
+	ErrTooManyAttempts = errors.New("too many failed login attempts")
+	// ErrInvalidToken is returned when a token is malformed, expired, already used or revoked
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrAccountDeactivated is returned by Login when the user deactivated their account or scheduled its deletion
+	ErrAccountDeactivated = errors.New("account is deactivated")
+	// ErrMFARequired is wrapped by MFARequiredError, which Login returns instead of a token when the user enabled MFA
+	ErrMFARequired = errors.New("MFA is required")
+	// ErrMFANotEnrolled is returned when the user didn't enrol TOTP, or didn't confirm the enrolment where an enabled MFA is needed
+	ErrMFANotEnrolled = errors.New("MFA is not enrolled")
+	// ErrDataExportNotFound is returned when the export doesn't exist, belongs to another user or is expired
+	ErrDataExportNotFound = errors.New("data export not found")
+	// ErrSessionNotFound is returned when the session doesn't exist, belongs to another user or is already revoked
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrForbidden is returned when the user lacks the permission of an action
+	ErrForbidden = errors.New("forbidden")
+	// ErrAccountSuspended is returned by Login when an admin or a moderator suspended the user
+	ErrAccountSuspended = errors.New("account is suspended")
+	// ErrAPIKeyNotFound is returned when the API key doesn't exist, belongs to another user or is already revoked
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	// ErrIdentityNotFound is returned when the subject of an OIDC provider isn't linked to a user
+	ErrIdentityNotFound = errors.New("identity not found")
+)
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// UserEventRecord is an event published about a user, kept so ExportUserData can include it
+type UserEventRecord struct {
+	UserID int
+	// Type is the name of the event, e.g. "Signup" or "PasswordChanged"
+	Type        string
+	Payload     json.RawMessage
+	PublishedAt time.Time
+}
+
+// events returns the publisher of the usecases, which records every published event to Storage after publishing it.
+// A failure to record is only logged, failing the usecase would publish the event again when the caller retries
+func (c Usecases) events() eventLog {
+	return eventLog{EventProducerConsumer: c.Publiser, c: c}
+}
+
+// eventLog records the events published by EventProducerConsumer. Register methods are passed through
+type eventLog struct {
+	EventProducerConsumer
+	c Usecases
+}
+
+func (l eventLog) record(ctx context.Context, userID int, eventType string, event interface{}, publishErr error) error {
+	if publishErr != nil {
+		return publishErr
+	}
+	if err := l.append(ctx, userID, eventType, event); err != nil {
+		trace.SpanFromContext(ctx).RecordError(err)
+		l.c.logger().Error("recording the event failed", "user_id", userID, "type", eventType, "err", err)
+	}
+	return nil
+}
+
+func (l eventLog) append(ctx context.Context, userID int, eventType string, event interface{}) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return l.c.Storage.AppendUserEvent(ctx, UserEventRecord{
+		UserID:      userID,
+		Type:        eventType,
+		Payload:     payload,
+		PublishedAt: l.c.now(),
+	})
+}
+
+func (l eventLog) PublishUserSignupEvent(ctx context.Context, event SignupEvent) error {
+	err := l.EventProducerConsumer.PublishUserSignupEvent(ctx, event)
+	return l.record(ctx, event.ID, "Signup", event, err)
+}
+
+func (l eventLog) PublishProfileUpdatedEvent(ctx context.Context, event ProfileUpdatedEvent) error {
+	err := l.EventProducerConsumer.PublishProfileUpdatedEvent(ctx, event)
+	return l.record(ctx, event.UserID, "ProfileUpdated", event, err)
+}
+
+func (l eventLog) PublishPasswordChangedEvent(ctx context.Context, event PasswordChangedEvent) error {
+	err := l.EventProducerConsumer.PublishPasswordChangedEvent(ctx, event)
+	return l.record(ctx, event.UserID, "PasswordChanged", event, err)
+}
+
+func (l eventLog) PublishEmailChangedEvent(ctx context.Context, event EmailChangedEvent) error {
+	err := l.EventProducerConsumer.PublishEmailChangedEvent(ctx, event)
+	return l.record(ctx, event.UserID, "EmailChanged", event, err)
+}
+
+func (l eventLog) PublishEmailVerifiedEvent(ctx context.Context, event EmailVerifiedEvent) error {
+	err := l.EventProducerConsumer.PublishEmailVerifiedEvent(ctx, event)
+	return l.record(ctx, event.UserID, "EmailVerified", event, err)
+}
+
+func (l eventLog) PublishAccountLockedEvent(ctx context.Context, event AccountLockedEvent) error {
+	err := l.EventProducerConsumer.PublishAccountLockedEvent(ctx, event)
+	return l.record(ctx, event.UserID, "AccountLocked", event, err)
+}
+
+func (l eventLog) PublishUserDeactivatedEvent(ctx context.Context, event UserDeactivatedEvent) error {
+	err := l.EventProducerConsumer.PublishUserDeactivatedEvent(ctx, event)
+	return l.record(ctx, event.UserID, "UserDeactivated", event, err)
+}
+
+func (l eventLog) PublishUserReactivatedEvent(ctx context.Context, event UserReactivatedEvent) error {
+	err := l.EventProducerConsumer.PublishUserReactivatedEvent(ctx, event)
+	return l.record(ctx, event.UserID, "UserReactivated", event, err)
+}
+
+// PublishUserDeletedEvent isn't recorded, the records of the user are deleted with them
+func (l eventLog) PublishUserDeletedEvent(ctx context.Context, event UserDeletedEvent) error {
+	return l.EventProducerConsumer.PublishUserDeletedEvent(ctx, event)
+}
+
+// PublishAuditEvent isn't recorded, it describes what a moderator did and would leak them into the export of the user
+func (l eventLog) PublishAuditEvent(ctx context.Context, event AuditEvent) error {
+	return l.EventProducerConsumer.PublishAuditEvent(ctx, event)
+}
@@ -5,18 +5,198 @@ import (
 	"time"
 )
 
+// SignupEvent is published when a user signs up. It has the profile of the user only,
+// the hash of their password and their role and moderation state stay in the service
 type SignupEvent struct {
-	// all user fields are necessary for the event right now.
-	// If User struct grows to have fields we don't want to send as an event, then copy the fields here
-	User
+	ID       int
+	Email    string
+	Username string
+
+	DisplayName string
+	Bio         string
+	Location    string
+	Website     string
+	AvatarURL   string
+
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	EmailVerifiedAt time.Time
+}
+
+// signupEventOf copies the fields of the user the SignupEvent has
+func signupEventOf(user User) SignupEvent {
+	return SignupEvent{
+		ID:              user.ID,
+		Email:           user.Email,
+		Username:        user.Username,
+		DisplayName:     user.DisplayName,
+		Bio:             user.Bio,
+		Location:        user.Location,
+		Website:         user.Website,
+		AvatarURL:       user.AvatarURL,
+		CreatedAt:       user.CreatedAt,
+		UpdatedAt:       user.UpdatedAt,
+		EmailVerifiedAt: user.EmailVerifiedAt,
+	}
+}
+
+// ProfileUpdatedEvent is published when a user changes their profile
+type ProfileUpdatedEvent struct {
+	UserID int
+	// Changes contains only the fields that are changed
+	Changes   ProfileUpdate
+	UpdatedAt time.Time
+}
+
+// PasswordChangedEvent is published when a user changes their password. It doesn't contain the password
+type PasswordChangedEvent struct {
+	UserID    int
+	ChangedAt time.Time
+}
+
+// EmailChangedEvent is published when a user confirms their new email address
+type EmailChangedEvent struct {
+	UserID    int
+	Email     string
+	ChangedAt time.Time
+}
+
+// EmailVerifiedEvent is published when a user verifies their email
+type EmailVerifiedEvent struct {
+	UserID     int
+	Email      string
+	VerifiedAt time.Time
+}
+
+// AccountLockedEvent is published when an account is locked after too many failed logins
+type AccountLockedEvent struct {
+	UserID int
+	// Failures is the number of consecutive failed logins that caused the lock
+	Failures    int
+	LockedUntil time.Time
+}
+
+// UserDeactivatedEvent is published when a user deactivates their account or schedules its deletion. Consumers should hide the user until a UserReactivatedEvent
+type UserDeactivatedEvent struct {
+	UserID        int
+	DeactivatedAt time.Time
+	// DeleteAfter is when the account is deleted for good, zero if the user only deactivated it
+	DeleteAfter time.Time
+}
+
+// UserReactivatedEvent is published when a deactivated account is reactivated, which also cancels its scheduled deletion
+type UserReactivatedEvent struct {
+	UserID        int
+	ReactivatedAt time.Time
+}
+
+// UserDeletedEvent is published when an account is deleted for good. Consumers must remove everything they store about the user
+type UserDeletedEvent struct {
+	UserID    int
+	DeletedAt time.Time
+}
+
+// AuditEvent is published when a user with elevated permissions acts on other users, e.g. suspends one
+type AuditEvent struct {
+	// ActorID is zero for the actions of the operators, e.g. AssignRoleAsOperator
+	ActorID int
+	Action  AuditAction
+	// UserID is the user the action is about, zero for the actions on many users like listing them
+	UserID int
+	// Details describes the action, e.g. the search query or the reason of a suspension
+	Details    string
+	OccurredAt time.Time
 }
 
 type EventProducerConsumer interface {
 	PublishUserSignupEvent(ctx context.Context, event SignupEvent) error
 	RegisterUserSignupEventConsumer(ctx context.Context, Handler func(event ConsumedSignupEvent))
+	PublishProfileUpdatedEvent(ctx context.Context, event ProfileUpdatedEvent) error
+	RegisterProfileUpdatedEventConsumer(ctx context.Context, Handler func(event ConsumedProfileUpdatedEvent))
+	PublishPasswordChangedEvent(ctx context.Context, event PasswordChangedEvent) error
+	RegisterPasswordChangedEventConsumer(ctx context.Context, Handler func(event ConsumedPasswordChangedEvent))
+	PublishEmailChangedEvent(ctx context.Context, event EmailChangedEvent) error
+	RegisterEmailChangedEventConsumer(ctx context.Context, Handler func(event ConsumedEmailChangedEvent))
+	PublishEmailVerifiedEvent(ctx context.Context, event EmailVerifiedEvent) error
+	RegisterEmailVerifiedEventConsumer(ctx context.Context, Handler func(event ConsumedEmailVerifiedEvent))
+	PublishAccountLockedEvent(ctx context.Context, event AccountLockedEvent) error
+	RegisterAccountLockedEventConsumer(ctx context.Context, Handler func(event ConsumedAccountLockedEvent))
+	PublishUserDeactivatedEvent(ctx context.Context, event UserDeactivatedEvent) error
+	RegisterUserDeactivatedEventConsumer(ctx context.Context, Handler func(event ConsumedUserDeactivatedEvent))
+	PublishUserReactivatedEvent(ctx context.Context, event UserReactivatedEvent) error
+	RegisterUserReactivatedEventConsumer(ctx context.Context, Handler func(event ConsumedUserReactivatedEvent))
+	PublishUserDeletedEvent(ctx context.Context, event UserDeletedEvent) error
+	RegisterUserDeletedEventConsumer(ctx context.Context, Handler func(event ConsumedUserDeletedEvent))
+	PublishAuditEvent(ctx context.Context, event AuditEvent) error
+	RegisterAuditEventConsumer(ctx context.Context, Handler func(event ConsumedAuditEvent))
 }
 
 type ConsumedSignupEvent interface {
 	Timestamp() time.Time
 	SignupEvent() SignupEvent
+	// Context carries the trace of the publisher, handlers should use it for their own spans
+	Context() context.Context
+}
+
+type ConsumedProfileUpdatedEvent interface {
+	Timestamp() time.Time
+	ProfileUpdatedEvent() ProfileUpdatedEvent
+	// Context carries the trace of the publisher, handlers should use it for their own spans
+	Context() context.Context
+}
+
+type ConsumedPasswordChangedEvent interface {
+	Timestamp() time.Time
+	PasswordChangedEvent() PasswordChangedEvent
+	// Context carries the trace of the publisher, handlers should use it for their own spans
+	Context() context.Context
+}
+
+type ConsumedEmailChangedEvent interface {
+	Timestamp() time.Time
+	EmailChangedEvent() EmailChangedEvent
+	// Context carries the trace of the publisher, handlers should use it for their own spans
+	Context() context.Context
+}
+
+type ConsumedEmailVerifiedEvent interface {
+	Timestamp() time.Time
+	EmailVerifiedEvent() EmailVerifiedEvent
+	// Context carries the trace of the publisher, handlers should use it for their own spans
+	Context() context.Context
+}
+
+type ConsumedAccountLockedEvent interface {
+	Timestamp() time.Time
+	AccountLockedEvent() AccountLockedEvent
+	// Context carries the trace of the publisher, handlers should use it for their own spans
+	Context() context.Context
+}
+
+type ConsumedUserDeactivatedEvent interface {
+	Timestamp() time.Time
+	UserDeactivatedEvent() UserDeactivatedEvent
+	// Context carries the trace of the publisher, handlers should use it for their own spans
+	Context() context.Context
+}
+
+type ConsumedUserReactivatedEvent interface {
+	Timestamp() time.Time
+	UserReactivatedEvent() UserReactivatedEvent
+	// Context carries the trace of the publisher, handlers should use it for their own spans
+	Context() context.Context
+}
+
+type ConsumedUserDeletedEvent interface {
+	Timestamp() time.Time
+	UserDeletedEvent() UserDeletedEvent
+	// Context carries the trace of the publisher, handlers should use it for their own spans
+	Context() context.Context
+}
+
+type ConsumedAuditEvent interface {
+	Timestamp() time.Time
+	AuditEvent() AuditEvent
+	// Context carries the trace of the publisher, handlers should use it for their own spans
+	Context() context.Context
 }
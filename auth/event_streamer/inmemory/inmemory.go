@@ -0,0 +1,296 @@
+// Package inmemory implements auth.EventProducerConsumer without a broker, for tests and local development
+package inmemory
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+)
+
+// EventStreamer keeps the published events in memory and sends them to the registered handlers.
+// Like a broker, events published before StartConsume are delivered once it is called
+type EventStreamer struct {
+	mu        sync.Mutex
+	published []consumedEvent
+	delivered int
+	consuming bool
+
+	signupEventHandler          func(event auth.ConsumedSignupEvent)
+	profileUpdatedEventHandler  func(event auth.ConsumedProfileUpdatedEvent)
+	passwordChangedEventHandler func(event auth.ConsumedPasswordChangedEvent)
+	emailChangedEventHandler    func(event auth.ConsumedEmailChangedEvent)
+	emailVerifiedEventHandler   func(event auth.ConsumedEmailVerifiedEvent)
+	accountLockedEventHandler   func(event auth.ConsumedAccountLockedEvent)
+	userDeactivatedEventHandler func(event auth.ConsumedUserDeactivatedEvent)
+	userReactivatedEventHandler func(event auth.ConsumedUserReactivatedEvent)
+	userDeletedEventHandler     func(event auth.ConsumedUserDeletedEvent)
+	auditEventHandler           func(event auth.ConsumedAuditEvent)
+}
+
+func New() *EventStreamer {
+	return &EventStreamer{}
+}
+
+// consumedEvent satisfies all auth.Consumed*Event interfaces, only one of the events is set
+type consumedEvent struct {
+	publishedAt     time.Time
+	ctx             context.Context
+	signup          *auth.SignupEvent
+	profileUpdated  *auth.ProfileUpdatedEvent
+	passwordChanged *auth.PasswordChangedEvent
+	emailChanged    *auth.EmailChangedEvent
+	emailVerified   *auth.EmailVerifiedEvent
+	accountLocked   *auth.AccountLockedEvent
+	userDeactivated *auth.UserDeactivatedEvent
+	userReactivated *auth.UserReactivatedEvent
+	userDeleted     *auth.UserDeletedEvent
+	audit           *auth.AuditEvent
+}
+
+func (e consumedEvent) Timestamp() time.Time          { return e.publishedAt }
+func (e consumedEvent) Context() context.Context      { return e.ctx }
+func (e consumedEvent) SignupEvent() auth.SignupEvent { return *e.signup }
+func (e consumedEvent) ProfileUpdatedEvent() auth.ProfileUpdatedEvent {
+	return *e.profileUpdated
+}
+func (e consumedEvent) PasswordChangedEvent() auth.PasswordChangedEvent {
+	return *e.passwordChanged
+}
+func (e consumedEvent) EmailChangedEvent() auth.EmailChangedEvent { return *e.emailChanged }
+func (e consumedEvent) EmailVerifiedEvent() auth.EmailVerifiedEvent {
+	return *e.emailVerified
+}
+func (e consumedEvent) AccountLockedEvent() auth.AccountLockedEvent {
+	return *e.accountLocked
+}
+func (e consumedEvent) UserDeactivatedEvent() auth.UserDeactivatedEvent {
+	return *e.userDeactivated
+}
+func (e consumedEvent) UserReactivatedEvent() auth.UserReactivatedEvent {
+	return *e.userReactivated
+}
+func (e consumedEvent) UserDeletedEvent() auth.UserDeletedEvent {
+	return *e.userDeleted
+}
+func (e consumedEvent) AuditEvent() auth.AuditEvent {
+	return *e.audit
+}
+
+func (s *EventStreamer) PublishUserSignupEvent(ctx context.Context, event auth.SignupEvent) error {
+	s.publish(consumedEvent{signup: &event})
+	return nil
+}
+
+func (s *EventStreamer) RegisterUserSignupEventConsumer(ctx context.Context, handlerFn func(event auth.ConsumedSignupEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signupEventHandler = handlerFn
+}
+
+func (s *EventStreamer) PublishProfileUpdatedEvent(ctx context.Context, event auth.ProfileUpdatedEvent) error {
+	s.publish(consumedEvent{profileUpdated: &event})
+	return nil
+}
+
+func (s *EventStreamer) RegisterProfileUpdatedEventConsumer(ctx context.Context, handlerFn func(event auth.ConsumedProfileUpdatedEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.profileUpdatedEventHandler = handlerFn
+}
+
+func (s *EventStreamer) PublishPasswordChangedEvent(ctx context.Context, event auth.PasswordChangedEvent) error {
+	s.publish(consumedEvent{passwordChanged: &event})
+	return nil
+}
+
+func (s *EventStreamer) RegisterPasswordChangedEventConsumer(ctx context.Context, handlerFn func(event auth.ConsumedPasswordChangedEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.passwordChangedEventHandler = handlerFn
+}
+
+func (s *EventStreamer) PublishEmailChangedEvent(ctx context.Context, event auth.EmailChangedEvent) error {
+	s.publish(consumedEvent{emailChanged: &event})
+	return nil
+}
+
+func (s *EventStreamer) RegisterEmailChangedEventConsumer(ctx context.Context, handlerFn func(event auth.ConsumedEmailChangedEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.emailChangedEventHandler = handlerFn
+}
+
+func (s *EventStreamer) PublishEmailVerifiedEvent(ctx context.Context, event auth.EmailVerifiedEvent) error {
+	s.publish(consumedEvent{emailVerified: &event})
+	return nil
+}
+
+func (s *EventStreamer) RegisterEmailVerifiedEventConsumer(ctx context.Context, handlerFn func(event auth.ConsumedEmailVerifiedEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.emailVerifiedEventHandler = handlerFn
+}
+
+func (s *EventStreamer) PublishAccountLockedEvent(ctx context.Context, event auth.AccountLockedEvent) error {
+	s.publish(consumedEvent{accountLocked: &event})
+	return nil
+}
+
+func (s *EventStreamer) RegisterAccountLockedEventConsumer(ctx context.Context, handlerFn func(event auth.ConsumedAccountLockedEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accountLockedEventHandler = handlerFn
+}
+
+func (s *EventStreamer) PublishUserDeactivatedEvent(ctx context.Context, event auth.UserDeactivatedEvent) error {
+	s.publish(consumedEvent{userDeactivated: &event})
+	return nil
+}
+
+func (s *EventStreamer) RegisterUserDeactivatedEventConsumer(ctx context.Context, handlerFn func(event auth.ConsumedUserDeactivatedEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.userDeactivatedEventHandler = handlerFn
+}
+
+func (s *EventStreamer) PublishUserReactivatedEvent(ctx context.Context, event auth.UserReactivatedEvent) error {
+	s.publish(consumedEvent{userReactivated: &event})
+	return nil
+}
+
+func (s *EventStreamer) RegisterUserReactivatedEventConsumer(ctx context.Context, handlerFn func(event auth.ConsumedUserReactivatedEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.userReactivatedEventHandler = handlerFn
+}
+
+func (s *EventStreamer) PublishUserDeletedEvent(ctx context.Context, event auth.UserDeletedEvent) error {
+	s.publish(consumedEvent{userDeleted: &event})
+	return nil
+}
+
+func (s *EventStreamer) RegisterUserDeletedEventConsumer(ctx context.Context, handlerFn func(event auth.ConsumedUserDeletedEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.userDeletedEventHandler = handlerFn
+}
+
+func (s *EventStreamer) PublishAuditEvent(ctx context.Context, event auth.AuditEvent) error {
+	s.publish(consumedEvent{audit: &event})
+	return nil
+}
+
+func (s *EventStreamer) RegisterAuditEventConsumer(ctx context.Context, handlerFn func(event auth.ConsumedAuditEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auditEventHandler = handlerFn
+}
+
+// StartConsume delivers the already published events, and the later ones as they are published, until the returned io.Closer is closed
+func (s *EventStreamer) StartConsume(ctx context.Context) io.Closer {
+	s.mu.Lock()
+	s.consuming = true
+	s.mu.Unlock()
+	s.deliver()
+	return closerFunc(func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.consuming = false
+		return nil
+	})
+}
+
+// Published returns all events published so far, e.g. to assert them in tests
+func (s *EventStreamer) Published() []interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := make([]interface{}, 0, len(s.published))
+	for _, e := range s.published {
+		switch {
+		case e.signup != nil:
+			events = append(events, *e.signup)
+		case e.profileUpdated != nil:
+			events = append(events, *e.profileUpdated)
+		case e.passwordChanged != nil:
+			events = append(events, *e.passwordChanged)
+		case e.emailChanged != nil:
+			events = append(events, *e.emailChanged)
+		case e.emailVerified != nil:
+			events = append(events, *e.emailVerified)
+		case e.accountLocked != nil:
+			events = append(events, *e.accountLocked)
+		case e.userDeactivated != nil:
+			events = append(events, *e.userDeactivated)
+		case e.userReactivated != nil:
+			events = append(events, *e.userReactivated)
+		case e.userDeleted != nil:
+			events = append(events, *e.userDeleted)
+		case e.audit != nil:
+			events = append(events, *e.audit)
+		}
+	}
+	return events
+}
+
+func (s *EventStreamer) publish(e consumedEvent) {
+	e.publishedAt = time.Now()
+	e.ctx = context.Background()
+	s.mu.Lock()
+	s.published = append(s.published, e)
+	s.mu.Unlock()
+	s.deliver()
+}
+
+// deliver sends the undelivered events to the handlers synchronously, handlers are called without holding the lock
+func (s *EventStreamer) deliver() {
+	for {
+		s.mu.Lock()
+		if !s.consuming || s.delivered == len(s.published) {
+			s.mu.Unlock()
+			return
+		}
+		e := s.published[s.delivered]
+		s.delivered++
+		signupHandler, profileUpdatedHandler := s.signupEventHandler, s.profileUpdatedEventHandler
+		passwordChangedHandler, emailChangedHandler := s.passwordChangedEventHandler, s.emailChangedEventHandler
+		emailVerifiedHandler := s.emailVerifiedEventHandler
+		accountLockedHandler := s.accountLockedEventHandler
+		userDeactivatedHandler := s.userDeactivatedEventHandler
+		userReactivatedHandler := s.userReactivatedEventHandler
+		userDeletedHandler := s.userDeletedEventHandler
+		auditHandler := s.auditEventHandler
+		s.mu.Unlock()
+
+		switch {
+		case e.signup != nil && signupHandler != nil:
+			signupHandler(e)
+		case e.profileUpdated != nil && profileUpdatedHandler != nil:
+			profileUpdatedHandler(e)
+		case e.passwordChanged != nil && passwordChangedHandler != nil:
+			passwordChangedHandler(e)
+		case e.emailChanged != nil && emailChangedHandler != nil:
+			emailChangedHandler(e)
+		case e.emailVerified != nil && emailVerifiedHandler != nil:
+			emailVerifiedHandler(e)
+		case e.accountLocked != nil && accountLockedHandler != nil:
+			accountLockedHandler(e)
+		case e.userDeactivated != nil && userDeactivatedHandler != nil:
+			userDeactivatedHandler(e)
+		case e.userReactivated != nil && userReactivatedHandler != nil:
+			userReactivatedHandler(e)
+		case e.userDeleted != nil && userDeletedHandler != nil:
+			userDeletedHandler(e)
+		case e.audit != nil && auditHandler != nil:
+			auditHandler(e)
+		}
+	}
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
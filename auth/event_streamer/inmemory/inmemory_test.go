@@ -0,0 +1,14 @@
+package inmemory_test
+
+import (
+	"testing"
+
+	"github.com/davudsafarli/twitter/auth/contracts"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+)
+
+func TestInMemory(t *testing.T) {
+	contracts.EventProducerConsumerContract{
+		Subject: inmemory.New(),
+	}.Test(t)
+}
@@ -7,7 +7,7 @@ import (
 func TestKafka(t *testing.T) {
 	t.Skip()
 	// kafka := kafka_go.NewKafka(kafka_go.KafkaOptions{
-	// 	Brokers:                   test_helpers.BROKERS,
+	// 	Brokers:                   test_helpers.Config(t).Kafka.Brokers,
 	// 	UserEventsTopic:           test_helpers.SEARCH_INGESTION_TOPIC,
 	// 	UserEventsConsumerGroupID: test_helpers.SEARCH_INGESTION_CONSUMER_GROUP_ID,
 	// })
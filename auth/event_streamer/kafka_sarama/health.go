@@ -0,0 +1,115 @@
+package kafka_sarama
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// HealthCheck fetches the metadata of UserEventsTopic from the brokers of Writer.
+// It fails if no broker is reachable or the topic doesn't exist.
+// While StartConsume runs, it checks the consumer group with ConsumerGroupHealthCheck too,
+// so the readiness of every binary that consumes the events covers its group.
+// Sarama can't cancel the fetch, so it outlives ctx; health.Run doesn't wait for it
+func (k SaramaClient) HealthCheck(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := k.client.RefreshMetadata(k.Options.UserEventsTopic); err != nil {
+		return fmt.Errorf("failed to fetch metadata of topic %q: %w", k.Options.UserEventsTopic, err)
+	}
+	if k.consumer.isConsuming() {
+		return k.ConsumerGroupHealthCheck(ctx)
+	}
+	return nil
+}
+
+// ConsumerGroupHealthCheck describes UserEventsConsumerGroupID with its coordinator.
+// It fails if the loop of StartConsume stopped, the coordinator is unreachable or the group is dead.
+// Kafka reports the groups nobody has joined yet as dead as well.
+// Rebalancing groups are healthy, rollouts rebalance them on purpose
+func (k SaramaClient) ConsumerGroupHealthCheck(ctx context.Context) error {
+	groupID := k.Options.UserEventsConsumerGroupID
+	if groupID == "" {
+		return errors.New("no consumer group is configured")
+	}
+	if err := k.consumer.err(); err != nil {
+		return fmt.Errorf("consumer of group %q stopped: %w", groupID, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	coordinator, err := k.client.Coordinator(groupID)
+	if err != nil {
+		return fmt.Errorf("failed to find the coordinator of group %q: %w", groupID, err)
+	}
+	res, err := coordinator.DescribeGroups(&sarama.DescribeGroupsRequest{Groups: []string{groupID}})
+	if err != nil {
+		return fmt.Errorf("failed to describe group %q: %w", groupID, err)
+	}
+	for _, g := range res.Groups {
+		if g.Err != sarama.ErrNoError {
+			return fmt.Errorf("failed to describe group %q: %w", groupID, g.Err)
+		}
+		if g.State == "Dead" {
+			return fmt.Errorf("group %q is dead", groupID)
+		}
+	}
+	return nil
+}
+
+// consumerState is shared by the copies of a SaramaClient, so the loop of StartConsume can report to the health checks
+type consumerState struct {
+	mu sync.Mutex
+	// consuming is set from StartConsume until the returned io.Closer is closed
+	consuming bool
+	stopErr   error
+}
+
+func (s *consumerState) started() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.consuming = true
+	s.stopErr = nil
+}
+
+// stopped records why the loop stopped, unless it was closed on purpose
+func (s *consumerState) stopped(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.consuming {
+		s.stopErr = err
+	}
+}
+
+func (s *consumerState) closed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.consuming = false
+}
+
+func (s *consumerState) isConsuming() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.consuming
+}
+
+func (s *consumerState) err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopErr
+}
+
+// consumerCloser closes the consumer group of StartConsume, and tells the health checks it was closed on purpose
+type consumerCloser struct {
+	reader sarama.ConsumerGroup
+	state  *consumerState
+}
+
+func (c consumerCloser) Close() error {
+	c.state.closed()
+	return c.reader.Close()
+}
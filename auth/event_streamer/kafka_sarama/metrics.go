@@ -0,0 +1,22 @@
+package kafka_sarama
+
+import "time"
+
+// Metrics observes publishing and consuming of messages. See metrics package for the Prometheus implementation
+type Metrics interface {
+	// ObservePublish is called after every publish attempt, err is nil if the message is written
+	ObservePublish(topic string, duration time.Duration, err error)
+	// ObserveHandle is called after a registered handler returns
+	ObserveHandle(topic, eventType string, duration time.Duration)
+	// SetConsumerLag is called with the number of messages the consumer group is behind the end of a partition
+	SetConsumerLag(group, topic string, partition int32, lag int64)
+	// IncDeadLetter is called for every consumed message that is dropped without handling, e.g. because it can't be decoded
+	IncDeadLetter(topic, reason string)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) ObservePublish(string, time.Duration, error) {}
+func (nopMetrics) ObserveHandle(string, string, time.Duration) {}
+func (nopMetrics) SetConsumerLag(string, string, int32, int64) {}
+func (nopMetrics) IncDeadLetter(string, string)                {}
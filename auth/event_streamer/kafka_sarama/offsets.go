@@ -0,0 +1,334 @@
+package kafka_sarama
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OffsetPosition is the position a consumer group's offsets are reset to.
+// Use ResetToEarliest, ResetToLatest, ResetToOffset or ResetToTimestamp to create one
+type OffsetPosition struct {
+	offset    int64
+	timestamp time.Time
+}
+
+// ResetToEarliest resets to the oldest message still available in a partition
+func ResetToEarliest() OffsetPosition {
+	return OffsetPosition{offset: sarama.OffsetOldest}
+}
+
+// ResetToLatest resets to the end of a partition, so only new messages are consumed
+func ResetToLatest() OffsetPosition {
+	return OffsetPosition{offset: sarama.OffsetNewest}
+}
+
+// ResetToOffset resets to a specific offset. It is clamped to the available range of each partition
+func ResetToOffset(offset int64) OffsetPosition {
+	return OffsetPosition{offset: offset}
+}
+
+// ResetToTimestamp resets to the first message published at or after t.
+// Partitions without such a message are reset to their end
+func ResetToTimestamp(t time.Time) OffsetPosition {
+	return OffsetPosition{timestamp: t}
+}
+
+func (p OffsetPosition) String() string {
+	switch {
+	case !p.timestamp.IsZero():
+		return p.timestamp.Format(time.RFC3339)
+	case p.offset == sarama.OffsetOldest:
+		return "earliest"
+	case p.offset == sarama.OffsetNewest:
+		return "latest"
+	default:
+		return fmt.Sprint(p.offset)
+	}
+}
+
+// OffsetReset describes which offsets of a consumer group should be moved where
+type OffsetReset struct {
+	GroupID string
+	Topic   string
+	// Partitions to reset. All partitions of the topic are reset if it is empty
+	Partitions []int32
+	To         OffsetPosition
+	// DryRun only calculates the changes without committing them
+	DryRun bool
+}
+
+// OffsetChange is the planned or applied change of a partition's committed offset.
+// Current is -1 if the group has no committed offset for the partition
+type OffsetChange struct {
+	Topic     string
+	Partition int32
+	Current   int64
+	New       int64
+}
+
+// ConsumerGroupAdmin manages the offsets of consumer groups
+type ConsumerGroupAdmin struct {
+	client sarama.Client
+	admin  sarama.ClusterAdmin
+}
+
+// NewConsumerGroupAdmin creates a ConsumerGroupAdmin connected to the given brokers
+func NewConsumerGroupAdmin(brokers []string) (ConsumerGroupAdmin, error) {
+	config := sarama.NewConfig()
+	// timestamp based offset lookups need a newer protocol version than sarama's default
+	config.Version = sarama.V2_0_0_0
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return ConsumerGroupAdmin{}, err
+	}
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		client.Close()
+		return ConsumerGroupAdmin{}, err
+	}
+	return ConsumerGroupAdmin{client: client, admin: admin}, nil
+}
+
+// Close closes the connection to the cluster
+func (a ConsumerGroupAdmin) Close() error {
+	// closing admin closes the underlying client as well
+	return a.admin.Close()
+}
+
+// ResetOffsets moves the committed offsets of a consumer group.
+// The group must not have active members, otherwise kafka would reject the commit, or the members would overwrite it.
+func (a ConsumerGroupAdmin) ResetOffsets(ctx context.Context, req OffsetReset) ([]OffsetChange, error) {
+	partitions := req.Partitions
+	if len(partitions) == 0 {
+		var err error
+		partitions, err = a.client.Partitions(req.Topic)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get partitions of topic %q: %w", req.Topic, err)
+		}
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+
+	current, err := a.admin.ListConsumerGroupOffsets(req.GroupID, map[string][]int32{req.Topic: partitions})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list offsets of group %q: %w", req.GroupID, err)
+	}
+
+	changes := make([]OffsetChange, 0, len(partitions))
+	for _, p := range partitions {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		newOffset, err := a.resolve(req.Topic, p, req.To)
+		if err != nil {
+			return nil, err
+		}
+		change := OffsetChange{Topic: req.Topic, Partition: p, Current: -1, New: newOffset}
+		if block := current.GetBlock(req.Topic, p); block != nil {
+			change.Current = block.Offset
+		}
+		changes = append(changes, change)
+	}
+	if req.DryRun {
+		return changes, nil
+	}
+
+	if err := a.ensureGroupIsInactive(req.GroupID); err != nil {
+		return nil, err
+	}
+	if err := a.commit(req.GroupID, changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// resolve converts an OffsetPosition to an absolute offset of the partition
+func (a ConsumerGroupAdmin) resolve(topic string, partition int32, pos OffsetPosition) (int64, error) {
+	oldest, err := a.client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get oldest offset of %s/%d: %w", topic, partition, err)
+	}
+	newest, err := a.client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get newest offset of %s/%d: %w", topic, partition, err)
+	}
+
+	switch {
+	case !pos.timestamp.IsZero():
+		offset, err := a.client.GetOffset(topic, partition, pos.timestamp.UnixNano()/int64(time.Millisecond))
+		if err != nil {
+			return 0, fmt.Errorf("failed to get offset of %s/%d at %v: %w", topic, partition, pos.timestamp, err)
+		}
+		// kafka returns -1 if there is no message after the timestamp
+		if offset < 0 {
+			return newest, nil
+		}
+		return offset, nil
+	case pos.offset == sarama.OffsetOldest:
+		return oldest, nil
+	case pos.offset == sarama.OffsetNewest:
+		return newest, nil
+	case pos.offset < oldest:
+		return oldest, nil
+	case pos.offset > newest:
+		return newest, nil
+	default:
+		return pos.offset, nil
+	}
+}
+
+func (a ConsumerGroupAdmin) ensureGroupIsInactive(groupID string) error {
+	groups, err := a.admin.DescribeConsumerGroups([]string{groupID})
+	if err != nil {
+		return fmt.Errorf("failed to describe group %q: %w", groupID, err)
+	}
+	for _, g := range groups {
+		if len(g.Members) > 0 {
+			return fmt.Errorf("group %q has %d active members, stop the consumers before resetting offsets", groupID, len(g.Members))
+		}
+	}
+	return nil
+}
+
+func (a ConsumerGroupAdmin) commit(groupID string, changes []OffsetChange) error {
+	om, err := sarama.NewOffsetManagerFromClient(groupID, a.client)
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		pom, err := om.ManagePartition(c.Topic, c.Partition)
+		if err != nil {
+			om.Close()
+			return err
+		}
+		// MarkOffset only moves the offset forward and ResetOffset only backwards (or keeps it),
+		// calling both marks the partition dirty with the new offset either way
+		pom.MarkOffset(c.New, "")
+		pom.ResetOffset(c.New, "")
+	}
+	// Close flushes the offsets to the broker
+	if err := om.Close(); err != nil {
+		return err
+	}
+
+	// offset manager doesn't report failed commits, check that they are persisted instead
+	partitions := map[string][]int32{}
+	for _, c := range changes {
+		partitions[c.Topic] = append(partitions[c.Topic], c.Partition)
+	}
+	committed, err := a.admin.ListConsumerGroupOffsets(groupID, partitions)
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		block := committed.GetBlock(c.Topic, c.Partition)
+		if block == nil || block.Offset != c.New {
+			return fmt.Errorf("offset of %s/%d wasn't committed", c.Topic, c.Partition)
+		}
+	}
+	return nil
+}
+
+// Replay reads the messages of options.UserEventsTopic published between from and to, and calls handlerFn for each of them.
+// Zero from means the beginning of the topic, zero to means the current end of it.
+// Offsets aren't committed, so replaying doesn't affect any consumer group. Partitions are replayed one after another.
+func Replay(ctx context.Context, options Options, from, to time.Time, handlerFn func(msg KafkaMessage)) error {
+	options = options.withDefaults()
+	topic := options.UserEventsTopic
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_0_0_0
+	client, err := sarama.NewClient(options.Brokers, config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return fmt.Errorf("failed to get partitions of topic %q: %w", topic, err)
+	}
+	for _, p := range partitions {
+		start, end, err := replayRange(client, topic, p, from, to)
+		if err != nil {
+			return err
+		}
+		if start >= end {
+			continue
+		}
+		if err := replayPartition(ctx, consumer, options, p, start, end, handlerFn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayRange returns the [start, end) offsets of the messages published between from and to
+func replayRange(client sarama.Client, topic string, partition int32, from, to time.Time) (int64, int64, error) {
+	end, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, 0, err
+	}
+	start := sarama.OffsetOldest
+	if !from.IsZero() {
+		start = from.UnixNano() / int64(time.Millisecond)
+	}
+	start, err = client.GetOffset(topic, partition, start)
+	if err != nil {
+		return 0, 0, err
+	}
+	// no message after from
+	if start < 0 {
+		return end, end, nil
+	}
+	if !to.IsZero() {
+		toOffset, err := client.GetOffset(topic, partition, to.UnixNano()/int64(time.Millisecond))
+		if err != nil {
+			return 0, 0, err
+		}
+		if toOffset >= 0 {
+			end = toOffset
+		}
+	}
+	return start, end, nil
+}
+
+func replayPartition(ctx context.Context, consumer sarama.Consumer, options Options, partition int32, start, end int64, handlerFn func(msg KafkaMessage)) error {
+	topic := options.UserEventsTopic
+	pc, err := consumer.ConsumePartition(topic, partition, start)
+	if err != nil {
+		return fmt.Errorf("failed to consume %s/%d: %w", topic, partition, err)
+	}
+	defer pc.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case message, ok := <-pc.Messages():
+			if !ok || message.Offset >= end {
+				return nil
+			}
+			v, err := decoder.Decode(message.Value)
+			if err != nil {
+				options.Logger.Error("failed to decode a replayed message",
+					append([]interface{}{"topic", topic, "partition", partition, "offset", message.Offset, "err", err}, options.payloadFields(message.Value)...)...)
+			} else {
+				var span trace.Span
+				v.ctx, span = options.startProcessSpan(message)
+				handlerFn(v)
+				span.End()
+			}
+			if message.Offset+1 >= end {
+				return nil
+			}
+		}
+	}
+}
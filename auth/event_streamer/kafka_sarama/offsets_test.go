@@ -0,0 +1,99 @@
+package kafka_sarama_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/event_streamer/kafka_sarama"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsumerGroupAdmin(t *testing.T) {
+	topicName := test_helpers.CreateTopic(t, "offsets-test")
+	groupID := fmt.Sprint(topicName, "-consumer")
+	k, err := kafka_sarama.NewSarama(kafka_sarama.Options{
+		Brokers:                   test_helpers.Config(t).Kafka.Brokers,
+		UserEventsTopic:           topicName,
+		UserEventsConsumerGroupID: groupID,
+	})
+	require.Nil(t, err)
+
+	beforePublish := time.Now().Add(-time.Second)
+	for i := 1; i <= 3; i++ {
+		require.Nil(t, k.PublishUserSignupEvent(context.Background(), auth.SignupEvent{
+			ID: i, Username: fmt.Sprint("user-", i),
+		}))
+	}
+
+	admin, err := kafka_sarama.NewConsumerGroupAdmin(test_helpers.Config(t).Kafka.Brokers)
+	require.Nil(t, err)
+	t.Cleanup(func() {
+		require.Nil(t, admin.Close())
+	})
+
+	t.Run(`#ResetOffsets with DryRun returns the changes without committing them`, func(t *testing.T) {
+		changes, err := admin.ResetOffsets(context.Background(), kafka_sarama.OffsetReset{
+			GroupID: groupID,
+			Topic:   topicName,
+			To:      kafka_sarama.ResetToLatest(),
+			DryRun:  true,
+		})
+		require.Nil(t, err)
+		require.Equal(t, []kafka_sarama.OffsetChange{
+			{Topic: topicName, Partition: 0, Current: -1, New: 3},
+		}, changes)
+
+		changes, err = admin.ResetOffsets(context.Background(), kafka_sarama.OffsetReset{
+			GroupID: groupID,
+			Topic:   topicName,
+			To:      kafka_sarama.ResetToEarliest(),
+			DryRun:  true,
+		})
+		require.Nil(t, err)
+		require.Equal(t, int64(-1), changes[0].Current, "nothing should have been committed by the previous dry run")
+	})
+
+	t.Run(`#ResetOffsets commits the offsets, and moves them backwards as well`, func(t *testing.T) {
+		for _, tc := range []struct {
+			to       kafka_sarama.OffsetPosition
+			expected int64
+		}{
+			{to: kafka_sarama.ResetToLatest(), expected: 3},
+			{to: kafka_sarama.ResetToOffset(1), expected: 1},
+			{to: kafka_sarama.ResetToTimestamp(beforePublish), expected: 0},
+		} {
+			_, err := admin.ResetOffsets(context.Background(), kafka_sarama.OffsetReset{
+				GroupID: groupID,
+				Topic:   topicName,
+				To:      tc.to,
+			})
+			require.Nil(t, err)
+
+			changes, err := admin.ResetOffsets(context.Background(), kafka_sarama.OffsetReset{
+				GroupID: groupID,
+				Topic:   topicName,
+				To:      tc.to,
+				DryRun:  true,
+			})
+			require.Nil(t, err)
+			require.Equal(t, tc.expected, changes[0].Current, tc.to.String())
+		}
+	})
+
+	t.Run(`#Replay sends the messages of the time range to the registered handler`, func(t *testing.T) {
+		var usernames []string
+		k.RegisterUserSignupEventConsumer(context.Background(), func(event auth.ConsumedSignupEvent) {
+			usernames = append(usernames, event.SignupEvent().Username)
+		})
+		require.Nil(t, k.Replay(context.Background(), beforePublish, time.Time{}))
+		require.Equal(t, []string{"user-1", "user-2", "user-3"}, usernames)
+
+		usernames = nil
+		require.Nil(t, k.Replay(context.Background(), time.Time{}, beforePublish))
+		require.Empty(t, usernames)
+	})
+}
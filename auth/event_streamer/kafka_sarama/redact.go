@@ -0,0 +1,63 @@
+package kafka_sarama
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// redact replaces the values of the given json fields, at any depth of the payload.
+// Payloads that aren't valid json are not logged at all, as it is not possible to find the fields in them
+func redact(payload []byte, fields []string) string {
+	var v interface{}
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return redacted
+	}
+	v = redactValue(v, fields)
+	b, err := json.Marshal(v)
+	if err != nil {
+		return redacted
+	}
+	return string(b)
+}
+
+// Redacted returns the json of the message with the values of the fields replaced, e.g. to print the messages of Replay.
+// DefaultRedactedFields are replaced if fields is nil
+func Redacted(msg KafkaMessage, fields []string) (json.RawMessage, error) {
+	if fields == nil {
+		fields = DefaultRedactedFields
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(redact(payload, fields)), nil
+}
+
+func redactValue(v interface{}, fields []string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, val := range v {
+			if isRedacted(k, fields) {
+				v[k] = redacted
+				continue
+			}
+			v[k] = redactValue(val, fields)
+		}
+	case []interface{}:
+		for i, val := range v {
+			v[i] = redactValue(val, fields)
+		}
+	}
+	return v
+}
+
+func isRedacted(key string, fields []string) bool {
+	for _, f := range fields {
+		if strings.EqualFold(key, f) {
+			return true
+		}
+	}
+	return false
+}
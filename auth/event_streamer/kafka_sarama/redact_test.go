@@ -0,0 +1,27 @@
+package kafka_sarama
+
+import (
+	"testing"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedact(t *testing.T) {
+	t.Run(`fields are redacted case-insensitively at any depth`, func(t *testing.T) {
+		payload := []byte(`{"PublishedAt":"2021-07-19T13:11:26Z","UserSignupEvent":{"ID":1,"Email":"e@mail","Username":"u","Password":"p"}}`)
+		require.JSONEq(t,
+			`{"PublishedAt":"2021-07-19T13:11:26Z","UserSignupEvent":{"ID":1,"Email":"[REDACTED]","Username":"u","Password":"[REDACTED]"}}`,
+			redact(payload, []string{"password", "email"}))
+	})
+
+	t.Run(`invalid json is not logged at all`, func(t *testing.T) {
+		require.Equal(t, redacted, redact([]byte(`{"Password":"p"`), DefaultRedactedFields))
+	})
+	t.Run(`#Redacted replaces the default fields of a message`, func(t *testing.T) {
+		raw, err := Redacted(KafkaMessage{UserSignupEvent: auth.SignupEvent{ID: 1, Email: "e@mail", Username: "u"}}, nil)
+		require.Nil(t, err)
+		require.NotContains(t, string(raw), "e@mail")
+		require.Contains(t, string(raw), `"Username":"u"`)
+	})
+}
@@ -5,11 +5,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"os"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/logging"
+	"github.com/davudsafarli/twitter/auth/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var decoder JSONEncoderDecoder
@@ -18,6 +23,52 @@ type Options struct {
 	Brokers                   []string
 	UserEventsTopic           string
 	UserEventsConsumerGroupID string
+
+	// Logger is used for all logs of the client.
+	// Defaults to INFO level logs to stderr, use logging.Nop() to silence it
+	Logger logging.Logger
+	// LogPayloads adds the values of the published and consumed messages to their DEBUG level logs.
+	// Values of RedactedFields are replaced before logging
+	LogPayloads bool
+	// RedactedFields are the (case-insensitive) json field names that are never logged. Defaults to DefaultRedactedFields
+	RedactedFields []string
+	// Metrics is optional, nothing is observed if it is nil
+	Metrics Metrics
+	// TracerProvider creates the spans of publishing and consuming. Defaults to the global TracerProvider
+	TracerProvider trace.TracerProvider
+	// Propagator injects the trace context to the headers of published messages, and extracts it from the consumed ones.
+	// Defaults to W3C Trace Context
+	Propagator propagation.TextMapPropagator
+}
+
+// DefaultRedactedFields are the fields of events that contain credentials or personal data
+var DefaultRedactedFields = []string{"Password", "Email"}
+
+func (o Options) withDefaults() Options {
+	if o.Logger == nil {
+		o.Logger = logging.New(os.Stderr, logging.LevelInfo)
+	}
+	if o.RedactedFields == nil {
+		o.RedactedFields = DefaultRedactedFields
+	}
+	if o.Metrics == nil {
+		o.Metrics = nopMetrics{}
+	}
+	if o.TracerProvider == nil {
+		o.TracerProvider = otel.GetTracerProvider()
+	}
+	if o.Propagator == nil {
+		o.Propagator = propagation.TraceContext{}
+	}
+	return o
+}
+
+// payloadFields returns the log fields of a message value, if payload logging is enabled
+func (o Options) payloadFields(value []byte) []interface{} {
+	if !o.LogPayloads {
+		return nil
+	}
+	return []interface{}{"payload", redact(value, o.RedactedFields)}
 }
 
 type SaramaClient struct {
@@ -25,15 +76,30 @@ type SaramaClient struct {
 	Writer  sarama.SyncProducer
 	Reader  sarama.ConsumerGroup
 
+	// client is shared by Writer and the health checks
+	client sarama.Client
+	// consumer records why the loop of StartConsume stopped
+	consumer *consumerState
+
 	handlers struct {
-		signupEventHandler func(event auth.ConsumedSignupEvent)
+		signupEventHandler          func(event auth.ConsumedSignupEvent)
+		profileUpdatedEventHandler  func(event auth.ConsumedProfileUpdatedEvent)
+		passwordChangedEventHandler func(event auth.ConsumedPasswordChangedEvent)
+		emailChangedEventHandler    func(event auth.ConsumedEmailChangedEvent)
+		emailVerifiedEventHandler   func(event auth.ConsumedEmailVerifiedEvent)
+		accountLockedEventHandler   func(event auth.ConsumedAccountLockedEvent)
+		userDeactivatedEventHandler func(event auth.ConsumedUserDeactivatedEvent)
+		userReactivatedEventHandler func(event auth.ConsumedUserReactivatedEvent)
+		userDeletedEventHandler     func(event auth.ConsumedUserDeletedEvent)
+		auditEventHandler           func(event auth.ConsumedAuditEvent)
 	}
 }
 
 // NewSarama creates a new KafkaClient using Sarama Go Library
 func NewSarama(options Options) (SaramaClient, error) {
 	k := SaramaClient{
-		Options: options,
+		Options:  options.withDefaults(),
+		consumer: &consumerState{},
 	}
 	if err := k.setupPublisher(); err != nil {
 		return SaramaClient{}, err
@@ -52,12 +118,18 @@ func (k *SaramaClient) setupPublisher() error {
 	config.Producer.Retry.Max = 10                   // Retry up to 10 times to produce the message
 	config.Producer.Return.Successes = true
 
-	producer, err := sarama.NewSyncProducer(k.Options.Brokers, config)
+	client, err := sarama.NewClient(k.Options.Brokers, config)
+	if err != nil {
+		return err
+	}
+	producer, err := sarama.NewSyncProducerFromClient(client)
 	if err != nil {
+		client.Close()
 		return err
 	}
+	k.client = client
 	k.Writer = producer
-	return err
+	return nil
 }
 
 // setupConsumer creates Consumer.
@@ -77,8 +149,28 @@ func (k *SaramaClient) setupConsumer() error {
 
 // KafkaMessage is the final struct that is encoded and sent to a kafka topic as a value
 type KafkaMessage struct {
-	PublishedAt     time.Time
-	UserSignupEvent auth.SignupEvent `json:",omitempty"`
+	PublishedAt              time.Time
+	UserSignupEvent          auth.SignupEvent           `json:",omitempty"`
+	UserProfileUpdatedEvent  *auth.ProfileUpdatedEvent  `json:",omitempty"`
+	UserPasswordChangedEvent *auth.PasswordChangedEvent `json:",omitempty"`
+	UserEmailChangedEvent    *auth.EmailChangedEvent    `json:",omitempty"`
+	UserEmailVerifiedEvent   *auth.EmailVerifiedEvent   `json:",omitempty"`
+	UserAccountLockedEvent   *auth.AccountLockedEvent   `json:",omitempty"`
+	UserUserDeactivatedEvent *auth.UserDeactivatedEvent `json:"UserDeactivatedEvent,omitempty"`
+	UserUserReactivatedEvent *auth.UserReactivatedEvent `json:"UserReactivatedEvent,omitempty"`
+	UserUserDeletedEvent     *auth.UserDeletedEvent     `json:"UserDeletedEvent,omitempty"`
+	UserAuditEvent           *auth.AuditEvent           `json:"AuditEvent,omitempty"`
+
+	// ctx carries the span of processing a consumed message
+	ctx context.Context
+}
+
+// Context returns the context of processing the consumed message, which continues the trace of the publisher
+func (msg KafkaMessage) Context() context.Context {
+	if msg.ctx == nil {
+		return context.Background()
+	}
+	return msg.ctx
 }
 
 // Timestamp returns the time that kafka message was sent to the kafka topic
@@ -86,27 +178,211 @@ func (msg KafkaMessage) Timestamp() time.Time {
 	return msg.PublishedAt
 }
 
-// SignupEvent returns the currenly consumed SignupEvent
+// EventType returns the name of the event the message carries
+func (msg KafkaMessage) EventType() string {
+	switch {
+	case msg.UserSignupEvent != auth.SignupEvent{}:
+		return "Signup"
+	case msg.UserProfileUpdatedEvent != nil:
+		return "ProfileUpdated"
+	case msg.UserPasswordChangedEvent != nil:
+		return "PasswordChanged"
+	case msg.UserEmailChangedEvent != nil:
+		return "EmailChanged"
+	case msg.UserEmailVerifiedEvent != nil:
+		return "EmailVerified"
+	case msg.UserAccountLockedEvent != nil:
+		return "AccountLocked"
+	case msg.UserUserDeactivatedEvent != nil:
+		return "UserDeactivated"
+	case msg.UserUserReactivatedEvent != nil:
+		return "UserReactivated"
+	case msg.UserUserDeletedEvent != nil:
+		return "UserDeleted"
+	case msg.UserAuditEvent != nil:
+		return "Audit"
+	}
+	return "Unknown"
+}
+
+// SignupEvent returns the currently consumed SignupEvent
 func (msg KafkaMessage) SignupEvent() auth.SignupEvent {
 	return msg.UserSignupEvent
 }
 
+// ProfileUpdatedEvent returns the currently consumed ProfileUpdatedEvent
+func (msg KafkaMessage) ProfileUpdatedEvent() auth.ProfileUpdatedEvent {
+	if msg.UserProfileUpdatedEvent == nil {
+		return auth.ProfileUpdatedEvent{}
+	}
+	return *msg.UserProfileUpdatedEvent
+}
+
+// PasswordChangedEvent returns the currently consumed PasswordChangedEvent
+func (msg KafkaMessage) PasswordChangedEvent() auth.PasswordChangedEvent {
+	if msg.UserPasswordChangedEvent == nil {
+		return auth.PasswordChangedEvent{}
+	}
+	return *msg.UserPasswordChangedEvent
+}
+
+// EmailChangedEvent returns the currently consumed EmailChangedEvent
+func (msg KafkaMessage) EmailChangedEvent() auth.EmailChangedEvent {
+	if msg.UserEmailChangedEvent == nil {
+		return auth.EmailChangedEvent{}
+	}
+	return *msg.UserEmailChangedEvent
+}
+
+// EmailVerifiedEvent returns the currently consumed EmailVerifiedEvent
+func (msg KafkaMessage) EmailVerifiedEvent() auth.EmailVerifiedEvent {
+	if msg.UserEmailVerifiedEvent == nil {
+		return auth.EmailVerifiedEvent{}
+	}
+	return *msg.UserEmailVerifiedEvent
+}
+
+// AccountLockedEvent returns the currently consumed AccountLockedEvent
+func (msg KafkaMessage) AccountLockedEvent() auth.AccountLockedEvent {
+	if msg.UserAccountLockedEvent == nil {
+		return auth.AccountLockedEvent{}
+	}
+	return *msg.UserAccountLockedEvent
+}
+
+// UserDeactivatedEvent returns the currently consumed UserDeactivatedEvent
+func (msg KafkaMessage) UserDeactivatedEvent() auth.UserDeactivatedEvent {
+	if msg.UserUserDeactivatedEvent == nil {
+		return auth.UserDeactivatedEvent{}
+	}
+	return *msg.UserUserDeactivatedEvent
+}
+
+// UserReactivatedEvent returns the currently consumed UserReactivatedEvent
+func (msg KafkaMessage) UserReactivatedEvent() auth.UserReactivatedEvent {
+	if msg.UserUserReactivatedEvent == nil {
+		return auth.UserReactivatedEvent{}
+	}
+	return *msg.UserUserReactivatedEvent
+}
+
+// UserDeletedEvent returns the currently consumed UserDeletedEvent
+func (msg KafkaMessage) UserDeletedEvent() auth.UserDeletedEvent {
+	if msg.UserUserDeletedEvent == nil {
+		return auth.UserDeletedEvent{}
+	}
+	return *msg.UserUserDeletedEvent
+}
+
+// AuditEvent returns the currently consumed AuditEvent
+func (msg KafkaMessage) AuditEvent() auth.AuditEvent {
+	if msg.UserAuditEvent == nil {
+		return auth.AuditEvent{}
+	}
+	return *msg.UserAuditEvent
+}
+
 // PublishUserSignupEvent publishes a UserEvent
 func (k SaramaClient) PublishUserSignupEvent(ctx context.Context, event auth.SignupEvent) error {
-	msg := KafkaMessage{
+	return k.publish(ctx, event.ID, KafkaMessage{
 		PublishedAt:     time.Now(),
 		UserSignupEvent: event,
-	}
+	})
+}
+
+// PublishProfileUpdatedEvent publishes a ProfileUpdatedEvent
+func (k SaramaClient) PublishProfileUpdatedEvent(ctx context.Context, event auth.ProfileUpdatedEvent) error {
+	return k.publish(ctx, event.UserID, KafkaMessage{
+		PublishedAt:             time.Now(),
+		UserProfileUpdatedEvent: &event,
+	})
+}
+
+// PublishPasswordChangedEvent publishes a PasswordChangedEvent
+func (k SaramaClient) PublishPasswordChangedEvent(ctx context.Context, event auth.PasswordChangedEvent) error {
+	return k.publish(ctx, event.UserID, KafkaMessage{
+		PublishedAt:              time.Now(),
+		UserPasswordChangedEvent: &event,
+	})
+}
+
+// PublishEmailChangedEvent publishes an EmailChangedEvent
+func (k SaramaClient) PublishEmailChangedEvent(ctx context.Context, event auth.EmailChangedEvent) error {
+	return k.publish(ctx, event.UserID, KafkaMessage{
+		PublishedAt:           time.Now(),
+		UserEmailChangedEvent: &event,
+	})
+}
+
+// PublishEmailVerifiedEvent publishes a EmailVerifiedEvent
+func (k SaramaClient) PublishEmailVerifiedEvent(ctx context.Context, event auth.EmailVerifiedEvent) error {
+	return k.publish(ctx, event.UserID, KafkaMessage{
+		PublishedAt:            time.Now(),
+		UserEmailVerifiedEvent: &event,
+	})
+}
+
+// PublishAccountLockedEvent publishes a AccountLockedEvent
+func (k SaramaClient) PublishAccountLockedEvent(ctx context.Context, event auth.AccountLockedEvent) error {
+	return k.publish(ctx, event.UserID, KafkaMessage{
+		PublishedAt:            time.Now(),
+		UserAccountLockedEvent: &event,
+	})
+}
+
+// PublishUserDeactivatedEvent publishes a UserDeactivatedEvent
+func (k SaramaClient) PublishUserDeactivatedEvent(ctx context.Context, event auth.UserDeactivatedEvent) error {
+	return k.publish(ctx, event.UserID, KafkaMessage{
+		PublishedAt:              time.Now(),
+		UserUserDeactivatedEvent: &event,
+	})
+}
+
+// PublishUserReactivatedEvent publishes a UserReactivatedEvent
+func (k SaramaClient) PublishUserReactivatedEvent(ctx context.Context, event auth.UserReactivatedEvent) error {
+	return k.publish(ctx, event.UserID, KafkaMessage{
+		PublishedAt:              time.Now(),
+		UserUserReactivatedEvent: &event,
+	})
+}
+
+// PublishUserDeletedEvent publishes a UserDeletedEvent
+func (k SaramaClient) PublishUserDeletedEvent(ctx context.Context, event auth.UserDeletedEvent) error {
+	return k.publish(ctx, event.UserID, KafkaMessage{
+		PublishedAt:          time.Now(),
+		UserUserDeletedEvent: &event,
+	})
+}
+
+// PublishAuditEvent publishes an AuditEvent
+func (k SaramaClient) PublishAuditEvent(ctx context.Context, event auth.AuditEvent) error {
+	return k.publish(ctx, event.UserID, KafkaMessage{
+		PublishedAt:    time.Now(),
+		UserAuditEvent: &event,
+	})
+}
+
+// publish writes the message to UserEventsTopic. Messages are keyed by the user ID,
+// so the events of a user go to the same partition and are consumed in order
+func (k SaramaClient) publish(ctx context.Context, userID int, msg KafkaMessage) (err error) {
 	value := &JSONEncoderDecoder{Value: msg}
-	p, offset, err := k.Writer.SendMessage(&sarama.ProducerMessage{
+	logger := k.Options.Logger.With("topic", k.Options.UserEventsTopic, "event_type", msg.EventType())
+	producerMsg := &sarama.ProducerMessage{
 		Topic: k.Options.UserEventsTopic,
-		Key:   sarama.StringEncoder(fmt.Sprint(event.ID)),
+		Key:   sarama.StringEncoder(fmt.Sprint(userID)),
 		Value: value,
-	})
+	}
+	_, span := k.Options.startPublishSpan(ctx, producerMsg)
+	defer tracing.EndSpan(span, &err)
+	start := time.Now()
+	p, offset, err := k.Writer.SendMessage(producerMsg)
+	k.Options.Metrics.ObservePublish(k.Options.UserEventsTopic, time.Since(start), err)
 	if err != nil {
+		logger.Error("failed to write message", "err", err)
 		return fmt.Errorf("failed to write message: %w", err)
 	}
-	log.Printf("Message is writter to topic/partition/offset : %v/%v/%v", k.Options.UserEventsTopic, p, offset)
+	encoded, _ := value.Encode()
+	logger.Debug("message written", append([]interface{}{"partition", p, "offset", offset}, k.Options.payloadFields(encoded)...)...)
 	return nil
 }
 
@@ -120,25 +396,114 @@ func (k *SaramaClient) RegisterUserSignupEventConsumer(ctx context.Context, hand
 	k.handlers.signupEventHandler = handlerFn
 }
 
+// RegisterProfileUpdatedEventConsumer registers a handler function for consuming "ProfileUpdatedEvent"s
+func (k *SaramaClient) RegisterProfileUpdatedEventConsumer(ctx context.Context, handlerFn func(event auth.ConsumedProfileUpdatedEvent)) {
+	k.handlers.profileUpdatedEventHandler = handlerFn
+}
+
+// RegisterPasswordChangedEventConsumer registers a handler function for consuming "PasswordChangedEvent"s
+func (k *SaramaClient) RegisterPasswordChangedEventConsumer(ctx context.Context, handlerFn func(event auth.ConsumedPasswordChangedEvent)) {
+	k.handlers.passwordChangedEventHandler = handlerFn
+}
+
+// RegisterEmailChangedEventConsumer registers a handler function for consuming "EmailChangedEvent"s
+func (k *SaramaClient) RegisterEmailChangedEventConsumer(ctx context.Context, handlerFn func(event auth.ConsumedEmailChangedEvent)) {
+	k.handlers.emailChangedEventHandler = handlerFn
+}
+
+// RegisterEmailVerifiedEventConsumer registers a handler function for consuming "EmailVerifiedEvent"s
+func (k *SaramaClient) RegisterEmailVerifiedEventConsumer(ctx context.Context, handlerFn func(event auth.ConsumedEmailVerifiedEvent)) {
+	k.handlers.emailVerifiedEventHandler = handlerFn
+}
+
+// RegisterAccountLockedEventConsumer registers a handler function for consuming "AccountLockedEvent"s
+func (k *SaramaClient) RegisterAccountLockedEventConsumer(ctx context.Context, handlerFn func(event auth.ConsumedAccountLockedEvent)) {
+	k.handlers.accountLockedEventHandler = handlerFn
+}
+
+// RegisterUserDeactivatedEventConsumer registers a handler function for consuming "UserDeactivatedEvent"s
+func (k *SaramaClient) RegisterUserDeactivatedEventConsumer(ctx context.Context, handlerFn func(event auth.ConsumedUserDeactivatedEvent)) {
+	k.handlers.userDeactivatedEventHandler = handlerFn
+}
+
+// RegisterUserReactivatedEventConsumer registers a handler function for consuming "UserReactivatedEvent"s
+func (k *SaramaClient) RegisterUserReactivatedEventConsumer(ctx context.Context, handlerFn func(event auth.ConsumedUserReactivatedEvent)) {
+	k.handlers.userReactivatedEventHandler = handlerFn
+}
+
+// RegisterUserDeletedEventConsumer registers a handler function for consuming "UserDeletedEvent"s
+func (k *SaramaClient) RegisterUserDeletedEventConsumer(ctx context.Context, handlerFn func(event auth.ConsumedUserDeletedEvent)) {
+	k.handlers.userDeletedEventHandler = handlerFn
+}
+
+// RegisterAuditEventConsumer registers a handler function for consuming "AuditEvent"s
+func (k *SaramaClient) RegisterAuditEventConsumer(ctx context.Context, handlerFn func(event auth.ConsumedAuditEvent)) {
+	k.handlers.auditEventHandler = handlerFn
+}
+
 // StartConsume starts listening kafka topics and send messages to the registered consumers.
 // It can see the registered handlers, and only listen the topics that are have handlers for
 func (k *SaramaClient) StartConsume(ctx context.Context) io.Closer {
+	k.consumer.started()
 	go func() {
 		consumer := SimpleGroupConsumer{
-			handlerFn: func(msg KafkaMessage) {
-				if ok := (msg.UserSignupEvent != auth.SignupEvent{}); ok {
-					k.handlers.signupEventHandler(msg)
-				}
-			},
+			handlerFn: k.dispatch,
+			options:   k.Options,
 		}
 		for {
 			if err := k.Reader.Consume(ctx, []string{k.Options.UserEventsTopic}, &consumer); err != nil {
-				log.Printf("Error from consumer: %v", err)
+				k.Options.Logger.Error("consumer stopped", "topic", k.Options.UserEventsTopic, "group", k.Options.UserEventsConsumerGroupID, "err", err)
+				k.consumer.stopped(err)
+				return
+			}
+			// Consume returns after every rebalance, and without an error once ctx is done
+			if err := ctx.Err(); err != nil {
+				k.consumer.stopped(err)
 				return
 			}
 		}
 	}()
-	return k.Reader
+	return consumerCloser{reader: k.Reader, state: k.consumer}
+}
+
+// Replay reads the messages of UserEventsTopic published between from and to, and sends them to the registered consumers.
+// Unlike StartConsume it doesn't use the consumer group, so no offsets are committed. It returns when all messages are replayed.
+func (k *SaramaClient) Replay(ctx context.Context, from, to time.Time) error {
+	return Replay(ctx, k.Options, from, to, k.dispatch)
+}
+
+// dispatch sends the message to the registered handler of its event type
+func (k *SaramaClient) dispatch(msg KafkaMessage) {
+	if ok := (msg.UserSignupEvent != auth.SignupEvent{}); ok && k.handlers.signupEventHandler != nil {
+		k.handlers.signupEventHandler(msg)
+	}
+	if msg.UserProfileUpdatedEvent != nil && k.handlers.profileUpdatedEventHandler != nil {
+		k.handlers.profileUpdatedEventHandler(msg)
+	}
+	if msg.UserPasswordChangedEvent != nil && k.handlers.passwordChangedEventHandler != nil {
+		k.handlers.passwordChangedEventHandler(msg)
+	}
+	if msg.UserEmailChangedEvent != nil && k.handlers.emailChangedEventHandler != nil {
+		k.handlers.emailChangedEventHandler(msg)
+	}
+	if msg.UserEmailVerifiedEvent != nil && k.handlers.emailVerifiedEventHandler != nil {
+		k.handlers.emailVerifiedEventHandler(msg)
+	}
+	if msg.UserAccountLockedEvent != nil && k.handlers.accountLockedEventHandler != nil {
+		k.handlers.accountLockedEventHandler(msg)
+	}
+	if msg.UserUserDeactivatedEvent != nil && k.handlers.userDeactivatedEventHandler != nil {
+		k.handlers.userDeactivatedEventHandler(msg)
+	}
+	if msg.UserUserReactivatedEvent != nil && k.handlers.userReactivatedEventHandler != nil {
+		k.handlers.userReactivatedEventHandler(msg)
+	}
+	if msg.UserUserDeletedEvent != nil && k.handlers.userDeletedEventHandler != nil {
+		k.handlers.userDeletedEventHandler(msg)
+	}
+	if msg.UserAuditEvent != nil && k.handlers.auditEventHandler != nil {
+		k.handlers.auditEventHandler(msg)
+	}
 }
 
 // SimpleGroupConsumer satisfies sarama.ConsumerGroupHandler interface and used for consuming messages from a topic partition.
@@ -146,6 +511,7 @@ func (k *SaramaClient) StartConsume(ctx context.Context) io.Closer {
 type SimpleGroupConsumer struct {
 	// TODO: add error return type
 	handlerFn func(msg KafkaMessage)
+	options   Options
 }
 
 func (c SimpleGroupConsumer) Setup(sarama.ConsumerGroupSession) error {
@@ -158,12 +524,22 @@ func (c SimpleGroupConsumer) Cleanup(sarama.ConsumerGroupSession) error {
 
 func (c SimpleGroupConsumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	for message := range claim.Messages() {
-		log.Printf("Message claimed: value = %s, timestamp = %v, topic = %s", string(message.Value), message.Timestamp, message.Topic)
+		logger := c.options.Logger.With("topic", message.Topic, "partition", message.Partition, "offset", message.Offset)
+		// HighWaterMarkOffset is the offset of the next message that will be produced
+		c.options.Metrics.SetConsumerLag(c.options.UserEventsConsumerGroupID, message.Topic, message.Partition, claim.HighWaterMarkOffset()-message.Offset-1)
 		v, err := decoder.Decode(message.Value)
 		if err != nil {
-			log.Printf("sarama failed to decode an incoming kafka message: %v", err)
+			logger.Error("failed to decode an incoming message", append([]interface{}{"err", err}, c.options.payloadFields(message.Value)...)...)
+			c.options.Metrics.IncDeadLetter(message.Topic, "decode")
+		} else {
+			logger.Debug("message claimed", append([]interface{}{"event_type", v.EventType(), "timestamp", message.Timestamp}, c.options.payloadFields(message.Value)...)...)
+			var span trace.Span
+			v.ctx, span = c.options.startProcessSpan(message)
+			start := time.Now()
+			c.handlerFn(v)
+			c.options.Metrics.ObserveHandle(message.Topic, v.EventType(), time.Since(start))
+			span.End()
 		}
-		c.handlerFn(v)
 		session.MarkMessage(message, "")
 	}
 	return nil
@@ -198,7 +574,6 @@ func (e *JSONEncoderDecoder) Length() int {
 	return len(e.encoded)
 }
 
-//
 // -- utility functions
 func DeleteTopic(brokers []string, topic string) error {
 	config := sarama.NewConfig()
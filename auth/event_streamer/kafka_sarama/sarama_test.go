@@ -1,9 +1,10 @@
 package kafka_sarama_test
 
 import (
+	"context"
 	"fmt"
-	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/davudsafarli/twitter/auth/contracts"
 	"github.com/davudsafarli/twitter/auth/event_streamer/kafka_sarama"
@@ -12,11 +13,11 @@ import (
 )
 
 func TestSarama(t *testing.T) {
-	// TODO: Move test-topic creating and DeleteTopic to EventProducerConsumerContract.Subject
-	topicName := fmt.Sprintf("sarama-test-%016x", rand.Int63())
+	// TODO: Move test-topic creating to EventProducerConsumerContract.Subject
+	topicName := test_helpers.CreateTopic(t, "sarama-test")
 	consumerName := fmt.Sprint(topicName, "-consumer")
 	sarama, err := kafka_sarama.NewSarama(kafka_sarama.Options{
-		Brokers:                   test_helpers.BROKERS,
+		Brokers:                   test_helpers.Config(t).Kafka.Brokers,
 		UserEventsTopic:           topicName,
 		UserEventsConsumerGroupID: consumerName,
 	})
@@ -24,7 +25,32 @@ func TestSarama(t *testing.T) {
 	contracts.EventProducerConsumerContract{
 		Subject: &sarama,
 	}.Test(t)
-	t.Cleanup(func() {
-		require.Nil(t, kafka_sarama.DeleteTopic(test_helpers.BROKERS, topicName))
+
+	t.Run(`#HealthCheck and #ConsumerGroupHealthCheck pass once the group is joined`, func(t *testing.T) {
+		require.Nil(t, sarama.HealthCheck(context.Background()))
+		require.Nil(t, sarama.ConsumerGroupHealthCheck(context.Background()))
+	})
+
+	t.Run(`#HealthCheck covers the consumer group while it consumes`, func(t *testing.T) {
+		consuming, err := kafka_sarama.NewSarama(kafka_sarama.Options{
+			Brokers:                   test_helpers.Config(t).Kafka.Brokers,
+			UserEventsTopic:           topicName,
+			UserEventsConsumerGroupID: fmt.Sprint(topicName, "-health"),
+		})
+		require.Nil(t, err)
+		require.Nil(t, consuming.HealthCheck(context.Background()), "the group isn't checked before StartConsume")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		consumer := consuming.StartConsume(ctx)
+		require.Eventually(t, func() bool {
+			return consuming.HealthCheck(context.Background()) == nil
+		}, 10*time.Second, 100*time.Millisecond, "the group should be joined")
+
+		cancel()
+		require.Eventually(t, func() bool {
+			return consuming.HealthCheck(context.Background()) != nil
+		}, 10*time.Second, 100*time.Millisecond, "the stopped consumer should take the check down")
+		require.Nil(t, consumer.Close())
+		require.Nil(t, consuming.HealthCheck(context.Background()), "closed consumers aren't checked")
 	})
 }
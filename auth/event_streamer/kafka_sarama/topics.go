@@ -0,0 +1,233 @@
+package kafka_sarama
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+const (
+	CleanupPolicyDelete        = "delete"
+	CleanupPolicyCompact       = "compact"
+	CleanupPolicyCompactDelete = "compact,delete"
+)
+
+// RetentionForever can be used as TopicSpec.Retention to keep the messages of a topic forever
+const RetentionForever = time.Duration(-1)
+
+// TopicSpec is a declarative description of a kafka topic.
+// Zero values of Retention, CleanupPolicy and MinCompactionLag mean "use the broker default"
+type TopicSpec struct {
+	Name              string
+	Partitions        int32
+	ReplicationFactor int16
+	// Retention is the retention.ms of the topic. Use RetentionForever to disable time based deletion
+	Retention     time.Duration
+	CleanupPolicy string
+	// MinCompactionLag is the min.compaction.lag.ms of the topic. Only makes sense for compacted topics
+	MinCompactionLag time.Duration
+}
+
+// DefaultTopicSpecs returns the specs of the topics described in auth package's design notes.
+// usersTopic is the name of the topic of the user events, it is the one UserEventsTopic is set to.
+// ReplicationFactor is 1 to match the single broker of the docker-compose setup, increase it for real clusters.
+func DefaultTopicSpecs(usersTopic string) []TopicSpec {
+	return []TopicSpec{
+		{
+			// events of users are the source of truth for other services, so they are kept forever
+			Name:              usersTopic,
+			Partitions:        3,
+			ReplicationFactor: 1,
+			Retention:         RetentionForever,
+			CleanupPolicy:     CleanupPolicyDelete,
+		},
+		{
+			Name:              "social",
+			Partitions:        6,
+			ReplicationFactor: 1,
+			Retention:         RetentionForever,
+			CleanupPolicy:     CleanupPolicyDelete,
+		},
+		{
+			Name:              "tweet",
+			Partitions:        12,
+			ReplicationFactor: 1,
+			Retention:         30 * 24 * time.Hour,
+			CleanupPolicy:     CleanupPolicyDelete,
+		},
+	}
+}
+
+// configEntries converts the spec to the topic-level configs kafka understands
+func (s TopicSpec) configEntries() map[string]string {
+	entries := map[string]string{}
+	if s.Retention != 0 {
+		entries["retention.ms"] = durationToMs(s.Retention)
+	}
+	if s.CleanupPolicy != "" {
+		entries["cleanup.policy"] = s.CleanupPolicy
+	}
+	if s.MinCompactionLag != 0 {
+		entries["min.compaction.lag.ms"] = durationToMs(s.MinCompactionLag)
+	}
+	return entries
+}
+
+func durationToMs(d time.Duration) string {
+	if d < 0 {
+		return "-1"
+	}
+	return strconv.FormatInt(d.Milliseconds(), 10)
+}
+
+// TopicDrift describes a setting of an existing topic that differs from its spec
+type TopicDrift struct {
+	Setting string
+	Want    string
+	Got     string
+}
+
+func (d TopicDrift) String() string {
+	return fmt.Sprintf("%s: want %s, got %s", d.Setting, d.Want, d.Got)
+}
+
+// TopicStatus is the result of comparing a TopicSpec with the cluster
+type TopicStatus struct {
+	Spec TopicSpec
+	// Missing is true if the topic doesn't exist in the cluster
+	Missing bool
+	// Created is true if the topic was missing and TopicManager created it
+	Created bool
+	// Drift lists the differences of an existing topic from its spec.
+	// TopicManager never alters existing topics, drifts should be fixed manually
+	Drift []TopicDrift
+}
+
+// TopicManager creates and inspects kafka topics according to TopicSpecs
+type TopicManager struct {
+	admin sarama.ClusterAdmin
+}
+
+// NewTopicManager creates a TopicManager connected to the given brokers
+func NewTopicManager(brokers []string) (TopicManager, error) {
+	config := sarama.NewConfig()
+	// DescribeConfigs and CreateTopics with configs need a newer protocol version than sarama's default
+	config.Version = sarama.V2_0_0_0
+	admin, err := sarama.NewClusterAdmin(brokers, config)
+	if err != nil {
+		return TopicManager{}, err
+	}
+	return TopicManager{admin: admin}, nil
+}
+
+// Close closes the connection to the cluster
+func (m TopicManager) Close() error {
+	return m.admin.Close()
+}
+
+// Check compares the specs with the cluster without changing anything
+func (m TopicManager) Check(specs []TopicSpec) ([]TopicStatus, error) {
+	existing, err := m.admin.ListTopics()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list topics: %w", err)
+	}
+	statuses := make([]TopicStatus, 0, len(specs))
+	for _, spec := range specs {
+		detail, ok := existing[spec.Name]
+		if !ok {
+			statuses = append(statuses, TopicStatus{Spec: spec, Missing: true})
+			continue
+		}
+		drift, err := m.drift(spec, detail)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, TopicStatus{Spec: spec, Drift: drift})
+	}
+	return statuses, nil
+}
+
+// Ensure creates the missing topics and reports the drift of the existing ones
+func (m TopicManager) Ensure(specs []TopicSpec) ([]TopicStatus, error) {
+	statuses, err := m.Check(specs)
+	if err != nil {
+		return nil, err
+	}
+	for i, status := range statuses {
+		if !status.Missing {
+			continue
+		}
+		if err := m.create(status.Spec); err != nil {
+			return statuses, err
+		}
+		statuses[i].Created = true
+	}
+	return statuses, nil
+}
+
+func (m TopicManager) create(spec TopicSpec) error {
+	entries := map[string]*string{}
+	for k, v := range spec.configEntries() {
+		v := v
+		entries[k] = &v
+	}
+	err := m.admin.CreateTopic(spec.Name, &sarama.TopicDetail{
+		NumPartitions:     spec.Partitions,
+		ReplicationFactor: spec.ReplicationFactor,
+		ConfigEntries:     entries,
+	}, false)
+	if err != nil {
+		return fmt.Errorf("failed to create topic %q: %w", spec.Name, err)
+	}
+	return nil
+}
+
+func (m TopicManager) drift(spec TopicSpec, detail sarama.TopicDetail) ([]TopicDrift, error) {
+	var drift []TopicDrift
+	if spec.Partitions != detail.NumPartitions {
+		drift = append(drift, TopicDrift{
+			Setting: "partitions",
+			Want:    fmt.Sprint(spec.Partitions),
+			Got:     fmt.Sprint(detail.NumPartitions),
+		})
+	}
+	if spec.ReplicationFactor != detail.ReplicationFactor {
+		drift = append(drift, TopicDrift{
+			Setting: "replication factor",
+			Want:    fmt.Sprint(spec.ReplicationFactor),
+			Got:     fmt.Sprint(detail.ReplicationFactor),
+		})
+	}
+
+	want := spec.configEntries()
+	if len(want) == 0 {
+		return drift, nil
+	}
+	names := make([]string, 0, len(want))
+	for name := range want {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// ListTopics omits configs with default values, so ask for the specified ones explicitly
+	entries, err := m.admin.DescribeConfig(sarama.ConfigResource{
+		Type:        sarama.TopicResource,
+		Name:        spec.Name,
+		ConfigNames: names,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe configs of topic %q: %w", spec.Name, err)
+	}
+	got := map[string]string{}
+	for _, entry := range entries {
+		got[entry.Name] = entry.Value
+	}
+	for _, name := range names {
+		if got[name] != want[name] {
+			drift = append(drift, TopicDrift{Setting: name, Want: want[name], Got: got[name]})
+		}
+	}
+	return drift, nil
+}
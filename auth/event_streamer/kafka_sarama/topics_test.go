@@ -0,0 +1,68 @@
+package kafka_sarama_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth/event_streamer/kafka_sarama"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopicManager(t *testing.T) {
+	tm, err := kafka_sarama.NewTopicManager(test_helpers.Config(t).Kafka.Brokers)
+	require.Nil(t, err)
+	t.Cleanup(func() {
+		require.Nil(t, tm.Close())
+	})
+
+	spec := kafka_sarama.TopicSpec{
+		Name:              fmt.Sprintf("topic-manager-test-%016x", rand.Int63()),
+		Partitions:        2,
+		ReplicationFactor: 1,
+		Retention:         time.Hour,
+		CleanupPolicy:     kafka_sarama.CleanupPolicyDelete,
+	}
+
+	t.Run(`#Check reports a topic that doesn't exist as missing`, func(t *testing.T) {
+		statuses, err := tm.Check([]kafka_sarama.TopicSpec{spec})
+		require.Nil(t, err)
+		require.Len(t, statuses, 1)
+		require.True(t, statuses[0].Missing)
+		require.False(t, statuses[0].Created)
+	})
+
+	t.Run(`#Ensure creates missing topics, and they have no drift afterwards`, func(t *testing.T) {
+		statuses, err := tm.Ensure([]kafka_sarama.TopicSpec{spec})
+		require.Nil(t, err)
+		t.Cleanup(func() {
+			require.Nil(t, kafka_sarama.DeleteTopic(test_helpers.Config(t).Kafka.Brokers, spec.Name))
+		})
+		require.True(t, statuses[0].Created)
+
+		statuses, err = tm.Check([]kafka_sarama.TopicSpec{spec})
+		require.Nil(t, err)
+		require.False(t, statuses[0].Missing)
+		require.Empty(t, statuses[0].Drift)
+
+		t.Run(`#Check reports the drift when an existing topic differs from its spec`, func(t *testing.T) {
+			changed := spec
+			changed.Partitions = 4
+			changed.Retention = 2 * time.Hour
+			statuses, err := tm.Check([]kafka_sarama.TopicSpec{changed})
+			require.Nil(t, err)
+			require.ElementsMatch(t, []kafka_sarama.TopicDrift{
+				{Setting: "partitions", Want: "4", Got: "2"},
+				{Setting: "retention.ms", Want: "7200000", Got: "3600000"},
+			}, statuses[0].Drift)
+		})
+	})
+}
+
+func TestDefaultTopicSpecs(t *testing.T) {
+	specs := kafka_sarama.DefaultTopicSpecs("auth-users")
+	require.Equal(t, "auth-users", specs[0].Name)
+	require.Equal(t, kafka_sarama.RetentionForever, specs[0].Retention)
+}
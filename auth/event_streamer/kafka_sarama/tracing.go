@@ -0,0 +1,106 @@
+package kafka_sarama
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/davudsafarli/twitter/auth/event_streamer/kafka_sarama"
+
+func (o Options) tracer() trace.Tracer {
+	return o.TracerProvider.Tracer(instrumentationName)
+}
+
+// startPublishSpan starts a producer span and injects its context to the headers of the message
+func (o Options) startPublishSpan(ctx context.Context, msg *sarama.ProducerMessage) (context.Context, trace.Span) {
+	ctx, span := o.tracer().Start(ctx, msg.Topic+" send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("kafka"),
+			semconv.MessagingDestinationKey.String(msg.Topic),
+			semconv.MessagingDestinationKindTopic,
+		),
+	)
+	o.Propagator.Inject(ctx, producerMessageCarrier{msg: msg})
+	return ctx, span
+}
+
+// startProcessSpan starts a consumer span that continues the trace of the publisher, extracted from the headers of the message
+func (o Options) startProcessSpan(message *sarama.ConsumerMessage) (context.Context, trace.Span) {
+	ctx := o.Propagator.Extract(context.Background(), consumerMessageCarrier{msg: message})
+	return o.tracer().Start(ctx, message.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("kafka"),
+			semconv.MessagingDestinationKey.String(message.Topic),
+			semconv.MessagingDestinationKindTopic,
+			semconv.MessagingOperationProcess,
+			semconv.MessagingMessageIDKey.String(fmt.Sprint(message.Offset)),
+			semconv.MessagingKafkaPartitionKey.Int64(int64(message.Partition)),
+		),
+	)
+}
+
+// producerMessageCarrier adapts the headers of a sarama.ProducerMessage to propagation.TextMapCarrier
+type producerMessageCarrier struct {
+	msg *sarama.ProducerMessage
+}
+
+func (c producerMessageCarrier) Get(key string) string {
+	for _, h := range c.msg.Headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c producerMessageCarrier) Set(key, value string) {
+	for i, h := range c.msg.Headers {
+		if string(h.Key) == key {
+			c.msg.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	c.msg.Headers = append(c.msg.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+func (c producerMessageCarrier) Keys() []string {
+	keys := make([]string, len(c.msg.Headers))
+	for i, h := range c.msg.Headers {
+		keys[i] = string(h.Key)
+	}
+	return keys
+}
+
+// consumerMessageCarrier adapts the headers of a sarama.ConsumerMessage to propagation.TextMapCarrier
+type consumerMessageCarrier struct {
+	msg *sarama.ConsumerMessage
+}
+
+func (c consumerMessageCarrier) Get(key string) string {
+	for _, h := range c.msg.Headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c consumerMessageCarrier) Set(key, value string) {
+	c.msg.Headers = append(c.msg.Headers, &sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+func (c consumerMessageCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.msg.Headers))
+	for _, h := range c.msg.Headers {
+		if h != nil {
+			keys = append(keys, string(h.Key))
+		}
+	}
+	return keys
+}
@@ -0,0 +1,47 @@
+package kafka_sarama
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracePropagation(t *testing.T) {
+	// test_helpers can't be used in internal tests, it imports this package
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	options := Options{TracerProvider: tp}.withDefaults()
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "Usecases.SignUpUser")
+	producerMsg := &sarama.ProducerMessage{Topic: "users"}
+	_, publishSpan := options.startPublishSpan(ctx, producerMsg)
+	publishSpan.End()
+	parent.End()
+	require.NotEmpty(t, producerMsg.Headers, "trace context should be injected to the headers")
+
+	// what the consumer receives
+	consumerMsg := &sarama.ConsumerMessage{Topic: "users", Partition: 1, Offset: 7}
+	for _, h := range producerMsg.Headers {
+		h := h
+		consumerMsg.Headers = append(consumerMsg.Headers, &h)
+	}
+	processCtx, processSpan := options.startProcessSpan(consumerMsg)
+	processSpan.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 3)
+	publish, process := spans[0], spans[2]
+	require.Equal(t, "users send", publish.Name)
+	require.Equal(t, "users process", process.Name)
+	require.Equal(t, trace.SpanKindProducer, publish.SpanKind)
+	require.Equal(t, trace.SpanKindConsumer, process.SpanKind)
+	require.Equal(t, parent.SpanContext().SpanID(), publish.Parent.SpanID())
+	require.Equal(t, publish.SpanContext.SpanID(), process.Parent.SpanID())
+	require.True(t, process.Parent.IsRemote())
+	require.Equal(t, process.SpanContext, trace.SpanContextFromContext(processCtx))
+}
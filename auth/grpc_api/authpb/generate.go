@@ -0,0 +1,4 @@
+// Package authpb is the generated code of auth.proto, the client and the server stubs of the gRPC API
+package authpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative auth.proto
@@ -0,0 +1,23 @@
+package grpc_api
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// BearerToken is the call option of the clients for the authenticated methods, with a token of Login or an API key
+func BearerToken(token string) grpc.CallOption {
+	return grpc.PerRPCCredentials(bearerToken(token))
+}
+
+type bearerToken string
+
+func (t bearerToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity is false like the HTTP API, TLS is terminated in front of the service
+func (bearerToken) RequireTransportSecurity() bool {
+	return false
+}
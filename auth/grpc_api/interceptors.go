@@ -0,0 +1,109 @@
+package grpc_api
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/davudsafarli/twitter/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// authenticatedMethods need a token or an API key in the authorization metadata, as "Bearer <token>"
+var authenticatedMethods = map[string]bool{
+	"/twitter.auth.v1.Auth/GetUser": true,
+}
+
+type claimsKey struct{}
+
+// claimsFrom returns the claims authenticate put to the context of an authenticated method
+func claimsFrom(ctx context.Context) (auth.TokenClaims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(auth.TokenClaims)
+	return claims, ok
+}
+
+// authenticate verifies the token of the authenticatedMethods, and puts its claims to the context
+func authenticate(uc auth.Usecases) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !authenticatedMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		var token string
+		if values := md.Get("authorization"); len(values) == 1 && strings.HasPrefix(values[0], "Bearer ") {
+			token = strings.TrimPrefix(values[0], "Bearer ")
+		}
+		if token == "" {
+			return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+		}
+		claims, err := verify(ctx, uc, token)
+		if err != nil {
+			return nil, err
+		}
+		return handler(context.WithValue(ctx, claimsKey{}, claims), req)
+	}
+}
+
+// verify checks a token of Login or an API key
+func verify(ctx context.Context, uc auth.Usecases, token string) (auth.TokenClaims, error) {
+	if auth.IsAPIKey(token) {
+		return uc.VerifyAPIKey(ctx, token)
+	}
+	return uc.VerifyToken(ctx, token)
+}
+
+// withClientInfo puts the auth.ClientInfo of the call to its context
+func withClientInfo(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var client auth.ClientInfo
+	if p, ok := peer.FromContext(ctx); ok {
+		client.IP = p.Addr.String()
+		if ip, _, err := net.SplitHostPort(client.IP); err == nil {
+			client.IP = ip
+		}
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ua := md.Get("user-agent"); len(ua) > 0 {
+		client.UserAgent = ua[0]
+	}
+	return handler(auth.WithClientInfo(ctx, client), req)
+}
+
+// mapErrors converts the errors of the usecases to the status codes of the package doc. Status errors are returned as they are
+func mapErrors(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	res, err := handler(ctx, req)
+	if err == nil {
+		return res, nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return nil, err
+	}
+	var tooManyAttempts *auth.TooManyAttemptsError
+	switch {
+	case errors.Is(err, auth.ErrInvalidInput):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		return nil, status.Error(codes.Unauthenticated, auth.ErrInvalidCredentials.Error())
+	case errors.Is(err, auth.ErrInvalidToken):
+		return nil, status.Error(codes.Unauthenticated, auth.ErrInvalidToken.Error())
+	case errors.Is(err, auth.ErrEmailNotVerified), errors.Is(err, auth.ErrAccountDeactivated),
+		errors.Is(err, auth.ErrAccountSuspended), errors.Is(err, auth.ErrForbidden):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, auth.ErrUserNotFound):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, auth.ErrUserAlreadyExists):
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	case errors.As(err, &tooManyAttempts):
+		retryAfter := strconv.Itoa(int(math.Ceil(tooManyAttempts.RetryAfter.Seconds())))
+		_ = grpc.SetTrailer(ctx, metadata.Pairs("retry-after", retryAfter))
+		return nil, status.Error(codes.ResourceExhausted, auth.ErrTooManyAttempts.Error())
+	default:
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+}
@@ -0,0 +1,139 @@
+// Package grpc_api exposes auth.Usecases over gRPC, see authpb/auth.proto for the API.
+//
+// The errors of the usecases are reported with the status codes below:
+//
+//	InvalidArgument     auth.ErrInvalidInput
+//	Unauthenticated     auth.ErrInvalidCredentials, auth.ErrInvalidToken, and a missing token
+//	PermissionDenied    auth.ErrEmailNotVerified, auth.ErrAccountDeactivated, auth.ErrAccountSuspended, auth.ErrForbidden
+//	NotFound            auth.ErrUserNotFound
+//	AlreadyExists       auth.ErrUserAlreadyExists
+//	ResourceExhausted   auth.TooManyAttemptsError, with the seconds to wait in the retry-after trailer
+//	Internal            anything else
+package grpc_api
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/grpc_api/authpb"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type server struct {
+	authpb.UnimplementedAuthServer
+	usecases auth.Usecases
+}
+
+// NewServer returns a grpc.Server serving the Auth service with the interceptors of the package.
+// Calls are traced with the TracerProvider of the usecases, in a span that continues the trace of their traceparent metadata.
+// The options are passed to grpc.NewServer, their unary interceptors run after the ones of the package
+func NewServer(uc auth.Usecases, options ...grpc.ServerOption) *grpc.Server {
+	tp := uc.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	options = append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(traced(tp), withClientInfo, mapErrors, authenticate(uc)),
+	}, options...)
+	s := grpc.NewServer(options...)
+	authpb.RegisterAuthServer(s, server{usecases: uc})
+	return s
+}
+
+func (s server) SignUp(ctx context.Context, req *authpb.SignUpRequest) (*authpb.User, error) {
+	user, err := s.usecases.SignUpUser(ctx, auth.User{
+		Email:    req.Email,
+		Username: req.Username,
+		Password: req.Password,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return userOf(user), nil
+}
+
+func (s server) Login(ctx context.Context, req *authpb.LoginRequest) (*authpb.LoginResponse, error) {
+	token, err := s.usecases.Login(ctx, req.Username, req.Password)
+	var mfaRequired *auth.MFARequiredError
+	if errors.As(err, &mfaRequired) {
+		return &authpb.LoginResponse{Result: &authpb.LoginResponse_MfaChallenge{MfaChallenge: mfaRequired.Challenge}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &authpb.LoginResponse{Result: &authpb.LoginResponse_Token{Token: token}}, nil
+}
+
+func (s server) VerifyToken(ctx context.Context, req *authpb.VerifyTokenRequest) (*authpb.TokenClaims, error) {
+	claims, err := verify(ctx, s.usecases, req.Token)
+	if err != nil {
+		return nil, err
+	}
+	permissions := make([]string, len(claims.Permissions))
+	for i, p := range claims.Permissions {
+		permissions[i] = string(p)
+	}
+	return &authpb.TokenClaims{
+		UserId:        int64(claims.UserID),
+		SessionId:     claims.SessionID,
+		ApiKeyId:      claims.APIKeyID,
+		Role:          string(claims.Role),
+		Permissions:   permissions,
+		IssuedAt:      timestampOf(claims.IssuedAt),
+		ExpiresAt:     timestampOf(claims.ExpiresAt),
+		EmailVerified: claims.EmailVerified,
+	}, nil
+}
+
+func (s server) Refresh(ctx context.Context, req *authpb.RefreshRequest) (*authpb.RefreshResponse, error) {
+	token, err := s.usecases.RefreshToken(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+	return &authpb.RefreshResponse{Token: token}, nil
+}
+
+func (s server) GetUser(ctx context.Context, req *authpb.GetUserRequest) (*authpb.User, error) {
+	claims, _ := claimsFrom(ctx)
+	actorID := claims.UserID
+	// the usecase checks the role of the actor. An API key can read the private fields only with the scope, even of its own user,
+	// like the HTTP API refuses the keys on the account routes
+	if (int(req.Id) != claims.UserID || claims.APIKeyID != "") && !claims.Can(auth.PermissionReadUsers) {
+		actorID = 0
+	}
+	user, err := s.usecases.GetUser(ctx, actorID, int(req.Id))
+	if err != nil {
+		return nil, err
+	}
+	return userOf(user), nil
+}
+
+func userOf(u auth.User) *authpb.User {
+	return &authpb.User{
+		Id:               int64(u.ID),
+		Username:         u.Username,
+		DisplayName:      u.DisplayName,
+		Bio:              u.Bio,
+		Location:         u.Location,
+		Website:          u.Website,
+		AvatarUrl:        u.AvatarURL,
+		CreatedAt:        timestampOf(u.CreatedAt),
+		Email:            u.Email,
+		EmailVerified:    !u.EmailVerifiedAt.IsZero(),
+		Role:             string(u.Role),
+		SuspendedAt:      timestampOf(u.SuspendedAt),
+		SuspensionReason: u.SuspensionReason,
+	}
+}
+
+// timestampOf returns nil for the zero time, it means the time isn't set
+func timestampOf(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
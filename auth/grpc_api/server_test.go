@@ -0,0 +1,148 @@
+package grpc_api_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/grpc_api"
+	"github.com/davudsafarli/twitter/auth/grpc_api/authpb"
+	"github.com/davudsafarli/twitter/auth/mailer"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestServer(t *testing.T) {
+	setup := func(t *testing.T) (auth.Usecases, authpb.AuthClient) {
+		uc := test_helpers.NewUsecases(inmemory.New(), mailer.NewInMemory())
+
+		listener := bufconn.Listen(1 << 20)
+		server := grpc_api.NewServer(uc)
+		go func() { _ = server.Serve(listener) }()
+		t.Cleanup(server.Stop)
+		conn, err := grpc.DialContext(context.Background(), "bufconn",
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+			grpc.WithInsecure(),
+			grpc.WithUserAgent("grpc-test"),
+		)
+		require.Nil(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		return uc, authpb.NewAuthClient(conn)
+	}
+	signUp := func(t *testing.T, client authpb.AuthClient) (*authpb.User, string) {
+		user := test_helpers.HopefullyUniqueUser()
+		created, err := client.SignUp(context.Background(), &authpb.SignUpRequest{
+			Email:    user.Email,
+			Username: user.Username,
+			Password: user.Password,
+		})
+		require.Nil(t, err)
+		res, err := client.Login(context.Background(), &authpb.LoginRequest{Username: user.Username, Password: user.Password})
+		require.Nil(t, err)
+		return created, res.GetToken()
+	}
+	requireCode := func(t *testing.T, code codes.Code, err error) {
+		t.Helper()
+		require.Equal(t, code, status.Code(err), err)
+	}
+
+	t.Run(`a user signs up, logs in, verifies and refreshes their token`, func(t *testing.T) {
+		uc, client := setup(t)
+		user, token := signUp(t, client)
+		require.NotZero(t, user.Id)
+		require.NotEmpty(t, token)
+
+		claims, err := client.VerifyToken(context.Background(), &authpb.VerifyTokenRequest{Token: token})
+		require.Nil(t, err)
+		require.Equal(t, user.Id, claims.UserId)
+		require.Equal(t, string(auth.RoleUser), claims.Role)
+		require.NotEmpty(t, claims.SessionId)
+		sessions, err := uc.ListSessions(context.Background(), int(user.Id))
+		require.Nil(t, err)
+		require.Len(t, sessions, 1)
+		require.True(t, strings.HasPrefix(sessions[0].UserAgent, "grpc-test"), sessions[0].UserAgent)
+
+		refreshed, err := client.Refresh(context.Background(), &authpb.RefreshRequest{Token: token})
+		require.Nil(t, err)
+		_, err = client.VerifyToken(context.Background(), &authpb.VerifyTokenRequest{Token: refreshed.Token})
+		require.Nil(t, err)
+		_, err = client.VerifyToken(context.Background(), &authpb.VerifyTokenRequest{Token: token})
+		requireCode(t, codes.Unauthenticated, err)
+	})
+
+	t.Run(`#GetUser needs a token, and returns the private fields to the user themself`, func(t *testing.T) {
+		_, client := setup(t)
+		user, token := signUp(t, client)
+		other, _ := signUp(t, client)
+
+		_, err := client.GetUser(context.Background(), &authpb.GetUserRequest{Id: user.Id})
+		requireCode(t, codes.Unauthenticated, err)
+		_, err = client.GetUser(context.Background(), &authpb.GetUserRequest{Id: user.Id}, grpc_api.BearerToken("invalid"))
+		requireCode(t, codes.Unauthenticated, err)
+
+		own, err := client.GetUser(context.Background(), &authpb.GetUserRequest{Id: user.Id}, grpc_api.BearerToken(token))
+		require.Nil(t, err)
+		require.Equal(t, user.Email, own.Email)
+		public, err := client.GetUser(context.Background(), &authpb.GetUserRequest{Id: other.Id}, grpc_api.BearerToken(token))
+		require.Nil(t, err)
+		require.Equal(t, other.Username, public.Username)
+		require.Empty(t, public.Email)
+		_, err = client.GetUser(context.Background(), &authpb.GetUserRequest{Id: user.Id + other.Id}, grpc_api.BearerToken(token))
+		requireCode(t, codes.NotFound, err)
+	})
+
+	t.Run(`API keys are accepted, and read the private fields only with the users:read scope`, func(t *testing.T) {
+		uc, client := setup(t)
+		user, _ := signUp(t, client)
+		other, _ := signUp(t, client)
+		require.Nil(t, uc.Storage.SetRole(context.Background(), int(user.Id), auth.RoleModerator))
+		unscoped, _, err := uc.CreateAPIKey(context.Background(), int(user.Id), "unscoped", nil, time.Now().AddDate(0, 0, 1))
+		require.Nil(t, err)
+
+		claims, err := client.VerifyToken(context.Background(), &authpb.VerifyTokenRequest{Token: unscoped})
+		require.Nil(t, err)
+		require.NotEmpty(t, claims.ApiKeyId)
+		public, err := client.GetUser(context.Background(), &authpb.GetUserRequest{Id: other.Id}, grpc_api.BearerToken(unscoped))
+		require.Nil(t, err)
+		require.Empty(t, public.Email)
+		own, err := client.GetUser(context.Background(), &authpb.GetUserRequest{Id: user.Id}, grpc_api.BearerToken(unscoped))
+		require.Nil(t, err)
+		require.Equal(t, user.Username, own.Username)
+		require.Empty(t, own.Email, "keys should not read the private fields of their own user without the scope")
+		require.Empty(t, own.Role)
+
+		scoped, _, err := uc.CreateAPIKey(context.Background(), int(user.Id), "scoped",
+			[]auth.Permission{auth.PermissionReadUsers}, time.Now().AddDate(0, 0, 1))
+		require.Nil(t, err)
+		private, err := client.GetUser(context.Background(), &authpb.GetUserRequest{Id: other.Id}, grpc_api.BearerToken(scoped))
+		require.Nil(t, err)
+		require.Equal(t, other.Email, private.Email)
+		own, err = client.GetUser(context.Background(), &authpb.GetUserRequest{Id: user.Id}, grpc_api.BearerToken(scoped))
+		require.Nil(t, err)
+		require.Equal(t, user.Email, own.Email)
+	})
+
+	t.Run(`errors of the usecases are mapped to status codes`, func(t *testing.T) {
+		_, client := setup(t)
+		user := test_helpers.HopefullyUniqueUser()
+		_, err := client.SignUp(context.Background(), &authpb.SignUpRequest{Email: user.Email, Username: user.Username, Password: "short"})
+		requireCode(t, codes.InvalidArgument, err)
+		_, err = client.SignUp(context.Background(), &authpb.SignUpRequest{Email: user.Email, Username: user.Username, Password: user.Password})
+		require.Nil(t, err)
+		_, err = client.SignUp(context.Background(), &authpb.SignUpRequest{Email: user.Email, Username: user.Username, Password: user.Password})
+		requireCode(t, codes.AlreadyExists, err)
+
+		_, err = client.Login(context.Background(), &authpb.LoginRequest{Username: user.Username, Password: "wrong-password"})
+		requireCode(t, codes.Unauthenticated, err)
+		_, err = client.Refresh(context.Background(), &authpb.RefreshRequest{Token: "invalid"})
+		requireCode(t, codes.Unauthenticated, err)
+	})
+}
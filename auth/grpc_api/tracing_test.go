@@ -0,0 +1,51 @@
+package grpc_api_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/grpc_api"
+	"github.com/davudsafarli/twitter/auth/grpc_api/authpb"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestTracing(t *testing.T) {
+	tp, exporter := test_helpers.NewTracerProvider(t)
+	uc := test_helpers.NewUsecases(inmemory.New(), nil)
+	uc.TracerProvider = tp
+	listener := bufconn.Listen(1 << 20)
+	server := grpc_api.NewServer(uc)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+	conn, err := grpc.DialContext(context.Background(), "bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithInsecure(),
+	)
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	ctx, caller := tp.Tracer("test").Start(context.Background(), "caller")
+	carrier := propagation.HeaderCarrier(http.Header{})
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	ctx = metadata.AppendToOutgoingContext(ctx, "traceparent", carrier.Get("traceparent"))
+	user := test_helpers.HopefullyUniqueUser()
+	_, err = authpb.NewAuthClient(conn).SignUp(ctx, &authpb.SignUpRequest{Email: user.Email, Username: user.Username, Password: user.Password})
+	require.Nil(t, err)
+	caller.End()
+
+	spans := exporter.GetSpans()
+	call := test_helpers.FindSpan(t, spans, "twitter.auth.v1.Auth/SignUp")
+	require.Equal(t, trace.SpanKindServer, call.SpanKind)
+	require.Equal(t, caller.SpanContext().SpanID(), call.Parent.SpanID(), "the server span should continue the trace of traceparent")
+	signup := test_helpers.FindSpan(t, spans, "Usecases.SignUpUser")
+	require.Equal(t, call.SpanContext.SpanID(), signup.Parent.SpanID())
+}
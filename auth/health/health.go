@@ -0,0 +1,109 @@
+// Package health checks the dependencies of the service for its readiness probe
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultTimeout is the timeout of the checks that don't set one
+const DefaultTimeout = 2 * time.Second
+
+// Checker checks a dependency, a nil error means it is usable
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc adapts a function, e.g. storage.Postgres.HealthCheck, to Checker
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Check is a named Checker
+type Check struct {
+	// Name identifies the check in the Report, e.g. "postgres"
+	Name    string
+	Checker Checker
+	// Timeout defaults to DefaultTimeout. The check fails when it is exceeded, even if Checker ignores its context
+	Timeout time.Duration
+}
+
+type Status string
+
+const (
+	StatusUp   Status = "up"
+	StatusDown Status = "down"
+)
+
+// Result is the outcome of a Check
+type Result struct {
+	Name       string `json:"name"`
+	Status     Status `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// Report is the outcome of all checks, it is up only if all of them are
+type Report struct {
+	Status Status   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Run runs the checks concurrently and reports them in the given order
+func Run(ctx context.Context, checks []Check) Report {
+	results := make([]Result, len(checks))
+	done := make(chan struct{}, len(checks))
+	for i, check := range checks {
+		go func(i int, check Check) {
+			results[i] = run(ctx, check)
+			done <- struct{}{}
+		}(i, check)
+	}
+	for range checks {
+		<-done
+	}
+
+	report := Report{Status: StatusUp, Checks: results}
+	for _, r := range results {
+		if r.Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+// run runs a single check, it returns at the timeout even if the check is still running
+func run(ctx context.Context, check Check) Result {
+	timeout := check.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- check.Checker.Check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %v", timeout)
+	}
+
+	result := Result{Name: check.Name, Status: StatusUp, DurationMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
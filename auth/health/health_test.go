@@ -0,0 +1,65 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth/health"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	up := health.CheckerFunc(func(ctx context.Context) error { return nil })
+
+	t.Run(`the report is up if all checks are, and keeps their order`, func(t *testing.T) {
+		report := health.Run(context.Background(), []health.Check{
+			{Name: "postgres", Checker: up},
+			{Name: "kafka", Checker: up},
+		})
+		require.Equal(t, health.StatusUp, report.Status)
+		require.Len(t, report.Checks, 2)
+		require.Equal(t, "postgres", report.Checks[0].Name)
+		require.Equal(t, "kafka", report.Checks[1].Name)
+		require.Equal(t, health.StatusUp, report.Checks[1].Status)
+		require.Empty(t, report.Checks[1].Error)
+	})
+
+	t.Run(`a failing check takes the report down`, func(t *testing.T) {
+		report := health.Run(context.Background(), []health.Check{
+			{Name: "postgres", Checker: up},
+			{Name: "kafka", Checker: health.CheckerFunc(func(ctx context.Context) error { return errors.New("no brokers") })},
+		})
+		require.Equal(t, health.StatusDown, report.Status)
+		require.Equal(t, health.StatusUp, report.Checks[0].Status)
+		require.Equal(t, health.StatusDown, report.Checks[1].Status)
+		require.Equal(t, "no brokers", report.Checks[1].Error)
+	})
+
+	t.Run(`checks time out, even if they ignore their context`, func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		start := time.Now()
+		report := health.Run(context.Background(), []health.Check{
+			{Name: "stuck", Timeout: 50 * time.Millisecond, Checker: health.CheckerFunc(func(ctx context.Context) error {
+				<-release
+				return nil
+			})},
+			{Name: "slow", Timeout: 50 * time.Millisecond, Checker: health.CheckerFunc(func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			})},
+		})
+		require.Less(t, time.Since(start), time.Second, "checks should run concurrently and stop at their timeout")
+		require.Equal(t, health.StatusDown, report.Status)
+		require.Equal(t, health.StatusDown, report.Checks[0].Status)
+		require.Contains(t, report.Checks[0].Error, "timed out")
+		require.Equal(t, health.StatusDown, report.Checks[1].Status)
+		require.Contains(t, report.Checks[1].Error, "timed out")
+	})
+
+	t.Run(`no checks are up`, func(t *testing.T) {
+		require.Equal(t, health.StatusUp, health.Run(context.Background(), nil).Status)
+	})
+}
@@ -0,0 +1,983 @@
+// Package http_api exposes auth.Usecases over JSON/HTTP
+package http_api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/health"
+	"go.opentelemetry.io/otel"
+)
+
+type Options struct {
+	// MetricsHandler is served on /metrics if it isn't nil
+	MetricsHandler http.Handler
+	// ReadinessChecks are run by /readyz, e.g. the pings of the database and the brokers
+	ReadinessChecks []health.Check
+}
+
+type handler struct {
+	usecases auth.Usecases
+}
+
+// NewHandler returns the http.Handler of the auth service with the routes below:
+//
+//	POST /signup
+//	POST /login
+//	POST /login/mfa
+//	POST /password-reset
+//	POST /password-reset/confirm
+//	POST /verify-email
+//	POST /verify-email/resend
+//	GET  /oidc/login?provider=
+//	GET  /oidc/callback             (logged in for links)
+//	POST /account/password          (logged in)
+//	POST /account/email             (logged in)
+//	POST /account/email/confirm
+//	POST /account/deactivate        (logged in)
+//	POST /account/delete            (logged in)
+//	POST /account/reactivate
+//	POST /account/export            (logged in)
+//	GET  /account/export/status     (logged in)
+//	GET  /account/export/download   (logged in)
+//	GET  /account/sessions          (logged in)
+//	POST /account/sessions/revoke   (logged in)
+//	GET  /account/login-history     (logged in)
+//	POST /account/api-keys          (logged in)
+//	GET  /account/api-keys          (logged in)
+//	POST /account/api-keys/revoke   (logged in)
+//	GET  /account/identities        (logged in)
+//	POST /account/identities/link   (logged in)
+//	POST /account/identities/unlink (logged in)
+//	GET  /admin/users               (users:read)
+//	GET  /admin/users/search        (users:read)
+//	POST /admin/users/suspend       (users:suspend)
+//	POST /admin/users/unsuspend     (users:suspend)
+//	POST /admin/users/role          (roles:assign)
+//	GET  /.well-known/jwks.json
+//	GET  /healthz
+//	GET  /readyz
+//	GET  /metrics
+//
+// Logged in routes accept only the bearer tokens of /login. API keys are accepted only by the routes of the permissions,
+// and only if the permission is in their scopes, so a leaked key can't act on the account of its user.
+// Requests are traced with the TracerProvider of the usecases, in a span that continues the trace of their traceparent header.
+// /healthz responds as long as the process serves requests, /readyz responds 503 unless all ReadinessChecks pass
+func NewHandler(uc auth.Usecases, options Options) http.Handler {
+	h := handler{usecases: uc}
+	mux := http.NewServeMux()
+	mux.Handle("/signup", allow(http.MethodPost, h.signup))
+	mux.Handle("/login", allow(http.MethodPost, h.login))
+	mux.Handle("/login/mfa", allow(http.MethodPost, h.completeMFA))
+	mux.Handle("/password-reset", allow(http.MethodPost, h.requestPasswordReset))
+	mux.Handle("/password-reset/confirm", allow(http.MethodPost, h.resetPassword))
+	mux.Handle("/verify-email", allow(http.MethodPost, h.verifyEmail))
+	mux.Handle("/verify-email/resend", allow(http.MethodPost, h.resendVerification))
+	mux.Handle("/oidc/login", allow(http.MethodGet, h.startOIDCLogin))
+	mux.Handle("/oidc/callback", allow(http.MethodGet, h.completeOIDC))
+	mux.Handle("/account/password", allow(http.MethodPost, h.loggedIn(h.changePassword)))
+	mux.Handle("/account/email", allow(http.MethodPost, h.loggedIn(h.changeEmail)))
+	mux.Handle("/account/email/confirm", allow(http.MethodPost, h.confirmEmailChange))
+	mux.Handle("/account/deactivate", allow(http.MethodPost, h.loggedIn(h.deactivateAccount)))
+	mux.Handle("/account/delete", allow(http.MethodPost, h.loggedIn(h.deleteAccount)))
+	mux.Handle("/account/reactivate", allow(http.MethodPost, h.reactivateAccount))
+	mux.Handle("/account/export", allow(http.MethodPost, h.loggedIn(h.exportUserData)))
+	mux.Handle("/account/export/status", allow(http.MethodGet, h.loggedIn(h.dataExportStatus)))
+	mux.Handle("/account/export/download", allow(http.MethodGet, h.loggedIn(h.downloadDataExport)))
+	mux.Handle("/account/sessions", allow(http.MethodGet, h.loggedIn(h.listSessions)))
+	mux.Handle("/account/sessions/revoke", allow(http.MethodPost, h.loggedIn(h.revokeSession)))
+	mux.Handle("/account/login-history", allow(http.MethodGet, h.loggedIn(h.loginHistory)))
+	mux.Handle("/account/api-keys", apiKeys{
+		create: h.loggedIn(h.createAPIKey),
+		list:   h.loggedIn(h.listAPIKeys),
+	})
+	mux.Handle("/account/api-keys/revoke", allow(http.MethodPost, h.loggedIn(h.revokeAPIKey)))
+	mux.Handle("/account/identities", allow(http.MethodGet, h.loggedIn(h.listIdentities)))
+	mux.Handle("/account/identities/link", allow(http.MethodPost, h.loggedIn(h.linkIdentity)))
+	mux.Handle("/account/identities/unlink", allow(http.MethodPost, h.loggedIn(h.unlinkIdentity)))
+	mux.Handle("/admin/users", allow(http.MethodGet, h.authorized(auth.PermissionReadUsers, h.listUsers)))
+	mux.Handle("/admin/users/search", allow(http.MethodGet, h.authorized(auth.PermissionReadUsers, h.searchUsers)))
+	mux.Handle("/admin/users/suspend", allow(http.MethodPost, h.authorized(auth.PermissionSuspendUsers, h.suspendUser)))
+	mux.Handle("/admin/users/unsuspend", allow(http.MethodPost, h.authorized(auth.PermissionSuspendUsers, h.unsuspendUser)))
+	mux.Handle("/admin/users/role", allow(http.MethodPost, h.authorized(auth.PermissionAssignRoles, h.assignRole)))
+	mux.Handle("/.well-known/jwks.json", allow(http.MethodGet, h.jwks))
+	mux.Handle("/healthz", allow(http.MethodGet, liveness))
+	mux.Handle("/readyz", allow(http.MethodGet, readiness(options.ReadinessChecks)))
+	if options.MetricsHandler != nil {
+		mux.Handle("/metrics", options.MetricsHandler)
+	}
+	tp := uc.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return withClientInfo(traced(mux, tp))
+}
+
+// liveness reports the process is up, without checking its dependencies. Restarting it wouldn't fix them
+func liveness(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, health.Report{Status: health.StatusUp, Checks: []health.Result{}})
+}
+
+// readiness runs the checks and responds with their breakdown
+func readiness(checks []health.Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := health.Run(r.Context(), checks)
+		status := http.StatusOK
+		if report.Status != health.StatusUp {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, report)
+	}
+}
+
+// withClientInfo puts the auth.ClientInfo of the request to its context
+func withClientInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		ctx := auth.WithClientInfo(r.Context(), auth.ClientInfo{IP: ip, UserAgent: r.UserAgent()})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type signupRequest struct {
+	Email    string `json:"email"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type userResponse struct {
+	ID       int    `json:"id"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+}
+
+func (h handler) signup(w http.ResponseWriter, r *http.Request) {
+	var req signupRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	user, err := h.usecases.SignUpUser(r.Context(), auth.User{
+		Email:    req.Email,
+		Username: req.Username,
+		Password: req.Password,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, userResponse{ID: user.ID, Email: user.Email, Username: user.Username})
+}
+
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// loginResponse has either the token, or the challenge to complete on /login/mfa if the user enabled MFA
+type loginResponse struct {
+	Token        string `json:"token,omitempty"`
+	MFAChallenge string `json:"mfa_challenge,omitempty"`
+}
+
+func (h handler) login(w http.ResponseWriter, r *http.Request) {
+	var req loginRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	token, err := h.usecases.Login(r.Context(), req.Username, req.Password)
+	var mfaRequired *auth.MFARequiredError
+	if errors.As(err, &mfaRequired) {
+		writeJSON(w, http.StatusOK, loginResponse{MFAChallenge: mfaRequired.Challenge})
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, loginResponse{Token: token})
+}
+
+type completeMFARequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+func (h handler) completeMFA(w http.ResponseWriter, r *http.Request) {
+	var req completeMFARequest
+	if !decode(w, r, &req) {
+		return
+	}
+	token, err := h.usecases.CompleteMFA(r.Context(), req.Challenge, req.Code)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, loginResponse{Token: token})
+}
+
+// oidcBindingCookie keeps the auth.OIDCFlow.Binding in the browser until /oidc/callback
+const oidcBindingCookie = "oidc_binding"
+
+// setOIDCBinding ties the flow to the browser. The cookie is sent only to the callback, and with SameSite=Lax,
+// since the provider redirects the browser back from another site
+func setOIDCBinding(w http.ResponseWriter, flow auth.OIDCFlow) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcBindingCookie,
+		Value:    flow.Binding,
+		Path:     "/oidc/callback",
+		Expires:  flow.ExpiresAt,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// startOIDCLogin redirects the browser to the provider, which redirects it back to /oidc/callback
+func (h handler) startOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	flow, err := h.usecases.StartOIDCLogin(r.Context(), r.URL.Query().Get("provider"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	setOIDCBinding(w, flow)
+	http.Redirect(w, r, flow.AuthorizationURL, http.StatusFound)
+}
+
+// oidcCallbackResponse has either the token of a login, the challenge to complete on /login/mfa, or linked for the links
+type oidcCallbackResponse struct {
+	Token        string `json:"token,omitempty"`
+	MFAChallenge string `json:"mfa_challenge,omitempty"`
+	Created      bool   `json:"created,omitempty"`
+	Linked       bool   `json:"linked,omitempty"`
+}
+
+// completeOIDC needs the cookie of the browser that started the flow. The callbacks of links must be authenticated
+// as well, so their RedirectURL should be a page of the client that calls it with the bearer token
+func (h handler) completeOIDC(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	// the flow is over whatever the outcome is
+	http.SetCookie(w, &http.Cookie{Name: oidcBindingCookie, Path: "/oidc/callback", MaxAge: -1, HttpOnly: true, Secure: true})
+	// e.g. access_denied if the user cancelled the sign in at the provider
+	if providerErr := query.Get("error"); providerErr != "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "provider responded with " + providerErr})
+		return
+	}
+	callback := auth.OIDCCallback{State: query.Get("state"), Code: query.Get("code")}
+	if cookie, err := r.Cookie(oidcBindingCookie); err == nil {
+		callback.Binding = cookie.Value
+	}
+	if r.Header.Get("Authorization") != "" {
+		h.loggedIn(func(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+			callback.UserID = claims.UserID
+			h.writeOIDCResult(w, r, callback)
+		})(w, r)
+		return
+	}
+	h.writeOIDCResult(w, r, callback)
+}
+
+func (h handler) writeOIDCResult(w http.ResponseWriter, r *http.Request, callback auth.OIDCCallback) {
+	result, err := h.usecases.CompleteOIDC(r.Context(), callback)
+	var mfaRequired *auth.MFARequiredError
+	if errors.As(err, &mfaRequired) {
+		writeJSON(w, http.StatusOK, oidcCallbackResponse{MFAChallenge: mfaRequired.Challenge})
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, oidcCallbackResponse{Token: result.Token, Created: result.Created, Linked: result.Linked})
+}
+
+type passwordResetRequest struct {
+	Email string `json:"email"`
+}
+
+func (h handler) requestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req passwordResetRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := h.usecases.RequestPasswordReset(r.Context(), req.Email); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (h handler) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := h.usecases.ResetPassword(r.Context(), req.Token, req.Password); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type verifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+func (h handler) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req verifyEmailRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := h.usecases.VerifyEmail(r.Context(), req.Token); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type resendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+func (h handler) resendVerification(w http.ResponseWriter, r *http.Request) {
+	var req resendVerificationRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := h.usecases.ResendVerification(r.Context(), req.Email); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type changePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+func (h handler) changePassword(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	var req changePasswordRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := h.usecases.ChangePassword(r.Context(), claims.UserID, req.OldPassword, req.NewPassword); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type changeEmailRequest struct {
+	Password string `json:"password"`
+	Email    string `json:"email"`
+}
+
+// changeEmail sends a token to the new email, the email changes when it is passed to /account/email/confirm
+func (h handler) changeEmail(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	var req changeEmailRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := h.usecases.ChangeEmail(r.Context(), claims.UserID, req.Password, req.Email); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h handler) confirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var req verifyEmailRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := h.usecases.ConfirmEmailChange(r.Context(), req.Token); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type passwordRequest struct {
+	Password string `json:"password"`
+}
+
+func (h handler) deactivateAccount(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	var req passwordRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := h.usecases.DeactivateAccount(r.Context(), claims.UserID, req.Password); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h handler) deleteAccount(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	var req passwordRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := h.usecases.DeleteAccount(r.Context(), claims.UserID, req.Password); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h handler) reactivateAccount(w http.ResponseWriter, r *http.Request) {
+	var req loginRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := h.usecases.ReactivateAccount(r.Context(), req.Username, req.Password); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type dataExportResponse struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+func dataExportResponseOf(e auth.DataExport) dataExportResponse {
+	res := dataExportResponse{ID: e.ID, Status: string(e.Status), CreatedAt: e.CreatedAt}
+	if !e.CompletedAt.IsZero() {
+		res.CompletedAt = &e.CompletedAt
+	}
+	if !e.ExpiresAt.IsZero() {
+		res.ExpiresAt = &e.ExpiresAt
+	}
+	return res
+}
+
+// exportUserData starts building the archive, the client polls /account/export/status until it is ready
+func (h handler) exportUserData(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	export, err := h.usecases.ExportUserData(r.Context(), claims.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Location", "/account/export/status?id="+url.QueryEscape(export.ID))
+	writeJSON(w, http.StatusAccepted, dataExportResponseOf(export))
+}
+
+func (h handler) dataExportStatus(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	export, err := h.usecases.FindDataExport(r.Context(), claims.UserID, r.URL.Query().Get("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, dataExportResponseOf(export))
+}
+
+func (h handler) downloadDataExport(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	id := r.URL.Query().Get("id")
+	archive, err := h.usecases.OpenDataExport(r.Context(), claims.UserID, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer archive.Close()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="twitter-data-`+id+`.zip"`)
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, archive)
+}
+
+type sessionResponse struct {
+	ID        string    `json:"id"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Current is true for the session of the token the request is authenticated with
+	Current bool `json:"current"`
+}
+
+func (h handler) listSessions(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	sessions, err := h.usecases.ListSessions(r.Context(), claims.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res := make([]sessionResponse, 0, len(sessions))
+	for _, s := range sessions {
+		res = append(res, sessionResponse{
+			ID:        s.ID,
+			IP:        s.IP,
+			UserAgent: s.UserAgent,
+			CreatedAt: s.CreatedAt,
+			ExpiresAt: s.ExpiresAt,
+			Current:   s.ID == claims.SessionID,
+		})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+type revokeSessionRequest struct {
+	ID string `json:"id"`
+}
+
+func (h handler) revokeSession(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	var req revokeSessionRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := h.usecases.RevokeSession(r.Context(), claims.UserID, req.ID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type loginEventResponse struct {
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Outcome   string    `json:"outcome"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// maxLoginHistory is the default and the maximum limit of /account/login-history
+const maxLoginHistory = 100
+
+func (h handler) loginHistory(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	limit := maxLoginHistory
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxLoginHistory {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "limit must be between 1 and " + strconv.Itoa(maxLoginHistory)})
+			return
+		}
+		limit = n
+	}
+	events, err := h.usecases.LoginHistory(r.Context(), claims.UserID, limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res := make([]loginEventResponse, 0, len(events))
+	for _, e := range events {
+		res = append(res, loginEventResponse{IP: e.IP, UserAgent: e.UserAgent, Outcome: string(e.Outcome), CreatedAt: e.CreatedAt})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+type createAPIKeyRequest struct {
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type apiKeyResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func apiKeyResponseOf(k auth.APIKey) apiKeyResponse {
+	res := apiKeyResponse{
+		ID:        k.ID,
+		Name:      k.Name,
+		Prefix:    k.Prefix,
+		Scopes:    make([]string, 0, len(k.Scopes)),
+		CreatedAt: k.CreatedAt,
+		ExpiresAt: k.ExpiresAt,
+	}
+	for _, scope := range k.Scopes {
+		res.Scopes = append(res.Scopes, string(scope))
+	}
+	if !k.LastUsedAt.IsZero() {
+		res.LastUsedAt = &k.LastUsedAt
+	}
+	return res
+}
+
+// createAPIKeyResponse is the only response with the key, it is not stored
+type createAPIKeyResponse struct {
+	Key string `json:"key"`
+	apiKeyResponse
+}
+
+// apiKeys routes /account/api-keys by method, allow supports a single one
+type apiKeys struct {
+	create, list http.HandlerFunc
+}
+
+func (k apiKeys) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		k.create(w, r)
+	case http.MethodGet:
+		k.list(w, r)
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+	}
+}
+
+func (h handler) createAPIKey(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	var req createAPIKeyRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	scopes := make([]auth.Permission, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		scopes = append(scopes, auth.Permission(scope))
+	}
+	key, apiKey, err := h.usecases.CreateAPIKey(r.Context(), claims.UserID, req.Name, scopes, req.ExpiresAt)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, createAPIKeyResponse{Key: key, apiKeyResponse: apiKeyResponseOf(apiKey)})
+}
+
+func (h handler) listAPIKeys(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	keys, err := h.usecases.ListAPIKeys(r.Context(), claims.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res := make([]apiKeyResponse, 0, len(keys))
+	for _, k := range keys {
+		res = append(res, apiKeyResponseOf(k))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+type revokeAPIKeyRequest struct {
+	ID string `json:"id"`
+}
+
+func (h handler) revokeAPIKey(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	var req revokeAPIKeyRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := h.usecases.RevokeAPIKey(r.Context(), claims.UserID, req.ID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type identityResponse struct {
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (h handler) listIdentities(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	identities, err := h.usecases.ListIdentities(r.Context(), claims.UserID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res := make([]identityResponse, 0, len(identities))
+	for _, i := range identities {
+		res = append(res, identityResponse{Provider: i.Provider, Email: i.Email, CreatedAt: i.CreatedAt})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+type identityRequest struct {
+	Provider string `json:"provider"`
+}
+
+type linkIdentityResponse struct {
+	// AuthorizationURL is where the browser should be sent to sign in at the provider
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+func (h handler) linkIdentity(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	var req identityRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	flow, err := h.usecases.StartOIDCLink(r.Context(), claims.UserID, req.Provider)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	setOIDCBinding(w, flow)
+	writeJSON(w, http.StatusOK, linkIdentityResponse{AuthorizationURL: flow.AuthorizationURL})
+}
+
+func (h handler) unlinkIdentity(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	var req identityRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := h.usecases.UnlinkIdentity(r.Context(), claims.UserID, req.Provider); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type adminUserResponse struct {
+	ID               int        `json:"id"`
+	Email            string     `json:"email"`
+	Username         string     `json:"username"`
+	DisplayName      string     `json:"display_name"`
+	Role             string     `json:"role"`
+	CreatedAt        time.Time  `json:"created_at"`
+	EmailVerified    bool       `json:"email_verified"`
+	DeactivatedAt    *time.Time `json:"deactivated_at,omitempty"`
+	SuspendedAt      *time.Time `json:"suspended_at,omitempty"`
+	SuspensionReason string     `json:"suspension_reason,omitempty"`
+}
+
+func adminUsersResponse(users []auth.User) []adminUserResponse {
+	res := make([]adminUserResponse, 0, len(users))
+	for _, u := range users {
+		user := adminUserResponse{
+			ID:               u.ID,
+			Email:            u.Email,
+			Username:         u.Username,
+			DisplayName:      u.DisplayName,
+			Role:             string(u.Role),
+			CreatedAt:        u.CreatedAt,
+			EmailVerified:    !u.EmailVerifiedAt.IsZero(),
+			SuspensionReason: u.SuspensionReason,
+		}
+		if !u.DeactivatedAt.IsZero() {
+			user.DeactivatedAt = &u.DeactivatedAt
+		}
+		if !u.SuspendedAt.IsZero() {
+			user.SuspendedAt = &u.SuspendedAt
+		}
+		res = append(res, user)
+	}
+	return res
+}
+
+// queryInt returns the integer query parameter, def if it is missing. It responds with 400 and returns false if it is invalid
+func queryInt(w http.ResponseWriter, r *http.Request, name string, def int) (int, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: name + " must be an integer"})
+		return 0, false
+	}
+	return n, true
+}
+
+// listUsers pages through the users, the client passes the ID of the last user as after to get the next page
+func (h handler) listUsers(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	after, ok := queryInt(w, r, "after", 0)
+	if !ok {
+		return
+	}
+	limit, ok := queryInt(w, r, "limit", auth.MaxUserQueryLimit)
+	if !ok {
+		return
+	}
+	users, err := h.usecases.ListUsers(r.Context(), claims.UserID, after, limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, adminUsersResponse(users))
+}
+
+func (h handler) searchUsers(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	limit, ok := queryInt(w, r, "limit", auth.MaxUserQueryLimit)
+	if !ok {
+		return
+	}
+	users, err := h.usecases.SearchUsers(r.Context(), claims.UserID, r.URL.Query().Get("q"), limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, adminUsersResponse(users))
+}
+
+type suspendUserRequest struct {
+	UserID int    `json:"user_id"`
+	Reason string `json:"reason"`
+}
+
+func (h handler) suspendUser(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	var req suspendUserRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := h.usecases.SuspendUser(r.Context(), claims.UserID, req.UserID, req.Reason); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h handler) unsuspendUser(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	var req suspendUserRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := h.usecases.UnsuspendUser(r.Context(), claims.UserID, req.UserID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type assignRoleRequest struct {
+	UserID int    `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+func (h handler) assignRole(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+	var req assignRoleRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	if err := h.usecases.AssignRole(r.Context(), claims.UserID, req.UserID, auth.Role(req.Role)); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// jwks serves the public keys of the tokens, so other services can verify them without the private keys
+func (h handler) jwks(w http.ResponseWriter, r *http.Request) {
+	// verifiers can cache the keys shortly, upcoming keys are published in advance
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, h.usecases.JWKS())
+}
+
+// authenticated passes the claims of the bearer token or API key to fn, and responds with 401 if it is missing or invalid
+func (h handler) authenticated(fn func(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || token == r.Header.Get("Authorization") {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "missing bearer token"})
+			return
+		}
+		verify := h.usecases.VerifyToken
+		if auth.IsAPIKey(token) {
+			verify = h.usecases.VerifyAPIKey
+		}
+		claims, err := verify(r.Context(), token)
+		if errors.Is(err, auth.ErrInvalidToken) {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeJSON(w, http.StatusUnauthorized, errorResponse{Error: auth.ErrInvalidToken.Error()})
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		fn(w, r, claims)
+	}
+}
+
+// loggedIn is authenticated, and responds with 403 to API keys. A leaked key shouldn't be able to act on the account, e.g. export its data
+func (h handler) loggedIn(fn func(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims)) http.HandlerFunc {
+	return h.authenticated(func(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+		if claims.APIKeyID != "" {
+			writeJSON(w, http.StatusForbidden, errorResponse{Error: "API keys can't be used for this action"})
+			return
+		}
+		fn(w, r, claims)
+	})
+}
+
+// authorized is authenticated, and responds with 403 if the token lacks the permission
+func (h handler) authorized(p auth.Permission, fn func(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims)) http.HandlerFunc {
+	return h.authenticated(func(w http.ResponseWriter, r *http.Request, claims auth.TokenClaims) {
+		if !claims.Can(p) {
+			writeJSON(w, http.StatusForbidden, errorResponse{Error: auth.ErrForbidden.Error()})
+			return
+		}
+		fn(w, r, claims)
+	})
+}
+
+// allow responds with 405 to the requests with other methods
+func allow(method string, fn http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+			return
+		}
+		fn(w, r)
+	})
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+		return false
+	}
+	return true
+}
+
+// writeError maps the errors of usecases to status codes. Unknown errors are not exposed to the client
+func writeError(w http.ResponseWriter, err error) {
+	var tooManyAttempts *auth.TooManyAttemptsError
+	switch {
+	case errors.Is(err, auth.ErrInvalidCredentials), errors.Is(err, auth.ErrUserNotFound):
+		writeJSON(w, http.StatusUnauthorized, errorResponse{Error: auth.ErrInvalidCredentials.Error()})
+	case errors.As(err, &tooManyAttempts):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(tooManyAttempts.RetryAfter.Seconds()))))
+		writeJSON(w, http.StatusTooManyRequests, errorResponse{Error: auth.ErrTooManyAttempts.Error()})
+	case errors.Is(err, auth.ErrEmailNotVerified), errors.Is(err, auth.ErrAccountDeactivated),
+		errors.Is(err, auth.ErrAccountSuspended), errors.Is(err, auth.ErrForbidden):
+		writeJSON(w, http.StatusForbidden, errorResponse{Error: err.Error()})
+	case errors.Is(err, auth.ErrDataExportNotFound), errors.Is(err, auth.ErrSessionNotFound), errors.Is(err, auth.ErrAPIKeyNotFound),
+		errors.Is(err, auth.ErrIdentityNotFound):
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+	case errors.Is(err, auth.ErrUserAlreadyExists):
+		writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error()})
+	case errors.Is(err, auth.ErrInvalidInput), errors.Is(err, auth.ErrInvalidToken), errors.Is(err, auth.ErrMFANotEnrolled):
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "internal error"})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,309 @@
+package http_api_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/blobstore"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/health"
+	"github.com/davudsafarli/twitter/auth/http_api"
+	"github.com/davudsafarli/twitter/auth/limiter"
+	"github.com/davudsafarli/twitter/auth/mailer"
+	"github.com/davudsafarli/twitter/auth/metrics"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	setup := func(t *testing.T) (auth.Usecases, http.Handler) {
+		uc := test_helpers.NewUsecases(inmemory.New(), mailer.NewInMemory())
+		return uc, http_api.NewHandler(uc, http_api.Options{})
+	}
+	// signUp creates a user of the role, and returns them with the token of their login
+	signUp := func(t *testing.T, uc auth.Usecases, role auth.Role) (auth.User, string) {
+		user := test_helpers.HopefullyUniqueUser()
+		created, err := uc.SignUpUser(context.Background(), user)
+		require.Nil(t, err)
+		require.Nil(t, uc.Storage.SetRole(context.Background(), created.ID, role))
+		token, err := uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+		created.Password = user.Password
+		return created, token
+	}
+	do := func(h http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+		var r io.Reader
+		if body != "" {
+			r = strings.NewReader(body)
+		}
+		req := httptest.NewRequest(method, path, r)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run(`users sign up and log in, and the usecases are exposed on /metrics`, func(t *testing.T) {
+		uc, _ := setup(t)
+		reg := prometheus.NewRegistry()
+		m, err := metrics.New(reg)
+		require.Nil(t, err)
+		uc.Metrics = m
+		h := http_api.NewHandler(uc, http_api.Options{MetricsHandler: metrics.Handler(reg)})
+		user := test_helpers.HopefullyUniqueUser()
+		signup := `{"email":"` + user.Email + `","username":"` + user.Username + `","password":"` + user.Password + `"}`
+
+		rec := do(h, http.MethodPost, "/signup", "", signup)
+		require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+		var created struct {
+			ID       int
+			Email    string
+			Username string
+		}
+		require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &created))
+		require.NotZero(t, created.ID)
+		require.Equal(t, user.Username, created.Username)
+		require.NotContains(t, rec.Body.String(), "password")
+		rec = do(h, http.MethodPost, "/signup", "", signup)
+		require.Equal(t, http.StatusConflict, rec.Code, rec.Body.String())
+		rec = do(h, http.MethodPost, "/signup", "", `{"email":`)
+		require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+		rec = do(h, http.MethodGet, "/signup", "", "")
+		require.Equal(t, http.StatusMethodNotAllowed, rec.Code, rec.Body.String())
+		require.Equal(t, http.MethodPost, rec.Header().Get("Allow"))
+
+		rec = do(h, http.MethodPost, "/login", "", `{"username":"`+user.Username+`","password":"wrong-password"}`)
+		require.Equal(t, http.StatusUnauthorized, rec.Code, rec.Body.String())
+		rec = do(h, http.MethodPost, "/login", "", `{"username":"`+user.Username+`","password":"`+user.Password+`"}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var login struct{ Token string }
+		require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &login))
+		claims, err := uc.VerifyToken(context.Background(), login.Token)
+		require.Nil(t, err)
+		require.Equal(t, created.ID, claims.UserID)
+
+		rec = do(h, http.MethodGet, "/metrics", "", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Body.String(), `auth_usecase_calls_total{outcome="success",usecase="signup"} 1`)
+		require.Contains(t, rec.Body.String(), `auth_usecase_calls_total{outcome="invalid_credentials",usecase="login"} 1`)
+		_, h = setup(t)
+		rec = do(h, http.MethodGet, "/metrics", "", "")
+		require.Equal(t, http.StatusNotFound, rec.Code, "/metrics should be served only with a MetricsHandler")
+	})
+
+	t.Run(`the password and the email are changed with the current password, and the checks are throttled`, func(t *testing.T) {
+		uc, _ := setup(t)
+		uc.LoginThrottling = auth.LoginThrottling{Store: limiter.NewMemory()}
+		h := http_api.NewHandler(uc, http_api.Options{})
+		user, token := signUp(t, uc, auth.RoleUser)
+
+		rec := do(h, http.MethodPost, "/account/email", token, `{"password":"`+user.Password+`","email":"changed@example.com"}`)
+		require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+		sent, ok := uc.Mailer.(*mailer.InMemory).Last("changed@example.com")
+		require.True(t, ok)
+		lines := strings.Split(strings.TrimSpace(sent.Body), "\n")
+		rec = do(h, http.MethodPost, "/account/email/confirm", "", `{"token":"`+lines[len(lines)-1]+`"}`)
+		require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+
+		rec = do(h, http.MethodPost, "/account/password", token, `{"old_password":"wrong-password","new_password":"a-new-long-password"}`)
+		require.Equal(t, http.StatusUnauthorized, rec.Code, rec.Body.String())
+		rec = do(h, http.MethodPost, "/account/password", token, `{"old_password":"`+user.Password+`","new_password":"a-new-long-password"}`)
+		require.Equal(t, http.StatusTooManyRequests, rec.Code, rec.Body.String())
+		require.Equal(t, "1", rec.Header().Get("Retry-After"))
+	})
+
+	t.Run(`the admin routes need the permission of the route`, func(t *testing.T) {
+		uc, h := setup(t)
+		_, userToken := signUp(t, uc, auth.RoleUser)
+		_, moderatorToken := signUp(t, uc, auth.RoleModerator)
+		_, adminToken := signUp(t, uc, auth.RoleAdmin)
+		target, _ := signUp(t, uc, auth.RoleUser)
+
+		rec := do(h, http.MethodGet, "/admin/users", "", "")
+		require.Equal(t, http.StatusUnauthorized, rec.Code, rec.Body.String())
+		rec = do(h, http.MethodGet, "/admin/users", userToken, "")
+		require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+		rec = do(h, http.MethodGet, "/admin/users", moderatorToken, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.Contains(t, rec.Body.String(), target.Email)
+
+		rec = do(h, http.MethodPost, "/admin/users/role", moderatorToken, `{"user_id":1,"role":"moderator"}`)
+		require.Equal(t, http.StatusForbidden, rec.Code, "moderators should not assign roles")
+		rec = do(h, http.MethodPost, "/admin/users/suspend", moderatorToken, `{"user_id":`+strconv.Itoa(target.ID)+`,"reason":"spam"}`)
+		require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+		rec = do(h, http.MethodPost, "/admin/users/role", adminToken, `{"user_id":`+strconv.Itoa(target.ID)+`,"role":"moderator"}`)
+		require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+	})
+
+	t.Run(`API keys are accepted by the routes of their scopes only, and refused on the account routes`, func(t *testing.T) {
+		uc, _ := setup(t)
+		now := time.Now().UTC()
+		uc.Now = func() time.Time { return now }
+		h := http_api.NewHandler(uc, http_api.Options{})
+		moderator, _ := signUp(t, uc, auth.RoleModerator)
+		createKey := func(scopes []auth.Permission, expiresAt time.Time) (string, auth.APIKey) {
+			key, apiKey, err := uc.CreateAPIKey(context.Background(), moderator.ID, "bot", scopes, expiresAt)
+			require.Nil(t, err)
+			require.True(t, auth.IsAPIKey(key), key)
+			return key, apiKey
+		}
+		scoped, _ := createKey([]auth.Permission{auth.PermissionReadUsers}, now.Add(time.Hour))
+		unscoped, _ := createKey(nil, now.Add(time.Hour))
+
+		rec := do(h, http.MethodGet, "/admin/users", scoped, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		rec = do(h, http.MethodGet, "/admin/users", unscoped, "")
+		require.Equal(t, http.StatusForbidden, rec.Code, "keys should not have the permissions of their user without the scope")
+
+		for _, path := range []string{"/account/password", "/account/email", "/account/export", "/account/api-keys"} {
+			rec = do(h, http.MethodPost, path, scoped, `{"password":"`+moderator.Password+`","old_password":"`+moderator.Password+`","new_password":"a-new-long-password","email":"changed@example.com"}`)
+			require.Equal(t, http.StatusForbidden, rec.Code, "%s: %s", path, rec.Body.String())
+		}
+
+		revoked, revokedKey := createKey([]auth.Permission{auth.PermissionReadUsers}, now.Add(time.Hour))
+		require.Nil(t, uc.RevokeAPIKey(context.Background(), moderator.ID, revokedKey.ID))
+		rec = do(h, http.MethodGet, "/admin/users", revoked, "")
+		require.Equal(t, http.StatusUnauthorized, rec.Code, rec.Body.String())
+
+		now = now.Add(time.Hour)
+		rec = do(h, http.MethodGet, "/admin/users", scoped, "")
+		require.Equal(t, http.StatusUnauthorized, rec.Code, "expired keys should be refused: %s", rec.Body.String())
+		require.Equal(t, `Bearer error="invalid_token"`, rec.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run(`data exports can be fetched and downloaded by their user only`, func(t *testing.T) {
+		uc, _ := setup(t)
+		blobs, err := blobstore.NewFilesystem(t.TempDir())
+		require.Nil(t, err)
+		uc.DataExports = auth.DataExportOptions{Blobs: blobs}
+		h := http_api.NewHandler(uc, http_api.Options{})
+		_, ownerToken := signUp(t, uc, auth.RoleUser)
+		_, otherToken := signUp(t, uc, auth.RoleUser)
+
+		rec := do(h, http.MethodPost, "/account/export", ownerToken, "")
+		require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+		status := rec.Header().Get("Location")
+		id := strings.TrimPrefix(status, "/account/export/status?id=")
+		require.NotEqual(t, status, id, "the Location should be the status of the export")
+		built, err := uc.BuildDataExports(context.Background(), 10)
+		require.Nil(t, err)
+		require.Equal(t, 1, built)
+
+		rec = do(h, http.MethodGet, status, ownerToken, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		rec = do(h, http.MethodGet, "/account/export/download?id="+id, ownerToken, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.Equal(t, "application/zip", rec.Header().Get("Content-Type"))
+
+		rec = do(h, http.MethodGet, status, otherToken, "")
+		require.Equal(t, http.StatusNotFound, rec.Code, "the export of another user should not be found: %s", rec.Body.String())
+		require.NotContains(t, rec.Body.String(), id)
+		rec = do(h, http.MethodGet, "/account/export/download?id="+id, otherToken, "")
+		require.Equal(t, http.StatusNotFound, rec.Code, "the export of another user should not be downloaded: %s", rec.Body.String())
+		require.NotEqual(t, "application/zip", rec.Header().Get("Content-Type"))
+	})
+
+	t.Run(`the OIDC callback needs the binding cookie of the browser that started the flow, and clears it`, func(t *testing.T) {
+		provider := test_helpers.NewFakeOIDCProvider(t)
+		uc, _ := setup(t)
+		uc.OIDC = auth.OIDCOptions{Providers: []auth.OIDCProvider{provider.Provider("fake")}}
+		h := http_api.NewHandler(uc, http_api.Options{})
+		bindingCookie := func(rec *httptest.ResponseRecorder) *http.Cookie {
+			for _, c := range rec.Result().Cookies() {
+				if c.Name == "oidc_binding" {
+					return c
+				}
+			}
+			return nil
+		}
+		callback := func(query string, cookie *http.Cookie) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/oidc/callback?"+query, nil)
+			if cookie != nil {
+				req.AddCookie(cookie)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			return rec
+		}
+
+		rec := do(h, http.MethodGet, "/oidc/login?provider=fake", "", "")
+		require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+		binding := bindingCookie(rec)
+		require.NotNil(t, binding)
+		require.Equal(t, "/oidc/callback", binding.Path)
+		require.True(t, binding.HttpOnly)
+		state, code := provider.Authorize(t, rec.Header().Get("Location"), test_helpers.FakeOIDCUser{
+			Subject: "alice-subject", Email: "alice@example.com", EmailVerified: true, PreferredUsername: "alice",
+		})
+		query := url.Values{"state": {state}, "code": {code}}.Encode()
+
+		rec = callback(query, nil)
+		require.Equal(t, http.StatusBadRequest, rec.Code, "callbacks without the cookie should fail: %s", rec.Body.String())
+		rec = callback(query, &http.Cookie{Name: "oidc_binding", Value: "the-binding-of-another-browser"})
+		require.Equal(t, http.StatusBadRequest, rec.Code, "callbacks with another cookie should fail: %s", rec.Body.String())
+		require.Equal(t, -1, bindingCookie(rec).MaxAge, "failed callbacks should clear the cookie too")
+
+		rec = callback(query, binding)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.Contains(t, rec.Body.String(), `"token"`)
+		cleared := bindingCookie(rec)
+		require.NotNil(t, cleared)
+		require.Equal(t, -1, cleared.MaxAge)
+		require.Empty(t, cleared.Value)
+		require.Equal(t, "/oidc/callback", cleared.Path)
+	})
+
+	t.Run(`/healthz is up regardless of the dependencies, /readyz is down with the breakdown unless all checks pass`, func(t *testing.T) {
+		uc, _ := setup(t)
+		kafkaErr := errors.New("no brokers")
+		h := http_api.NewHandler(uc, http_api.Options{ReadinessChecks: []health.Check{
+			{Name: "postgres", Checker: health.CheckerFunc(func(ctx context.Context) error { return nil })},
+			{Name: "kafka", Checker: health.CheckerFunc(func(ctx context.Context) error { return kafkaErr })},
+		}})
+
+		rec := do(h, http.MethodGet, "/healthz", "", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		rec = do(h, http.MethodGet, "/readyz", "", "")
+		require.Equal(t, http.StatusServiceUnavailable, rec.Code, rec.Body.String())
+		var report health.Report
+		require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		require.Equal(t, health.StatusDown, report.Status)
+		require.Len(t, report.Checks, 2)
+		require.Equal(t, "postgres", report.Checks[0].Name)
+		require.Equal(t, health.StatusUp, report.Checks[0].Status)
+		require.Equal(t, "kafka", report.Checks[1].Name)
+		require.Equal(t, health.StatusDown, report.Checks[1].Status)
+		require.Equal(t, "no brokers", report.Checks[1].Error)
+
+		kafkaErr = nil
+		rec = do(h, http.MethodGet, "/readyz", "", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		require.Equal(t, health.StatusUp, report.Status)
+	})
+
+	t.Run(`tokens of a role downgraded in the storage are refused`, func(t *testing.T) {
+		uc, h := setup(t)
+		moderator, token := signUp(t, uc, auth.RoleModerator)
+		require.Nil(t, uc.Storage.SetRole(context.Background(), moderator.ID, auth.RoleUser))
+
+		rec := do(h, http.MethodGet, "/admin/users", token, "")
+		require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+		rec = do(h, http.MethodGet, "/admin/users/search?q="+moderator.Username, token, "")
+		require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+	})
+}
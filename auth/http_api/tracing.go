@@ -0,0 +1,50 @@
+package http_api
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/davudsafarli/twitter/auth/http_api"
+
+// traced serves the requests in a server span named after their route. The span continues the trace of the caller,
+// extracted from the headers of the request, e.g. traceparent. The query isn't recorded, as it carries tokens, e.g. of /oidc/callback
+func traced(mux *http.ServeMux, tp trace.TracerProvider) http.Handler {
+	tracer := tp.Tracer(instrumentationName)
+	propagator := propagation.TraceContext{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		name := "HTTP " + r.Method
+		attributes := []attribute.KeyValue{semconv.HTTPMethodKey.String(r.Method)}
+		if _, route := mux.Handler(r); route != "" {
+			name += " " + route
+			attributes = append(attributes, semconv.HTTPRouteKey.String(route))
+		}
+		ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attributes...))
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		mux.ServeHTTP(recorder, r.WithContext(ctx))
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(recorder.status))
+		// client errors are the failures of the caller, not of the server
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// statusRecorder remembers the status code of the response for the span
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,38 @@
+package http_api_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/http_api"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing(t *testing.T) {
+	tp, exporter := test_helpers.NewTracerProvider(t)
+	uc := test_helpers.NewUsecases(inmemory.New(), nil)
+	uc.TracerProvider = tp
+	handler := http_api.NewHandler(uc, http_api.Options{})
+
+	ctx, caller := tp.Tracer("test").Start(context.Background(), "caller")
+	req := httptest.NewRequest(http.MethodPost, "/signup", strings.NewReader(`{"email":"trace@example.com","username":"trace","password":"a-long-enough-password"}`))
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	caller.End()
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	spans := exporter.GetSpans()
+	server := test_helpers.FindSpan(t, spans, "HTTP POST /signup")
+	require.Equal(t, trace.SpanKindServer, server.SpanKind)
+	require.Equal(t, caller.SpanContext().SpanID(), server.Parent.SpanID(), "the server span should continue the trace of traceparent")
+	signup := test_helpers.FindSpan(t, spans, "Usecases.SignUpUser")
+	require.Equal(t, server.SpanContext.SpanID(), signup.Parent.SpanID())
+}
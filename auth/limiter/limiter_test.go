@@ -0,0 +1,57 @@
+package limiter_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/davudsafarli/twitter/auth/contracts"
+	"github.com/davudsafarli/twitter/auth/limiter"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestMemory(t *testing.T) {
+	contracts.LoginAttemptStoreContract{Subject: limiter.NewMemory()}.Test(t)
+}
+
+func TestRedis(t *testing.T) {
+	server := miniredis.NewMiniRedis()
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	clock := time.Now()
+	server.SetTime(clock)
+	contracts.LoginAttemptStoreContract{
+		Subject: limiter.NewRedis(goRedis{client: client}, "auth:login:"),
+		// miniredis expires the keys only when its clock is fast forwarded
+		SetClock: func(now time.Time) {
+			if now.After(clock) {
+				server.FastForward(now.Sub(clock))
+			}
+			clock = now
+			server.SetTime(now)
+		},
+	}.Test(t)
+}
+
+// goRedis is the adapter of the doc of limiter.RedisClient
+type goRedis struct {
+	client *redis.Client
+}
+
+func (a goRedis) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return a.client.HGetAll(ctx, key).Result()
+}
+
+func (a goRedis) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return a.client.Eval(ctx, script, keys, args...).Result()
+}
+
+func (a goRedis) Del(ctx context.Context, key string) error {
+	return a.client.Del(ctx, key).Err()
+}
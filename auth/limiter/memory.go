@@ -0,0 +1,94 @@
+// Package limiter implements auth.LoginAttemptStore
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+)
+
+// Memory keeps the attempts in memory. It is enough for a single instance of the service, use Redis or storage.Postgres otherwise
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	// nextSweep is when the expired entries are removed next
+	nextSweep time.Time
+}
+
+// sweepInterval is how often the expired entries are removed at most
+const sweepInterval = time.Minute
+
+type memoryEntry struct {
+	auth.LoginAttempts
+	expiresAt time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{entries: map[string]memoryEntry{}}
+}
+
+func (m *Memory) LoginAttempts(ctx context.Context, key string, now time.Time) (auth.LoginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.get(key, now).LoginAttempts, nil
+}
+
+func (m *Memory) RecordLoginFailure(ctx context.Context, key string, now time.Time, ttl time.Duration) (auth.LoginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.get(key, now)
+	e.Failures++
+	e.LastFailureAt = now
+	if exp := now.Add(ttl); exp.After(e.expiresAt) {
+		e.expiresAt = exp
+	}
+	m.entries[key] = e
+	// expired entries are removed lazily, writes are a good time for it since they are rare compared to the reads.
+	// It is done once a sweepInterval, so a failure doesn't scan all the entries
+	if !now.Before(m.nextSweep) {
+		m.removeExpired(now)
+		m.nextSweep = now.Add(sweepInterval)
+	}
+	return e.LoginAttempts, nil
+}
+
+func (m *Memory) LockLogin(ctx context.Context, key string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+	e.LockedUntil = until
+	if until.After(e.expiresAt) {
+		e.expiresAt = until
+	}
+	m.entries[key] = e
+	return nil
+}
+
+func (m *Memory) ResetLoginAttempts(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+// get returns the entry of the key, the zero value if it is expired at now
+func (m *Memory) get(key string, now time.Time) memoryEntry {
+	e, ok := m.entries[key]
+	if !ok || !e.expiresAt.After(now) {
+		return memoryEntry{}
+	}
+	return e
+}
+
+func (m *Memory) removeExpired(now time.Time) {
+	for key, e := range m.entries {
+		if !e.expiresAt.After(now) {
+			delete(m.entries, key)
+		}
+	}
+}
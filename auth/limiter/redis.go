@@ -0,0 +1,145 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+)
+
+// RedisClient is the subset of redis commands Redis needs. It is kept small,
+// so any redis client can satisfy it with a thin adapter, e.g. go-redis:
+//
+//	func (a adapter) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+//		return a.client.Eval(ctx, script, keys, args...).Result()
+//	}
+type RedisClient interface {
+	HGetAll(ctx context.Context, key string) (map[string]string, error)
+	// Eval runs a lua script, the replies of redis are returned as the go-redis Cmd.Result does,
+	// e.g. an array as []interface{} of strings
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+	Del(ctx context.Context, key string) error
+}
+
+// Redis keeps the attempts in redis hashes, so they are shared by all instances of the service.
+// Expiry is left to redis, which uses its own clock instead of the given now.
+// The writes are lua scripts, so they are atomic and take a single round trip
+type Redis struct {
+	client RedisClient
+	// prefix is prepended to the keys
+	prefix string
+}
+
+// NewRedis creates a Redis store. Keys are prefixed with prefix, e.g. "auth:login:"
+func NewRedis(client RedisClient, prefix string) Redis {
+	return Redis{client: client, prefix: prefix}
+}
+
+const (
+	fieldFailures      = "failures"
+	fieldLastFailureAt = "last_failure_at"
+	fieldLockedUntil   = "locked_until"
+)
+
+// extendExpiryScript sets the expiry of KEYS[1] to the unix milliseconds of the last ARGV, unless it already expires later.
+// The expiry is kept in a field too, since PEXPIREAT can't only extend it in older redis versions.
+// Milliseconds are exact in the numbers of lua, unlike the nanoseconds of the other fields
+const extendExpiryScript = `
+local at = ARGV[#ARGV]
+if tonumber(at) > tonumber(redis.call('HGET', KEYS[1], 'expires_at_ms') or '0') then
+	redis.call('HSET', KEYS[1], 'expires_at_ms', at)
+	redis.call('PEXPIREAT', KEYS[1], at)
+end
+`
+
+// recordLoginFailureScript counts a failure at ARGV[1], and returns the hash
+const recordLoginFailureScript = `
+redis.call('HINCRBY', KEYS[1], '` + fieldFailures + `', 1)
+redis.call('HSET', KEYS[1], '` + fieldLastFailureAt + `', ARGV[1])
+` + extendExpiryScript + `
+return redis.call('HGETALL', KEYS[1])
+`
+
+// lockLoginScript sets the lock to ARGV[1]
+const lockLoginScript = `
+redis.call('HSET', KEYS[1], '` + fieldLockedUntil + `', ARGV[1])
+` + extendExpiryScript + `
+return 1
+`
+
+func (r Redis) LoginAttempts(ctx context.Context, key string, now time.Time) (auth.LoginAttempts, error) {
+	fields, err := r.client.HGetAll(ctx, r.prefix+key)
+	if err != nil {
+		return auth.LoginAttempts{}, err
+	}
+	return loginAttemptsOf(fields), nil
+}
+
+func (r Redis) RecordLoginFailure(ctx context.Context, key string, now time.Time, ttl time.Duration) (auth.LoginAttempts, error) {
+	reply, err := r.client.Eval(ctx, recordLoginFailureScript, []string{r.prefix + key}, formatUnixNano(now), formatUnixMilli(now.Add(ttl)))
+	if err != nil {
+		return auth.LoginAttempts{}, err
+	}
+	fields, err := hashOf(reply)
+	if err != nil {
+		return auth.LoginAttempts{}, err
+	}
+	return loginAttemptsOf(fields), nil
+}
+
+func (r Redis) LockLogin(ctx context.Context, key string, until time.Time) error {
+	_, err := r.client.Eval(ctx, lockLoginScript, []string{r.prefix + key}, formatUnixNano(until), formatUnixMilli(until))
+	return err
+}
+
+func (r Redis) ResetLoginAttempts(ctx context.Context, key string) error {
+	return r.client.Del(ctx, r.prefix+key)
+}
+
+func loginAttemptsOf(fields map[string]string) auth.LoginAttempts {
+	failures, _ := strconv.Atoi(fields[fieldFailures])
+	return auth.LoginAttempts{
+		Failures:      failures,
+		LastFailureAt: parseUnixNano(fields[fieldLastFailureAt]),
+		LockedUntil:   parseUnixNano(fields[fieldLockedUntil]),
+	}
+}
+
+// hashOf converts the HGETALL reply of a script, a flat array of fields and values, to a map
+func hashOf(reply interface{}) (map[string]string, error) {
+	values, ok := reply.([]interface{})
+	if !ok || len(values)%2 != 0 {
+		return nil, fmt.Errorf("unexpected reply of redis: %v", reply)
+	}
+	fields := make(map[string]string, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		field, ok1 := values[i].(string)
+		value, ok2 := values[i+1].(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("unexpected reply of redis: %v", reply)
+		}
+		fields[field] = value
+	}
+	return fields, nil
+}
+
+func formatUnixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func formatUnixMilli(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+}
+
+func parseUnixNano(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
@@ -0,0 +1,161 @@
+// Package logging provides a small leveled, structured logger.
+// Its Logger interface follows the key/value style of log/slog, so any slog (or similar) logger can be adapted to it.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota - 1
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+}
+
+// ParseLevel parses the case-insensitive name of a level, e.g. "debug"
+func ParseLevel(s string) (Level, error) {
+	for _, l := range []Level{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// Logger logs messages with alternating key/value pairs, e.g. logger.Info("message written", "topic", "users", "offset", 12)
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+	// With returns a Logger that adds the keyvals to every message
+	With(keyvals ...interface{}) Logger
+}
+
+// Nop returns a Logger that discards everything
+func Nop() Logger {
+	return nop{}
+}
+
+type nop struct{}
+
+func (nop) Debug(string, ...interface{}) {}
+func (nop) Info(string, ...interface{})  {}
+func (nop) Warn(string, ...interface{})  {}
+func (nop) Error(string, ...interface{}) {}
+func (n nop) With(...interface{}) Logger { return n }
+
+// New returns a Logger that writes the messages at or above the level to w in logfmt format:
+//
+//	time=2021-07-19T13:11:26Z level=INFO msg="message written" topic=users offset=12
+func New(w io.Writer, level Level) Logger {
+	return &textLogger{out: &syncWriter{w: w}, level: level}
+}
+
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+type textLogger struct {
+	out    *syncWriter
+	level  Level
+	fields []interface{}
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func (l *textLogger) Debug(msg string, keyvals ...interface{}) { l.log(LevelDebug, msg, keyvals) }
+func (l *textLogger) Info(msg string, keyvals ...interface{})  { l.log(LevelInfo, msg, keyvals) }
+func (l *textLogger) Warn(msg string, keyvals ...interface{})  { l.log(LevelWarn, msg, keyvals) }
+func (l *textLogger) Error(msg string, keyvals ...interface{}) { l.log(LevelError, msg, keyvals) }
+
+func (l *textLogger) With(keyvals ...interface{}) Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	return &textLogger{out: l.out, level: l.level, fields: fields, now: l.now}
+}
+
+func (l *textLogger) log(level Level, msg string, keyvals []interface{}) {
+	if level < l.level {
+		return
+	}
+	now := time.Now
+	if l.now != nil {
+		now = l.now
+	}
+	var b strings.Builder
+	b.WriteString("time=")
+	b.WriteString(now().UTC().Format(time.RFC3339Nano))
+	b.WriteString(" level=")
+	b.WriteString(level.String())
+	b.WriteString(" msg=")
+	b.WriteString(formatValue(msg))
+	writeKeyvals(&b, l.fields)
+	writeKeyvals(&b, keyvals)
+	b.WriteByte('\n')
+	_, _ = io.WriteString(l.out, b.String())
+}
+
+func writeKeyvals(b *strings.Builder, keyvals []interface{}) {
+	for i := 0; i < len(keyvals); i += 2 {
+		b.WriteByte(' ')
+		if i+1 == len(keyvals) {
+			// odd number of keyvals, like slog does, log the value without a key
+			b.WriteString("!BADKEY=")
+			b.WriteString(formatValue(keyvals[i]))
+			return
+		}
+		b.WriteString(fmt.Sprint(keyvals[i]))
+		b.WriteByte('=')
+		b.WriteString(formatValue(keyvals[i+1]))
+	}
+}
+
+func formatValue(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	case []byte:
+		s = string(v)
+	default:
+		s = fmt.Sprint(v)
+	}
+	if s == "" || strings.ContainsAny(s, " =\"\n\t") {
+		return strconv.Quote(s)
+	}
+	return s
+}
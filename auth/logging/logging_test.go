@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTextLogger(t *testing.T) {
+	now := time.Date(2021, 7, 19, 13, 11, 26, 0, time.UTC)
+	newLogger := func(level Level) (*textLogger, *bytes.Buffer) {
+		buf := &bytes.Buffer{}
+		l := New(buf, level).(*textLogger)
+		l.now = func() time.Time { return now }
+		return l, buf
+	}
+
+	t.Run(`messages are written in logfmt format with their fields`, func(t *testing.T) {
+		l, buf := newLogger(LevelInfo)
+		l.Info("message written", "topic", "users", "offset", 12, "err", errors.New("some error"))
+		require.Equal(t, `time=2021-07-19T13:11:26Z level=INFO msg="message written" topic=users offset=12 err="some error"`+"\n", buf.String())
+	})
+
+	t.Run(`messages below the level are dropped`, func(t *testing.T) {
+		l, buf := newLogger(LevelWarn)
+		l.Debug("debug")
+		l.Info("info")
+		l.Warn("warn")
+		require.Equal(t, "time=2021-07-19T13:11:26Z level=WARN msg=warn\n", buf.String())
+	})
+
+	t.Run(`#With adds the fields to every message, without changing the parent logger`, func(t *testing.T) {
+		l, buf := newLogger(LevelInfo)
+		l.With("component", "consumer").Error("failed", "partition", 1)
+		l.Info("parent")
+		require.Equal(t,
+			"time=2021-07-19T13:11:26Z level=ERROR msg=failed component=consumer partition=1\n"+
+				"time=2021-07-19T13:11:26Z level=INFO msg=parent\n",
+			buf.String())
+	})
+
+	t.Run(`a key without a value is still logged`, func(t *testing.T) {
+		l, buf := newLogger(LevelInfo)
+		l.Info("odd", "lonely")
+		require.Equal(t, "time=2021-07-19T13:11:26Z level=INFO msg=odd !BADKEY=lonely\n", buf.String())
+	})
+}
+
+func TestParseLevel(t *testing.T) {
+	l, err := ParseLevel("debug")
+	require.Nil(t, err)
+	require.Equal(t, LevelDebug, l)
+	_, err = ParseLevel("verbose")
+	require.NotNil(t, err)
+}
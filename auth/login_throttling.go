@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// LoginAttempts are the recent failed logins of an account or an IP
+type LoginAttempts struct {
+	Failures      int
+	LastFailureAt time.Time
+	// LockedUntil is zero if the key was never locked
+	LockedUntil time.Time
+}
+
+// LoginAttemptStore keeps the LoginAttempts per key. Entries expire like redis keys, an expired entry is the same as a missing one.
+// See limiter package and storage.Postgres for the implementations
+type LoginAttemptStore interface {
+	// LoginAttempts returns the attempts of the key, the zero value if it doesn't exist or is expired at now
+	LoginAttempts(ctx context.Context, key string, now time.Time) (LoginAttempts, error)
+	// RecordLoginFailure increments the failures of the key, starting from zero if it is expired at now.
+	// It sets LastFailureAt to now, and extends the expiry of the key to now+ttl at least
+	RecordLoginFailure(ctx context.Context, key string, now time.Time, ttl time.Duration) (LoginAttempts, error)
+	// LockLogin sets the LockedUntil of an existing key, and extends its expiry to until at least
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	ResetLoginAttempts(ctx context.Context, key string) error
+}
+
+// LoginThrottling slows down guessing passwords. After every failed login of an account or an IP,
+// the next login for it is rejected with a TooManyAttemptsError for an exponentially growing delay.
+// After too many failures the account or the IP is locked for LockoutDuration. Zero values are replaced with the defaults below
+type LoginThrottling struct {
+	// Store keeps the attempts, logins are not throttled if it is nil
+	Store LoginAttemptStore
+	// MaxAccountFailures locks an account after as many consecutive failures. Defaults to 5
+	MaxAccountFailures int
+	// MaxIPFailures locks an IP after as many consecutive failures, for any account. Defaults to 20
+	MaxIPFailures int
+	// BaseDelay is the delay after the first failure, it doubles after every failure. Defaults to 1 second
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between the failures. Defaults to 1 minute
+	MaxDelay time.Duration
+	// LockoutDuration defaults to 15 minutes
+	LockoutDuration time.Duration
+	// Window is how long the failures are remembered after the last one. Defaults to 1 hour
+	Window time.Duration
+}
+
+func (t LoginThrottling) withDefaults() LoginThrottling {
+	if t.MaxAccountFailures == 0 {
+		t.MaxAccountFailures = 5
+	}
+	if t.MaxIPFailures == 0 {
+		t.MaxIPFailures = 20
+	}
+	if t.BaseDelay == 0 {
+		t.BaseDelay = time.Second
+	}
+	if t.MaxDelay == 0 {
+		t.MaxDelay = time.Minute
+	}
+	if t.LockoutDuration == 0 {
+		t.LockoutDuration = 15 * time.Minute
+	}
+	if t.Window == 0 {
+		t.Window = time.Hour
+	}
+	return t
+}
+
+// TooManyAttemptsError is returned by Login when the account or the IP is throttled. It wraps ErrTooManyAttempts
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (e *TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", ErrTooManyAttempts, e.RetryAfter)
+}
+
+func (e *TooManyAttemptsError) Unwrap() error {
+	return ErrTooManyAttempts
+}
+
+// throttleKey is a key of LoginAttemptStore with the failure limit of its kind
+type throttleKey struct {
+	key         string
+	maxFailures int
+	// account is true for the key of the username
+	account bool
+}
+
+// keys returns the throttled keys of a login. IP is not throttled if the transport didn't set it
+func (t LoginThrottling) keys(ctx context.Context, usnm string) []throttleKey {
+	keys := []throttleKey{{key: "account:" + usnm, maxFailures: t.MaxAccountFailures, account: true}}
+	if ip := ClientInfoFrom(ctx).IP; ip != "" {
+		keys = append(keys, throttleKey{key: "ip:" + ip, maxFailures: t.MaxIPFailures})
+	}
+	return keys
+}
+
+// delay returns the wait after the given number of failures
+func (t LoginThrottling) delay(failures int) time.Duration {
+	d := t.BaseDelay
+	for i := 1; i < failures && d < t.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > t.MaxDelay {
+		d = t.MaxDelay
+	}
+	return d
+}
+
+// The methods below do nothing if LoginThrottling.Store is nil
+
+// checkLoginThrottling returns a TooManyAttemptsError if any of the keys is locked or waiting for its delay
+func (c Usecases) checkLoginThrottling(ctx context.Context, keys []throttleKey) error {
+	t := c.LoginThrottling.withDefaults()
+	if t.Store == nil {
+		return nil
+	}
+	now := c.now()
+	var retryAt time.Time
+	for _, k := range keys {
+		attempts, err := t.Store.LoginAttempts(ctx, k.key, now)
+		if err != nil {
+			return err
+		}
+		if attempts.LockedUntil.After(retryAt) {
+			retryAt = attempts.LockedUntil
+		}
+		if attempts.Failures > 0 {
+			if next := attempts.LastFailureAt.Add(t.delay(attempts.Failures)); next.After(retryAt) {
+				retryAt = next
+			}
+		}
+	}
+	if retryAt.After(now) {
+		return &TooManyAttemptsError{RetryAfter: retryAt.Sub(now)}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed login for all keys, and locks the ones with too many failures.
+// user is the zero value if the username doesn't exist, accounts that don't exist are throttled the same way but no event is published
+func (c Usecases) recordLoginFailure(ctx context.Context, keys []throttleKey, user User) error {
+	t := c.LoginThrottling.withDefaults()
+	if t.Store == nil {
+		return nil
+	}
+	now := c.now()
+	for _, k := range keys {
+		attempts, err := t.Store.RecordLoginFailure(ctx, k.key, now, t.Window)
+		if err != nil {
+			return err
+		}
+		if attempts.Failures < k.maxFailures {
+			continue
+		}
+		lockedUntil := now.Add(t.LockoutDuration)
+		if err := t.Store.LockLogin(ctx, k.key, lockedUntil); err != nil {
+			return err
+		}
+		if k.account && user.ID != 0 {
+			err := c.events().PublishAccountLockedEvent(ctx, AccountLockedEvent{
+				UserID:      user.ID,
+				Failures:    attempts.Failures,
+				LockedUntil: lockedUntil,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resetLoginThrottling forgets the failures of the account after a successful login.
+// Failures of the IP are kept, otherwise an attacker could reset them by logging in to their own account
+func (c Usecases) resetLoginThrottling(ctx context.Context, keys []throttleKey) error {
+	if c.LoginThrottling.Store == nil {
+		return nil
+	}
+	for _, k := range keys {
+		if !k.account {
+			continue
+		}
+		if err := c.LoginThrottling.Store.ResetLoginAttempts(ctx, k.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/limiter"
+	"github.com/davudsafarli/twitter/auth/mailer"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is advanced manually, so throttling can be tested without waiting
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestLoginThrottling(t *testing.T) {
+	setup := func(t *testing.T) (auth.Usecases, *inmemory.EventStreamer, *fakeClock, auth.User) {
+		events := inmemory.New()
+		clock := &fakeClock{now: time.Date(2021, 7, 19, 13, 11, 26, 0, time.UTC)}
+		uc := test_helpers.NewUsecases(events, mailer.NewInMemory())
+		uc.Now = clock.Now
+		uc.LoginThrottling = auth.LoginThrottling{
+			Store:              limiter.NewMemory(),
+			MaxAccountFailures: 3,
+			MaxIPFailures:      5,
+			BaseDelay:          time.Second,
+			MaxDelay:           time.Minute,
+			LockoutDuration:    15 * time.Minute,
+		}
+		user := test_helpers.HopefullyUniqueUser()
+		created, err := uc.SignUpUser(context.Background(), user)
+		require.Nil(t, err)
+		created.Password = user.Password
+		return uc, events, clock, created
+	}
+	retryAfter := func(t *testing.T, err error) time.Duration {
+		var tooMany *auth.TooManyAttemptsError
+		require.True(t, errors.As(err, &tooMany), "expected TooManyAttemptsError, got %v", err)
+		require.ErrorIs(t, err, auth.ErrTooManyAttempts)
+		return tooMany.RetryAfter
+	}
+	withIP := func(ip string) context.Context {
+		return auth.WithClientInfo(context.Background(), auth.ClientInfo{IP: ip})
+	}
+
+	t.Run(`failures are delayed exponentially`, func(t *testing.T) {
+		uc, _, clock, user := setup(t)
+		_, err := uc.Login(context.Background(), user.Username, "wrong-password")
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+		_, err = uc.Login(context.Background(), user.Username, user.Password)
+		require.Equal(t, time.Second, retryAfter(t, err), "even the correct password should wait for the delay")
+
+		clock.Advance(time.Second)
+		_, err = uc.Login(context.Background(), user.Username, "wrong-password")
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+		_, err = uc.Login(context.Background(), user.Username, user.Password)
+		require.Equal(t, 2*time.Second, retryAfter(t, err))
+
+		clock.Advance(2 * time.Second)
+		_, err = uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+		_, err = uc.Login(context.Background(), user.Username, "wrong-password")
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials, "successful login should reset the failures")
+		_, err = uc.Login(context.Background(), user.Username, user.Password)
+		require.Equal(t, time.Second, retryAfter(t, err))
+	})
+
+	t.Run(`account is locked after too many failures, and AccountLocked is published`, func(t *testing.T) {
+		uc, events, clock, user := setup(t)
+		for i := 0; i < 3; i++ {
+			_, err := uc.Login(context.Background(), user.Username, "wrong-password")
+			require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+			clock.Advance(time.Minute)
+		}
+		_, err := uc.Login(context.Background(), user.Username, user.Password)
+		require.Equal(t, 14*time.Minute, retryAfter(t, err))
+
+		published := events.Published()
+		require.Equal(t, auth.AccountLockedEvent{
+			UserID:      user.ID,
+			Failures:    3,
+			LockedUntil: clock.Now().Add(-time.Minute + 15*time.Minute),
+		}, published[len(published)-1])
+
+		clock.Advance(14 * time.Minute)
+		_, err = uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+	})
+
+	t.Run(`unknown usernames are throttled the same way, without events`, func(t *testing.T) {
+		uc, events, clock, _ := setup(t)
+		for i := 0; i < 3; i++ {
+			_, err := uc.Login(context.Background(), "username-that-doesnt-exist", "pwd")
+			require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+			clock.Advance(time.Minute)
+		}
+		_, err := uc.Login(context.Background(), "username-that-doesnt-exist", "pwd")
+		require.Equal(t, 14*time.Minute, retryAfter(t, err))
+		require.Len(t, events.Published(), 1, "only the signup event should be published")
+	})
+
+	t.Run(`IP is throttled across accounts`, func(t *testing.T) {
+		uc, _, clock, user := setup(t)
+		for i := 0; i < 5; i++ {
+			_, err := uc.Login(withIP("10.0.0.1"), "username-that-doesnt-exist-"+string(rune('a'+i)), "pwd")
+			require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+			clock.Advance(time.Minute)
+		}
+		_, err := uc.Login(withIP("10.0.0.1"), user.Username, user.Password)
+		require.Equal(t, 14*time.Minute, retryAfter(t, err))
+		_, err = uc.Login(withIP("10.0.0.2"), user.Username, user.Password)
+		require.Nil(t, err, "other IPs should not be affected")
+	})
+
+	t.Run(`the password checks of #ChangePassword and #ChangeEmail are throttled with the logins`, func(t *testing.T) {
+		uc, _, clock, user := setup(t)
+		err := uc.ChangePassword(context.Background(), user.ID, "wrong-password", "a-new-long-password")
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+		err = uc.ChangeEmail(context.Background(), user.ID, user.Password, user.Username+"@example.com")
+		require.Equal(t, time.Second, retryAfter(t, err), "even the correct password should wait for the delay")
+		_, err = uc.Login(context.Background(), user.Username, user.Password)
+		require.Equal(t, time.Second, retryAfter(t, err), "logins of the account should be throttled too")
+
+		for i := 0; i < 2; i++ {
+			clock.Advance(time.Minute)
+			err = uc.ChangeEmail(context.Background(), user.ID, "wrong-password", user.Username+"@example.com")
+			require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+		}
+		clock.Advance(time.Minute)
+		err = uc.ChangePassword(context.Background(), user.ID, user.Password, "a-new-long-password")
+		require.Equal(t, 14*time.Minute, retryAfter(t, err), "the account should be locked")
+	})
+}
@@ -0,0 +1,94 @@
+package auth_test
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/mailer"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestLoginTiming checks that unknown usernames can't be told apart from wrong passwords by the response time.
+// The time of a login is the time of verifying a hash, so both have to verify a hash of the same parameters
+func TestLoginTiming(t *testing.T) {
+	t.Run(`unknown usernames take as long as wrong passwords`, func(t *testing.T) {
+		uc := test_helpers.NewUsecases(inmemory.New(), mailer.NewInMemory())
+		// slow enough for the hash to dominate the time of a login, fast enough for the samples
+		uc.PasswordHasher = auth.BcryptHasher{Cost: bcrypt.MinCost + 4}
+		user := test_helpers.HopefullyUniqueUser()
+		_, err := uc.SignUpUser(context.Background(), user)
+		require.Nil(t, err)
+
+		login := func(usnm string) time.Duration {
+			start := time.Now()
+			_, err := uc.Login(context.Background(), usnm, "wrong-password")
+			d := time.Since(start)
+			require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+			return d
+		}
+		median := func(ds []time.Duration) time.Duration {
+			sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+			return ds[len(ds)/2]
+		}
+
+		// the first login of an unknown user creates the dummy hash
+		login("username-that-doesnt-exist")
+		// samples are interleaved, so a change of the machine's load affects both of them
+		const samples = 15
+		var wrongPassword, unknownUser []time.Duration
+		for i := 0; i < samples; i++ {
+			wrongPassword = append(wrongPassword, login(user.Username))
+			unknownUser = append(unknownUser, login("username-that-doesnt-exist"))
+		}
+		ratio := float64(median(unknownUser)) / float64(median(wrongPassword))
+		require.InDelta(t, 1, ratio, 0.25, "median login time of unknown users is %v, of wrong passwords is %v",
+			median(unknownUser), median(wrongPassword))
+	})
+
+	t.Run(`unknown usernames and wrong passwords verify a hash of the parameters of the hasher`, func(t *testing.T) {
+		hasher := &countingHasher{PasswordHasher: test_helpers.FastPasswordHasher}
+		uc := test_helpers.NewUsecases(inmemory.New(), mailer.NewInMemory())
+		uc.PasswordHasher = hasher
+		user := test_helpers.HopefullyUniqueUser()
+		_, err := uc.SignUpUser(context.Background(), user)
+		require.Nil(t, err)
+
+		for _, username := range []string{user.Username, "username-that-doesnt-exist"} {
+			_, err := uc.Login(context.Background(), username, "wrong-password")
+			require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+			verified := hasher.takeVerified()
+			require.Len(t, verified, 1, "login of %s should verify a single hash", username)
+			require.False(t, hasher.NeedsRehash(verified[0]), "login of %s should verify a hash of the parameters of the hasher", username)
+		}
+	})
+}
+
+// countingHasher remembers the hashes it verified
+type countingHasher struct {
+	auth.PasswordHasher
+	mu       sync.Mutex
+	verified []string
+}
+
+func (h *countingHasher) Verify(password, hash string) (bool, error) {
+	h.mu.Lock()
+	h.verified = append(h.verified, hash)
+	h.mu.Unlock()
+	return h.PasswordHasher.Verify(password, hash)
+}
+
+// takeVerified returns the hashes verified since the last call
+func (h *countingHasher) takeVerified() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	verified := h.verified
+	h.verified = nil
+	return verified
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+	"time"
+)
+
+// Email is a plain text email sent to a user
+type Email struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails to users. See mailer package for the implementations
+type Mailer interface {
+	Send(ctx context.Context, email Email) error
+}
+
+// backgroundEmailTimeout bounds the emails sent after their usecase returned, nothing cancels them otherwise
+const backgroundEmailTimeout = time.Minute
+
+// sendInBackground runs send after the usecase returns, so its response time doesn't tell whether an email was sent.
+// Failures are logged with msg, the usecase can't return them anymore.
+// The emails in flight are lost if the process stops, the user can ask for another one
+func (c Usecases) sendInBackground(ctx context.Context, msg string, user User, send func(ctx context.Context, user User) error) {
+	ctx, cancel := context.WithTimeout(detachedContext{parent: ctx}, backgroundEmailTimeout)
+	go func() {
+		defer cancel()
+		ctx, span := c.tracer().Start(ctx, "Usecases.sendInBackground")
+		defer span.End()
+		if err := send(ctx, user); err != nil {
+			span.RecordError(err)
+			c.logger().Error(msg, "user_id", user.ID, "err", err)
+		}
+	}()
+}
+
+// detachedContext keeps the values of its parent, e.g. its span, but not its deadline and cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (d detachedContext) Value(key interface{}) interface{} { return d.parent.Value(key) }
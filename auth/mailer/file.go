@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+)
+
+// File writes every email to a separate .eml file in a directory, for local development without a mail server
+type File struct {
+	dir string
+
+	mu   sync.Mutex
+	last int64
+}
+
+// NewFile creates the directory if it doesn't exist
+func NewFile(dir string) (*File, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &File{dir: dir}, nil
+}
+
+var unsafeFileNameChars = regexp.MustCompile(`[^a-zA-Z0-9@._-]`)
+
+// Send writes the email to <dir>/<unix nano>-<to>.eml, so the files are listed in the order they are sent
+func (m *File) Send(ctx context.Context, email auth.Email) error {
+	content := fmt.Sprintf("To: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s", email.To, email.Subject, email.Body)
+	name := fmt.Sprintf("%d-%s.eml", m.nextID(), unsafeFileNameChars.ReplaceAllString(email.To, "_"))
+	// the emails contain secrets, e.g. password reset tokens
+	return ioutil.WriteFile(filepath.Join(m.dir, name), []byte(content), 0o600)
+}
+
+// nextID returns the current unix nano, or a greater one if it is already used
+func (m *File) nextID() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := time.Now().UnixNano()
+	if id <= m.last {
+		id = m.last + 1
+	}
+	m.last = id
+	return id
+}
@@ -0,0 +1,45 @@
+// Package mailer implements auth.Mailer
+package mailer
+
+import (
+	"context"
+	"sync"
+
+	"github.com/davudsafarli/twitter/auth"
+)
+
+// InMemory keeps the sent emails in memory, for tests and local development
+type InMemory struct {
+	mu   sync.Mutex
+	sent []auth.Email
+}
+
+func NewInMemory() *InMemory {
+	return &InMemory{}
+}
+
+func (m *InMemory) Send(ctx context.Context, email auth.Email) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, email)
+	return nil
+}
+
+// Sent returns the emails sent so far, oldest first
+func (m *InMemory) Sent() []auth.Email {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]auth.Email(nil), m.sent...)
+}
+
+// Last returns the last email sent to the address, false if nothing is sent to it
+func (m *InMemory) Last(to string) (auth.Email, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].To == to {
+			return m.sent[i], true
+		}
+	}
+	return auth.Email{}, false
+}
@@ -0,0 +1,44 @@
+package mailer_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/mailer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mails")
+	m, err := mailer.NewFile(dir)
+	require.Nil(t, err)
+
+	require.Nil(t, m.Send(context.Background(), auth.Email{To: "a@example.com", Subject: "first", Body: "body 1"}))
+	require.Nil(t, m.Send(context.Background(), auth.Email{To: "../b@example.com", Subject: "second", Body: "body 2"}))
+
+	files, err := ioutil.ReadDir(dir)
+	require.Nil(t, err)
+	require.Len(t, files, 2, "paths in addresses should not escape the directory")
+	first, err := ioutil.ReadFile(filepath.Join(dir, files[0].Name()))
+	require.Nil(t, err)
+	require.True(t, strings.HasSuffix(files[0].Name(), "-a@example.com.eml"))
+	require.Equal(t, "To: a@example.com\r\nSubject: first\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nbody 1", string(first))
+}
+
+func TestInMemory(t *testing.T) {
+	m := mailer.NewInMemory()
+	require.Nil(t, m.Send(context.Background(), auth.Email{To: "a@example.com", Body: "1"}))
+	require.Nil(t, m.Send(context.Background(), auth.Email{To: "b@example.com", Body: "2"}))
+	require.Nil(t, m.Send(context.Background(), auth.Email{To: "a@example.com", Body: "3"}))
+
+	require.Len(t, m.Sent(), 3)
+	last, ok := m.Last("a@example.com")
+	require.True(t, ok)
+	require.Equal(t, "3", last.Body)
+	_, ok = m.Last("c@example.com")
+	require.False(t, ok)
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"errors"
+	"time"
+)
+
+// Outcome is the result category of a usecase, used to label metrics
+type Outcome string
+
+const (
+	OutcomeSuccess            Outcome = "success"
+	OutcomeInvalidCredentials Outcome = "invalid_credentials"
+	OutcomeConflict           Outcome = "conflict"
+	OutcomeInvalidInput       Outcome = "invalid_input"
+	OutcomeInvalidToken       Outcome = "invalid_token"
+	OutcomeEmailNotVerified   Outcome = "email_not_verified"
+	OutcomeTooManyAttempts    Outcome = "too_many_attempts"
+	OutcomeAccountDeactivated Outcome = "account_deactivated"
+	OutcomeMFARequired        Outcome = "mfa_required"
+	OutcomeMFANotEnrolled     Outcome = "mfa_not_enrolled"
+	OutcomeNotFound           Outcome = "not_found"
+	OutcomeForbidden          Outcome = "forbidden"
+	OutcomeAccountSuspended   Outcome = "account_suspended"
+	OutcomeError              Outcome = "error"
+)
+
+// OutcomeOf categorizes the error returned from a usecase
+func OutcomeOf(err error) Outcome {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.Is(err, ErrInvalidCredentials), errors.Is(err, ErrUserNotFound):
+		return OutcomeInvalidCredentials
+	case errors.Is(err, ErrUserAlreadyExists):
+		return OutcomeConflict
+	case errors.Is(err, ErrInvalidInput):
+		return OutcomeInvalidInput
+	case errors.Is(err, ErrInvalidToken):
+		return OutcomeInvalidToken
+	case errors.Is(err, ErrEmailNotVerified):
+		return OutcomeEmailNotVerified
+	case errors.Is(err, ErrTooManyAttempts):
+		return OutcomeTooManyAttempts
+	case errors.Is(err, ErrAccountDeactivated):
+		return OutcomeAccountDeactivated
+	case errors.Is(err, ErrMFARequired):
+		return OutcomeMFARequired
+	case errors.Is(err, ErrMFANotEnrolled):
+		return OutcomeMFANotEnrolled
+	case errors.Is(err, ErrDataExportNotFound), errors.Is(err, ErrSessionNotFound), errors.Is(err, ErrAPIKeyNotFound),
+		errors.Is(err, ErrIdentityNotFound):
+		return OutcomeNotFound
+	case errors.Is(err, ErrForbidden):
+		return OutcomeForbidden
+	case errors.Is(err, ErrAccountSuspended):
+		return OutcomeAccountSuspended
+	default:
+		return OutcomeError
+	}
+}
+
+// Metrics observes the usecases. See metrics package for the Prometheus implementation
+type Metrics interface {
+	// ObserveUsecase is called when a usecase, e.g. "login", returns
+	ObserveUsecase(usecase string, outcome Outcome, duration time.Duration)
+	// ObservePasswordHashing is called after a password is hashed with the algorithm and the params of the PasswordHasher
+	ObservePasswordHashing(algorithm, params string, duration time.Duration)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveUsecase(string, Outcome, time.Duration)        {}
+func (nopMetrics) ObservePasswordHashing(string, string, time.Duration) {}
@@ -0,0 +1,119 @@
+// Package metrics implements auth.Metrics and kafka_sarama.Metrics with Prometheus collectors
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds the Prometheus collectors of the auth service
+type Metrics struct {
+	usecaseTotal    *prometheus.CounterVec
+	usecaseDuration *prometheus.HistogramVec
+	hashDuration    *prometheus.HistogramVec
+
+	publishDuration *prometheus.HistogramVec
+	publishFailures *prometheus.CounterVec
+	consumerLag     *prometheus.GaugeVec
+	handleDuration  *prometheus.HistogramVec
+	deadLetters     *prometheus.CounterVec
+}
+
+// New creates the collectors and registers them to reg
+func New(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		usecaseTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "auth",
+			Name:      "usecase_calls_total",
+			Help:      "Number of usecase calls by their outcome.",
+		}, []string{"usecase", "outcome"}),
+		usecaseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "auth",
+			Name:      "usecase_duration_seconds",
+			Help:      "Duration of usecase calls by their outcome.",
+			// password hashing dominates signup and login, so buckets go up to a few seconds
+			Buckets: []float64{.01, .05, .1, .25, .5, 1, 2, 4, 8},
+		}, []string{"usecase", "outcome"}),
+		hashDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "auth",
+			Name:      "password_hash_duration_seconds",
+			Help:      "Duration of hashing a password by the algorithm and the parameters of the hash.",
+			Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2, 4, 8},
+		}, []string{"algorithm", "params"}),
+
+		publishDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "event_streamer",
+			Name:      "publish_duration_seconds",
+			Help:      "Duration of publishing a message, including the failed attempts.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"topic"}),
+		publishFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "event_streamer",
+			Name:      "publish_failures_total",
+			Help:      "Number of messages that couldn't be published.",
+		}, []string{"topic"}),
+		consumerLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "event_streamer",
+			Name:      "consumer_lag",
+			Help:      "Number of messages the consumer group is behind the end of the partition.",
+		}, []string{"group", "topic", "partition"}),
+		handleDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "event_streamer",
+			Name:      "handler_duration_seconds",
+			Help:      "Duration of handling a consumed message.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"topic", "event_type"}),
+		deadLetters: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "event_streamer",
+			Name:      "dead_letters_total",
+			Help:      "Number of consumed messages dropped without handling.",
+		}, []string{"topic", "reason"}),
+	}
+	for _, c := range []prometheus.Collector{
+		m.usecaseTotal, m.usecaseDuration, m.hashDuration,
+		m.publishDuration, m.publishFailures, m.consumerLag, m.handleDuration, m.deadLetters,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Handler serves the metrics of the gatherer in Prometheus exposition format
+func Handler(g prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(g, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) ObserveUsecase(usecase string, outcome auth.Outcome, duration time.Duration) {
+	m.usecaseTotal.WithLabelValues(usecase, string(outcome)).Inc()
+	m.usecaseDuration.WithLabelValues(usecase, string(outcome)).Observe(duration.Seconds())
+}
+
+func (m *Metrics) ObservePasswordHashing(algorithm, params string, duration time.Duration) {
+	m.hashDuration.WithLabelValues(algorithm, params).Observe(duration.Seconds())
+}
+
+func (m *Metrics) ObservePublish(topic string, duration time.Duration, err error) {
+	m.publishDuration.WithLabelValues(topic).Observe(duration.Seconds())
+	if err != nil {
+		m.publishFailures.WithLabelValues(topic).Inc()
+	}
+}
+
+func (m *Metrics) ObserveHandle(topic, eventType string, duration time.Duration) {
+	m.handleDuration.WithLabelValues(topic, eventType).Observe(duration.Seconds())
+}
+
+func (m *Metrics) SetConsumerLag(group, topic string, partition int32, lag int64) {
+	m.consumerLag.WithLabelValues(group, topic, fmt.Sprint(partition)).Set(float64(lag))
+}
+
+func (m *Metrics) IncDeadLetter(topic, reason string) {
+	m.deadLetters.WithLabelValues(topic, reason).Inc()
+}
@@ -0,0 +1,74 @@
+package metrics_test
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/metrics"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, reg *prometheus.Registry) string {
+	rec := httptest.NewRecorder()
+	metrics.Handler(reg).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	return rec.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	t.Run(`Usecases report their outcomes and the password hashing`, func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		m, err := metrics.New(reg)
+		require.Nil(t, err)
+		uc := auth.NewUsecases(test_helpers.NewInMemoryStorage(), inmemory.New())
+		uc.Metrics = m
+
+		user := test_helpers.HopefullyUniqueUser()
+		_, err = uc.SignUpUser(context.Background(), user)
+		require.Nil(t, err)
+		_, err = uc.SignUpUser(context.Background(), user)
+		require.ErrorIs(t, err, auth.ErrUserAlreadyExists)
+		_, err = uc.Login(context.Background(), "username-that-doesnt-exist", "pwd")
+		require.NotNil(t, err)
+
+		body := scrape(t, reg)
+		require.Contains(t, body, `auth_usecase_calls_total{outcome="success",usecase="signup"} 1`)
+		require.Contains(t, body, `auth_usecase_calls_total{outcome="conflict",usecase="signup"} 1`)
+		require.Contains(t, body, `auth_usecase_calls_total{outcome="invalid_credentials",usecase="login"} 1`)
+		require.Contains(t, body, `auth_usecase_duration_seconds_count{outcome="success",usecase="signup"} 1`)
+		require.Contains(t, body, `auth_password_hash_duration_seconds_count{algorithm="bcrypt",params="cost=14"} 2`)
+	})
+
+	t.Run(`Event streaming metrics are exposed`, func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		m, err := metrics.New(reg)
+		require.Nil(t, err)
+
+		m.ObservePublish("users", time.Millisecond, nil)
+		m.ObservePublish("users", time.Millisecond, io.EOF)
+		m.ObserveHandle("users", "Signup", time.Millisecond)
+		m.SetConsumerLag("search", "users", 2, 42)
+		m.IncDeadLetter("users", "decode")
+
+		body := scrape(t, reg)
+		require.Contains(t, body, `event_streamer_publish_duration_seconds_count{topic="users"} 2`)
+		require.Contains(t, body, `event_streamer_publish_failures_total{topic="users"} 1`)
+		require.Contains(t, body, `event_streamer_handler_duration_seconds_count{event_type="Signup",topic="users"} 1`)
+		require.Contains(t, body, `event_streamer_consumer_lag{group="search",partition="2",topic="users"} 42`)
+		require.Contains(t, body, `event_streamer_dead_letters_total{reason="decode",topic="users"} 1`)
+	})
+
+	t.Run(`New fails if the collectors are already registered`, func(t *testing.T) {
+		reg := prometheus.NewRegistry()
+		_, err := metrics.New(reg)
+		require.Nil(t, err)
+		_, err = metrics.New(reg)
+		require.NotNil(t, err)
+	})
+}
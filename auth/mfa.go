@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth/tracing"
+)
+
+// TOTP is the stored TOTP (RFC 6238) enrolment of a user
+type TOTP struct {
+	UserID int
+	// EncryptedSecret is the secret sealed with MFAOptions.EncryptionKey
+	EncryptedSecret []byte
+	// ConfirmedAt is zero until the user confirms the enrolment with a code, MFA is enabled after it
+	ConfirmedAt time.Time
+	// LastUsedStep is the time step of the last accepted code, codes of the same or earlier steps are rejected so they can't be replayed
+	LastUsedStep int64
+}
+
+// TOTPEnrollment is shown to the user once, when they enrol TOTP
+type TOTPEnrollment struct {
+	// Secret is base32 encoded, for the authenticator apps that can't scan URI
+	Secret string
+	// URI is the otpauth URI of the secret, usually shown as a QR code
+	URI string
+	// RecoveryCodes can be used once each instead of a TOTP code, e.g. when the user loses their device
+	RecoveryCodes []string
+}
+
+// MFAOptions configures the TOTP enrolments and the login challenges. Zero values are replaced with the defaults below
+type MFAOptions struct {
+	// EncryptionKey encrypts the TOTP secrets with AES-GCM, it must be 16, 24 or 32 bytes long. TOTP can't be enrolled if it is empty
+	EncryptionKey []byte
+	// Issuer is the name of the service shown in authenticator apps. Defaults to "Twitter"
+	Issuer string
+	// ChallengeTTL is how long the challenge returned by Login is valid. Defaults to 5 minutes
+	ChallengeTTL time.Duration
+}
+
+func (o MFAOptions) withDefaults() MFAOptions {
+	if o.Issuer == "" {
+		o.Issuer = "Twitter"
+	}
+	if o.ChallengeTTL == 0 {
+		o.ChallengeTTL = 5 * time.Minute
+	}
+	return o
+}
+
+func (o MFAOptions) aead() (cipher.AEAD, error) {
+	if len(o.EncryptionKey) == 0 {
+		return nil, errors.New("MFAOptions.EncryptionKey is not set")
+	}
+	block, err := aes.NewCipher(o.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt returns the nonce followed by the sealed secret
+func (o MFAOptions) encrypt(secret []byte) ([]byte, error) {
+	aead, err := o.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, secret, nil), nil
+}
+
+func (o MFAOptions) decrypt(encrypted []byte) ([]byte, error) {
+	aead, err := o.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(encrypted) < aead.NonceSize() {
+		return nil, errors.New("encrypted TOTP secret is too short")
+	}
+	nonce, sealed := encrypted[:aead.NonceSize()], encrypted[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, nil)
+}
+
+// MFARequiredError is returned by Login instead of a token when the user enabled MFA. It wraps ErrMFARequired.
+// The Challenge and a code of the user are exchanged for the token with CompleteMFA
+type MFARequiredError struct {
+	Challenge string
+}
+
+func (e *MFARequiredError) Error() string {
+	return ErrMFARequired.Error()
+}
+
+func (e *MFARequiredError) Unwrap() error {
+	return ErrMFARequired
+}
+
+// EnrollTOTP creates a new TOTP secret and recovery codes for the user. MFA is enabled after the enrolment is confirmed with ConfirmTOTP.
+// An unconfirmed enrolment is replaced, and ErrInvalidInput is returned if MFA is already enabled
+func (c Usecases) EnrollTOTP(ctx context.Context, userID int) (_ TOTPEnrollment, err error) {
+	defer c.observe("enroll_totp", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.EnrollTOTP")
+	defer tracing.EndSpan(span, &err)
+
+	user, err := c.Storage.FindUserByID(ctx, userID)
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	existing, err := c.Storage.FindTOTP(ctx, userID)
+	if err == nil && !existing.ConfirmedAt.IsZero() {
+		return TOTPEnrollment{}, fmt.Errorf("%w: MFA is already enabled", ErrInvalidInput)
+	}
+	if err != nil && !errors.Is(err, ErrMFANotEnrolled) {
+		return TOTPEnrollment{}, err
+	}
+
+	options := c.MFA.withDefaults()
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	encrypted, err := options.encrypt(secret)
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	err = c.Storage.SaveTOTP(ctx, TOTP{UserID: userID, EncryptedSecret: encrypted}, hashes)
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	return TOTPEnrollment{
+		Secret:        totpSecretEncoding.EncodeToString(secret),
+		URI:           totpURI(options.Issuer, user.Username, secret),
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// ConfirmTOTP enables MFA if the code is generated from the enrolled secret, which proves the authenticator app is set up.
+// It returns ErrMFANotEnrolled if the user didn't enrol, and ErrInvalidCredentials if the code is wrong
+func (c Usecases) ConfirmTOTP(ctx context.Context, userID int, code string) (err error) {
+	defer c.observe("confirm_totp", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.ConfirmTOTP")
+	defer tracing.EndSpan(span, &err)
+
+	totp, err := c.Storage.FindTOTP(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !totp.ConfirmedAt.IsZero() {
+		return fmt.Errorf("%w: MFA is already enabled", ErrInvalidInput)
+	}
+	// recovery codes are not accepted, the point is to check the authenticator app
+	if !isTOTPCode(code) {
+		return ErrInvalidCredentials
+	}
+	if err := c.verifyMFACode(ctx, totp, code); err != nil {
+		return err
+	}
+	return c.Storage.ConfirmTOTP(ctx, userID, c.now())
+}
+
+// DisableTOTP disables MFA and removes the secret and the recovery codes of the user.
+// The code can be a TOTP or a recovery code, so a stolen session alone is not enough to disable MFA
+func (c Usecases) DisableTOTP(ctx context.Context, userID int, code string) (err error) {
+	defer c.observe("disable_totp", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.DisableTOTP")
+	defer tracing.EndSpan(span, &err)
+
+	totp, err := c.mfaEnabled(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := c.verifyMFACode(ctx, totp, code); err != nil {
+		return err
+	}
+	return c.Storage.DeleteTOTP(ctx, userID)
+}
+
+// CompleteMFA exchanges the challenge returned by Login and a TOTP or recovery code for the token of the user.
+// The challenge can be used only once, Login has to be repeated after a wrong code.
+// It returns ErrInvalidToken for invalid challenges and ErrInvalidCredentials for wrong codes
+func (c Usecases) CompleteMFA(ctx context.Context, challenge, code string) (token string, err error) {
+	defer c.observe("complete_mfa", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.CompleteMFA")
+	defer tracing.EndSpan(span, &err)
+
+	t, err := c.Storage.ConsumeOneTimeToken(ctx, TokenPurposeMFAChallenge, hashOneTimeToken(challenge), c.now())
+	if err != nil {
+		return "", err
+	}
+	user, err := c.Storage.FindUserByID(ctx, t.UserID)
+	if errors.Is(err, ErrUserNotFound) {
+		return "", fmt.Errorf("%w: user doesn't exist", ErrInvalidToken)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer func() { c.recordLoginEvent(ctx, user.ID, err) }()
+	totp, err := c.mfaEnabled(ctx, user.ID)
+	if errors.Is(err, ErrMFANotEnrolled) {
+		// MFA is disabled since the challenge was issued
+		return "", fmt.Errorf("%w: MFA is disabled", ErrInvalidToken)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := c.verifyMFACode(ctx, totp, code); err != nil {
+		return "", err
+	}
+	return c.issueToken(ctx, user)
+}
+
+// mfaEnabled returns the confirmed TOTP of the user, ErrMFANotEnrolled if there isn't one
+func (c Usecases) mfaEnabled(ctx context.Context, userID int) (TOTP, error) {
+	totp, err := c.Storage.FindTOTP(ctx, userID)
+	if err != nil {
+		return TOTP{}, err
+	}
+	if totp.ConfirmedAt.IsZero() {
+		return TOTP{}, ErrMFANotEnrolled
+	}
+	return totp, nil
+}
+
+// mfaChallenge returns the MFARequiredError of a user who passed the password step of Login
+func (c Usecases) mfaChallenge(ctx context.Context, userID int) error {
+	challenge, t, err := newOneTimeToken(TokenPurposeMFAChallenge, userID, "", c.now().Add(c.MFA.withDefaults().ChallengeTTL))
+	if err != nil {
+		return err
+	}
+	if err := c.Storage.CreateOneTimeToken(ctx, t); err != nil {
+		return err
+	}
+	return &MFARequiredError{Challenge: challenge}
+}
+
+// verifyMFACode accepts a TOTP code of the current time steps or an unused recovery code, and uses it up.
+// Codes are throttled per user like the passwords, since 6 digits are easy to guess otherwise
+func (c Usecases) verifyMFACode(ctx context.Context, totp TOTP, code string) error {
+	keys := []throttleKey{{
+		key:         "mfa:" + strconv.Itoa(totp.UserID),
+		maxFailures: c.LoginThrottling.withDefaults().MaxAccountFailures,
+		account:     true,
+	}}
+	if err := c.checkLoginThrottling(ctx, keys); err != nil {
+		return err
+	}
+	err := c.useMFACode(ctx, totp, code)
+	if errors.Is(err, ErrInvalidCredentials) {
+		if err := c.recordLoginFailure(ctx, keys, User{ID: totp.UserID}); err != nil {
+			return err
+		}
+		return ErrInvalidCredentials
+	}
+	if err != nil {
+		return err
+	}
+	return c.resetLoginThrottling(ctx, keys)
+}
+
+func (c Usecases) useMFACode(ctx context.Context, totp TOTP, code string) error {
+	if !isTOTPCode(code) {
+		return c.Storage.UseRecoveryCode(ctx, totp.UserID, hashRecoveryCode(code), c.now())
+	}
+	secret, err := c.MFA.decrypt(totp.EncryptedSecret)
+	if err != nil {
+		return err
+	}
+	step, ok := matchTOTPStep(secret, code, c.now())
+	if !ok {
+		return ErrInvalidCredentials
+	}
+	return c.Storage.UseTOTPStep(ctx, totp.UserID, step)
+}
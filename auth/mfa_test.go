@@ -0,0 +1,147 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/limiter"
+	"github.com/davudsafarli/twitter/auth/mailer"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMFA(t *testing.T) {
+	setup := func(t *testing.T) (auth.Usecases, *fakeClock, auth.User) {
+		clock := &fakeClock{now: time.Date(2021, 7, 19, 13, 11, 26, 0, time.UTC)}
+		uc := test_helpers.NewUsecases(inmemory.New(), mailer.NewInMemory())
+		uc.Now = clock.Now
+		uc.MFA = auth.MFAOptions{EncryptionKey: []byte("0123456789abcdef0123456789abcdef")}
+		user := test_helpers.HopefullyUniqueUser()
+		created, err := uc.SignUpUser(context.Background(), user)
+		require.Nil(t, err)
+		created.Password = user.Password
+		return uc, clock, created
+	}
+	// enable enrols and confirms TOTP, and moves the clock to the next step so the code of the confirmation can't be reused
+	enable := func(t *testing.T, uc auth.Usecases, clock *fakeClock, user auth.User) auth.TOTPEnrollment {
+		enrollment, err := uc.EnrollTOTP(context.Background(), user.ID)
+		require.Nil(t, err)
+		require.Nil(t, uc.ConfirmTOTP(context.Background(), user.ID, test_helpers.TOTPCode(enrollment.Secret, clock.Now())))
+		clock.Advance(30 * time.Second)
+		return enrollment
+	}
+	challenge := func(t *testing.T, uc auth.Usecases, user auth.User) string {
+		token, err := uc.Login(context.Background(), user.Username, user.Password)
+		require.Empty(t, token)
+		var mfaRequired *auth.MFARequiredError
+		require.True(t, errors.As(err, &mfaRequired), "expected MFARequiredError, got %v", err)
+		require.ErrorIs(t, err, auth.ErrMFARequired)
+		require.NotEmpty(t, mfaRequired.Challenge)
+		return mfaRequired.Challenge
+	}
+
+	t.Run(`#EnrollTOTP returns the secret, its otpauth URI and recovery codes`, func(t *testing.T) {
+		uc, _, user := setup(t)
+		enrollment, err := uc.EnrollTOTP(context.Background(), user.ID)
+		require.Nil(t, err)
+		require.Contains(t, enrollment.URI, "otpauth://totp/Twitter:"+user.Username+"?")
+		require.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+		require.Len(t, enrollment.RecoveryCodes, 10)
+	})
+
+	t.Run(`#EnrollTOTP fails without an encryption key`, func(t *testing.T) {
+		uc, _, user := setup(t)
+		uc.MFA = auth.MFAOptions{}
+		_, err := uc.EnrollTOTP(context.Background(), user.ID)
+		require.NotNil(t, err)
+	})
+
+	t.Run(`#Login issues the token until the enrolment is confirmed`, func(t *testing.T) {
+		uc, clock, user := setup(t)
+		enrollment, err := uc.EnrollTOTP(context.Background(), user.ID)
+		require.Nil(t, err)
+		token, err := uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+		require.NotEmpty(t, token)
+
+		require.ErrorIs(t, uc.ConfirmTOTP(context.Background(), user.ID, "000000"), auth.ErrInvalidCredentials)
+		require.ErrorIs(t, uc.ConfirmTOTP(context.Background(), user.ID, enrollment.RecoveryCodes[0]), auth.ErrInvalidCredentials,
+			"recovery codes should not confirm the enrolment")
+		require.Nil(t, uc.ConfirmTOTP(context.Background(), user.ID, test_helpers.TOTPCode(enrollment.Secret, clock.Now())))
+		challenge(t, uc, user)
+
+		_, err = uc.EnrollTOTP(context.Background(), user.ID)
+		require.ErrorIs(t, err, auth.ErrInvalidInput, "enabled MFA should not be replaced")
+	})
+
+	t.Run(`#CompleteMFA issues the token for a TOTP code, once per challenge and step`, func(t *testing.T) {
+		uc, clock, user := setup(t)
+		enrollment := enable(t, uc, clock, user)
+
+		c := challenge(t, uc, user)
+		code := test_helpers.TOTPCode(enrollment.Secret, clock.Now())
+		token, err := uc.CompleteMFA(context.Background(), c, code)
+		require.Nil(t, err)
+		claims, err := uc.VerifyToken(context.Background(), token)
+		require.Nil(t, err)
+		require.Equal(t, user.ID, claims.UserID)
+
+		_, err = uc.CompleteMFA(context.Background(), c, code)
+		require.ErrorIs(t, err, auth.ErrInvalidToken, "challenge should be used once")
+		_, err = uc.CompleteMFA(context.Background(), challenge(t, uc, user), code)
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials, "code should not be replayed")
+
+		clock.Advance(30 * time.Second)
+		_, err = uc.CompleteMFA(context.Background(), challenge(t, uc, user), test_helpers.TOTPCode(enrollment.Secret, clock.Now().Add(-30*time.Second)))
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials, "code of a used step should be rejected even if it is within the skew")
+		_, err = uc.CompleteMFA(context.Background(), challenge(t, uc, user), test_helpers.TOTPCode(enrollment.Secret, clock.Now().Add(30*time.Second)))
+		require.Nil(t, err, "code of the next step should be accepted for clock drift")
+	})
+
+	t.Run(`#CompleteMFA accepts each recovery code once`, func(t *testing.T) {
+		uc, clock, user := setup(t)
+		enrollment := enable(t, uc, clock, user)
+
+		_, err := uc.CompleteMFA(context.Background(), challenge(t, uc, user), enrollment.RecoveryCodes[0])
+		require.Nil(t, err)
+		_, err = uc.CompleteMFA(context.Background(), challenge(t, uc, user), enrollment.RecoveryCodes[0])
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	})
+
+	t.Run(`#CompleteMFA rejects expired challenges`, func(t *testing.T) {
+		uc, clock, user := setup(t)
+		enrollment := enable(t, uc, clock, user)
+
+		c := challenge(t, uc, user)
+		clock.Advance(5 * time.Minute)
+		_, err := uc.CompleteMFA(context.Background(), c, test_helpers.TOTPCode(enrollment.Secret, clock.Now()))
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+
+	t.Run(`wrong codes are throttled`, func(t *testing.T) {
+		uc, clock, user := setup(t)
+		uc.LoginThrottling = auth.LoginThrottling{Store: limiter.NewMemory(), MaxAccountFailures: 2}
+		enrollment := enable(t, uc, clock, user)
+
+		_, err := uc.CompleteMFA(context.Background(), challenge(t, uc, user), "000000")
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+		_, err = uc.CompleteMFA(context.Background(), challenge(t, uc, user), test_helpers.TOTPCode(enrollment.Secret, clock.Now()))
+		require.ErrorIs(t, err, auth.ErrTooManyAttempts, "a correct password should not reset the failures of the codes")
+	})
+
+	t.Run(`#DisableTOTP requires a code and disables MFA`, func(t *testing.T) {
+		uc, clock, user := setup(t)
+		enrollment := enable(t, uc, clock, user)
+
+		require.ErrorIs(t, uc.DisableTOTP(context.Background(), user.ID, "000000"), auth.ErrInvalidCredentials)
+		require.Nil(t, uc.DisableTOTP(context.Background(), user.ID, test_helpers.TOTPCode(enrollment.Secret, clock.Now())))
+		token, err := uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+		require.NotEmpty(t, token)
+		require.ErrorIs(t, uc.DisableTOTP(context.Background(), user.ID, enrollment.RecoveryCodes[0]), auth.ErrMFANotEnrolled)
+	})
+}
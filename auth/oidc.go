@@ -0,0 +1,422 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth/tracing"
+)
+
+// OIDCProvider is an OpenID Connect provider users can sign in with, e.g. Google
+type OIDCProvider struct {
+	// Name identifies the provider in the requests and the identities, e.g. "google"
+	Name string
+	// Issuer is the issuer URL of the provider, its endpoints are discovered from Issuer/.well-known/openid-configuration
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback registered at the provider, it passes the state and the code to CompleteOIDC
+	RedirectURL string
+	// Scopes are requested in addition to openid. Defaults to email and profile
+	Scopes []string
+}
+
+// OIDCOptions configures the sign in with OIDC providers. Zero values are replaced with the defaults below
+type OIDCOptions struct {
+	Providers []OIDCProvider
+	// HTTPClient calls the providers. Defaults to a client with a 10 seconds timeout
+	HTTPClient *http.Client
+	// StateTTL is how long users have to sign in at the provider. Defaults to 10 minutes
+	StateTTL time.Duration
+}
+
+func (o OIDCOptions) withDefaults() OIDCOptions {
+	if o.HTTPClient == nil {
+		o.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if o.StateTTL == 0 {
+		o.StateTTL = 10 * time.Minute
+	}
+	return o
+}
+
+// provider returns the provider with the name, ErrInvalidInput if it isn't configured
+func (o OIDCOptions) provider(name string) (OIDCProvider, error) {
+	for _, p := range o.Providers {
+		if p.Name == name {
+			if len(p.Scopes) == 0 {
+				p.Scopes = []string{"email", "profile"}
+			}
+			return p, nil
+		}
+	}
+	return OIDCProvider{}, fmt.Errorf("%w: unknown provider %q", ErrInvalidInput, name)
+}
+
+// OIDCState is the server side of a flow started by StartOIDCLogin or StartOIDCLink, kept until its callback
+type OIDCState struct {
+	// Hash is the hex encoded sha256 of the state parameter
+	Hash     string
+	Provider string
+	// Verifier is the PKCE code verifier (RFC 7636), the browser only sees its challenge
+	Verifier string
+	Nonce    string
+	// UserID is the user linking the provider, zero for logins
+	UserID    int
+	ExpiresAt time.Time
+}
+
+// Identity links the subject of a user at an OIDC provider to the user. A user has at most one identity per provider
+type Identity struct {
+	UserID   int
+	Provider string
+	Subject  string
+	// Email is the email of the user at the provider when the identity was linked
+	Email     string
+	CreatedAt time.Time
+}
+
+// OIDCResult is the outcome of CompleteOIDC
+type OIDCResult struct {
+	// Token is the token of the user for logins, see Login
+	Token string
+	// Created is true if the login provisioned a new user
+	Created bool
+	// Linked is true if the flow was started by StartOIDCLink, no token is issued then
+	Linked bool
+}
+
+// OIDCFlow is a flow started by StartOIDCLogin or StartOIDCLink
+type OIDCFlow struct {
+	// AuthorizationURL is where the browser should be sent to sign in at the provider
+	AuthorizationURL string
+	// Binding ties the flow to the browser that started it, against login CSRF (RFC 6749 section 10.12).
+	// It should be kept in an HttpOnly cookie until ExpiresAt, and passed to CompleteOIDC with the callback
+	Binding   string
+	ExpiresAt time.Time
+}
+
+// OIDCCallback is what the provider redirected the browser back with, and what proves who started the flow
+type OIDCCallback struct {
+	State string
+	Code  string
+	// Binding is the OIDCFlow.Binding kept by the browser
+	Binding string
+	// UserID is the user the callback request is authenticated as, zero if it isn't. Links require it
+	UserID int
+}
+
+// StartOIDCLogin starts the sign in with the provider, the user should be redirected to the AuthorizationURL of the flow.
+// The provider redirects the user back to its RedirectURL, which should call CompleteOIDC
+func (c Usecases) StartOIDCLogin(ctx context.Context, provider string) (_ OIDCFlow, err error) {
+	defer c.observe("start_oidc_login", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.StartOIDCLogin")
+	defer tracing.EndSpan(span, &err)
+	return c.startOIDC(ctx, provider, 0)
+}
+
+// StartOIDCLink starts linking the provider to the account of the user, like StartOIDCLogin.
+// The callback of the flow must be authenticated as the user as well
+func (c Usecases) StartOIDCLink(ctx context.Context, userID int, provider string) (_ OIDCFlow, err error) {
+	defer c.observe("start_oidc_link", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.StartOIDCLink")
+	defer tracing.EndSpan(span, &err)
+
+	identities, err := c.Storage.ListIdentities(ctx, userID)
+	if err != nil {
+		return OIDCFlow{}, err
+	}
+	for _, identity := range identities {
+		if identity.Provider == provider {
+			return OIDCFlow{}, fmt.Errorf("%w: %s is already linked", ErrUserAlreadyExists, provider)
+		}
+	}
+	return c.startOIDC(ctx, provider, userID)
+}
+
+func (c Usecases) startOIDC(ctx context.Context, providerName string, userID int) (OIDCFlow, error) {
+	p, err := c.OIDC.provider(providerName)
+	if err != nil {
+		return OIDCFlow{}, err
+	}
+	config, err := c.discoverOIDC(ctx, p)
+	if err != nil {
+		return OIDCFlow{}, err
+	}
+	state, err := randomURLSafe()
+	if err != nil {
+		return OIDCFlow{}, err
+	}
+	verifier, err := randomURLSafe()
+	if err != nil {
+		return OIDCFlow{}, err
+	}
+	nonce, err := randomURLSafe()
+	if err != nil {
+		return OIDCFlow{}, err
+	}
+	flow := OIDCFlow{
+		Binding:   hashOneTimeToken(state),
+		ExpiresAt: c.now().Add(c.OIDC.withDefaults().StateTTL),
+	}
+	err = c.Storage.CreateOIDCState(ctx, OIDCState{
+		Hash:      flow.Binding,
+		Provider:  p.Name,
+		Verifier:  verifier,
+		Nonce:     nonce,
+		UserID:    userID,
+		ExpiresAt: flow.ExpiresAt,
+	})
+	if err != nil {
+		return OIDCFlow{}, err
+	}
+
+	authURL, err := url.Parse(config.AuthorizationEndpoint)
+	if err != nil {
+		return OIDCFlow{}, fmt.Errorf("invalid authorization endpoint of %s: %w", p.Name, err)
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(append([]string{"openid"}, p.Scopes...), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+	flow.AuthorizationURL = authURL.String()
+	return flow, nil
+}
+
+// CompleteOIDC exchanges the state and the code the provider redirected the user back with.
+// For logins, it returns the token of the user whose identity it is, or provisions a new user on their first login.
+// A new user gets the email and the username of the provider, with a random suffix if the username is taken.
+// If the email belongs to another user, it returns ErrUserAlreadyExists, the user should log in and link the provider instead.
+// Like Login, it returns a MFARequiredError if the user enabled MFA.
+// For links, it links the identity to the user who started the flow, the callback must be authenticated as them.
+// It returns ErrInvalidToken if the state is unknown or expired, or the callback doesn't come from the browser
+// (Binding) or the user (UserID) that started the flow
+func (c Usecases) CompleteOIDC(ctx context.Context, callback OIDCCallback) (_ OIDCResult, err error) {
+	defer c.observe("complete_oidc", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.CompleteOIDC")
+	defer tracing.EndSpan(span, &err)
+
+	hash := hashOneTimeToken(callback.State)
+	// otherwise an attacker could send their own callback to a victim, signing them in as the attacker
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(callback.Binding)) != 1 {
+		return OIDCResult{}, fmt.Errorf("%w: the flow was started by another browser", ErrInvalidToken)
+	}
+	s, err := c.Storage.ConsumeOIDCState(ctx, hash, c.now())
+	if err != nil {
+		return OIDCResult{}, err
+	}
+	if s.UserID != 0 && s.UserID != callback.UserID {
+		return OIDCResult{}, fmt.Errorf("%w: the link was started by another user", ErrInvalidToken)
+	}
+	p, err := c.OIDC.provider(s.Provider)
+	if err != nil {
+		return OIDCResult{}, fmt.Errorf("%w: provider %q is removed", ErrInvalidToken, s.Provider)
+	}
+	claims, err := c.exchangeOIDCCode(ctx, p, callback.Code, s)
+	if err != nil {
+		return OIDCResult{}, err
+	}
+	if s.UserID != 0 {
+		err := c.Storage.CreateIdentity(ctx, Identity{
+			UserID:    s.UserID,
+			Provider:  p.Name,
+			Subject:   claims.Subject,
+			Email:     claims.Email,
+			CreatedAt: c.now(),
+		})
+		if errors.Is(err, ErrUserAlreadyExists) {
+			return OIDCResult{}, fmt.Errorf("%w: the %s account is linked to a user already", ErrUserAlreadyExists, p.Name)
+		}
+		return OIDCResult{Linked: true}, err
+	}
+
+	result := OIDCResult{}
+	var user User
+	defer func() { c.recordLoginEvent(ctx, user.ID, err) }()
+	identity, err := c.Storage.FindIdentity(ctx, p.Name, claims.Subject)
+	switch {
+	case errors.Is(err, ErrIdentityNotFound):
+		user, err = c.provisionOIDCUser(ctx, p, claims)
+		if err != nil {
+			return OIDCResult{}, err
+		}
+		result.Created = true
+	case err != nil:
+		return OIDCResult{}, err
+	default:
+		if user, err = c.Storage.FindUserByID(ctx, identity.UserID); err != nil {
+			return OIDCResult{}, err
+		}
+	}
+	if !user.SuspendedAt.IsZero() {
+		return OIDCResult{}, ErrAccountSuspended
+	}
+	if !user.DeactivatedAt.IsZero() {
+		return OIDCResult{}, ErrAccountDeactivated
+	}
+	if c.EmailVerification == EmailVerificationRequired && user.EmailVerifiedAt.IsZero() {
+		return OIDCResult{}, ErrEmailNotVerified
+	}
+	_, err = c.mfaEnabled(ctx, user.ID)
+	if err == nil {
+		return OIDCResult{}, c.mfaChallenge(ctx, user.ID)
+	}
+	if !errors.Is(err, ErrMFANotEnrolled) {
+		return OIDCResult{}, err
+	}
+	result.Token, err = c.issueToken(ctx, user)
+	return result, err
+}
+
+// maxUsernameAttempts limits the random suffixes tried when the username of a provisioned user is taken
+const maxUsernameAttempts = 5
+
+// provisionOIDCUser creates the user of a first login and their identity, and publishes a SignupEvent like SignUpUser.
+// The user has no password, they can set one with RequestPasswordReset
+func (c Usecases) provisionOIDCUser(ctx context.Context, p OIDCProvider, claims oidcClaims) (User, error) {
+	if claims.Email == "" {
+		return User{}, fmt.Errorf("%w: %s didn't share the email of the user", ErrInvalidInput, p.Name)
+	}
+	_, err := c.Storage.FindUserByEmail(ctx, claims.Email)
+	if err == nil {
+		return User{}, fmt.Errorf("%w: the email is used by an account, log in and link %s to it", ErrUserAlreadyExists, p.Name)
+	}
+	if !errors.Is(err, ErrUserNotFound) {
+		return User{}, err
+	}
+
+	base := usernameOf(claims)
+	var user User
+	for attempt := 0; ; attempt++ {
+		username := base
+		if attempt > 0 {
+			suffix, err := randomUsernameSuffix()
+			if err != nil {
+				return User{}, err
+			}
+			username += suffix
+		}
+		user, err = c.Storage.CreateUser(ctx, User{Email: claims.Email, Username: username, DisplayName: claims.Name})
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrUserAlreadyExists) || attempt == maxUsernameAttempts {
+			return User{}, err
+		}
+	}
+	if claims.EmailVerified {
+		now := c.now()
+		if err := c.Storage.SetEmailVerified(ctx, user.ID, user.Email, now); err != nil {
+			return User{}, err
+		}
+		user.EmailVerifiedAt = now
+	}
+	err = c.Storage.CreateIdentity(ctx, Identity{
+		UserID:    user.ID,
+		Provider:  p.Name,
+		Subject:   claims.Subject,
+		Email:     claims.Email,
+		CreatedAt: c.now(),
+	})
+	if err != nil {
+		// a concurrent first login of the same subject provisioned another user, this one would have no way to log in
+		if err := c.Storage.DeleteUser(ctx, user.ID); err != nil {
+			return User{}, err
+		}
+		return User{}, err
+	}
+	if err := c.events().PublishUserSignupEvent(ctx, signupEventOf(user)); err != nil {
+		return User{}, err
+	}
+	if user.EmailVerifiedAt.IsZero() {
+		c.sendSignupVerificationEmail(ctx, user)
+	}
+	return user, nil
+}
+
+// maxProvisionedUsernameLength leaves room for the suffix in the 50 characters of usernames
+const maxProvisionedUsernameLength = 40
+
+// usernameOf derives a username from the preferred username or the email of the user at the provider
+func usernameOf(claims oidcClaims) string {
+	candidate := claims.PreferredUsername
+	if candidate == "" {
+		candidate = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+	var b strings.Builder
+	for _, r := range candidate {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '.' || r == '-' {
+			b.WriteRune(r)
+		}
+	}
+	username := b.String()
+	if username == "" {
+		username = "user"
+	}
+	if len(username) > maxProvisionedUsernameLength {
+		username = username[:maxProvisionedUsernameLength]
+	}
+	return username
+}
+
+func randomUsernameSuffix() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("_%05d", binary.BigEndian.Uint32(b)%100000), nil
+}
+
+func randomURLSafe() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ListIdentities returns the providers linked to the account of the user
+func (c Usecases) ListIdentities(ctx context.Context, userID int) (_ []Identity, err error) {
+	defer c.observe("list_identities", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.ListIdentities")
+	defer tracing.EndSpan(span, &err)
+	return c.Storage.ListIdentities(ctx, userID)
+}
+
+// UnlinkIdentity removes the identity of the provider from the account of the user. It returns ErrIdentityNotFound if there is none.
+// The last identity of a user without a password can't be unlinked, they couldn't log in anymore
+func (c Usecases) UnlinkIdentity(ctx context.Context, userID int, provider string) (err error) {
+	defer c.observe("unlink_identity", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.UnlinkIdentity")
+	defer tracing.EndSpan(span, &err)
+
+	user, err := c.Storage.FindUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	identities, err := c.Storage.ListIdentities(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.Password == "" && len(identities) == 1 && identities[0].Provider == provider {
+		return fmt.Errorf("%w: set a password before unlinking the last provider", ErrInvalidInput)
+	}
+	return c.Storage.DeleteIdentity(ctx, userID, provider)
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/davudsafarli/twitter/auth/tracing"
+	"github.com/golang-jwt/jwt"
+)
+
+// oidcConfiguration is the part of the discovery document (OpenID Connect Discovery 1.0) the flows need
+type oidcConfiguration struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcClaims are the claims of a verified ID token
+type oidcClaims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+}
+
+// maxOIDCResponseSize limits the responses read from the providers
+const maxOIDCResponseSize = 1 << 20
+
+// discoverOIDC fetches the configuration of the provider. It isn't cached, logins are rare enough
+func (c Usecases) discoverOIDC(ctx context.Context, p OIDCProvider) (_ oidcConfiguration, err error) {
+	ctx, span := c.tracer().Start(ctx, "OIDCProvider.Discover")
+	defer tracing.EndSpan(span, &err)
+
+	config := oidcConfiguration{}
+	if err := c.getOIDCJSON(ctx, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", &config); err != nil {
+		return oidcConfiguration{}, err
+	}
+	// a provider can only speak for its own issuer
+	if config.Issuer != p.Issuer {
+		return oidcConfiguration{}, fmt.Errorf("issuer of %s is %q, not %q", p.Name, config.Issuer, p.Issuer)
+	}
+	return config, nil
+}
+
+// exchangeOIDCCode redeems the code with the PKCE verifier of the state, and returns the claims of the ID token.
+// It returns ErrInvalidToken if the provider rejects the code, or the ID token is invalid
+func (c Usecases) exchangeOIDCCode(ctx context.Context, p OIDCProvider, code string, state OIDCState) (_ oidcClaims, err error) {
+	config, err := c.discoverOIDC(ctx, p)
+	if err != nil {
+		return oidcClaims{}, err
+	}
+	ctx, span := c.tracer().Start(ctx, "OIDCProvider.ExchangeCode")
+	defer tracing.EndSpan(span, &err)
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {state.Verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oidcClaims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// client_secret_basic, the credentials are form encoded first (RFC 6749 2.3.1)
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	res, err := c.OIDC.withDefaults().HTTPClient.Do(req)
+	if err != nil {
+		return oidcClaims{}, err
+	}
+	defer res.Body.Close()
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxOIDCResponseSize)).Decode(&body); err != nil {
+		return oidcClaims{}, fmt.Errorf("invalid token response of %s: %w", p.Name, err)
+	}
+	if res.StatusCode == http.StatusBadRequest || res.StatusCode == http.StatusUnauthorized {
+		return oidcClaims{}, fmt.Errorf("%w: %s rejected the code: %s %s", ErrInvalidToken, p.Name, body.Error, body.ErrorDescription)
+	}
+	if res.StatusCode != http.StatusOK {
+		return oidcClaims{}, fmt.Errorf("token endpoint of %s responded with %d", p.Name, res.StatusCode)
+	}
+	return c.verifyIDToken(ctx, p, config, body.IDToken, state.Nonce)
+}
+
+// verifyIDToken checks the signature of the ID token with the keys of the provider, and its issuer, audience, expiry and nonce.
+// Only RSA keys are supported, they are the ones every provider has
+func (c Usecases) verifyIDToken(ctx context.Context, p OIDCProvider, config oidcConfiguration, idToken, nonce string) (oidcClaims, error) {
+	var keys JSONWebKeySet
+	if err := c.getOIDCJSON(ctx, config.JWKSURI, &keys); err != nil {
+		return oidcClaims{}, err
+	}
+	// the expiry is checked below with c.now(), the parser would use the wall clock
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	parsed, err := parser.Parse(idToken, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		kid, _ := t.Header["kid"].(string)
+		for _, k := range keys.Keys {
+			if k.KeyType == "RSA" && (k.KeyID == kid || kid == "" && len(keys.Keys) == 1) {
+				return rsaPublicKeyOf(k)
+			}
+		}
+		return nil, fmt.Errorf("unknown key %q", kid)
+	})
+	if err != nil {
+		return oidcClaims{}, fmt.Errorf("%w: invalid ID token of %s: %v", ErrInvalidToken, p.Name, err)
+	}
+	claims, _ := parsed.Claims.(jwt.MapClaims)
+	if iss, _ := claims["iss"].(string); iss != config.Issuer {
+		return oidcClaims{}, fmt.Errorf("%w: ID token is issued by %q", ErrInvalidToken, iss)
+	}
+	if !claims.VerifyAudience(p.ClientID, true) {
+		return oidcClaims{}, fmt.Errorf("%w: ID token is not issued to the client", ErrInvalidToken)
+	}
+	if !claims.VerifyExpiresAt(c.now().Unix(), true) {
+		return oidcClaims{}, fmt.Errorf("%w: ID token is expired", ErrInvalidToken)
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return oidcClaims{}, fmt.Errorf("%w: nonce of the ID token doesn't match", ErrInvalidToken)
+	}
+	result := oidcClaims{}
+	result.Subject, _ = claims["sub"].(string)
+	if result.Subject == "" {
+		return oidcClaims{}, fmt.Errorf("%w: ID token has no subject", ErrInvalidToken)
+	}
+	result.Email, _ = claims["email"].(string)
+	result.EmailVerified, _ = claims["email_verified"].(bool)
+	result.PreferredUsername, _ = claims["preferred_username"].(string)
+	result.Name, _ = claims["name"].(string)
+	return result, nil
+}
+
+func rsaPublicKeyOf(k JSONWebKey) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
+
+func (c Usecases) getOIDCJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := c.OIDC.withDefaults().HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s responded with %d", url, res.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, maxOIDCResponseSize)).Decode(v)
+}
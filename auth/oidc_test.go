@@ -0,0 +1,173 @@
+package auth_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/logging"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDC(t *testing.T) {
+	provider := test_helpers.NewFakeOIDCProvider(t)
+	setup := func(t *testing.T) (auth.Usecases, *inmemory.EventStreamer) {
+		events := inmemory.New()
+		uc := test_helpers.NewUsecases(events, nil)
+		uc.Now = (&fakeClock{now: time.Date(2021, 7, 19, 13, 11, 26, 0, time.UTC)}).Now
+		uc.OIDC = auth.OIDCOptions{Providers: []auth.OIDCProvider{provider.Provider("fake")}}
+		return uc, events
+	}
+	signIn := func(t *testing.T, uc auth.Usecases, user test_helpers.FakeOIDCUser) (auth.OIDCResult, error) {
+		flow, err := uc.StartOIDCLogin(context.Background(), "fake")
+		require.Nil(t, err)
+		state, code := provider.Authorize(t, flow.AuthorizationURL, user)
+		return uc.CompleteOIDC(context.Background(), auth.OIDCCallback{State: state, Code: code, Binding: flow.Binding})
+	}
+	alice := test_helpers.FakeOIDCUser{Subject: "alice-subject", Email: "alice@example.com", EmailVerified: true, PreferredUsername: "alice", Name: "Alice"}
+
+	t.Run(`the first login provisions a user and publishes a SignupEvent, the next ones log into it`, func(t *testing.T) {
+		uc, events := setup(t)
+		result, err := signIn(t, uc, alice)
+		require.Nil(t, err)
+		require.True(t, result.Created)
+		claims, err := uc.VerifyToken(context.Background(), result.Token)
+		require.Nil(t, err)
+		require.True(t, claims.EmailVerified, "the provider verified the email")
+
+		user, err := uc.Storage.FindUserByID(context.Background(), claims.UserID)
+		require.Nil(t, err)
+		require.Equal(t, "alice", user.Username)
+		require.Equal(t, "alice@example.com", user.Email)
+		require.Equal(t, "Alice", user.DisplayName)
+		published := events.Published()
+		signup, ok := published[len(published)-1].(auth.SignupEvent)
+		require.True(t, ok, "a SignupEvent should be published")
+		require.Equal(t, user.ID, signup.ID)
+		require.Equal(t, user.Username, signup.Username)
+
+		result, err = signIn(t, uc, alice)
+		require.Nil(t, err)
+		require.False(t, result.Created)
+		claims, err = uc.VerifyToken(context.Background(), result.Token)
+		require.Nil(t, err)
+		require.Equal(t, user.ID, claims.UserID)
+		identities, err := uc.ListIdentities(context.Background(), user.ID)
+		require.Nil(t, err)
+		require.Len(t, identities, 1)
+		require.Equal(t, "alice-subject", identities[0].Subject)
+	})
+
+	t.Run(`the first login with an unverified email succeeds even if the verification email can't be sent`, func(t *testing.T) {
+		uc, _ := setup(t)
+		uc.Mailer = failingMailer{}
+		uc.Logger = logging.Nop()
+		unverified := test_helpers.FakeOIDCUser{Subject: "carol-subject", Email: "carol@example.com", PreferredUsername: "carol"}
+		result, err := signIn(t, uc, unverified)
+		require.Nil(t, err)
+		require.True(t, result.Created)
+		require.NotEmpty(t, result.Token)
+	})
+
+	t.Run(`a taken username gets a suffix, and a taken email needs linking`, func(t *testing.T) {
+		uc, _ := setup(t)
+		_, err := uc.SignUpUser(context.Background(), auth.User{Email: "other@example.com", Username: "alice", Password: "a-long-password"})
+		require.Nil(t, err)
+		result, err := signIn(t, uc, alice)
+		require.Nil(t, err)
+		claims, err := uc.VerifyToken(context.Background(), result.Token)
+		require.Nil(t, err)
+		user, err := uc.Storage.FindUserByID(context.Background(), claims.UserID)
+		require.Nil(t, err)
+		require.True(t, strings.HasPrefix(user.Username, "alice_"), user.Username)
+
+		bob := test_helpers.FakeOIDCUser{Subject: "bob-subject", Email: "other@example.com", EmailVerified: true}
+		_, err = signIn(t, uc, bob)
+		require.ErrorIs(t, err, auth.ErrUserAlreadyExists, "accounts should not be taken over by their email")
+	})
+
+	t.Run(`#StartOIDCLink links the provider to an account, and #UnlinkIdentity removes it`, func(t *testing.T) {
+		uc, _ := setup(t)
+		user, err := uc.SignUpUser(context.Background(), auth.User{Email: "alice@example.com", Username: "alice", Password: "a-long-password"})
+		require.Nil(t, err)
+		flow, err := uc.StartOIDCLink(context.Background(), user.ID, "fake")
+		require.Nil(t, err)
+		state, code := provider.Authorize(t, flow.AuthorizationURL, alice)
+		result, err := uc.CompleteOIDC(context.Background(), auth.OIDCCallback{State: state, Code: code, Binding: flow.Binding, UserID: user.ID})
+		require.Nil(t, err)
+		require.Equal(t, auth.OIDCResult{Linked: true}, result)
+		_, err = uc.StartOIDCLink(context.Background(), user.ID, "fake")
+		require.ErrorIs(t, err, auth.ErrUserAlreadyExists)
+
+		result, err = signIn(t, uc, alice)
+		require.Nil(t, err)
+		claims, err := uc.VerifyToken(context.Background(), result.Token)
+		require.Nil(t, err)
+		require.Equal(t, user.ID, claims.UserID)
+
+		require.Nil(t, uc.UnlinkIdentity(context.Background(), user.ID, "fake"))
+		require.ErrorIs(t, uc.UnlinkIdentity(context.Background(), user.ID, "fake"), auth.ErrIdentityNotFound)
+	})
+
+	t.Run(`the last identity of a user without a password can't be unlinked`, func(t *testing.T) {
+		uc, _ := setup(t)
+		result, err := signIn(t, uc, alice)
+		require.Nil(t, err)
+		claims, err := uc.VerifyToken(context.Background(), result.Token)
+		require.Nil(t, err)
+		require.ErrorIs(t, uc.UnlinkIdentity(context.Background(), claims.UserID, "fake"), auth.ErrInvalidInput)
+	})
+
+	t.Run(`states are used once, and codes are checked by the provider`, func(t *testing.T) {
+		uc, _ := setup(t)
+		_, err := uc.StartOIDCLogin(context.Background(), "unknown")
+		require.ErrorIs(t, err, auth.ErrInvalidInput)
+
+		flow, err := uc.StartOIDCLogin(context.Background(), "fake")
+		require.Nil(t, err)
+		state, code := provider.Authorize(t, flow.AuthorizationURL, alice)
+		_, err = uc.CompleteOIDC(context.Background(), auth.OIDCCallback{State: state, Code: "wrong-code", Binding: flow.Binding})
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+		_, err = uc.CompleteOIDC(context.Background(), auth.OIDCCallback{State: state, Code: code, Binding: flow.Binding})
+		require.ErrorIs(t, err, auth.ErrInvalidToken, "the state should be consumed by the first callback")
+
+		flow, err = uc.StartOIDCLogin(context.Background(), "fake")
+		require.Nil(t, err)
+		_, code = provider.Authorize(t, flow.AuthorizationURL, alice)
+		_, err = uc.CompleteOIDC(context.Background(), auth.OIDCCallback{State: "forged-state", Code: code, Binding: flow.Binding})
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+
+	t.Run(`callbacks are rejected unless they come from the browser, and for links the user, that started the flow`, func(t *testing.T) {
+		uc, _ := setup(t)
+		// the attacker starts a flow, and sends its callback to the victim, whose browser doesn't have the binding
+		attackers, err := uc.StartOIDCLogin(context.Background(), "fake")
+		require.Nil(t, err)
+		victims, err := uc.StartOIDCLogin(context.Background(), "fake")
+		require.Nil(t, err)
+		state, code := provider.Authorize(t, attackers.AuthorizationURL, alice)
+		_, err = uc.CompleteOIDC(context.Background(), auth.OIDCCallback{State: state, Code: code})
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+		_, err = uc.CompleteOIDC(context.Background(), auth.OIDCCallback{State: state, Code: code, Binding: victims.Binding})
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+
+		attacker, err := uc.SignUpUser(context.Background(), auth.User{Email: "mallory@example.com", Username: "mallory", Password: "a-long-password"})
+		require.Nil(t, err)
+		victim, err := uc.SignUpUser(context.Background(), auth.User{Email: "victim@example.com", Username: "victim", Password: "a-long-password"})
+		require.Nil(t, err)
+		for _, userID := range []int{0, victim.ID} {
+			link, err := uc.StartOIDCLink(context.Background(), attacker.ID, "fake")
+			require.Nil(t, err)
+			state, code := provider.Authorize(t, link.AuthorizationURL, alice)
+			_, err = uc.CompleteOIDC(context.Background(), auth.OIDCCallback{State: state, Code: code, Binding: link.Binding, UserID: userID})
+			require.ErrorIs(t, err, auth.ErrInvalidToken)
+		}
+		identities, err := uc.ListIdentities(context.Background(), victim.ID)
+		require.Nil(t, err)
+		require.Empty(t, identities)
+	})
+}
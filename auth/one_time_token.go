@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+type TokenPurpose string
+
+const (
+	TokenPurposeEmailChange       TokenPurpose = "email_change"
+	TokenPurposePasswordReset     TokenPurpose = "password_reset"
+	TokenPurposeEmailVerification TokenPurpose = "email_verification"
+	TokenPurposeMFAChallenge      TokenPurpose = "mfa_challenge"
+)
+
+// OneTimeToken is a random secret sent to a user to confirm an action, e.g. changing their email.
+// Only the hash of the secret is stored, and it can be consumed only once before it expires
+type OneTimeToken struct {
+	// Hash is the hex encoded sha256 of the secret. The secret has enough entropy, so a slow hash is not needed
+	Hash    string
+	Purpose TokenPurpose
+	UserID  int
+	// Data is the purpose specific payload, e.g. the new email address
+	Data      string
+	ExpiresAt time.Time
+}
+
+// newOneTimeToken returns a random secret for the user and its OneTimeToken to store
+func newOneTimeToken(purpose TokenPurpose, userID int, data string, expiresAt time.Time) (string, OneTimeToken, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", OneTimeToken{}, err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	return secret, OneTimeToken{
+		Hash:      hashOneTimeToken(secret),
+		Purpose:   purpose,
+		UserID:    userID,
+		Data:      data,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func hashOneTimeToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher hashes passwords into self-describing strings, which contain the algorithm and its parameters.
+// Argon2id hashes are in the PHC string format, bcrypt hashes are in their own modular crypt format the PHC format is based on.
+// Implementations don't have to be comparable, the usecases tell the hashers apart by their Algorithm and Params
+type PasswordHasher interface {
+	// Algorithm is the name of the algorithm, e.g. "argon2id"
+	Algorithm() string
+	// Params describes the parameters of the new hashes, e.g. "cost=14"
+	Params() string
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches a hash of the same algorithm, whatever its parameters are
+	Verify(password, hash string) (bool, error)
+	// NeedsRehash reports whether the hash isn't created with the algorithm and the parameters of the hasher
+	NeedsRehash(hash string) bool
+}
+
+// DefaultPasswordHasher is used when Usecases.PasswordHasher is nil
+var DefaultPasswordHasher PasswordHasher = BcryptHasher{Cost: 14}
+
+// errUnsupportedHash is returned when a stored hash can't be verified by any of the known hashers
+var errUnsupportedHash = errors.New("unsupported password hash")
+
+// hasherOf returns a hasher that can verify the hash
+func hasherOf(hash string) (PasswordHasher, error) {
+	switch {
+	case isBcryptHash(hash):
+		return BcryptHasher{}, nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return Argon2idHasher{}, nil
+	}
+	return nil, errUnsupportedHash
+}
+
+// BcryptHasher hashes with bcrypt. Cost defaults to bcrypt.DefaultCost
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) cost() int {
+	if h.Cost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return h.Cost
+}
+
+func (h BcryptHasher) Algorithm() string {
+	return "bcrypt"
+}
+
+func (h BcryptHasher) Params() string {
+	return fmt.Sprintf("cost=%d", h.cost())
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost())
+	return string(hash), err
+}
+
+func (h BcryptHasher) Verify(password, hash string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h BcryptHasher) NeedsRehash(hash string) bool {
+	if !isBcryptHash(hash) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.cost()
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// Argon2idHasher hashes with Argon2id. Zero values are replaced with the defaults of RFC 9106's second recommended option
+type Argon2idHasher struct {
+	// Memory in KiB, defaults to 64 MiB
+	Memory uint32
+	// Iterations defaults to 3
+	Iterations uint32
+	// Parallelism defaults to 4
+	Parallelism uint8
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+func (h Argon2idHasher) withDefaults() Argon2idHasher {
+	if h.Memory == 0 {
+		h.Memory = 64 * 1024
+	}
+	if h.Iterations == 0 {
+		h.Iterations = 3
+	}
+	if h.Parallelism == 0 {
+		h.Parallelism = 4
+	}
+	return h
+}
+
+func (h Argon2idHasher) Algorithm() string {
+	return "argon2id"
+}
+
+func (h Argon2idHasher) Params() string {
+	h = h.withDefaults()
+	return fmt.Sprintf("m=%d,t=%d,p=%d", h.Memory, h.Iterations, h.Parallelism)
+}
+
+// Hash returns a PHC string, e.g. $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	h = h.withDefaults()
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$%s$%s$%s", argon2.Version, h.Params(),
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h Argon2idHasher) Verify(password, hash string) (bool, error) {
+	params, salt, key, err := parseArgon2idHash(hash)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash string) bool {
+	params, _, key, err := parseArgon2idHash(hash)
+	return err != nil || params != h.withDefaults() || len(key) != argon2KeyLength
+}
+
+func parseArgon2idHash(hash string) (params Argon2idHasher, salt, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=65536,t=3,p=4", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2idHasher{}, nil, nil, errUnsupportedHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2idHasher{}, nil, nil, fmt.Errorf("%w: argon2 version %q", errUnsupportedHash, parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return Argon2idHasher{}, nil, nil, fmt.Errorf("%w: argon2 params %q", errUnsupportedHash, parts[3])
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return Argon2idHasher{}, nil, nil, err
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return Argon2idHasher{}, nil, nil, err
+	}
+	return params, salt, key, nil
+}
+
+// dummyHashes caches a hash per algorithm and parameters, which is verified against the passwords of unknown users.
+// Hashes have the parameters of their hasher, so verifying them takes as long as verifying the hashes of real users.
+// They are keyed by dummyHashKey, since the hashers aren't necessarily comparable
+var dummyHashes = struct {
+	mu     sync.Mutex
+	hashes map[string]string
+}{
+	hashes: map[string]string{
+		// precomputed, so the default hasher doesn't pay for it on the first login of an unknown user
+		dummyHashKey(BcryptHasher{Cost: 14}): "$2a$14$hfGjByAZHPUUlljrmOckWukbPMLJApGD5pQInK0jSzZqlxIBI.xUy",
+	},
+}
+
+func dummyHashKey(h PasswordHasher) string {
+	return h.Algorithm() + " " + h.Params()
+}
+
+// dummyHash hashes outside the lock, so the concurrent logins of unknown users don't wait for each other.
+// Concurrent first calls of the same parameters may hash more than once, the first hash is kept
+func dummyHash(h PasswordHasher) (string, error) {
+	key := dummyHashKey(h)
+	dummyHashes.mu.Lock()
+	hash, ok := dummyHashes.hashes[key]
+	dummyHashes.mu.Unlock()
+	if ok {
+		return hash, nil
+	}
+	hash, err := h.Hash("dummy password of unknown users")
+	if err != nil {
+		return "", err
+	}
+	dummyHashes.mu.Lock()
+	defer dummyHashes.mu.Unlock()
+	if cached, ok := dummyHashes.hashes[key]; ok {
+		return cached, nil
+	}
+	dummyHashes.hashes[key] = hash
+	return hash, nil
+}
@@ -0,0 +1,99 @@
+package auth_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/mailer"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordHashers(t *testing.T) {
+	// cheap parameters, the defaults are too slow for tests
+	for _, h := range []auth.PasswordHasher{
+		auth.BcryptHasher{Cost: bcrypt.MinCost},
+		auth.Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1},
+	} {
+		h := h
+		t.Run(h.Algorithm(), func(t *testing.T) {
+			hash, err := h.Hash("password")
+			require.Nil(t, err)
+			other, err := h.Hash("password")
+			require.Nil(t, err)
+			require.NotEqual(t, hash, other, "hashes should be salted")
+
+			correct, err := h.Verify("password", hash)
+			require.Nil(t, err)
+			require.True(t, correct)
+			correct, err = h.Verify("wrong password", hash)
+			require.Nil(t, err)
+			require.False(t, correct)
+			require.False(t, h.NeedsRehash(hash))
+		})
+	}
+
+	t.Run(`argon2id hashes are PHC strings with their parameters`, func(t *testing.T) {
+		h := auth.Argon2idHasher{Memory: 1024, Iterations: 2, Parallelism: 1}
+		hash, err := h.Hash("password")
+		require.Nil(t, err)
+		require.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=2,p=1$"), hash)
+
+		require.True(t, auth.Argon2idHasher{Memory: 1024, Iterations: 3, Parallelism: 1}.NeedsRehash(hash))
+		correct, err := auth.Argon2idHasher{}.Verify("password", hash)
+		require.Nil(t, err)
+		require.True(t, correct, "hashes should be verified with their own parameters")
+	})
+
+	t.Run(`hashes of other algorithms or parameters need rehash`, func(t *testing.T) {
+		bcryptHash, err := auth.BcryptHasher{Cost: bcrypt.MinCost}.Hash("password")
+		require.Nil(t, err)
+		require.True(t, auth.BcryptHasher{Cost: bcrypt.MinCost + 1}.NeedsRehash(bcryptHash))
+		require.True(t, auth.Argon2idHasher{}.NeedsRehash(bcryptHash))
+		require.True(t, auth.BcryptHasher{}.NeedsRehash("$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$a2V5"))
+	})
+}
+
+func TestPasswordRehash(t *testing.T) {
+	uc := test_helpers.NewUsecases(inmemory.New(), mailer.NewInMemory())
+	user := test_helpers.HopefullyUniqueUser()
+	created, err := uc.SignUpUser(context.Background(), user)
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(created.Password, "$2a$04$"), created.Password)
+
+	argon2id := auth.Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1}
+	uc.PasswordHasher = argon2id
+	token, err := uc.Login(context.Background(), user.Username, user.Password)
+	require.Nil(t, err)
+	rehashed, err := uc.Storage.FindUserByID(context.Background(), created.ID)
+	require.Nil(t, err)
+	require.False(t, argon2id.NeedsRehash(rehashed.Password), "password should be rehashed with the new hasher")
+	_, err = uc.VerifyToken(context.Background(), token)
+	require.Nil(t, err, "rehashing should not revoke the sessions")
+
+	_, err = uc.Login(context.Background(), user.Username, user.Password)
+	require.Nil(t, err)
+	again, err := uc.Storage.FindUserByID(context.Background(), created.ID)
+	require.Nil(t, err)
+	require.Equal(t, rehashed.Password, again.Password, "up to date hashes should not be rehashed")
+
+	_, err = uc.Login(context.Background(), user.Username, "wrong-password")
+	require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+}
+
+func TestLoginOfUnknownUsersWithUncomparableHasher(t *testing.T) {
+	uc := test_helpers.NewUsecases(inmemory.New(), mailer.NewInMemory())
+	uc.PasswordHasher = uncomparableHasher{PasswordHasher: test_helpers.FastPasswordHasher}
+	_, err := uc.Login(context.Background(), "username-that-doesnt-exist", "password")
+	require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+}
+
+// uncomparableHasher can't be a map key, like any hasher with a slice or a map
+type uncomparableHasher struct {
+	auth.PasswordHasher
+	pepper []byte
+}
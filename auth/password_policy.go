@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// PasswordPolicy is the set of rules new passwords must satisfy
+type PasswordPolicy struct {
+	MinLength int
+}
+
+// DefaultPasswordPolicy is used when Usecases.PasswordPolicy is the zero value
+var DefaultPasswordPolicy = PasswordPolicy{MinLength: 8}
+
+// bcrypt ignores the bytes after the 72nd, longer passwords would give a false sense of security
+const maxPasswordBytes = 72
+
+// Validate returns an ErrInvalidInput if the password doesn't satisfy the policy
+func (p PasswordPolicy) Validate(password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("%w: password must be at least %d characters", ErrInvalidInput, p.MinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("%w: password can't be longer than %d bytes", ErrInvalidInput, maxPasswordBytes)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth/tracing"
+)
+
+// PasswordResetTokenTTL is how long the token sent by RequestPasswordReset is valid
+const PasswordResetTokenTTL = time.Hour
+
+// RequestPasswordReset sends a password reset token to the email if a user has it.
+// It succeeds for unknown emails too, so it can't be used to find out which emails are registered.
+// For the same reason, the token is sent in the background after it returns, and failing to send it is logged
+func (c Usecases) RequestPasswordReset(ctx context.Context, email string) (err error) {
+	defer c.observe("request_password_reset", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.RequestPasswordReset")
+	defer tracing.EndSpan(span, &err)
+
+	user, err := c.Storage.FindUserByEmail(ctx, email)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.sendInBackground(ctx, "sending the password reset email failed", user, c.sendPasswordResetEmail)
+	return nil
+}
+
+// sendPasswordResetEmail creates a password reset token and mails it to the user
+func (c Usecases) sendPasswordResetEmail(ctx context.Context, user User) error {
+	secret, token, err := newOneTimeToken(TokenPurposePasswordReset, user.ID, "", c.now().Add(PasswordResetTokenTTL))
+	if err != nil {
+		return err
+	}
+	if err := c.Storage.CreateOneTimeToken(ctx, token); err != nil {
+		return err
+	}
+	return c.mailer().Send(ctx, Email{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the code below to reset your password. It expires in %v.\nIgnore this email if you didn't ask for it.\n\n%s\n",
+			user.Username, PasswordResetTokenTTL, secret),
+	})
+}
+
+// ResetPassword sets the password of the user the token was sent to. Tokens can be used only once.
+// Like ChangePassword, it revokes all sessions and API keys of the user and publishes a PasswordChangedEvent
+func (c Usecases) ResetPassword(ctx context.Context, secret, newPwd string) (err error) {
+	defer c.observe("reset_password", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.ResetPassword")
+	defer tracing.EndSpan(span, &err)
+
+	// the policy is checked first, so an invalid password doesn't use up the token
+	if err := c.passwordPolicy().Validate(newPwd); err != nil {
+		return err
+	}
+	token, err := c.Storage.ConsumeOneTimeToken(ctx, TokenPurposePasswordReset, hashOneTimeToken(secret), c.now())
+	if err != nil {
+		return err
+	}
+	return c.setPassword(ctx, token.UserID, newPwd)
+}
@@ -0,0 +1,147 @@
+package auth_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/logging"
+	"github.com/davudsafarli/twitter/auth/mailer"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordReset(t *testing.T) {
+	setup := func(t *testing.T) (auth.Usecases, *inmemory.EventStreamer, *mailer.InMemory, auth.User) {
+		events := inmemory.New()
+		mails := mailer.NewInMemory()
+		uc := test_helpers.NewUsecases(events, nil)
+		user := test_helpers.HopefullyUniqueUser()
+		created, err := uc.SignUpUser(context.Background(), user)
+		require.Nil(t, err)
+		uc.Mailer = mails
+		created.Password = user.Password
+		return uc, events, mails, created
+	}
+	requestToken := func(t *testing.T, uc auth.Usecases, mails *mailer.InMemory, email string) string {
+		require.Nil(t, uc.RequestPasswordReset(context.Background(), email))
+		var sent auth.Email
+		require.Eventually(t, func() bool {
+			var ok bool
+			sent, ok = mails.Last(email)
+			return ok
+		}, time.Second, time.Millisecond, "the token should be sent in the background")
+		lines := strings.Split(strings.TrimSpace(sent.Body), "\n")
+		return lines[len(lines)-1]
+	}
+
+	t.Run(`#ResetPassword sets the new password with the emailed token, once`, func(t *testing.T) {
+		uc, events, mails, user := setup(t)
+		oldToken, err := uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+		token := requestToken(t, uc, mails, user.Email)
+
+		require.Nil(t, uc.ResetPassword(context.Background(), token, "new-password"))
+		_, err = uc.Login(context.Background(), user.Username, "new-password")
+		require.Nil(t, err)
+		_, err = uc.VerifyToken(context.Background(), oldToken)
+		require.ErrorIs(t, err, auth.ErrInvalidToken, "sessions should be revoked")
+		published := events.Published()
+		require.Equal(t, user.ID, published[len(published)-1].(auth.PasswordChangedEvent).UserID)
+
+		err = uc.ResetPassword(context.Background(), token, "another-password")
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+
+	t.Run(`#RequestPasswordReset succeeds without sending anything for unknown emails`, func(t *testing.T) {
+		uc, _, mails, _ := setup(t)
+		require.Nil(t, uc.RequestPasswordReset(context.Background(), "unknown@example.com"))
+		require.Len(t, mails.Sent(), 0)
+	})
+
+	t.Run(`#RequestPasswordReset logs instead of returning the failures of sending the token`, func(t *testing.T) {
+		uc, _, _, user := setup(t)
+		logs := &syncBuffer{}
+		uc.Logger = logging.New(logs, logging.LevelInfo)
+		uc.Mailer = failingMailer{}
+		require.Nil(t, uc.RequestPasswordReset(context.Background(), user.Email), "registered emails should not be told apart by the failures")
+		require.Eventually(t, func() bool {
+			return strings.Contains(logs.String(), "smtp is down")
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run(`#RequestPasswordReset returns before the token is sent, so registered emails take as long as unknown ones`, func(t *testing.T) {
+		uc, _, _, user := setup(t)
+		blocking := blockingMailer{sending: make(chan auth.Email, 1), unblock: make(chan struct{})}
+		uc.Mailer = blocking
+		t.Cleanup(func() { close(blocking.unblock) })
+		ctx, cancel := context.WithCancel(context.Background())
+		returned := make(chan error, 1)
+		go func() { returned <- uc.RequestPasswordReset(ctx, user.Email) }()
+		select {
+		case err := <-returned:
+			require.Nil(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("#RequestPasswordReset should not wait for the email")
+		}
+		// the request is over, its cancellation shouldn't stop the email
+		cancel()
+		sending := <-blocking.sending
+		require.Equal(t, user.Email, sending.To)
+	})
+
+	t.Run(`#ResetPassword rejects invalid passwords without using up the token`, func(t *testing.T) {
+		uc, _, mails, user := setup(t)
+		token := requestToken(t, uc, mails, user.Email)
+		require.ErrorIs(t, uc.ResetPassword(context.Background(), token, "short"), auth.ErrInvalidInput)
+		require.Nil(t, uc.ResetPassword(context.Background(), token, "new-password"))
+	})
+
+	t.Run(`#ResetPassword rejects expired and unknown tokens`, func(t *testing.T) {
+		uc, _, mails, user := setup(t)
+		token := requestToken(t, uc, mails, user.Email)
+		require.ErrorIs(t, uc.ResetPassword(context.Background(), "unknown", "new-password"), auth.ErrInvalidToken)
+
+		uc.Now = func() time.Time { return time.Now().Add(auth.PasswordResetTokenTTL) }
+		require.ErrorIs(t, uc.ResetPassword(context.Background(), token, "new-password"), auth.ErrInvalidToken)
+	})
+}
+
+// blockingMailer reports the emails to sending, and sends them once unblock is closed
+type blockingMailer struct {
+	sending chan auth.Email
+	unblock chan struct{}
+}
+
+func (m blockingMailer) Send(ctx context.Context, email auth.Email) error {
+	m.sending <- email
+	select {
+	case <-m.unblock:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// syncBuffer is a bytes.Buffer the emails sent in the background can log to while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
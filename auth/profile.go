@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+	"unicode/utf8"
+
+	"github.com/davudsafarli/twitter/auth/tracing"
+)
+
+// ProfileUpdate holds the new values of the profile fields of a user. Nil fields are not changed
+type ProfileUpdate struct {
+	DisplayName *string `json:",omitempty"`
+	Bio         *string `json:",omitempty"`
+	Location    *string `json:",omitempty"`
+	Website     *string `json:",omitempty"`
+	AvatarURL   *string `json:",omitempty"`
+}
+
+// Validate checks the lengths of the fields and that the links are http(s) URLs. Empty values are valid, they clear the field
+func (p ProfileUpdate) Validate() error {
+	for _, f := range []struct {
+		name   string
+		value  *string
+		maxLen int
+		isURL  bool
+	}{
+		{"display name", p.DisplayName, 50, false},
+		{"bio", p.Bio, 160, false},
+		{"location", p.Location, 30, false},
+		{"website", p.Website, 100, true},
+		{"avatar url", p.AvatarURL, 255, true},
+	} {
+		if f.value == nil || *f.value == "" {
+			continue
+		}
+		if utf8.RuneCountInString(*f.value) > f.maxLen {
+			return fmt.Errorf("%w: %s can't be longer than %d characters", ErrInvalidInput, f.name, f.maxLen)
+		}
+		if f.isURL && !isHTTPURL(*f.value) {
+			return fmt.Errorf("%w: %s must be an http or https URL", ErrInvalidInput, f.name)
+		}
+	}
+	return nil
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// changedFrom drops the fields that are equal to the current profile of the user
+func (p ProfileUpdate) changedFrom(u User) ProfileUpdate {
+	changed := func(new *string, current string) *string {
+		if new == nil || *new == current {
+			return nil
+		}
+		return new
+	}
+	return ProfileUpdate{
+		DisplayName: changed(p.DisplayName, u.DisplayName),
+		Bio:         changed(p.Bio, u.Bio),
+		Location:    changed(p.Location, u.Location),
+		Website:     changed(p.Website, u.Website),
+		AvatarURL:   changed(p.AvatarURL, u.AvatarURL),
+	}
+}
+
+func (p ProfileUpdate) isEmpty() bool {
+	return p == ProfileUpdate{}
+}
+
+// UpdateProfile validates and saves the changed profile fields of a user.
+// It publishes a ProfileUpdatedEvent with only the changed fields, nothing is published if nothing changes
+func (c Usecases) UpdateProfile(ctx context.Context, userID int, update ProfileUpdate) (_ User, err error) {
+	defer c.observe("update_profile", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.UpdateProfile")
+	defer tracing.EndSpan(span, &err)
+
+	if err := update.Validate(); err != nil {
+		return User{}, err
+	}
+	user, err := c.Storage.FindUserByID(ctx, userID)
+	if err != nil {
+		return User{}, err
+	}
+	changes := update.changedFrom(user)
+	if changes.isEmpty() {
+		return user, nil
+	}
+	user, err = c.Storage.UpdateProfile(ctx, userID, changes)
+	if err != nil {
+		return User{}, err
+	}
+	err = c.events().PublishProfileUpdatedEvent(ctx, ProfileUpdatedEvent{
+		UserID:    user.ID,
+		Changes:   changes,
+		UpdatedAt: user.UpdatedAt,
+	})
+	return user, err
+}
+
+// GetUser returns a user to the actor. The actor gets their own account, and the actors with PermissionReadUsers
+// get the account of anyone. Others, and anonymous actors with the zero ID, get only the public profile,
+// and ErrUserNotFound for the deactivated and the suspended users. Password hashes are never returned
+func (c Usecases) GetUser(ctx context.Context, actorID, userID int) (_ User, err error) {
+	defer c.observe("get_user", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.GetUser")
+	defer tracing.EndSpan(span, &err)
+
+	user, err := c.Storage.FindUserByID(ctx, userID)
+	if err != nil {
+		return User{}, err
+	}
+	user.Password = ""
+	if actorID == userID {
+		return user, nil
+	}
+	_, err = c.authorize(ctx, actorID, PermissionReadUsers)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, ErrForbidden) {
+		return User{}, err
+	}
+	if !user.DeactivatedAt.IsZero() || !user.SuspendedAt.IsZero() {
+		return User{}, ErrUserNotFound
+	}
+	return publicProfile(user), nil
+}
+
+// publicProfile drops the fields of the user only they and the staff can see
+func publicProfile(u User) User {
+	return User{
+		ID:          u.ID,
+		Username:    u.Username,
+		DisplayName: u.DisplayName,
+		Bio:         u.Bio,
+		Location:    u.Location,
+		Website:     u.Website,
+		AvatarURL:   u.AvatarURL,
+		CreatedAt:   u.CreatedAt,
+	}
+}
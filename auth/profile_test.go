@@ -0,0 +1,66 @@
+package auth_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateProfile(t *testing.T) {
+	setup := func(t *testing.T) (auth.Usecases, *inmemory.EventStreamer, auth.User) {
+		storage := test_helpers.NewInMemoryStorage()
+		events := inmemory.New()
+		// created directly with the storage to skip password hashing
+		user, err := storage.CreateUser(context.Background(), test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		return auth.NewUsecases(storage, events), events, user
+	}
+	str := func(s string) *string { return &s }
+
+	t.Run(`only the changed fields are published`, func(t *testing.T) {
+		uc, events, user := setup(t)
+		updated, err := uc.UpdateProfile(context.Background(), user.ID, auth.ProfileUpdate{
+			DisplayName: str("Davud"),
+			Bio:         str(""),
+		})
+		require.Nil(t, err)
+		require.Equal(t, "Davud", updated.DisplayName)
+		require.Equal(t, []interface{}{auth.ProfileUpdatedEvent{
+			UserID:    user.ID,
+			Changes:   auth.ProfileUpdate{DisplayName: str("Davud")},
+			UpdatedAt: updated.UpdatedAt,
+		}}, events.Published())
+	})
+
+	t.Run(`nothing is published if nothing changes`, func(t *testing.T) {
+		uc, events, user := setup(t)
+		_, err := uc.UpdateProfile(context.Background(), user.ID, auth.ProfileUpdate{Bio: str(user.Bio)})
+		require.Nil(t, err)
+		require.Empty(t, events.Published())
+	})
+
+	t.Run(`invalid input is rejected`, func(t *testing.T) {
+		uc, events, user := setup(t)
+		for _, update := range []auth.ProfileUpdate{
+			{DisplayName: str(strings.Repeat("a", 51))},
+			{Bio: str(strings.Repeat("a", 161))},
+			{Website: str("javascript:alert(1)")},
+			{AvatarURL: str("not a url")},
+		} {
+			_, err := uc.UpdateProfile(context.Background(), user.ID, update)
+			require.ErrorIs(t, err, auth.ErrInvalidInput)
+		}
+		require.Empty(t, events.Published())
+	})
+
+	t.Run(`unknown users can't be updated`, func(t *testing.T) {
+		uc, _, _ := setup(t)
+		_, err := uc.UpdateProfile(context.Background(), -1, auth.ProfileUpdate{Bio: str("bio")})
+		require.ErrorIs(t, err, auth.ErrUserNotFound)
+	})
+}
@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth/tracing"
+	"github.com/golang-jwt/jwt"
+)
+
+// Role groups the permissions of users, every user has exactly one
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// Permission allows an action on other users. Users don't need any to act on their own account
+type Permission string
+
+const (
+	PermissionReadUsers    Permission = "users:read"
+	PermissionSuspendUsers Permission = "users:suspend"
+	PermissionAssignRoles  Permission = "roles:assign"
+)
+
+// DefaultRolePermissions are the permissions the migrations grant to the roles.
+// Storage is the source of truth, so the permissions can be changed without a deploy
+var DefaultRolePermissions = map[Role][]Permission{
+	RoleUser:      nil,
+	RoleModerator: {PermissionReadUsers, PermissionSuspendUsers},
+	RoleAdmin:     {PermissionReadUsers, PermissionSuspendUsers, PermissionAssignRoles},
+}
+
+// AuditAction is the Action of an AuditEvent
+type AuditAction string
+
+const (
+	AuditActionListUsers     AuditAction = "users.list"
+	AuditActionSearchUsers   AuditAction = "users.search"
+	AuditActionSuspendUser   AuditAction = "users.suspend"
+	AuditActionUnsuspendUser AuditAction = "users.unsuspend"
+	AuditActionAssignRole    AuditAction = "roles.assign"
+)
+
+// Can reports whether the token grants the permission. The permissions are the ones of the role when the token was issued,
+// AssignRole revokes the sessions of the user so they don't outlive a role change
+func (c TokenClaims) Can(p Permission) bool {
+	return hasPermission(c.Permissions, p)
+}
+
+// roleOf returns the role of the user, users created before roles have none
+func roleOf(u User) Role {
+	if u.Role == "" {
+		return RoleUser
+	}
+	return u.Role
+}
+
+// authorize returns the actor if they can act with the permission, ErrForbidden otherwise.
+// The permissions are read from Storage rather than the token, so a revoked permission takes effect immediately
+func (c Usecases) authorize(ctx context.Context, actorID int, p Permission) (User, error) {
+	actor, err := c.Storage.FindUserByID(ctx, actorID)
+	if errors.Is(err, ErrUserNotFound) {
+		return User{}, ErrForbidden
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if !actor.SuspendedAt.IsZero() || !actor.DeactivatedAt.IsZero() {
+		return User{}, ErrForbidden
+	}
+	permissions, err := c.Storage.RolePermissions(ctx, roleOf(actor))
+	if err != nil {
+		return User{}, err
+	}
+	if hasPermission(permissions, p) {
+		return actor, nil
+	}
+	return User{}, fmt.Errorf("%w: %s is required", ErrForbidden, p)
+}
+
+// targetUser returns the user an action is about. A missing user is an invalid input,
+// ErrUserNotFound would be reported as the invalid credentials of the actor
+func (c Usecases) targetUser(ctx context.Context, userID int) (User, error) {
+	user, err := c.Storage.FindUserByID(ctx, userID)
+	if errors.Is(err, ErrUserNotFound) {
+		return User{}, fmt.Errorf("%w: user %d doesn't exist", ErrInvalidInput, userID)
+	}
+	return user, err
+}
+
+// UserQuery selects the users of ListUsers, ordered by ID
+type UserQuery struct {
+	// Search matches the users whose username, email or display name contains it, case-insensitively. Empty matches all
+	Search string
+	// AfterID is the ID of the last user of the previous page, zero for the first page
+	AfterID int
+	Limit   int
+}
+
+// MaxUserQueryLimit is the maximum Limit of ListUsers and SearchUsers
+const MaxUserQueryLimit = 100
+
+// ListUsers returns a page of all users, for the actors with PermissionReadUsers. It publishes an AuditEvent
+func (c Usecases) ListUsers(ctx context.Context, actorID, afterID, limit int) (_ []User, err error) {
+	defer c.observe("list_users", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.ListUsers")
+	defer tracing.EndSpan(span, &err)
+
+	return c.queryUsers(ctx, actorID, AuditActionListUsers, UserQuery{AfterID: afterID, Limit: limit})
+}
+
+// SearchUsers returns the users matching the search as described by UserQuery, for the actors with PermissionReadUsers.
+// It publishes an AuditEvent with the search
+func (c Usecases) SearchUsers(ctx context.Context, actorID int, search string, limit int) (_ []User, err error) {
+	defer c.observe("search_users", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.SearchUsers")
+	defer tracing.EndSpan(span, &err)
+
+	search = strings.TrimSpace(search)
+	if search == "" {
+		return nil, fmt.Errorf("%w: search is empty", ErrInvalidInput)
+	}
+	return c.queryUsers(ctx, actorID, AuditActionSearchUsers, UserQuery{Search: search, Limit: limit})
+}
+
+func (c Usecases) queryUsers(ctx context.Context, actorID int, action AuditAction, query UserQuery) ([]User, error) {
+	if query.Limit < 1 || query.Limit > MaxUserQueryLimit {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidInput, MaxUserQueryLimit)
+	}
+	if _, err := c.authorize(ctx, actorID, PermissionReadUsers); err != nil {
+		return nil, err
+	}
+	users, err := c.Storage.ListUsers(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		users[i].Password = ""
+	}
+	// published before returning the users, so every access is audited
+	return users, c.events().PublishAuditEvent(ctx, AuditEvent{
+		ActorID:    actorID,
+		Action:     action,
+		Details:    query.Search,
+		OccurredAt: c.now(),
+	})
+}
+
+// SuspendUser blocks a user for breaking the rules, for the actors with PermissionSuspendUsers. Suspended users can't log in
+// and their sessions are revoked, until UnsuspendUser. Only admins can suspend admins, and nobody can suspend themselves.
+// It publishes an AuditEvent with the reason
+func (c Usecases) SuspendUser(ctx context.Context, actorID, userID int, reason string) (err error) {
+	defer c.observe("suspend_user", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.SuspendUser")
+	defer tracing.EndSpan(span, &err)
+
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return fmt.Errorf("%w: reason is empty", ErrInvalidInput)
+	}
+	if actorID == userID {
+		return fmt.Errorf("%w: users can't suspend themselves", ErrInvalidInput)
+	}
+	actor, err := c.authorize(ctx, actorID, PermissionSuspendUsers)
+	if err != nil {
+		return err
+	}
+	user, err := c.targetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if roleOf(user) == RoleAdmin && roleOf(actor) != RoleAdmin {
+		return fmt.Errorf("%w: only admins can suspend admins", ErrForbidden)
+	}
+	if !user.SuspendedAt.IsZero() {
+		return fmt.Errorf("%w: user is already suspended", ErrInvalidInput)
+	}
+	now := c.now()
+	if err := c.Storage.SetSuspension(ctx, userID, now, reason); err != nil {
+		return err
+	}
+	if err := c.Storage.RevokeSessions(ctx, userID); err != nil {
+		return err
+	}
+	return c.events().PublishAuditEvent(ctx, AuditEvent{
+		ActorID:    actorID,
+		Action:     AuditActionSuspendUser,
+		UserID:     userID,
+		Details:    reason,
+		OccurredAt: now,
+	})
+}
+
+// UnsuspendUser lifts the suspension of a user, for the actors with PermissionSuspendUsers. It publishes an AuditEvent
+func (c Usecases) UnsuspendUser(ctx context.Context, actorID, userID int) (err error) {
+	defer c.observe("unsuspend_user", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.UnsuspendUser")
+	defer tracing.EndSpan(span, &err)
+
+	if _, err := c.authorize(ctx, actorID, PermissionSuspendUsers); err != nil {
+		return err
+	}
+	user, err := c.targetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.SuspendedAt.IsZero() {
+		return fmt.Errorf("%w: user is not suspended", ErrInvalidInput)
+	}
+	if err := c.Storage.SetSuspension(ctx, userID, time.Time{}, ""); err != nil {
+		return err
+	}
+	return c.events().PublishAuditEvent(ctx, AuditEvent{
+		ActorID:    actorID,
+		Action:     AuditActionUnsuspendUser,
+		UserID:     userID,
+		OccurredAt: c.now(),
+	})
+}
+
+// AssignRole changes the role of a user, for the actors with PermissionAssignRoles. The sessions of the user are revoked,
+// so their tokens get the permissions of the new role on the next Login. Admins can't change their own role,
+// so there is always an admin left. It publishes an AuditEvent with the new role
+func (c Usecases) AssignRole(ctx context.Context, actorID, userID int, role Role) (err error) {
+	defer c.observe("assign_role", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.AssignRole")
+	defer tracing.EndSpan(span, &err)
+
+	if actorID == userID {
+		return fmt.Errorf("%w: users can't change their own role", ErrInvalidInput)
+	}
+	if _, err := c.authorize(ctx, actorID, PermissionAssignRoles); err != nil {
+		return err
+	}
+	return c.assignRole(ctx, actorID, userID, role)
+}
+
+// AssignRoleAsOperator is AssignRole by the operators of the service, e.g. to make the first admin from the command line.
+// Its AuditEvent has no ActorID
+func (c Usecases) AssignRoleAsOperator(ctx context.Context, userID int, role Role) (err error) {
+	defer c.observe("assign_role_as_operator", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.AssignRoleAsOperator")
+	defer tracing.EndSpan(span, &err)
+
+	return c.assignRole(ctx, 0, userID, role)
+}
+
+func (c Usecases) assignRole(ctx context.Context, actorID, userID int, role Role) error {
+	if _, err := c.targetUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := c.Storage.SetRole(ctx, userID, role); err != nil {
+		return err
+	}
+	if err := c.Storage.RevokeSessions(ctx, userID); err != nil {
+		return err
+	}
+	return c.events().PublishAuditEvent(ctx, AuditEvent{
+		ActorID:    actorID,
+		Action:     AuditActionAssignRole,
+		UserID:     userID,
+		Details:    string(role),
+		OccurredAt: c.now(),
+	})
+}
+
+// roleClaim returns the role of the token, RoleUser for the tokens issued before roles
+func roleClaim(claims jwt.MapClaims) Role {
+	role, _ := claims["role"].(string)
+	if role == "" {
+		return RoleUser
+	}
+	return Role(role)
+}
+
+// permissionsClaim returns the permissions of the token. Arrays of MapClaims are decoded as []interface{}
+func permissionsClaim(claims jwt.MapClaims) []Permission {
+	raw, _ := claims["perms"].([]interface{})
+	var permissions []Permission
+	for _, p := range raw {
+		if s, ok := p.(string); ok {
+			permissions = append(permissions, Permission(s))
+		}
+	}
+	return permissions
+}
@@ -0,0 +1,168 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/mailer"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRBAC(t *testing.T) {
+	type users struct {
+		admin, moderator, user auth.User
+	}
+	setup := func(t *testing.T) (auth.Usecases, *inmemory.EventStreamer, *fakeClock, users) {
+		events := inmemory.New()
+		clock := &fakeClock{now: time.Date(2021, 7, 19, 13, 11, 26, 0, time.UTC)}
+		uc := test_helpers.NewUsecases(events, mailer.NewInMemory())
+		uc.Now = clock.Now
+		signUp := func(role auth.Role) auth.User {
+			user := test_helpers.HopefullyUniqueUser()
+			created, err := uc.SignUpUser(context.Background(), user)
+			require.Nil(t, err)
+			require.Nil(t, uc.Storage.SetRole(context.Background(), created.ID, role))
+			created.Password = user.Password
+			created.Role = role
+			return created
+		}
+		return uc, events, clock, users{admin: signUp(auth.RoleAdmin), moderator: signUp(auth.RoleModerator), user: signUp(auth.RoleUser)}
+	}
+	lastEvent := func(events *inmemory.EventStreamer) interface{} {
+		published := events.Published()
+		return published[len(published)-1]
+	}
+
+	t.Run(`tokens carry the role and the permissions of the user`, func(t *testing.T) {
+		uc, _, _, u := setup(t)
+		token, err := uc.Login(context.Background(), u.moderator.Username, u.moderator.Password)
+		require.Nil(t, err)
+		claims, err := uc.VerifyToken(context.Background(), token)
+		require.Nil(t, err)
+		require.Equal(t, auth.RoleModerator, claims.Role)
+		require.True(t, claims.Can(auth.PermissionReadUsers))
+		require.True(t, claims.Can(auth.PermissionSuspendUsers))
+		require.False(t, claims.Can(auth.PermissionAssignRoles))
+
+		token, err = uc.Login(context.Background(), u.user.Username, u.user.Password)
+		require.Nil(t, err)
+		claims, err = uc.VerifyToken(context.Background(), token)
+		require.Nil(t, err)
+		require.Equal(t, auth.RoleUser, claims.Role)
+		require.Empty(t, claims.Permissions)
+	})
+
+	t.Run(`#ListUsers and #SearchUsers need users:read, and are audited`, func(t *testing.T) {
+		uc, events, clock, u := setup(t)
+		_, err := uc.ListUsers(context.Background(), u.user.ID, 0, 10)
+		require.ErrorIs(t, err, auth.ErrForbidden)
+
+		listed, err := uc.ListUsers(context.Background(), u.moderator.ID, 0, 2)
+		require.Nil(t, err)
+		require.Len(t, listed, 2)
+		require.Equal(t, u.admin.ID, listed[0].ID)
+		require.Empty(t, listed[0].Password, "password hashes should not be returned")
+		require.Equal(t, auth.AuditEvent{ActorID: u.moderator.ID, Action: auth.AuditActionListUsers, OccurredAt: clock.Now()}, lastEvent(events))
+		listed, err = uc.ListUsers(context.Background(), u.moderator.ID, listed[1].ID, 2)
+		require.Nil(t, err)
+		require.Len(t, listed, 1)
+		require.Equal(t, u.user.ID, listed[0].ID)
+
+		found, err := uc.SearchUsers(context.Background(), u.admin.ID, u.user.Username, 10)
+		require.Nil(t, err)
+		require.Len(t, found, 1)
+		require.Equal(t, u.user.ID, found[0].ID)
+		require.Equal(t, auth.AuditEvent{ActorID: u.admin.ID, Action: auth.AuditActionSearchUsers, Details: u.user.Username, OccurredAt: clock.Now()}, lastEvent(events))
+		_, err = uc.SearchUsers(context.Background(), u.admin.ID, " ", 10)
+		require.ErrorIs(t, err, auth.ErrInvalidInput)
+		_, err = uc.SearchUsers(context.Background(), u.admin.ID, "a", auth.MaxUserQueryLimit+1)
+		require.ErrorIs(t, err, auth.ErrInvalidInput)
+	})
+
+	t.Run(`#SuspendUser blocks the user until #UnsuspendUser`, func(t *testing.T) {
+		uc, events, clock, u := setup(t)
+		token, err := uc.Login(context.Background(), u.user.Username, u.user.Password)
+		require.Nil(t, err)
+
+		require.ErrorIs(t, uc.SuspendUser(context.Background(), u.user.ID, u.moderator.ID, "spam"), auth.ErrForbidden)
+		require.ErrorIs(t, uc.SuspendUser(context.Background(), u.moderator.ID, u.admin.ID, "spam"), auth.ErrForbidden, "moderators should not suspend admins")
+		require.ErrorIs(t, uc.SuspendUser(context.Background(), u.moderator.ID, u.user.ID, ""), auth.ErrInvalidInput)
+		require.ErrorIs(t, uc.SuspendUser(context.Background(), u.moderator.ID, 0, "spam"), auth.ErrInvalidInput)
+
+		require.Nil(t, uc.SuspendUser(context.Background(), u.moderator.ID, u.user.ID, "spam"))
+		require.Equal(t, auth.AuditEvent{ActorID: u.moderator.ID, Action: auth.AuditActionSuspendUser, UserID: u.user.ID, Details: "spam", OccurredAt: clock.Now()}, lastEvent(events))
+		_, err = uc.VerifyToken(context.Background(), token)
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+		_, err = uc.Login(context.Background(), u.user.Username, u.user.Password)
+		require.ErrorIs(t, err, auth.ErrAccountSuspended)
+		_, err = uc.ListUsers(context.Background(), u.user.ID, 0, 10)
+		require.ErrorIs(t, err, auth.ErrForbidden)
+		require.ErrorIs(t, uc.SuspendUser(context.Background(), u.moderator.ID, u.user.ID, "spam"), auth.ErrInvalidInput)
+
+		require.Nil(t, uc.UnsuspendUser(context.Background(), u.admin.ID, u.user.ID))
+		require.Equal(t, auth.AuditEvent{ActorID: u.admin.ID, Action: auth.AuditActionUnsuspendUser, UserID: u.user.ID, OccurredAt: clock.Now()}, lastEvent(events))
+		_, err = uc.Login(context.Background(), u.user.Username, u.user.Password)
+		require.Nil(t, err)
+		require.ErrorIs(t, uc.UnsuspendUser(context.Background(), u.admin.ID, u.user.ID), auth.ErrInvalidInput)
+	})
+
+	t.Run(`#AssignRole needs roles:assign, and revokes the sessions of the user`, func(t *testing.T) {
+		uc, events, clock, u := setup(t)
+		token, err := uc.Login(context.Background(), u.user.Username, u.user.Password)
+		require.Nil(t, err)
+
+		require.ErrorIs(t, uc.AssignRole(context.Background(), u.moderator.ID, u.user.ID, auth.RoleModerator), auth.ErrForbidden)
+		require.ErrorIs(t, uc.AssignRole(context.Background(), u.admin.ID, u.admin.ID, auth.RoleUser), auth.ErrInvalidInput)
+		require.ErrorIs(t, uc.AssignRole(context.Background(), u.admin.ID, u.user.ID, "superuser"), auth.ErrInvalidInput)
+
+		require.Nil(t, uc.AssignRole(context.Background(), u.admin.ID, u.user.ID, auth.RoleModerator))
+		require.Equal(t, auth.AuditEvent{ActorID: u.admin.ID, Action: auth.AuditActionAssignRole, UserID: u.user.ID, Details: "moderator", OccurredAt: clock.Now()}, lastEvent(events))
+		_, err = uc.VerifyToken(context.Background(), token)
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+		token, err = uc.Login(context.Background(), u.user.Username, u.user.Password)
+		require.Nil(t, err)
+		claims, err := uc.VerifyToken(context.Background(), token)
+		require.Nil(t, err)
+		require.True(t, claims.Can(auth.PermissionSuspendUsers))
+	})
+
+	t.Run(`#AssignRoleAsOperator is audited without an actor`, func(t *testing.T) {
+		uc, events, clock, u := setup(t)
+		require.Nil(t, uc.AssignRoleAsOperator(context.Background(), u.user.ID, auth.RoleAdmin))
+		require.Equal(t, auth.AuditEvent{Action: auth.AuditActionAssignRole, UserID: u.user.ID, Details: "admin", OccurredAt: clock.Now()}, lastEvent(events))
+		require.ErrorIs(t, uc.AssignRoleAsOperator(context.Background(), 1<<30, auth.RoleAdmin), auth.ErrInvalidInput)
+	})
+
+	t.Run(`#GetUser returns the whole account to the user and to users:read, the public profile to others`, func(t *testing.T) {
+		uc, _, clock, u := setup(t)
+		own, err := uc.GetUser(context.Background(), u.user.ID, u.user.ID)
+		require.Nil(t, err)
+		require.Equal(t, u.user.Email, own.Email)
+		require.Empty(t, own.Password, "password hashes should not be returned")
+
+		seen, err := uc.GetUser(context.Background(), u.moderator.ID, u.user.ID)
+		require.Nil(t, err)
+		require.Equal(t, u.user.Email, seen.Email)
+
+		public, err := uc.GetUser(context.Background(), u.user.ID, u.moderator.ID)
+		require.Nil(t, err)
+		require.Equal(t, u.moderator.Username, public.Username)
+		require.Empty(t, public.Email)
+		require.Empty(t, public.Role)
+
+		require.Nil(t, uc.SuspendUser(context.Background(), u.moderator.ID, u.user.ID, "spam"))
+		_, err = uc.GetUser(context.Background(), u.user.ID, u.user.ID)
+		require.Nil(t, err)
+		seen, err = uc.GetUser(context.Background(), u.admin.ID, u.user.ID)
+		require.Nil(t, err)
+		require.Equal(t, clock.Now(), seen.SuspendedAt)
+		_, err = uc.GetUser(context.Background(), u.moderator.ID+u.admin.ID+u.user.ID, u.user.ID)
+		require.ErrorIs(t, err, auth.ErrUserNotFound, "suspended users should be hidden from the public")
+		_, err = uc.GetUser(context.Background(), u.admin.ID, 0)
+		require.ErrorIs(t, err, auth.ErrUserNotFound)
+	})
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Session is a device the user logged in from. Every token issued by Login belongs to a session,
+// so it can be revoked without logging the user out of their other devices
+type Session struct {
+	ID        string
+	UserID    int
+	IP        string
+	UserAgent string
+	CreatedAt time.Time
+	// StartedAt is the time of the login, RefreshToken carries it forward to the new session
+	StartedAt time.Time
+	ExpiresAt time.Time
+	// RevokedAt is zero unless the session is revoked by RevokeSession or RevokeSessions
+	RevokedAt time.Time
+}
+
+// LoginEvent is a login attempt to the account of a user, successful or not
+type LoginEvent struct {
+	UserID    int
+	IP        string
+	UserAgent string
+	// Outcome is OutcomeSuccess if a token is issued, e.g. OutcomeMFARequired if the login continues with CompleteMFA
+	Outcome   Outcome
+	CreatedAt time.Time
+}
+
+// maxUserAgentLength limits the user agents saved with the sessions and the login events, clients choose them
+const maxUserAgentLength = 512
+
+func truncateUserAgent(ua string) string {
+	if len(ua) > maxUserAgentLength {
+		return ua[:maxUserAgentLength]
+	}
+	return ua
+}
+
+// newSession creates the session of a token that expires at expiresAt, continuing a login at startedAt
+func (c Usecases) newSession(ctx context.Context, userID int, startedAt, expiresAt time.Time) (Session, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Session{}, err
+	}
+	client := ClientInfoFrom(ctx)
+	session := Session{
+		ID:        hex.EncodeToString(id),
+		UserID:    userID,
+		IP:        client.IP,
+		UserAgent: truncateUserAgent(client.UserAgent),
+		CreatedAt: c.now(),
+		StartedAt: startedAt,
+		ExpiresAt: expiresAt,
+	}
+	return session, c.Storage.CreateSession(ctx, session)
+}
+
+// recordLoginEvent saves the login attempt with the ClientInfo of ctx. Attempts to unknown usernames are not recorded,
+// there is no account to show them to. A failure is only recorded to the span, it shouldn't fail the login
+func (c Usecases) recordLoginEvent(ctx context.Context, userID int, loginErr error) {
+	if userID == 0 {
+		return
+	}
+	client := ClientInfoFrom(ctx)
+	err := c.Storage.RecordLoginEvent(ctx, LoginEvent{
+		UserID:    userID,
+		IP:        client.IP,
+		UserAgent: truncateUserAgent(client.UserAgent),
+		Outcome:   OutcomeOf(loginErr),
+		CreatedAt: c.now(),
+	})
+	if err != nil {
+		trace.SpanFromContext(ctx).RecordError(err)
+	}
+}
+
+// ListSessions returns the active sessions of the user, the newest first
+func (c Usecases) ListSessions(ctx context.Context, userID int) (_ []Session, err error) {
+	defer c.observe("list_sessions", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.ListSessions")
+	defer tracing.EndSpan(span, &err)
+	return c.Storage.ListSessions(ctx, userID, c.now())
+}
+
+// RevokeSession logs the user out of a single session, the tokens of the session are invalid afterwards.
+// It returns ErrSessionNotFound if the session doesn't belong to the user or is already revoked
+func (c Usecases) RevokeSession(ctx context.Context, userID int, sessionID string) (err error) {
+	defer c.observe("revoke_session", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.RevokeSession")
+	defer tracing.EndSpan(span, &err)
+	return c.Storage.RevokeSession(ctx, userID, sessionID, c.now())
+}
+
+// RefreshToken exchanges a valid token for one with a new expiry, and the current role and permissions of the user.
+// The session of the old token is revoked and a new one is started, so a token can be refreshed only once.
+// The new session keeps the login time of the old one, and the tokens aren't refreshed past Tokens.MaxSessionAge.
+// It returns ErrInvalidToken for the tokens VerifyToken rejects, for the tokens issued before sessions and for the sessions too old
+func (c Usecases) RefreshToken(ctx context.Context, token string) (_ string, err error) {
+	defer c.observe("refresh_token", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.RefreshToken")
+	defer tracing.EndSpan(span, &err)
+
+	claims, err := c.VerifyToken(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	if claims.SessionID == "" {
+		return "", fmt.Errorf("%w: token has no session", ErrInvalidToken)
+	}
+	session, err := c.Storage.FindSession(ctx, claims.SessionID)
+	if err != nil {
+		return "", err
+	}
+	if !c.now().Before(session.StartedAt.Add(c.Tokens.withDefaults().MaxSessionAge)) {
+		return "", fmt.Errorf("%w: session is too old to be refreshed", ErrInvalidToken)
+	}
+	user, err := c.Storage.FindUserByID(ctx, claims.UserID)
+	if err != nil {
+		return "", err
+	}
+	// a concurrent refresh of the same token revokes the session first
+	err = c.Storage.RevokeSession(ctx, user.ID, claims.SessionID, c.now())
+	if errors.Is(err, ErrSessionNotFound) {
+		return "", fmt.Errorf("%w: session is revoked", ErrInvalidToken)
+	}
+	if err != nil {
+		return "", err
+	}
+	return c.issueSessionToken(ctx, user, session.StartedAt)
+}
+
+// LoginHistory returns up to limit login attempts to the account of the user, the newest first
+func (c Usecases) LoginHistory(ctx context.Context, userID, limit int) (_ []LoginEvent, err error) {
+	defer c.observe("login_history", time.Now(), &err)
+	ctx, span := c.tracer().Start(ctx, "Usecases.LoginHistory")
+	defer tracing.EndSpan(span, &err)
+	return c.Storage.ListLoginEvents(ctx, userID, limit)
+}
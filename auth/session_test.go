@@ -0,0 +1,160 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/mailer"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessions(t *testing.T) {
+	setup := func(t *testing.T) (auth.Usecases, *fakeClock, auth.User) {
+		clock := &fakeClock{now: time.Date(2021, 7, 19, 13, 11, 26, 0, time.UTC)}
+		uc := test_helpers.NewUsecases(inmemory.New(), mailer.NewInMemory())
+		uc.Now = clock.Now
+		user := test_helpers.HopefullyUniqueUser()
+		created, err := uc.SignUpUser(context.Background(), user)
+		require.Nil(t, err)
+		created.Password = user.Password
+		return uc, clock, created
+	}
+	client := func(ip, userAgent string) context.Context {
+		return auth.WithClientInfo(context.Background(), auth.ClientInfo{IP: ip, UserAgent: userAgent})
+	}
+
+	t.Run(`#Login starts a session per device, and #RevokeSession logs out only one of them`, func(t *testing.T) {
+		uc, clock, user := setup(t)
+		phone, err := uc.Login(client("203.0.113.7", "phone"), user.Username, user.Password)
+		require.Nil(t, err)
+		clock.Advance(time.Minute)
+		laptop, err := uc.Login(client("198.51.100.2", "laptop"), user.Username, user.Password)
+		require.Nil(t, err)
+
+		sessions, err := uc.ListSessions(context.Background(), user.ID)
+		require.Nil(t, err)
+		require.Len(t, sessions, 2)
+		require.Equal(t, "laptop", sessions[0].UserAgent, "newest session should be first")
+		require.Equal(t, "198.51.100.2", sessions[0].IP)
+		require.Equal(t, "phone", sessions[1].UserAgent)
+		phoneClaims, err := uc.VerifyToken(context.Background(), phone)
+		require.Nil(t, err)
+		require.Equal(t, sessions[1].ID, phoneClaims.SessionID)
+
+		other, err := uc.SignUpUser(context.Background(), test_helpers.HopefullyUniqueUser())
+		require.Nil(t, err)
+		require.ErrorIs(t, uc.RevokeSession(context.Background(), other.ID, phoneClaims.SessionID), auth.ErrSessionNotFound)
+
+		require.Nil(t, uc.RevokeSession(context.Background(), user.ID, phoneClaims.SessionID))
+		_, err = uc.VerifyToken(context.Background(), phone)
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+		_, err = uc.VerifyToken(context.Background(), laptop)
+		require.Nil(t, err, "other sessions should stay valid")
+		require.ErrorIs(t, uc.RevokeSession(context.Background(), user.ID, phoneClaims.SessionID), auth.ErrSessionNotFound)
+		sessions, err = uc.ListSessions(context.Background(), user.ID)
+		require.Nil(t, err)
+		require.Len(t, sessions, 1)
+	})
+
+	t.Run(`expired sessions and sessions revoked by #ChangePassword are not listed`, func(t *testing.T) {
+		uc, clock, user := setup(t)
+		_, err := uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+		clock.Advance(24 * time.Hour)
+		sessions, err := uc.ListSessions(context.Background(), user.ID)
+		require.Nil(t, err)
+		require.Empty(t, sessions)
+
+		_, err = uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+		require.Nil(t, uc.ChangePassword(context.Background(), user.ID, user.Password, "a-new-long-password"))
+		sessions, err = uc.ListSessions(context.Background(), user.ID)
+		require.Nil(t, err)
+		require.Empty(t, sessions)
+	})
+
+	t.Run(`#LoginHistory contains the successful and the failed logins, the newest first`, func(t *testing.T) {
+		uc, clock, user := setup(t)
+		_, err := uc.Login(client("203.0.113.7", "attacker"), user.Username, "wrong-password")
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+		clock.Advance(time.Minute)
+		_, err = uc.Login(client("198.51.100.2", "laptop"), user.Username, user.Password)
+		require.Nil(t, err)
+		_, err = uc.Login(client("203.0.113.7", "attacker"), "unknown-"+user.Username, "wrong-password")
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+		history, err := uc.LoginHistory(context.Background(), user.ID, 10)
+		require.Nil(t, err)
+		require.Equal(t, []auth.LoginEvent{
+			{UserID: user.ID, IP: "198.51.100.2", UserAgent: "laptop", Outcome: auth.OutcomeSuccess, CreatedAt: clock.Now()},
+			{UserID: user.ID, IP: "203.0.113.7", UserAgent: "attacker", Outcome: auth.OutcomeInvalidCredentials, CreatedAt: clock.Now().Add(-time.Minute)},
+		}, history)
+
+		history, err = uc.LoginHistory(context.Background(), user.ID, 1)
+		require.Nil(t, err)
+		require.Len(t, history, 1)
+	})
+
+	t.Run(`#RefreshToken issues a token with the current role once, and revokes the old session`, func(t *testing.T) {
+		uc, clock, user := setup(t)
+		token, err := uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+		old, err := uc.VerifyToken(context.Background(), token)
+		require.Nil(t, err)
+		require.Nil(t, uc.Storage.SetRole(context.Background(), user.ID, auth.RoleModerator))
+		clock.Advance(time.Hour)
+
+		refreshed, err := uc.RefreshToken(client("203.0.113.7", "phone"), token)
+		require.Nil(t, err)
+		claims, err := uc.VerifyToken(context.Background(), refreshed)
+		require.Nil(t, err)
+		require.Equal(t, user.ID, claims.UserID)
+		require.NotEqual(t, old.SessionID, claims.SessionID)
+		require.Equal(t, old.ExpiresAt.Add(time.Hour), claims.ExpiresAt)
+		require.Equal(t, auth.RoleModerator, claims.Role)
+
+		_, err = uc.VerifyToken(context.Background(), token)
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+		_, err = uc.RefreshToken(context.Background(), token)
+		require.ErrorIs(t, err, auth.ErrInvalidToken, "tokens should be refreshed once")
+		sessions, err := uc.ListSessions(context.Background(), user.ID)
+		require.Nil(t, err)
+		require.Len(t, sessions, 1)
+		require.Equal(t, "phone", sessions[0].UserAgent)
+
+		clock.Advance(24 * time.Hour)
+		_, err = uc.RefreshToken(context.Background(), refreshed)
+		require.ErrorIs(t, err, auth.ErrInvalidToken, "expired tokens should not be refreshed")
+	})
+
+	t.Run(`#RefreshToken keeps the login time, and doesn't refresh past the maximum session age`, func(t *testing.T) {
+		uc, clock, user := setup(t)
+		uc.Tokens.MaxSessionAge = 36 * time.Hour
+		loggedInAt := clock.Now()
+		token, err := uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+
+		clock.Advance(23 * time.Hour)
+		token, err = uc.RefreshToken(context.Background(), token)
+		require.Nil(t, err)
+		claims, err := uc.VerifyToken(context.Background(), token)
+		require.Nil(t, err)
+		require.Equal(t, loggedInAt.Add(36*time.Hour), claims.ExpiresAt, "tokens should not outlive the maximum session age")
+		sessions, err := uc.ListSessions(context.Background(), user.ID)
+		require.Nil(t, err)
+		require.Len(t, sessions, 1)
+		require.Equal(t, loggedInAt, sessions[0].StartedAt)
+		require.Equal(t, clock.Now(), sessions[0].CreatedAt)
+
+		clock.Advance(12 * time.Hour)
+		uc.Tokens.MaxSessionAge = 30 * time.Hour
+		_, err = uc.RefreshToken(context.Background(), token)
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+		_, err = uc.VerifyToken(context.Background(), token)
+		require.Nil(t, err, "a refused refresh should not revoke the session")
+	})
+}
@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Signing algorithms of SigningKey, the "alg" header of the tokens
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// SigningKey is a private key that signs the tokens of Login, see TokenOptions for the rotation
+type SigningKey struct {
+	// ID is the "kid" header of the tokens signed with the key
+	ID string
+	// PrivateKey is a *rsa.PrivateKey for RS256 or an ed25519.PrivateKey for EdDSA
+	PrivateKey crypto.Signer
+	// ActiveFrom is when the key starts signing. The key is published in JWKS before, so verifiers can fetch it in advance
+	ActiveFrom time.Time
+}
+
+// Algorithm returns the signing algorithm of the key, or an empty string if the key type isn't supported
+func (k SigningKey) Algorithm() string {
+	switch k.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		return AlgorithmRS256
+	case ed25519.PrivateKey:
+		return AlgorithmEdDSA
+	}
+	return ""
+}
+
+func (k SigningKey) signingMethod() jwt.SigningMethod {
+	if k.Algorithm() == AlgorithmEdDSA {
+		return signingMethodEdDSA{}
+	}
+	return jwt.SigningMethodRS256
+}
+
+// ParseSigningKey parses a PKCS #8 PEM encoded RSA or Ed25519 private key, see the keygen command of the auth binary
+func ParseSigningKey(id string, pemBytes []byte, activeFrom time.Time) (SigningKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return SigningKey{}, fmt.Errorf("signing key %q is not PEM encoded", id)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("signing key %q: %w", id, err)
+	}
+	signer, _ := parsed.(crypto.Signer)
+	key := SigningKey{ID: id, PrivateKey: signer, ActiveFrom: activeFrom}
+	if signer == nil || key.Algorithm() == "" {
+		return SigningKey{}, fmt.Errorf("signing key %q has unsupported type %T", id, parsed)
+	}
+	return key, nil
+}
+
+// TokenOptions configures the tokens of Login. Zero values are replaced with the defaults below.
+//
+// Keys are rotated by adding a key with a future ActiveFrom. The latest active key signs the new tokens,
+// and a replaced key is still accepted and published for TTL, until the tokens it signed expire.
+// If there are no Keys, the tokens are signed with HS256 and the Secret, so every verifier needs the secret
+type TokenOptions struct {
+	Keys []SigningKey
+	// Secret signs the tokens if there are no Keys. If it is empty too, a random secret of the process signs them,
+	// so the tokens are invalid after a restart and on the other instances
+	Secret []byte
+	// TTL is how long the tokens are valid. Defaults to 24 hours
+	TTL time.Duration
+	// MaxSessionAge is how long after the login the tokens of a session can be refreshed, the user logs in again afterwards.
+	// Defaults to 30 days
+	MaxSessionAge time.Duration
+}
+
+func (o TokenOptions) withDefaults() TokenOptions {
+	if o.TTL == 0 {
+		o.TTL = 24 * time.Hour
+	}
+	if o.MaxSessionAge == 0 {
+		o.MaxSessionAge = 30 * 24 * time.Hour
+	}
+	return o
+}
+
+// minSecretLength is the size of the SHA-256 output, the minimum HS256 key size of RFC 7518
+const minSecretLength = 32
+
+// processSecret is the Secret of the options without one
+var processSecret = func() []byte {
+	secret := make([]byte, minSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}()
+
+func (o TokenOptions) secret() []byte {
+	if len(o.Secret) == 0 {
+		return processSecret
+	}
+	return o.Secret
+}
+
+// Validate checks the keys are supported, big enough and have unique IDs, and the secret is big enough
+func (o TokenOptions) Validate() error {
+	if len(o.Secret) > 0 && len(o.Secret) < minSecretLength {
+		return fmt.Errorf("token secret is shorter than %d bytes", minSecretLength)
+	}
+	ids := map[string]bool{}
+	for _, k := range o.Keys {
+		if k.ID == "" {
+			return errors.New("signing key without ID")
+		}
+		if ids[k.ID] {
+			return fmt.Errorf("duplicate signing key ID %q", k.ID)
+		}
+		ids[k.ID] = true
+		switch key := k.PrivateKey.(type) {
+		case *rsa.PrivateKey:
+			if key.N.BitLen() < 2048 {
+				return fmt.Errorf("RSA signing key %q is shorter than 2048 bits", k.ID)
+			}
+		case ed25519.PrivateKey:
+		default:
+			return fmt.Errorf("signing key %q has unsupported type %T", k.ID, k.PrivateKey)
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns the keys from the latest ActiveFrom to the earliest
+func (o TokenOptions) sortedKeys() []SigningKey {
+	keys := append([]SigningKey(nil), o.Keys...)
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].ActiveFrom.After(keys[j].ActiveFrom) })
+	return keys
+}
+
+// signingKey returns the latest key active at now
+func (o TokenOptions) signingKey(now time.Time) (SigningKey, error) {
+	for _, k := range o.sortedKeys() {
+		if !k.ActiveFrom.After(now) {
+			return k, nil
+		}
+	}
+	return SigningKey{}, errors.New("no signing key is active yet")
+}
+
+// verificationKeys returns the keys whose tokens can still be valid at now: the upcoming keys, the signing key,
+// and the keys replaced less than TTL ago
+func (o TokenOptions) verificationKeys(now time.Time) []SigningKey {
+	o = o.withDefaults()
+	var keys []SigningKey
+	for _, k := range o.sortedKeys() {
+		keys = append(keys, k)
+		if !k.ActiveFrom.Add(o.TTL).After(now) {
+			// the keys before were replaced by this one at least TTL ago
+			break
+		}
+	}
+	return keys
+}
+
+// JSONWebKey is the public part of a SigningKey (RFC 7517)
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	// N and E are the modulus and the exponent of RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Curve and X are the curve and the public key of OKP keys (RFC 8037)
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JSONWebKeySet is served on /.well-known/jwks.json
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns the public keys the tokens can be verified with now, including the upcoming ones.
+// It is empty if the tokens are signed with HS256
+func (c Usecases) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, k := range c.Tokens.verificationKeys(c.now()) {
+		jwk := JSONWebKey{KeyID: k.ID, Algorithm: k.Algorithm(), Use: "sig"}
+		switch public := k.PrivateKey.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// signToken signs the claims with the current key, or with the secret if there are no keys
+func (c Usecases) signToken(claims jwt.MapClaims) (string, error) {
+	if len(c.Tokens.Keys) == 0 {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(c.Tokens.secret())
+	}
+	key, err := c.Tokens.signingKey(c.now())
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.signingMethod(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+// verificationKey is the jwt.Keyfunc of VerifyToken
+func (c Usecases) verificationKey(t *jwt.Token) (interface{}, error) {
+	if len(c.Tokens.Keys) == 0 {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return c.Tokens.secret(), nil
+	}
+	kid, _ := t.Header["kid"].(string)
+	for _, k := range c.Tokens.verificationKeys(c.now()) {
+		if k.ID != kid {
+			continue
+		}
+		// the algorithm comes from the key, never from the token, so a public key can't be used as an HMAC secret
+		if t.Method.Alg() != k.Algorithm() {
+			return nil, fmt.Errorf("unexpected signing method %v for key %q", t.Header["alg"], kid)
+		}
+		return k.PrivateKey.Public(), nil
+	}
+	return nil, fmt.Errorf("unknown or retired key %q", kid)
+}
+
+// signingMethodEdDSA signs tokens with Ed25519 (RFC 8037), which the jwt package doesn't support
+type signingMethodEdDSA struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(AlgorithmEdDSA, func() jwt.SigningMethod { return signingMethodEdDSA{} })
+}
+
+func (signingMethodEdDSA) Alg() string {
+	return AlgorithmEdDSA
+}
+
+func (signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(private, []byte(signingString))), nil
+}
+
+func (signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(public, []byte(signingString), sig) {
+		return errors.New("ed25519: verification error")
+	}
+	return nil
+}
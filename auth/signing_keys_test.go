@@ -0,0 +1,147 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/mailer"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigningKeys(t *testing.T) {
+	start := time.Date(2021, 7, 19, 13, 11, 26, 0, time.UTC)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+
+	setup := func(t *testing.T, keys ...auth.SigningKey) (auth.Usecases, *fakeClock, auth.User) {
+		clock := &fakeClock{now: start}
+		uc := test_helpers.NewUsecases(inmemory.New(), mailer.NewInMemory())
+		uc.Now = clock.Now
+		uc.Tokens = auth.TokenOptions{Keys: keys, TTL: time.Hour}
+		require.Nil(t, uc.Tokens.Validate())
+		user := test_helpers.HopefullyUniqueUser()
+		created, err := uc.SignUpUser(context.Background(), user)
+		require.Nil(t, err)
+		created.Password = user.Password
+		return uc, clock, created
+	}
+	header := func(t *testing.T, token string) map[string]interface{} {
+		parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+		require.Nil(t, err)
+		return parsed.Header
+	}
+
+	for alg, key := range map[string]auth.SigningKey{
+		auth.AlgorithmEdDSA: {ID: "ed", PrivateKey: edKey},
+		auth.AlgorithmRS256: {ID: "rsa", PrivateKey: rsaKey},
+	} {
+		key := key
+		t.Run(alg+` tokens carry the kid and are verified until they expire`, func(t *testing.T) {
+			uc, clock, user := setup(t, key)
+			token, err := uc.Login(context.Background(), user.Username, user.Password)
+			require.Nil(t, err)
+			require.Equal(t, alg, header(t, token)["alg"])
+			require.Equal(t, key.ID, header(t, token)["kid"])
+
+			claims, err := uc.VerifyToken(context.Background(), token)
+			require.Nil(t, err)
+			require.Equal(t, user.ID, claims.UserID)
+			require.Equal(t, start.Add(time.Hour), claims.ExpiresAt)
+
+			clock.Advance(time.Hour)
+			_, err = uc.VerifyToken(context.Background(), token)
+			require.ErrorIs(t, err, auth.ErrInvalidToken)
+		})
+	}
+
+	t.Run(`replaced keys are accepted and published until their tokens expire`, func(t *testing.T) {
+		old := auth.SigningKey{ID: "old", PrivateKey: rsaKey}
+		next := auth.SigningKey{ID: "next", PrivateKey: edKey, ActiveFrom: start.Add(10 * time.Minute)}
+		uc, clock, user := setup(t, next, old)
+		kids := func() []string {
+			var kids []string
+			for _, k := range uc.JWKS().Keys {
+				kids = append(kids, k.KeyID)
+			}
+			return kids
+		}
+		require.Equal(t, []string{"next", "old"}, kids(), "upcoming key should be published in advance")
+
+		oldToken, err := uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+		require.Equal(t, "old", header(t, oldToken)["kid"])
+
+		clock.Advance(10 * time.Minute)
+		newToken, err := uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+		require.Equal(t, "next", header(t, newToken)["kid"])
+		_, err = uc.VerifyToken(context.Background(), oldToken)
+		require.Nil(t, err, "tokens of the replaced key should be valid until they expire")
+
+		clock.Advance(time.Hour)
+		require.Equal(t, []string{"next"}, kids())
+		_, err = uc.VerifyToken(context.Background(), oldToken)
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+
+	t.Run(`tokens can't choose the algorithm of a key`, func(t *testing.T) {
+		uc, _, _ := setup(t, auth.SigningKey{ID: "rsa", PrivateKey: rsaKey})
+		public, err := x509.MarshalPKIXPublicKey(rsaKey.Public())
+		require.Nil(t, err)
+		forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"ID":  "1",
+			"exp": start.Add(time.Hour).Unix(),
+		})
+		forged.Header["kid"] = "rsa"
+		token, err := forged.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}))
+		require.Nil(t, err)
+		_, err = uc.VerifyToken(context.Background(), token)
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+
+	t.Run(`ParseSigningKey reads PKCS #8 PEM keys`, func(t *testing.T) {
+		for _, private := range []interface{}{edKey, rsaKey} {
+			der, err := x509.MarshalPKCS8PrivateKey(private)
+			require.Nil(t, err)
+			key, err := auth.ParseSigningKey("kid", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), start)
+			require.Nil(t, err)
+			require.Equal(t, private, key.PrivateKey)
+		}
+		_, err := auth.ParseSigningKey("kid", []byte("not a key"), start)
+		require.NotNil(t, err)
+	})
+
+	t.Run(`without keys, tokens are signed with HS256 and the Secret`, func(t *testing.T) {
+		uc, _, user := setup(t)
+		uc.Tokens.Secret = []byte("a-secret-of-at-least-thirty-two-bytes")
+		token, err := uc.Login(context.Background(), user.Username, user.Password)
+		require.Nil(t, err)
+		require.Equal(t, "HS256", header(t, token)["alg"])
+		_, err = uc.VerifyToken(context.Background(), token)
+		require.Nil(t, err)
+
+		uc.Tokens.Secret = []byte("another-secret-of-at-least-thirty-two-bytes")
+		_, err = uc.VerifyToken(context.Background(), token)
+		require.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+
+	t.Run(`Validate rejects duplicate IDs, short RSA keys and short secrets`, func(t *testing.T) {
+		short, err := rsa.GenerateKey(rand.Reader, 1024)
+		require.Nil(t, err)
+		require.NotNil(t, auth.TokenOptions{Keys: []auth.SigningKey{{ID: "rsa", PrivateKey: short}}}.Validate())
+		require.NotNil(t, auth.TokenOptions{Keys: []auth.SigningKey{{ID: "a", PrivateKey: edKey}, {ID: "a", PrivateKey: rsaKey}}}.Validate())
+		require.NotNil(t, auth.TokenOptions{Secret: []byte("jwt_secret")}.Validate())
+	})
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/tracing"
+	"github.com/lib/pq"
+)
+
+func (s Postgres) CreateAPIKey(ctx context.Context, key auth.APIKey) (err error) {
+	scopes := make([]string, 0, len(key.Scopes))
+	for _, scope := range key.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+	query := s.qb.Insert("api_keys").
+		Columns("id", "user_id", "name", "prefix", "hash", "scopes", "created_at", "expires_at", "last_used_at", "revoked_at").
+		Values(key.ID, key.UserID, key.Name, key.Prefix, key.Hash, pq.Array(scopes), key.CreatedAt, key.ExpiresAt,
+			nullTime(key.LastUsedAt), nullTime(key.RevokedAt))
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+	ctx, span := s.startSpan(ctx, "CreateAPIKey", sql)
+	defer tracing.EndSpan(span, &err)
+	_, err = s.db.ExecContext(ctx, sql, args...)
+	if isForeignKeyViolation(err) {
+		return auth.ErrUserNotFound
+	}
+	return err
+}
+
+const apiKeyColumns = "id, user_id, name, prefix, hash, scopes, created_at, expires_at, last_used_at, revoked_at"
+
+func scanAPIKey(row squirrel.RowScanner) (auth.APIKey, error) {
+	key := auth.APIKey{}
+	var scopes []string
+	var lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Hash, pq.Array(&scopes), &key.CreatedAt, &key.ExpiresAt,
+		&lastUsedAt, &revokedAt)
+	if isNoRows(err) {
+		return auth.APIKey{}, auth.ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return auth.APIKey{}, err
+	}
+	for _, scope := range scopes {
+		key.Scopes = append(key.Scopes, auth.Permission(scope))
+	}
+	key.CreatedAt = key.CreatedAt.UTC()
+	key.ExpiresAt = key.ExpiresAt.UTC()
+	key.LastUsedAt = timeOf(lastUsedAt)
+	key.RevokedAt = timeOf(revokedAt)
+	return key, nil
+}
+
+func (s Postgres) FindAPIKeyByHash(ctx context.Context, hash string) (_ auth.APIKey, err error) {
+	query := s.qb.Select(apiKeyColumns).From("api_keys").
+		Where(squirrel.Eq{"hash": hash})
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return auth.APIKey{}, err
+	}
+	ctx, span := s.startSpan(ctx, "FindAPIKeyByHash", sql)
+	defer tracing.EndSpan(span, &err)
+	return scanAPIKey(s.db.QueryRowContext(ctx, sql, args...))
+}
+
+func (s Postgres) ListAPIKeys(ctx context.Context, userID int) (_ []auth.APIKey, err error) {
+	query := s.qb.Select(apiKeyColumns).From("api_keys").
+		Where(squirrel.Eq{"user_id": userID, "revoked_at": nil}).
+		OrderBy("created_at DESC")
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := s.startSpan(ctx, "ListAPIKeys", sql)
+	defer tracing.EndSpan(span, &err)
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []auth.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey checks the owner and sets revoked_at in a single statement
+func (s Postgres) RevokeAPIKey(ctx context.Context, userID int, ID string, at time.Time) error {
+	query := s.qb.Update("api_keys").
+		Set("revoked_at", at).
+		Where(squirrel.Eq{"id": ID, "user_id": userID, "revoked_at": nil})
+	return s.execOne(ctx, "RevokeAPIKey", query, auth.ErrAPIKeyNotFound)
+}
+
+func (s Postgres) TouchAPIKey(ctx context.Context, ID string, at time.Time) error {
+	query := s.qb.Update("api_keys").
+		Set("last_used_at", at).
+		Where(squirrel.Eq{"id": ID})
+	return s.execOne(ctx, "TouchAPIKey", query, auth.ErrAPIKeyNotFound)
+}
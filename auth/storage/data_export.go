@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/tracing"
+)
+
+func (s Postgres) AppendUserEvent(ctx context.Context, record auth.UserEventRecord) (err error) {
+	query := s.qb.Insert("user_events").
+		Columns("user_id", "type", "payload", "published_at").
+		Values(record.UserID, record.Type, string(record.Payload), record.PublishedAt)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+	ctx, span := s.startSpan(ctx, "AppendUserEvent", sql)
+	defer tracing.EndSpan(span, &err)
+	_, err = s.db.ExecContext(ctx, sql, args...)
+	if isForeignKeyViolation(err) {
+		return auth.ErrUserNotFound
+	}
+	return err
+}
+
+func (s Postgres) ListUserEvents(ctx context.Context, userID int) (_ []auth.UserEventRecord, err error) {
+	query := s.qb.Select("user_id, type, payload, published_at").From("user_events").
+		Where(squirrel.Eq{"user_id": userID}).
+		OrderBy("id")
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := s.startSpan(ctx, "ListUserEvents", sql)
+	defer tracing.EndSpan(span, &err)
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []auth.UserEventRecord
+	for rows.Next() {
+		r := auth.UserEventRecord{}
+		if err := rows.Scan(&r.UserID, &r.Type, &r.Payload, &r.PublishedAt); err != nil {
+			return nil, err
+		}
+		r.PublishedAt = r.PublishedAt.UTC()
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+const dataExportColumns = "id, user_id, status, blob_key, error, created_at, started_at, completed_at, expires_at"
+
+func scanDataExport(row squirrel.RowScanner) (auth.DataExport, error) {
+	e := auth.DataExport{}
+	var startedAt, completedAt, expiresAt sql.NullTime
+	err := row.Scan(&e.ID, &e.UserID, &e.Status, &e.BlobKey, &e.Error, &e.CreatedAt, &startedAt, &completedAt, &expiresAt)
+	if isNoRows(err) {
+		return auth.DataExport{}, auth.ErrDataExportNotFound
+	}
+	if err != nil {
+		return auth.DataExport{}, err
+	}
+	e.CreatedAt = e.CreatedAt.UTC()
+	e.StartedAt = timeOf(startedAt)
+	e.CompletedAt = timeOf(completedAt)
+	e.ExpiresAt = timeOf(expiresAt)
+	return e, nil
+}
+
+// timeOf is the inverse of nullTime
+func timeOf(t sql.NullTime) time.Time {
+	if !t.Valid {
+		return time.Time{}
+	}
+	return t.Time.UTC()
+}
+
+func (s Postgres) CreateDataExport(ctx context.Context, export auth.DataExport) (err error) {
+	query := s.qb.Insert("data_exports").
+		Columns("id", "user_id", "status", "blob_key", "error", "created_at", "started_at", "completed_at", "expires_at").
+		Values(export.ID, export.UserID, string(export.Status), export.BlobKey, export.Error, export.CreatedAt,
+			nullTime(export.StartedAt), nullTime(export.CompletedAt), nullTime(export.ExpiresAt))
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+	ctx, span := s.startSpan(ctx, "CreateDataExport", sql)
+	defer tracing.EndSpan(span, &err)
+	_, err = s.db.ExecContext(ctx, sql, args...)
+	if isForeignKeyViolation(err) {
+		return auth.ErrUserNotFound
+	}
+	return err
+}
+
+// ClaimDataExport selects and updates the export in a single statement. SKIP LOCKED lets concurrent builders
+// claim the next export instead of waiting for the same one
+func (s Postgres) ClaimDataExport(ctx context.Context, now, staleBefore time.Time) (_ auth.DataExport, err error) {
+	query := s.qb.Update("data_exports").
+		Set("status", string(auth.DataExportBuilding)).
+		Set("started_at", now).
+		Where(squirrel.Expr(`id = (
+			SELECT id FROM data_exports
+			WHERE status = ? OR (status = ? AND started_at < ?)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)`, string(auth.DataExportPending), string(auth.DataExportBuilding), staleBefore)).
+		Suffix("RETURNING " + dataExportColumns)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return auth.DataExport{}, err
+	}
+	ctx, span := s.startSpan(ctx, "ClaimDataExport", sql)
+	defer tracing.EndSpan(span, &err)
+	return scanDataExport(s.db.QueryRowContext(ctx, sql, args...))
+}
+
+func (s Postgres) UpdateDataExport(ctx context.Context, export auth.DataExport) error {
+	query := s.qb.Update("data_exports").
+		Set("status", string(export.Status)).
+		Set("blob_key", export.BlobKey).
+		Set("error", export.Error).
+		Set("started_at", nullTime(export.StartedAt)).
+		Set("completed_at", nullTime(export.CompletedAt)).
+		Set("expires_at", nullTime(export.ExpiresAt)).
+		Where(squirrel.Eq{"id": export.ID})
+	return s.execOne(ctx, "UpdateDataExport", query, auth.ErrDataExportNotFound)
+}
+
+func (s Postgres) FindDataExport(ctx context.Context, ID string) (_ auth.DataExport, err error) {
+	query := s.qb.Select(dataExportColumns).From("data_exports").
+		Where(squirrel.Eq{"id": ID})
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return auth.DataExport{}, err
+	}
+	ctx, span := s.startSpan(ctx, "FindDataExport", sql)
+	defer tracing.EndSpan(span, &err)
+	return scanDataExport(s.db.QueryRowContext(ctx, sql, args...))
+}
+
+func (s Postgres) ListDataExports(ctx context.Context, userID int) ([]auth.DataExport, error) {
+	query := s.qb.Select(dataExportColumns).From("data_exports").
+		Where(squirrel.Eq{"user_id": userID}).
+		OrderBy("created_at")
+	return s.listDataExports(ctx, "ListDataExports", query)
+}
+
+func (s Postgres) ExpiredDataExports(ctx context.Context, now time.Time, limit int) ([]auth.DataExport, error) {
+	query := s.qb.Select(dataExportColumns).From("data_exports").
+		Where(squirrel.LtOrEq{"expires_at": now}).
+		OrderBy("expires_at").
+		Limit(uint64(limit))
+	return s.listDataExports(ctx, "ExpiredDataExports", query)
+}
+
+func (s Postgres) listDataExports(ctx context.Context, operation string, query squirrel.SelectBuilder) (_ []auth.DataExport, err error) {
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := s.startSpan(ctx, operation, sql)
+	defer tracing.EndSpan(span, &err)
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var exports []auth.DataExport
+	for rows.Next() {
+		e, err := scanDataExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, e)
+	}
+	return exports, rows.Err()
+}
+
+func (s Postgres) DeleteDataExport(ctx context.Context, ID string) (err error) {
+	query := s.qb.Delete("data_exports").Where(squirrel.Eq{"id": ID})
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+	ctx, span := s.startSpan(ctx, "DeleteDataExport", sql)
+	defer tracing.EndSpan(span, &err)
+	_, err = s.db.ExecContext(ctx, sql, args...)
+	return err
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/tracing"
+)
+
+// CreateOIDCState deletes the expired states before saving the new one
+func (s Postgres) CreateOIDCState(ctx context.Context, state auth.OIDCState) (err error) {
+	deleteSQL, deleteArgs, err := s.qb.Delete("oidc_states").
+		Where(squirrel.Lt{"expires_at": squirrel.Expr("now()")}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	userID := sql.NullInt64{Int64: int64(state.UserID), Valid: state.UserID != 0}
+	insertSQL, insertArgs, err := s.qb.Insert("oidc_states").
+		Columns("hash", "provider", "verifier", "nonce", "user_id", "expires_at").
+		Values(state.Hash, state.Provider, state.Verifier, state.Nonce, userID, state.ExpiresAt).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	ctx, span := s.startSpan(ctx, "CreateOIDCState", insertSQL)
+	defer tracing.EndSpan(span, &err)
+	if _, err := s.db.ExecContext(ctx, deleteSQL, deleteArgs...); err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, insertSQL, insertArgs...)
+	if isForeignKeyViolation(err) {
+		return auth.ErrUserNotFound
+	}
+	return err
+}
+
+// ConsumeOIDCState deletes the state even if it is expired
+func (s Postgres) ConsumeOIDCState(ctx context.Context, hash string, now time.Time) (_ auth.OIDCState, err error) {
+	// declared before sql is shadowed by the query
+	var userID sql.NullInt64
+	query := s.qb.Delete("oidc_states").
+		Where(squirrel.Eq{"hash": hash}).
+		Suffix("RETURNING hash, provider, verifier, nonce, user_id, expires_at")
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return auth.OIDCState{}, err
+	}
+	ctx, span := s.startSpan(ctx, "ConsumeOIDCState", sql)
+	defer tracing.EndSpan(span, &err)
+	state := auth.OIDCState{}
+	err = s.db.QueryRowContext(ctx, sql, args...).Scan(&state.Hash, &state.Provider, &state.Verifier, &state.Nonce, &userID, &state.ExpiresAt)
+	if isNoRows(err) {
+		return auth.OIDCState{}, auth.ErrInvalidToken
+	}
+	if err != nil {
+		return auth.OIDCState{}, err
+	}
+	if !state.ExpiresAt.After(now) {
+		return auth.OIDCState{}, auth.ErrInvalidToken
+	}
+	state.UserID = int(userID.Int64)
+	state.ExpiresAt = state.ExpiresAt.UTC()
+	return state, nil
+}
+
+func (s Postgres) CreateIdentity(ctx context.Context, identity auth.Identity) (err error) {
+	query := s.qb.Insert("identities").
+		Columns("user_id", "provider", "subject", "email", "created_at").
+		Values(identity.UserID, identity.Provider, identity.Subject, identity.Email, identity.CreatedAt)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+	ctx, span := s.startSpan(ctx, "CreateIdentity", sql)
+	defer tracing.EndSpan(span, &err)
+	_, err = s.db.ExecContext(ctx, sql, args...)
+	if isUniqueViolation(err) {
+		return auth.ErrUserAlreadyExists
+	}
+	if isForeignKeyViolation(err) {
+		return auth.ErrUserNotFound
+	}
+	return err
+}
+
+const identityColumns = "user_id, provider, subject, email, created_at"
+
+func scanIdentity(row squirrel.RowScanner) (auth.Identity, error) {
+	identity := auth.Identity{}
+	err := row.Scan(&identity.UserID, &identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt)
+	if isNoRows(err) {
+		return auth.Identity{}, auth.ErrIdentityNotFound
+	}
+	if err != nil {
+		return auth.Identity{}, err
+	}
+	identity.CreatedAt = identity.CreatedAt.UTC()
+	return identity, nil
+}
+
+func (s Postgres) FindIdentity(ctx context.Context, provider, subject string) (_ auth.Identity, err error) {
+	query := s.qb.Select(identityColumns).From("identities").
+		Where(squirrel.Eq{"provider": provider, "subject": subject})
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return auth.Identity{}, err
+	}
+	ctx, span := s.startSpan(ctx, "FindIdentity", sql)
+	defer tracing.EndSpan(span, &err)
+	return scanIdentity(s.db.QueryRowContext(ctx, sql, args...))
+}
+
+func (s Postgres) ListIdentities(ctx context.Context, userID int) (_ []auth.Identity, err error) {
+	query := s.qb.Select(identityColumns).From("identities").
+		Where(squirrel.Eq{"user_id": userID}).
+		OrderBy("provider")
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := s.startSpan(ctx, "ListIdentities", sql)
+	defer tracing.EndSpan(span, &err)
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var identities []auth.Identity
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+func (s Postgres) DeleteIdentity(ctx context.Context, userID int, provider string) (err error) {
+	query := s.qb.Delete("identities").
+		Where(squirrel.Eq{"user_id": userID, "provider": provider})
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+	ctx, span := s.startSpan(ctx, "DeleteIdentity", sql)
+	defer tracing.EndSpan(span, &err)
+	res, err := s.db.ExecContext(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return auth.ErrIdentityNotFound
+	}
+	return nil
+}
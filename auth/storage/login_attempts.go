@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/tracing"
+)
+
+// Postgres implements auth.LoginAttemptStore too, so the attempts are shared by all instances of the service without another database
+
+// loginAttemptColumns are the columns scanned by scanLoginAttempts, in order
+const loginAttemptColumns = "failures, last_failure_at, locked_until"
+
+func scanLoginAttempts(row squirrel.RowScanner) (auth.LoginAttempts, error) {
+	a := auth.LoginAttempts{}
+	var lockedUntil sql.NullTime
+	err := row.Scan(&a.Failures, &a.LastFailureAt, &lockedUntil)
+	if isNoRows(err) {
+		return auth.LoginAttempts{}, nil
+	}
+	if err != nil {
+		return auth.LoginAttempts{}, err
+	}
+	a.LastFailureAt = a.LastFailureAt.UTC()
+	if lockedUntil.Valid {
+		a.LockedUntil = lockedUntil.Time.UTC()
+	}
+	return a, nil
+}
+
+func (s Postgres) LoginAttempts(ctx context.Context, key string, now time.Time) (_ auth.LoginAttempts, err error) {
+	query := s.qb.Select(loginAttemptColumns).From("login_attempts").
+		Where(squirrel.Eq{"key": key}).
+		Where(squirrel.Gt{"expires_at": now})
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return auth.LoginAttempts{}, err
+	}
+	ctx, span := s.startSpan(ctx, "LoginAttempts", sql)
+	defer tracing.EndSpan(span, &err)
+	return scanLoginAttempts(s.db.QueryRowContext(ctx, sql, args...))
+}
+
+// RecordLoginFailure upserts the attempts in one statement, so concurrent failures are all counted
+func (s Postgres) RecordLoginFailure(ctx context.Context, key string, now time.Time, ttl time.Duration) (_ auth.LoginAttempts, err error) {
+	expiresAt := now.Add(ttl)
+	query := s.qb.Insert("login_attempts").
+		Columns("key", "failures", "last_failure_at", "expires_at").
+		Values(key, 1, now, expiresAt).
+		Suffix(`ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.expires_at > EXCLUDED.last_failure_at THEN login_attempts.failures + 1 ELSE 1 END,
+			locked_until = CASE WHEN login_attempts.expires_at > EXCLUDED.last_failure_at THEN login_attempts.locked_until END,
+			last_failure_at = EXCLUDED.last_failure_at,
+			expires_at = CASE WHEN login_attempts.expires_at > EXCLUDED.expires_at THEN login_attempts.expires_at ELSE EXCLUDED.expires_at END
+		RETURNING ` + loginAttemptColumns)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return auth.LoginAttempts{}, err
+	}
+	ctx, span := s.startSpan(ctx, "RecordLoginFailure", sql)
+	defer tracing.EndSpan(span, &err)
+	return scanLoginAttempts(s.db.QueryRowContext(ctx, sql, args...))
+}
+
+func (s Postgres) LockLogin(ctx context.Context, key string, until time.Time) (err error) {
+	query := s.qb.Update("login_attempts").
+		Set("locked_until", until).
+		Set("expires_at", squirrel.Expr("GREATEST(expires_at, ?)", until)).
+		Where(squirrel.Eq{"key": key})
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+	ctx, span := s.startSpan(ctx, "LockLogin", sql)
+	defer tracing.EndSpan(span, &err)
+	_, err = s.db.ExecContext(ctx, sql, args...)
+	return err
+}
+
+func (s Postgres) ResetLoginAttempts(ctx context.Context, key string) (err error) {
+	query := s.qb.Delete("login_attempts").
+		Where(squirrel.Eq{"key": key})
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+	ctx, span := s.startSpan(ctx, "ResetLoginAttempts", sql)
+	defer tracing.EndSpan(span, &err)
+	_, err = s.db.ExecContext(ctx, sql, args...)
+	return err
+}
+
+// PurgeExpiredLoginAttempts deletes up to limit keys expired at now, and returns how many it deleted.
+// Expired keys are ignored by the other methods, they are deleted to keep the table small
+func (s Postgres) PurgeExpiredLoginAttempts(ctx context.Context, now time.Time, limit int) (_ int, err error) {
+	expired := s.qb.Select("key").From("login_attempts").
+		Where(squirrel.LtOrEq{"expires_at": now}).
+		Limit(uint64(limit))
+	expiredSQL, args, err := expired.ToSql()
+	if err != nil {
+		return 0, err
+	}
+	sql := "DELETE FROM login_attempts WHERE key IN (" + expiredSQL + ")"
+
+	ctx, span := s.startSpan(ctx, "PurgeExpiredLoginAttempts", sql)
+	defer tracing.EndSpan(span, &err)
+	res, err := s.db.ExecContext(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/tracing"
+)
+
+// SaveTOTP replaces the enrolment and the recovery codes in one transaction, so the codes of an old enrolment can't remain
+func (s Postgres) SaveTOTP(ctx context.Context, totp auth.TOTP, recoveryCodeHashes []string) (err error) {
+	upsert := s.qb.Insert("totp_secrets").
+		Columns("user_id", "encrypted_secret", "confirmed_at", "last_used_step").
+		Values(totp.UserID, totp.EncryptedSecret, nullTime(totp.ConfirmedAt), totp.LastUsedStep).
+		Suffix(`ON CONFLICT (user_id) DO UPDATE SET
+			encrypted_secret = EXCLUDED.encrypted_secret,
+			confirmed_at = EXCLUDED.confirmed_at,
+			last_used_step = EXCLUDED.last_used_step,
+			created_at = now()`)
+	upsertSQL, upsertArgs, err := upsert.ToSql()
+	if err != nil {
+		return err
+	}
+	deleteSQL, deleteArgs, err := s.qb.Delete("recovery_codes").Where(squirrel.Eq{"user_id": totp.UserID}).ToSql()
+	if err != nil {
+		return err
+	}
+	insert := s.qb.Insert("recovery_codes").Columns("user_id", "hash")
+	for _, hash := range recoveryCodeHashes {
+		insert = insert.Values(totp.UserID, hash)
+	}
+
+	ctx, span := s.startSpan(ctx, "SaveTOTP", upsertSQL)
+	defer tracing.EndSpan(span, &err)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, upsertSQL, upsertArgs...); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, deleteSQL, deleteArgs...); err != nil {
+		return err
+	}
+	if len(recoveryCodeHashes) > 0 {
+		insertSQL, insertArgs, err := insert.ToSql()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, insertSQL, insertArgs...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s Postgres) FindTOTP(ctx context.Context, userID int) (_ auth.TOTP, err error) {
+	query := s.qb.Select("user_id, encrypted_secret, confirmed_at, last_used_step").From("totp_secrets").
+		Where(squirrel.Eq{"user_id": userID})
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return auth.TOTP{}, err
+	}
+	ctx, span := s.startSpan(ctx, "FindTOTP", sql)
+	defer tracing.EndSpan(span, &err)
+	return scanTOTP(s.db.QueryRowContext(ctx, sql, args...))
+}
+
+func scanTOTP(row squirrel.RowScanner) (auth.TOTP, error) {
+	totp := auth.TOTP{}
+	var confirmedAt sql.NullTime
+	err := row.Scan(&totp.UserID, &totp.EncryptedSecret, &confirmedAt, &totp.LastUsedStep)
+	if isNoRows(err) {
+		return auth.TOTP{}, auth.ErrMFANotEnrolled
+	}
+	if err != nil {
+		return auth.TOTP{}, err
+	}
+	if confirmedAt.Valid {
+		totp.ConfirmedAt = confirmedAt.Time.UTC()
+	}
+	return totp, nil
+}
+
+func (s Postgres) ConfirmTOTP(ctx context.Context, userID int, at time.Time) error {
+	query := s.qb.Update("totp_secrets").
+		Set("confirmed_at", at).
+		Where(squirrel.Eq{"user_id": userID})
+	return s.execOne(ctx, "ConfirmTOTP", query, auth.ErrMFANotEnrolled)
+}
+
+// UseTOTPStep compares and sets the step in a single statement, so a code can't be used twice concurrently
+func (s Postgres) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	query := s.qb.Update("totp_secrets").
+		Set("last_used_step", step).
+		Where(squirrel.Eq{"user_id": userID}).
+		Where(squirrel.Lt{"last_used_step": step})
+	return s.execOne(ctx, "UseTOTPStep", query, auth.ErrInvalidCredentials)
+}
+
+func (s Postgres) UseRecoveryCode(ctx context.Context, userID int, hash string, at time.Time) error {
+	query := s.qb.Update("recovery_codes").
+		Set("used_at", at).
+		Where(squirrel.Eq{"user_id": userID, "hash": hash, "used_at": nil})
+	return s.execOne(ctx, "UseRecoveryCode", query, auth.ErrInvalidCredentials)
+}
+
+// DeleteTOTP removes the enrolment and its recovery codes in one transaction
+func (s Postgres) DeleteTOTP(ctx context.Context, userID int) (err error) {
+	deleteCodes, codesArgs, err := s.qb.Delete("recovery_codes").Where(squirrel.Eq{"user_id": userID}).ToSql()
+	if err != nil {
+		return err
+	}
+	deleteSecret, secretArgs, err := s.qb.Delete("totp_secrets").Where(squirrel.Eq{"user_id": userID}).ToSql()
+	if err != nil {
+		return err
+	}
+	ctx, span := s.startSpan(ctx, "DeleteTOTP", deleteSecret)
+	defer tracing.EndSpan(span, &err)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, deleteCodes, codesArgs...); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, deleteSecret, secretArgs...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// execOne runs the update and returns notFound if no row is updated
+func (s Postgres) execOne(ctx context.Context, operation string, query squirrel.UpdateBuilder, notFound error) (err error) {
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+	ctx, span := s.startSpan(ctx, operation, sql)
+	defer tracing.EndSpan(span, &err)
+	res, err := s.db.ExecContext(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type Direction int
+
+const (
+	// Up applies all pending migrations
+	Up Direction = iota
+	// Down reverts all applied migrations
+	Down
+)
+
+// Migration is a pair of embedded up and down sql files, e.g. 20210719131126_create_users_table.up.sql
+type Migration struct {
+	Version int64
+	Name    string
+	up      string
+	down    string
+}
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migrations returns the embedded migrations ordered by their versions
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		m := migrationFileName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", e.Name())
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		content, err := migrationFiles.ReadFile(path.Join("migrations", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if m[3] == "up" {
+			mig.up = string(content)
+		} else {
+			mig.down = string(content)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrate applies (Up) or reverts (Down) all the embedded migrations
+func Migrate(ctx context.Context, db *sql.DB, direction Direction) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	var target int64
+	if direction == Up && len(migrations) > 0 {
+		target = migrations[len(migrations)-1].Version
+	}
+	return migrateTo(ctx, db, migrations, target)
+}
+
+// MigrateTo applies or reverts the migrations until the given version is the current one. Version 0 reverts everything
+func MigrateTo(ctx context.Context, db *sql.DB, version int64) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	if version != 0 {
+		found := false
+		for _, m := range migrations {
+			found = found || m.Version == version
+		}
+		if !found {
+			return fmt.Errorf("migration %d doesn't exist", version)
+		}
+	}
+	return migrateTo(ctx, db, migrations, version)
+}
+
+// MigrationVersion returns the version of the last applied migration, 0 if nothing is applied
+func MigrationVersion(ctx context.Context, db *sql.DB) (int64, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if err := ensureVersionTable(ctx, conn); err != nil {
+		return 0, err
+	}
+	version, dirty, err := currentVersion(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	if dirty {
+		return version, errDirty(version)
+	}
+	return version, nil
+}
+
+// migrateTo runs on a single connection, which holds an advisory lock during the migration,
+// so concurrently starting instances wait for each other instead of running the same migrations.
+//
+// Versions are kept in the schema_migrations table in the same layout as the migrate CLI,
+// so databases migrated with it can be migrated with this package and vice versa.
+func migrateTo(ctx context.Context, db *sql.DB, migrations []Migration, target int64) (err error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// the lock is per schema, so that tests migrating separate schemas don't block each other
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock(hashtext(current_schema() || '.schema_migrations'))`); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer func() {
+		// ctx may be already canceled, unlock anyway. Closing the connection releases the lock otherwise
+		_, unlockErr := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext(current_schema() || '.schema_migrations'))`)
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+
+	if err := ensureVersionTable(ctx, conn); err != nil {
+		return err
+	}
+	current, dirty, err := currentVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return errDirty(current)
+	}
+
+	if target >= current {
+		for _, m := range migrations {
+			if m.Version <= current || m.Version > target {
+				continue
+			}
+			if err := apply(ctx, conn, m.up, m.Version); err != nil {
+				return fmt.Errorf("failed to apply migration %d_%s: %w", m.Version, m.Name, err)
+			}
+		}
+		return nil
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version > current || m.Version <= target {
+			continue
+		}
+		previous := int64(0)
+		if i > 0 {
+			previous = migrations[i-1].Version
+		}
+		if err := apply(ctx, conn, m.down, previous); err != nil {
+			return fmt.Errorf("failed to revert migration %d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+func errDirty(version int64) error {
+	return fmt.Errorf("migration %d failed halfway (dirty database), fix the schema manually and set schema_migrations.dirty to false", version)
+}
+
+func ensureVersionTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)`)
+	return err
+}
+
+func currentVersion(ctx context.Context, conn *sql.Conn) (version int64, dirty bool, err error) {
+	err = conn.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// apply runs the sql and sets the version in one transaction, so a failed migration leaves nothing behind
+func apply(ctx context.Context, conn *sql.Conn, query string, version int64) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations`); err != nil {
+		return err
+	}
+	if version != 0 {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)`, version); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
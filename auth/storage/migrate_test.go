@@ -0,0 +1,81 @@
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+
+	"github.com/davudsafarli/twitter/auth/storage"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	migrations, err := storage.Migrations()
+	require.Nil(t, err)
+	require.NotEmpty(t, migrations)
+	latest := migrations[len(migrations)-1].Version
+
+	// NewSchema applies all migrations
+	db, err := sql.Open("postgres", test_helpers.NewSchema(t))
+	require.Nil(t, err)
+	t.Cleanup(func() {
+		require.Nil(t, db.Close())
+	})
+	ctx := context.Background()
+
+	version, err := storage.MigrationVersion(ctx, db)
+	require.Nil(t, err)
+	require.Equal(t, latest, version)
+
+	t.Run(`#Migrate Down reverts everything, and Up applies it back`, func(t *testing.T) {
+		require.Nil(t, storage.Migrate(ctx, db, storage.Down))
+		version, err := storage.MigrationVersion(ctx, db)
+		require.Nil(t, err)
+		require.Zero(t, version)
+		var exists bool
+		require.Nil(t, db.QueryRow(`SELECT to_regclass('users') IS NOT NULL`).Scan(&exists))
+		require.False(t, exists, "users table should be dropped")
+
+		require.Nil(t, storage.Migrate(ctx, db, storage.Up))
+		version, err = storage.MigrationVersion(ctx, db)
+		require.Nil(t, err)
+		require.Equal(t, latest, version)
+	})
+
+	t.Run(`#MigrateTo moves to the given version in both directions`, func(t *testing.T) {
+		first := migrations[0].Version
+		require.Nil(t, storage.MigrateTo(ctx, db, first))
+		version, err := storage.MigrationVersion(ctx, db)
+		require.Nil(t, err)
+		require.Equal(t, first, version)
+
+		require.Nil(t, storage.MigrateTo(ctx, db, latest))
+		version, err = storage.MigrationVersion(ctx, db)
+		require.Nil(t, err)
+		require.Equal(t, latest, version)
+
+		require.NotNil(t, storage.MigrateTo(ctx, db, 42), "unknown versions should be rejected")
+	})
+
+	t.Run(`concurrent #Migrate calls don't race`, func(t *testing.T) {
+		require.Nil(t, storage.Migrate(ctx, db, storage.Down))
+		var wg sync.WaitGroup
+		errs := make([]error, 5)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = storage.Migrate(ctx, db, storage.Up)
+			}(i)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			require.Nil(t, err)
+		}
+		version, err := storage.MigrationVersion(ctx, db)
+		require.Nil(t, err)
+		require.Equal(t, latest, version)
+	})
+}
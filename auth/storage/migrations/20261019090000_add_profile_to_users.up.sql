@@ -0,0 +1,8 @@
+ALTER TABLE users
+    ADD COLUMN display_name VARCHAR (50) NOT NULL DEFAULT '',
+    ADD COLUMN bio VARCHAR (160) NOT NULL DEFAULT '',
+    ADD COLUMN location VARCHAR (30) NOT NULL DEFAULT '',
+    ADD COLUMN website VARCHAR (100) NOT NULL DEFAULT '',
+    ADD COLUMN avatar_url VARCHAR (255) NOT NULL DEFAULT '',
+    ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
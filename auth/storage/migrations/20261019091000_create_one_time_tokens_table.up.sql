@@ -0,0 +1,14 @@
+ALTER TABLE users
+    ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS one_time_tokens (
+    hash CHAR (64) PRIMARY KEY,
+    purpose VARCHAR (30) NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    data VARCHAR (255) NOT NULL DEFAULT '',
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS one_time_tokens_user_id_idx ON one_time_tokens (user_id);
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    hash CHAR (64) NOT NULL,
+    used_at TIMESTAMPTZ NULL,
+    PRIMARY KEY (user_id, hash)
+);
@@ -0,0 +1,5 @@
+ALTER TABLE users
+    ADD COLUMN deactivated_at TIMESTAMPTZ NULL,
+    ADD COLUMN delete_after TIMESTAMPTZ NULL;
+
+CREATE INDEX IF NOT EXISTS users_delete_after_idx ON users (delete_after) WHERE delete_after IS NOT NULL;
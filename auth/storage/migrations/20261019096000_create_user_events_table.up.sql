@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS user_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    type VARCHAR (50) NOT NULL,
+    payload JSONB NOT NULL,
+    published_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS user_events_user_id_idx ON user_events (user_id, id);
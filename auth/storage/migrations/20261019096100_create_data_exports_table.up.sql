@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS data_exports (
+    id CHAR (32) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    status VARCHAR (20) NOT NULL,
+    blob_key VARCHAR (255) NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL,
+    started_at TIMESTAMPTZ NULL,
+    completed_at TIMESTAMPTZ NULL,
+    expires_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX IF NOT EXISTS data_exports_user_id_idx ON data_exports (user_id);
+CREATE INDEX IF NOT EXISTS data_exports_status_idx ON data_exports (status, created_at) WHERE status IN ('pending', 'building');
+CREATE INDEX IF NOT EXISTS data_exports_expires_at_idx ON data_exports (expires_at) WHERE expires_at IS NOT NULL;
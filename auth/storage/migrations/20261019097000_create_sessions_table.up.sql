@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    id CHAR (32) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    ip VARCHAR (45) NOT NULL,
+    user_agent VARCHAR (512) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id, created_at DESC) WHERE revoked_at IS NULL;
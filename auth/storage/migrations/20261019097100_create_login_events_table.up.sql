@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS login_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    ip VARCHAR (45) NOT NULL,
+    user_agent VARCHAR (512) NOT NULL,
+    outcome VARCHAR (30) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS login_events_user_id_idx ON login_events (user_id, id DESC);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR (30) PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role VARCHAR (30) NOT NULL REFERENCES roles (name) ON DELETE CASCADE,
+    permission VARCHAR (50) NOT NULL,
+    PRIMARY KEY (role, permission)
+);
+
+INSERT INTO roles (name) VALUES ('user'), ('moderator'), ('admin') ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('moderator', 'users:read'),
+    ('moderator', 'users:suspend'),
+    ('admin', 'users:read'),
+    ('admin', 'users:suspend'),
+    ('admin', 'roles:assign')
+ON CONFLICT DO NOTHING;
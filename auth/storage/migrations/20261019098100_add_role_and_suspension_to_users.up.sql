@@ -0,0 +1,4 @@
+ALTER TABLE users
+    ADD COLUMN role VARCHAR (30) NOT NULL DEFAULT 'user' REFERENCES roles (name),
+    ADD COLUMN suspended_at TIMESTAMPTZ NULL,
+    ADD COLUMN suspension_reason TEXT NOT NULL DEFAULT '';
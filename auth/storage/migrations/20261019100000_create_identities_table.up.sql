@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS identities (
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    provider VARCHAR (50) NOT NULL,
+    subject VARCHAR (255) NOT NULL,
+    email VARCHAR (255) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (provider, subject),
+    UNIQUE (user_id, provider)
+);
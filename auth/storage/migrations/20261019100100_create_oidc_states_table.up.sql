@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS oidc_states (
+    hash CHAR (64) PRIMARY KEY,
+    provider VARCHAR (50) NOT NULL,
+    verifier VARCHAR (64) NOT NULL,
+    nonce VARCHAR (64) NOT NULL,
+    user_id INTEGER NULL REFERENCES users (id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS oidc_states_expires_at_idx ON oidc_states (expires_at);
@@ -0,0 +1,5 @@
+ALTER TABLE sessions
+    ADD COLUMN started_at TIMESTAMPTZ NULL;
+UPDATE sessions SET started_at = created_at;
+ALTER TABLE sessions
+    ALTER COLUMN started_at SET NOT NULL;
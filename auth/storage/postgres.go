@@ -3,52 +3,252 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Masterminds/squirrel"
 	"github.com/davudsafarli/twitter/auth"
-	_ "github.com/lib/pq"
+	"github.com/davudsafarli/twitter/auth/tracing"
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type postgres struct {
-	db *sql.DB
-	qb squirrel.StatementBuilderType
+// uniqueViolation is the postgres error code of unique constraint violations
+const uniqueViolation = "23505"
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
+
+// foreignKeyViolation is the postgres error code of foreign key constraint violations
+const foreignKeyViolation = "23503"
+
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation
+}
+
+func isNoRows(err error) bool {
+	return errors.Is(err, sql.ErrNoRows)
+}
+
+// Options configures the connection pool of Postgres. Zero values are replaced with the defaults below
+type Options struct {
+	ConnStr string
+
+	// MaxOpenConns defaults to 25
+	MaxOpenConns int
+	// MaxIdleConns defaults to MaxOpenConns, so connections are reused instead of reopened under load
+	MaxIdleConns int
+	// ConnMaxLifetime defaults to 30 minutes
+	ConnMaxLifetime time.Duration
+
+	// StartupTimeout is how long NewPostgres waits for the database to be reachable. Defaults to 30 seconds
+	StartupTimeout time.Duration
+	// RetryBackoff is the initial wait between the startup pings, it doubles after every failed ping. Defaults to 100ms
+	RetryBackoff time.Duration
+	// MaxRetryBackoff caps the wait between the startup pings. Defaults to 5 seconds
+	MaxRetryBackoff time.Duration
+
+	// StatementTimeout aborts statements that take longer, zero means no timeout
+	StatementTimeout time.Duration
+
+	// TracerProvider creates the spans of queries. Defaults to the global TracerProvider
+	TracerProvider trace.TracerProvider
 }
 
-func NewPostgres(connstr string) (postgres, error) {
-	db, err := sql.Open("postgres", connstr)
+func (o Options) withDefaults() Options {
+	if o.MaxOpenConns == 0 {
+		o.MaxOpenConns = 25
+	}
+	if o.MaxIdleConns == 0 {
+		o.MaxIdleConns = o.MaxOpenConns
+	}
+	if o.ConnMaxLifetime == 0 {
+		o.ConnMaxLifetime = 30 * time.Minute
+	}
+	if o.StartupTimeout == 0 {
+		o.StartupTimeout = 30 * time.Second
+	}
+	if o.RetryBackoff == 0 {
+		o.RetryBackoff = 100 * time.Millisecond
+	}
+	if o.MaxRetryBackoff == 0 {
+		o.MaxRetryBackoff = 5 * time.Second
+	}
+	if o.TracerProvider == nil {
+		o.TracerProvider = otel.GetTracerProvider()
+	}
+	return o
+}
+
+// Postgres implements auth.Storage
+type Postgres struct {
+	db     *sql.DB
+	qb     squirrel.StatementBuilderType
+	tracer trace.Tracer
+}
+
+// NewPostgres opens a connection pool and waits until the database is reachable.
+// It retries with an exponential backoff until options.StartupTimeout or ctx expires, so services can start before the database.
+func NewPostgres(ctx context.Context, options Options) (Postgres, error) {
+	options = options.withDefaults()
+	connStr, err := withStatementTimeout(options.ConnStr, options.StatementTimeout)
 	if err != nil {
-		return postgres{}, err
+		return Postgres{}, err
 	}
-	err = db.Ping()
+	db, err := sql.Open("postgres", connStr)
 	if err != nil {
-		panic(err)
+		return Postgres{}, err
 	}
-	pg := postgres{
-		db: db,
-		qb: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+	db.SetMaxOpenConns(options.MaxOpenConns)
+	db.SetMaxIdleConns(options.MaxIdleConns)
+	db.SetConnMaxLifetime(options.ConnMaxLifetime)
+
+	if err := waitUntilReachable(ctx, db, options); err != nil {
+		db.Close()
+		return Postgres{}, err
+	}
+	pg := Postgres{
+		db:     db,
+		qb:     squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+		tracer: options.TracerProvider.Tracer(instrumentationName),
 	}
 	return pg, nil
 }
 
-func (s postgres) CreateUser(ctx context.Context, u auth.User) (auth.User, error) {
+func waitUntilReachable(ctx context.Context, db *sql.DB, options Options) error {
+	ctx, cancel := context.WithTimeout(ctx, options.StartupTimeout)
+	defer cancel()
+	backoff := options.RetryBackoff
+	for {
+		err := db.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("postgres is not reachable: %w", err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > options.MaxRetryBackoff {
+			backoff = options.MaxRetryBackoff
+		}
+	}
+}
+
+// withStatementTimeout adds the statement_timeout run-time parameter to the connection string,
+// which can be either a URL or a list of key=value pairs
+func withStatementTimeout(connStr string, timeout time.Duration) (string, error) {
+	if timeout <= 0 {
+		return connStr, nil
+	}
+	ms := strconv.FormatInt(timeout.Milliseconds(), 10)
+	if !strings.HasPrefix(connStr, "postgres://") && !strings.HasPrefix(connStr, "postgresql://") {
+		return connStr + " statement_timeout=" + ms, nil
+	}
+	u, err := url.Parse(connStr)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("statement_timeout", ms)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Close closes the connection pool
+func (s Postgres) Close() error {
+	return s.db.Close()
+}
+
+// DB returns the underlying connection pool, e.g. to run Migrate
+func (s Postgres) DB() *sql.DB {
+	return s.db
+}
+
+// HealthCheck pings the database with a connection of the pool
+func (s Postgres) HealthCheck(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// userColumns are the columns scanned by scanUser, in order
+var userColumns = []string{
+	"id", "email", "username", "password",
+	"display_name", "bio", "location", "website", "avatar_url",
+	"created_at", "updated_at",
+	"token_version", "email_verified_at",
+	"deactivated_at", "delete_after",
+	"role", "suspended_at", "suspension_reason",
+}
+
+// scanUser scans a row selected with userColumns
+func scanUser(row squirrel.RowScanner) (auth.User, error) {
+	u := auth.User{}
+	var emailVerifiedAt, deactivatedAt, deleteAfter, suspendedAt sql.NullTime
+	err := row.Scan(
+		&u.ID, &u.Email, &u.Username, &u.Password,
+		&u.DisplayName, &u.Bio, &u.Location, &u.Website, &u.AvatarURL,
+		&u.CreatedAt, &u.UpdatedAt,
+		&u.TokenVersion, &emailVerifiedAt,
+		&deactivatedAt, &deleteAfter,
+		&u.Role, &suspendedAt, &u.SuspensionReason,
+	)
+	if isNoRows(err) {
+		return auth.User{}, auth.ErrUserNotFound
+	}
+	if err != nil {
+		return auth.User{}, err
+	}
+	// timestamps are kept in UTC, so users read from the database and from events are equal
+	u.CreatedAt = u.CreatedAt.UTC()
+	u.UpdatedAt = u.UpdatedAt.UTC()
+	if emailVerifiedAt.Valid {
+		u.EmailVerifiedAt = emailVerifiedAt.Time.UTC()
+	}
+	if deactivatedAt.Valid {
+		u.DeactivatedAt = deactivatedAt.Time.UTC()
+	}
+	if deleteAfter.Valid {
+		u.DeleteAfter = deleteAfter.Time.UTC()
+	}
+	if suspendedAt.Valid {
+		u.SuspendedAt = suspendedAt.Time.UTC()
+	}
+	return u, nil
+}
+
+func (s Postgres) CreateUser(ctx context.Context, u auth.User) (_ auth.User, err error) {
 	query := s.qb.Insert("users").
-		Columns("email", "username", "password").
-		Values(u.Email, u.Username, u.Password).
-		Suffix("RETURNING id")
+		Columns("email", "username", "password", "display_name", "bio", "location", "website", "avatar_url").
+		Values(u.Email, u.Username, u.Password, u.DisplayName, u.Bio, u.Location, u.Website, u.AvatarURL).
+		Suffix("RETURNING " + strings.Join(userColumns, ", "))
 
 	sql, args, err := query.ToSql()
 	if err != nil {
 		return auth.User{}, err
 	}
+	ctx, span := s.startSpan(ctx, "CreateUser", sql)
+	defer tracing.EndSpan(span, &err)
 	row := s.db.QueryRowContext(ctx, sql, args...)
-	err = row.Scan(&u.ID)
+	u, err = scanUser(row)
+	if isUniqueViolation(err) {
+		return auth.User{}, auth.ErrUserAlreadyExists
+	}
 	if err != nil {
 		return auth.User{}, err
 	}
 	return u, nil
 }
 
-func (s postgres) DeleteUser(ctx context.Context, ID int) error {
+func (s Postgres) DeleteUser(ctx context.Context, ID int) (err error) {
 	query := s.qb.Delete("users").
 		Where(squirrel.Eq{"id": ID})
 
@@ -56,6 +256,8 @@ func (s postgres) DeleteUser(ctx context.Context, ID int) error {
 	if err != nil {
 		return err
 	}
+	ctx, span := s.startSpan(ctx, "DeleteUser", sql)
+	defer tracing.EndSpan(span, &err)
 	_, err = s.db.ExecContext(ctx, sql, args...)
 	if err != nil {
 		return err
@@ -63,21 +265,226 @@ func (s postgres) DeleteUser(ctx context.Context, ID int) error {
 	return nil
 }
 
-func (s postgres) FindUser(ctx context.Context, usnm string) (auth.User, error) {
-	query := s.qb.Select("id", "email", "username", "password").From("users").
-		Where(squirrel.Eq{"username": usnm})
+func (s Postgres) PurgeUser(ctx context.Context, ID int, now time.Time, beforeDelete func(ctx context.Context) error) (err error) {
+	// the row is locked until the deletion, so a concurrent reactivation waits and then finds no user to update
+	lockSQL, lockArgs, err := s.qb.Select("id").From("users").
+		Where(squirrel.Eq{"id": ID}).
+		Where(squirrel.LtOrEq{"delete_after": now}).
+		Suffix("FOR UPDATE").
+		ToSql()
+	if err != nil {
+		return err
+	}
+	deleteSQL, deleteArgs, err := s.qb.Delete("users").
+		Where(squirrel.Eq{"id": ID}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	ctx, span := s.startSpan(ctx, "PurgeUser", deleteSQL)
+	defer tracing.EndSpan(span, &err)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var id int
+	if err := tx.QueryRowContext(ctx, lockSQL, lockArgs...).Scan(&id); err != nil {
+		if isNoRows(err) {
+			return auth.ErrUserNotFound
+		}
+		return err
+	}
+	if err := beforeDelete(ctx); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, deleteSQL, deleteArgs...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s Postgres) FindUser(ctx context.Context, usnm string) (_ auth.User, err error) {
+	return s.findUserBy(ctx, "FindUser", squirrel.Eq{"username": usnm})
+}
+
+func (s Postgres) FindUserByID(ctx context.Context, ID int) (_ auth.User, err error) {
+	return s.findUserBy(ctx, "FindUserByID", squirrel.Eq{"id": ID})
+}
+
+func (s Postgres) FindUserByEmail(ctx context.Context, email string) (_ auth.User, err error) {
+	return s.findUserBy(ctx, "FindUserByEmail", squirrel.Eq{"email": email})
+}
+
+func (s Postgres) findUserBy(ctx context.Context, operation string, where squirrel.Eq) (_ auth.User, err error) {
+	query := s.qb.Select(userColumns...).From("users").
+		Where(where)
 
 	sql, args, err := query.ToSql()
 	if err != nil {
 		return auth.User{}, err
 	}
+	ctx, span := s.startSpan(ctx, operation, sql)
+	defer tracing.EndSpan(span, &err)
 	row := s.db.QueryRowContext(ctx, sql, args...)
+	return scanUser(row)
+}
 
-	u := auth.User{}
+// UpdateProfile sets the non-nil fields of the update, and returns the updated user
+func (s Postgres) UpdateProfile(ctx context.Context, ID int, update auth.ProfileUpdate) (_ auth.User, err error) {
+	query := s.qb.Update("users").
+		Set("updated_at", squirrel.Expr("now()")).
+		Where(squirrel.Eq{"id": ID}).
+		Suffix("RETURNING " + strings.Join(userColumns, ", "))
+	for _, field := range []struct {
+		column string
+		value  *string
+	}{
+		{"display_name", update.DisplayName},
+		{"bio", update.Bio},
+		{"location", update.Location},
+		{"website", update.Website},
+		{"avatar_url", update.AvatarURL},
+	} {
+		if field.value != nil {
+			query = query.Set(field.column, *field.value)
+		}
+	}
 
-	if err := row.Scan(&u.ID, &u.Email, &u.Username, &u.Password); err != nil {
+	sql, args, err := query.ToSql()
+	if err != nil {
 		return auth.User{}, err
 	}
+	ctx, span := s.startSpan(ctx, "UpdateProfile", sql)
+	defer tracing.EndSpan(span, &err)
+	row := s.db.QueryRowContext(ctx, sql, args...)
+	return scanUser(row)
+}
 
-	return u, nil
+func (s Postgres) UpdatePassword(ctx context.Context, ID int, hashedPwd string) error {
+	return s.updateUser(ctx, "UpdatePassword", ID, s.qb.Update("users").Set("password", hashedPwd))
+}
+
+// UpdateEmail returns auth.ErrUserAlreadyExists if the email is taken by another user
+func (s Postgres) UpdateEmail(ctx context.Context, ID int, email string, verifiedAt time.Time) error {
+	err := s.updateUser(ctx, "UpdateEmail", ID, s.qb.Update("users").Set("email", email).Set("email_verified_at", nullTime(verifiedAt)))
+	if isUniqueViolation(err) {
+		return auth.ErrUserAlreadyExists
+	}
+	return err
+}
+
+// SetEmailVerified sets email_verified_at only if the user still has the email, so a token sent to an old email can't verify the new one
+func (s Postgres) SetEmailVerified(ctx context.Context, ID int, email string, at time.Time) error {
+	return s.updateUser(ctx, "SetEmailVerified", ID, s.qb.Update("users").
+		Set("email_verified_at", nullTime(at)).
+		Where(squirrel.Eq{"email": email}))
+}
+
+// nullTime converts the zero time to NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func (s Postgres) SetDeactivation(ctx context.Context, ID int, deactivatedAt, deleteAfter time.Time) error {
+	return s.updateUser(ctx, "SetDeactivation", ID, s.qb.Update("users").
+		Set("deactivated_at", nullTime(deactivatedAt)).
+		Set("delete_after", nullTime(deleteAfter)))
+}
+
+func (s Postgres) UsersToPurge(ctx context.Context, now time.Time, limit int) (_ []auth.User, err error) {
+	query := s.qb.Select(userColumns...).From("users").
+		Where(squirrel.LtOrEq{"delete_after": now}).
+		OrderBy("delete_after").
+		Limit(uint64(limit))
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := s.startSpan(ctx, "UsersToPurge", sql)
+	defer tracing.EndSpan(span, &err)
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []auth.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// updateUser runs the update for the user with ID, and sets its updated_at.
+// It returns auth.ErrUserNotFound if no user is updated
+func (s Postgres) updateUser(ctx context.Context, operation string, ID int, query squirrel.UpdateBuilder) (err error) {
+	query = query.
+		Set("updated_at", squirrel.Expr("now()")).
+		Where(squirrel.Eq{"id": ID})
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+	ctx, span := s.startSpan(ctx, operation, sql)
+	defer tracing.EndSpan(span, &err)
+	res, err := s.db.ExecContext(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return auth.ErrUserNotFound
+	}
+	return nil
+}
+
+func (s Postgres) CreateOneTimeToken(ctx context.Context, token auth.OneTimeToken) (err error) {
+	query := s.qb.Insert("one_time_tokens").
+		Columns("hash", "purpose", "user_id", "data", "expires_at").
+		Values(token.Hash, string(token.Purpose), token.UserID, token.Data, token.ExpiresAt)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+	ctx, span := s.startSpan(ctx, "CreateOneTimeToken", sql)
+	defer tracing.EndSpan(span, &err)
+	_, err = s.db.ExecContext(ctx, sql, args...)
+	return err
+}
+
+// ConsumeOneTimeToken marks the token as used in a single statement, so concurrent calls can't both consume it
+func (s Postgres) ConsumeOneTimeToken(ctx context.Context, purpose auth.TokenPurpose, hash string, now time.Time) (_ auth.OneTimeToken, err error) {
+	query := s.qb.Update("one_time_tokens").
+		Set("used_at", now).
+		Where(squirrel.Eq{"hash": hash, "purpose": string(purpose), "used_at": nil}).
+		Where(squirrel.Gt{"expires_at": now}).
+		Suffix("RETURNING hash, purpose, user_id, data, expires_at")
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return auth.OneTimeToken{}, err
+	}
+	ctx, span := s.startSpan(ctx, "ConsumeOneTimeToken", sql)
+	defer tracing.EndSpan(span, &err)
+	token := auth.OneTimeToken{}
+	err = s.db.QueryRowContext(ctx, sql, args...).Scan(&token.Hash, &token.Purpose, &token.UserID, &token.Data, &token.ExpiresAt)
+	if isNoRows(err) {
+		return auth.OneTimeToken{}, auth.ErrInvalidToken
+	}
+	if err != nil {
+		return auth.OneTimeToken{}, err
+	}
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	return token, nil
 }
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/tracing"
+)
+
+func (s Postgres) SetSuspension(ctx context.Context, ID int, suspendedAt time.Time, reason string) error {
+	return s.updateUser(ctx, "SetSuspension", ID, s.qb.Update("users").
+		Set("suspended_at", nullTime(suspendedAt)).
+		Set("suspension_reason", reason))
+}
+
+// SetRole relies on the foreign key of users.role to reject unknown roles
+func (s Postgres) SetRole(ctx context.Context, ID int, role auth.Role) error {
+	err := s.updateUser(ctx, "SetRole", ID, s.qb.Update("users").Set("role", string(role)))
+	if isForeignKeyViolation(err) {
+		return fmt.Errorf("%w: role %q doesn't exist", auth.ErrInvalidInput, role)
+	}
+	return err
+}
+
+func (s Postgres) RolePermissions(ctx context.Context, role auth.Role) (_ []auth.Permission, err error) {
+	query := s.qb.Select("permission").From("role_permissions").
+		Where(squirrel.Eq{"role": string(role)}).
+		OrderBy("permission")
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := s.startSpan(ctx, "RolePermissions", sql)
+	defer tracing.EndSpan(span, &err)
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var permissions []auth.Permission
+	for rows.Next() {
+		var p auth.Permission
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, p)
+	}
+	return permissions, rows.Err()
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns, so a search matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (s Postgres) ListUsers(ctx context.Context, query auth.UserQuery) (_ []auth.User, err error) {
+	q := s.qb.Select(userColumns...).From("users").
+		Where(squirrel.Gt{"id": query.AfterID}).
+		OrderBy("id").
+		Limit(uint64(query.Limit))
+	if query.Search != "" {
+		pattern := "%" + likeEscaper.Replace(query.Search) + "%"
+		q = q.Where(squirrel.Or{
+			squirrel.ILike{"username": pattern},
+			squirrel.ILike{"email": pattern},
+			squirrel.ILike{"display_name": pattern},
+		})
+	}
+
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := s.startSpan(ctx, "ListUsers", sql)
+	defer tracing.EndSpan(span, &err)
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []auth.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
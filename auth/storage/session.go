@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/tracing"
+)
+
+// RevokeSessions increments the token version and revokes the sessions in one transaction
+func (s Postgres) RevokeSessions(ctx context.Context, ID int) (err error) {
+	updateSQL, updateArgs, err := s.qb.Update("users").
+		Set("token_version", squirrel.Expr("token_version + 1")).
+		Set("updated_at", squirrel.Expr("now()")).
+		Where(squirrel.Eq{"id": ID}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	revokeSQL, revokeArgs, err := s.qb.Update("sessions").
+		Set("revoked_at", squirrel.Expr("now()")).
+		Where(squirrel.Eq{"user_id": ID, "revoked_at": nil}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	ctx, span := s.startSpan(ctx, "RevokeSessions", updateSQL)
+	defer tracing.EndSpan(span, &err)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, updateSQL, updateArgs...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return auth.ErrUserNotFound
+	}
+	if _, err := tx.ExecContext(ctx, revokeSQL, revokeArgs...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s Postgres) CreateSession(ctx context.Context, session auth.Session) (err error) {
+	query := s.qb.Insert("sessions").
+		Columns("id", "user_id", "ip", "user_agent", "created_at", "started_at", "expires_at", "revoked_at").
+		Values(session.ID, session.UserID, session.IP, session.UserAgent, session.CreatedAt, session.StartedAt, session.ExpiresAt, nullTime(session.RevokedAt))
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+	ctx, span := s.startSpan(ctx, "CreateSession", sql)
+	defer tracing.EndSpan(span, &err)
+	_, err = s.db.ExecContext(ctx, sql, args...)
+	if isForeignKeyViolation(err) {
+		return auth.ErrUserNotFound
+	}
+	return err
+}
+
+const sessionColumns = "id, user_id, ip, user_agent, created_at, started_at, expires_at, revoked_at"
+
+func scanSession(row squirrel.RowScanner) (auth.Session, error) {
+	session := auth.Session{}
+	var revokedAt sql.NullTime
+	err := row.Scan(&session.ID, &session.UserID, &session.IP, &session.UserAgent, &session.CreatedAt, &session.StartedAt, &session.ExpiresAt, &revokedAt)
+	if isNoRows(err) {
+		return auth.Session{}, auth.ErrSessionNotFound
+	}
+	if err != nil {
+		return auth.Session{}, err
+	}
+	session.CreatedAt = session.CreatedAt.UTC()
+	session.StartedAt = session.StartedAt.UTC()
+	session.ExpiresAt = session.ExpiresAt.UTC()
+	session.RevokedAt = timeOf(revokedAt)
+	return session, nil
+}
+
+func (s Postgres) FindSession(ctx context.Context, ID string) (_ auth.Session, err error) {
+	query := s.qb.Select(sessionColumns).From("sessions").
+		Where(squirrel.Eq{"id": ID})
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return auth.Session{}, err
+	}
+	ctx, span := s.startSpan(ctx, "FindSession", sql)
+	defer tracing.EndSpan(span, &err)
+	return scanSession(s.db.QueryRowContext(ctx, sql, args...))
+}
+
+func (s Postgres) ListSessions(ctx context.Context, userID int, now time.Time) (_ []auth.Session, err error) {
+	query := s.qb.Select(sessionColumns).From("sessions").
+		Where(squirrel.Eq{"user_id": userID, "revoked_at": nil}).
+		Where(squirrel.Gt{"expires_at": now}).
+		OrderBy("created_at DESC")
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := s.startSpan(ctx, "ListSessions", sql)
+	defer tracing.EndSpan(span, &err)
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var sessions []auth.Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession checks the owner and sets revoked_at in a single statement
+func (s Postgres) RevokeSession(ctx context.Context, userID int, ID string, at time.Time) error {
+	query := s.qb.Update("sessions").
+		Set("revoked_at", at).
+		Where(squirrel.Eq{"id": ID, "user_id": userID, "revoked_at": nil})
+	return s.execOne(ctx, "RevokeSession", query, auth.ErrSessionNotFound)
+}
+
+func (s Postgres) RecordLoginEvent(ctx context.Context, event auth.LoginEvent) (err error) {
+	query := s.qb.Insert("login_events").
+		Columns("user_id", "ip", "user_agent", "outcome", "created_at").
+		Values(event.UserID, event.IP, event.UserAgent, string(event.Outcome), event.CreatedAt)
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+	ctx, span := s.startSpan(ctx, "RecordLoginEvent", sql)
+	defer tracing.EndSpan(span, &err)
+	_, err = s.db.ExecContext(ctx, sql, args...)
+	if isForeignKeyViolation(err) {
+		return auth.ErrUserNotFound
+	}
+	return err
+}
+
+func (s Postgres) ListLoginEvents(ctx context.Context, userID int, limit int) (_ []auth.LoginEvent, err error) {
+	query := s.qb.Select("user_id, ip, user_agent, outcome, created_at").From("login_events").
+		Where(squirrel.Eq{"user_id": userID}).
+		OrderBy("id DESC")
+	if limit > 0 {
+		query = query.Limit(uint64(limit))
+	}
+
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := s.startSpan(ctx, "ListLoginEvents", sql)
+	defer tracing.EndSpan(span, &err)
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []auth.LoginEvent
+	for rows.Next() {
+		e := auth.LoginEvent{}
+		if err := rows.Scan(&e.UserID, &e.IP, &e.UserAgent, &e.Outcome, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.CreatedAt = e.CreatedAt.UTC()
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
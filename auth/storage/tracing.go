@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"context"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/davudsafarli/twitter/auth/storage"
+
+// startSpan starts a client span for a query. Arguments of the statement are never recorded, as they contain user data
+func (s Postgres) startSpan(ctx context.Context, operation, statement string) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, "postgres."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperationKey.String(operation),
+			semconv.DBStatementKey.String(statement),
+		),
+	)
+}
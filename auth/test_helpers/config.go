@@ -0,0 +1,25 @@
+package test_helpers
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/davudsafarli/twitter/config"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	loadConfig sync.Once
+	testConfig config.Config
+	configErr  error
+)
+
+// Config returns the configuration of the integration tests, loaded once with config.FromEnv.
+// E.g. TWITTER_POSTGRES_CONN_STR runs them against another database
+func Config(t testing.TB) config.Config {
+	loadConfig.Do(func() {
+		testConfig, configErr = config.FromEnv()
+	})
+	require.Nil(t, configErr)
+	return testConfig
+}
@@ -0,0 +1,719 @@
+package test_helpers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+)
+
+// InMemoryStorage is an auth.Storage for the tests that don't need a real database
+type InMemoryStorage struct {
+	mu     sync.Mutex
+	lastID int
+	users  map[int]auth.User
+	tokens map[string]inMemoryToken
+	totps  map[int]auth.TOTP
+	// recoveryCodes are the hashes of the unused codes per user
+	recoveryCodes map[int]map[string]bool
+	events        []auth.UserEventRecord
+	exports       map[string]auth.DataExport
+	sessions      map[string]auth.Session
+	loginEvents   []auth.LoginEvent
+	apiKeys       map[string]auth.APIKey
+	oidcStates    map[string]auth.OIDCState
+	identities    []auth.Identity
+	// roles are the permissions per role, auth.DefaultRolePermissions like the migrations
+	roles map[auth.Role][]auth.Permission
+}
+
+type inMemoryToken struct {
+	auth.OneTimeToken
+	used bool
+}
+
+func NewInMemoryStorage() *InMemoryStorage {
+	s := &InMemoryStorage{
+		users:         map[int]auth.User{},
+		tokens:        map[string]inMemoryToken{},
+		totps:         map[int]auth.TOTP{},
+		recoveryCodes: map[int]map[string]bool{},
+		exports:       map[string]auth.DataExport{},
+		sessions:      map[string]auth.Session{},
+		apiKeys:       map[string]auth.APIKey{},
+		oidcStates:    map[string]auth.OIDCState{},
+		roles:         map[auth.Role][]auth.Permission{},
+	}
+	for role, permissions := range auth.DefaultRolePermissions {
+		s.roles[role] = permissions
+	}
+	return s
+}
+
+func (s *InMemoryStorage) CreateUser(ctx context.Context, u auth.User) (auth.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.users {
+		if existing.Username == u.Username || existing.Email == u.Email {
+			return auth.User{}, auth.ErrUserAlreadyExists
+		}
+	}
+	s.lastID++
+	u.ID = s.lastID
+	u.Role = auth.RoleUser
+	u.CreatedAt = time.Now().UTC()
+	u.UpdatedAt = u.CreatedAt
+	s.users[u.ID] = u
+	return u, nil
+}
+
+func (s *InMemoryStorage) FindUser(ctx context.Context, usnm string) (auth.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Username == usnm {
+			return u, nil
+		}
+	}
+	return auth.User{}, auth.ErrUserNotFound
+}
+
+func (s *InMemoryStorage) FindUserByID(ctx context.Context, ID int) (auth.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[ID]
+	if !ok {
+		return auth.User{}, auth.ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (s *InMemoryStorage) FindUserByEmail(ctx context.Context, email string) (auth.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return auth.User{}, auth.ErrUserNotFound
+}
+
+func (s *InMemoryStorage) UpdateProfile(ctx context.Context, ID int, update auth.ProfileUpdate) (auth.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[ID]
+	if !ok {
+		return auth.User{}, auth.ErrUserNotFound
+	}
+	set := func(field *string, value *string) {
+		if value != nil {
+			*field = *value
+		}
+	}
+	set(&u.DisplayName, update.DisplayName)
+	set(&u.Bio, update.Bio)
+	set(&u.Location, update.Location)
+	set(&u.Website, update.Website)
+	set(&u.AvatarURL, update.AvatarURL)
+	u.UpdatedAt = time.Now().UTC()
+	s.users[ID] = u
+	return u, nil
+}
+
+func (s *InMemoryStorage) UpdatePassword(ctx context.Context, ID int, hashedPwd string) error {
+	return s.updateUser(ID, func(u *auth.User) error {
+		u.Password = hashedPwd
+		return nil
+	})
+}
+
+func (s *InMemoryStorage) UpdateEmail(ctx context.Context, ID int, email string, verifiedAt time.Time) error {
+	return s.updateUser(ID, func(u *auth.User) error {
+		for _, existing := range s.users {
+			if existing.ID != ID && existing.Email == email {
+				return auth.ErrUserAlreadyExists
+			}
+		}
+		u.Email = email
+		u.EmailVerifiedAt = verifiedAt
+		return nil
+	})
+}
+
+func (s *InMemoryStorage) SetEmailVerified(ctx context.Context, ID int, email string, at time.Time) error {
+	return s.updateUser(ID, func(u *auth.User) error {
+		if u.Email != email {
+			return auth.ErrUserNotFound
+		}
+		u.EmailVerifiedAt = at
+		return nil
+	})
+}
+
+func (s *InMemoryStorage) SetDeactivation(ctx context.Context, ID int, deactivatedAt, deleteAfter time.Time) error {
+	return s.updateUser(ID, func(u *auth.User) error {
+		u.DeactivatedAt = deactivatedAt
+		u.DeleteAfter = deleteAfter
+		return nil
+	})
+}
+
+func (s *InMemoryStorage) UsersToPurge(ctx context.Context, now time.Time, limit int) ([]auth.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var users []auth.User
+	for _, u := range s.users {
+		if !u.DeleteAfter.IsZero() && !u.DeleteAfter.After(now) {
+			users = append(users, u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].DeleteAfter.Before(users[j].DeleteAfter) })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (s *InMemoryStorage) SetSuspension(ctx context.Context, ID int, suspendedAt time.Time, reason string) error {
+	return s.updateUser(ID, func(u *auth.User) error {
+		u.SuspendedAt = suspendedAt
+		u.SuspensionReason = reason
+		return nil
+	})
+}
+
+func (s *InMemoryStorage) SetRole(ctx context.Context, ID int, role auth.Role) error {
+	return s.updateUser(ID, func(u *auth.User) error {
+		if _, ok := s.roles[role]; !ok {
+			return fmt.Errorf("%w: role %q doesn't exist", auth.ErrInvalidInput, role)
+		}
+		u.Role = role
+		return nil
+	})
+}
+
+func (s *InMemoryStorage) RolePermissions(ctx context.Context, role auth.Role) ([]auth.Permission, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]auth.Permission(nil), s.roles[role]...), nil
+}
+
+func (s *InMemoryStorage) ListUsers(ctx context.Context, query auth.UserQuery) ([]auth.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	search := strings.ToLower(query.Search)
+	var users []auth.User
+	for _, u := range s.users {
+		matches := strings.Contains(strings.ToLower(u.Username), search) ||
+			strings.Contains(strings.ToLower(u.Email), search) ||
+			strings.Contains(strings.ToLower(u.DisplayName), search)
+		if u.ID > query.AfterID && matches {
+			users = append(users, u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	if len(users) > query.Limit {
+		users = users[:query.Limit]
+	}
+	return users, nil
+}
+
+func (s *InMemoryStorage) RevokeSessions(ctx context.Context, ID int) error {
+	return s.updateUser(ID, func(u *auth.User) error {
+		u.TokenVersion++
+		now := time.Now().UTC()
+		for id, session := range s.sessions {
+			if session.UserID == ID && session.RevokedAt.IsZero() {
+				session.RevokedAt = now
+				s.sessions[id] = session
+			}
+		}
+		return nil
+	})
+}
+
+func (s *InMemoryStorage) CreateSession(ctx context.Context, session auth.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[session.UserID]; !ok {
+		return auth.ErrUserNotFound
+	}
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *InMemoryStorage) FindSession(ctx context.Context, ID string) (auth.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[ID]
+	if !ok {
+		return auth.Session{}, auth.ErrSessionNotFound
+	}
+	return session, nil
+}
+
+func (s *InMemoryStorage) ListSessions(ctx context.Context, userID int, now time.Time) ([]auth.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sessions []auth.Session
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.RevokedAt.IsZero() && session.ExpiresAt.After(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.After(sessions[j].CreatedAt) })
+	return sessions, nil
+}
+
+func (s *InMemoryStorage) RevokeSession(ctx context.Context, userID int, ID string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[ID]
+	if !ok || session.UserID != userID || !session.RevokedAt.IsZero() {
+		return auth.ErrSessionNotFound
+	}
+	session.RevokedAt = at
+	s.sessions[ID] = session
+	return nil
+}
+
+func (s *InMemoryStorage) RecordLoginEvent(ctx context.Context, event auth.LoginEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[event.UserID]; !ok {
+		return auth.ErrUserNotFound
+	}
+	s.loginEvents = append(s.loginEvents, event)
+	return nil
+}
+
+func (s *InMemoryStorage) ListLoginEvents(ctx context.Context, userID int, limit int) ([]auth.LoginEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []auth.LoginEvent
+	// events are appended in order, so the newest are at the end
+	for i := len(s.loginEvents) - 1; i >= 0 && (limit == 0 || len(events) < limit); i-- {
+		if s.loginEvents[i].UserID == userID {
+			events = append(events, s.loginEvents[i])
+		}
+	}
+	return events, nil
+}
+
+func (s *InMemoryStorage) CreateAPIKey(ctx context.Context, key auth.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[key.UserID]; !ok {
+		return auth.ErrUserNotFound
+	}
+	// the scopes are copied, so the caller can't change the stored key
+	key.Scopes = append([]auth.Permission(nil), key.Scopes...)
+	s.apiKeys[key.ID] = key
+	return nil
+}
+
+func (s *InMemoryStorage) FindAPIKeyByHash(ctx context.Context, hash string) (auth.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range s.apiKeys {
+		if key.Hash == hash {
+			return key, nil
+		}
+	}
+	return auth.APIKey{}, auth.ErrAPIKeyNotFound
+}
+
+func (s *InMemoryStorage) ListAPIKeys(ctx context.Context, userID int) ([]auth.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []auth.APIKey
+	for _, key := range s.apiKeys {
+		if key.UserID == userID && key.RevokedAt.IsZero() {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func (s *InMemoryStorage) RevokeAPIKey(ctx context.Context, userID int, ID string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.apiKeys[ID]
+	if !ok || key.UserID != userID || !key.RevokedAt.IsZero() {
+		return auth.ErrAPIKeyNotFound
+	}
+	key.RevokedAt = at
+	s.apiKeys[ID] = key
+	return nil
+}
+
+func (s *InMemoryStorage) TouchAPIKey(ctx context.Context, ID string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.apiKeys[ID]
+	if !ok {
+		return auth.ErrAPIKeyNotFound
+	}
+	key.LastUsedAt = at
+	s.apiKeys[ID] = key
+	return nil
+}
+
+func (s *InMemoryStorage) CreateOIDCState(ctx context.Context, state auth.OIDCState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[state.UserID]; state.UserID != 0 && !ok {
+		return auth.ErrUserNotFound
+	}
+	// expired states are not deleted, they can't be consumed anyway and tests are short-lived
+	s.oidcStates[state.Hash] = state
+	return nil
+}
+
+func (s *InMemoryStorage) ConsumeOIDCState(ctx context.Context, hash string, now time.Time) (auth.OIDCState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.oidcStates[hash]
+	delete(s.oidcStates, hash)
+	if !ok || !state.ExpiresAt.After(now) {
+		return auth.OIDCState{}, auth.ErrInvalidToken
+	}
+	return state, nil
+}
+
+func (s *InMemoryStorage) CreateIdentity(ctx context.Context, identity auth.Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[identity.UserID]; !ok {
+		return auth.ErrUserNotFound
+	}
+	for _, existing := range s.identities {
+		if existing.Provider == identity.Provider && (existing.Subject == identity.Subject || existing.UserID == identity.UserID) {
+			return auth.ErrUserAlreadyExists
+		}
+	}
+	s.identities = append(s.identities, identity)
+	return nil
+}
+
+func (s *InMemoryStorage) FindIdentity(ctx context.Context, provider, subject string) (auth.Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, identity := range s.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return auth.Identity{}, auth.ErrIdentityNotFound
+}
+
+func (s *InMemoryStorage) ListIdentities(ctx context.Context, userID int) ([]auth.Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var identities []auth.Identity
+	for _, identity := range s.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool { return identities[i].Provider < identities[j].Provider })
+	return identities, nil
+}
+
+func (s *InMemoryStorage) DeleteIdentity(ctx context.Context, userID int, provider string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, identity := range s.identities {
+		if identity.UserID == userID && identity.Provider == provider {
+			s.identities = append(s.identities[:i], s.identities[i+1:]...)
+			return nil
+		}
+	}
+	return auth.ErrIdentityNotFound
+}
+
+// updateUser calls fn with the user while holding the lock, and saves the user if fn succeeds
+func (s *InMemoryStorage) updateUser(ID int, fn func(u *auth.User) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[ID]
+	if !ok {
+		return auth.ErrUserNotFound
+	}
+	if err := fn(&u); err != nil {
+		return err
+	}
+	u.UpdatedAt = time.Now().UTC()
+	s.users[ID] = u
+	return nil
+}
+
+func (s *InMemoryStorage) CreateOneTimeToken(ctx context.Context, token auth.OneTimeToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token.Hash] = inMemoryToken{OneTimeToken: token}
+	return nil
+}
+
+func (s *InMemoryStorage) ConsumeOneTimeToken(ctx context.Context, purpose auth.TokenPurpose, hash string, now time.Time) (auth.OneTimeToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token, ok := s.tokens[hash]
+	if !ok || token.used || token.Purpose != purpose || !token.ExpiresAt.After(now) {
+		return auth.OneTimeToken{}, auth.ErrInvalidToken
+	}
+	token.used = true
+	s.tokens[hash] = token
+	return token.OneTimeToken, nil
+}
+
+func (s *InMemoryStorage) SaveTOTP(ctx context.Context, totp auth.TOTP, recoveryCodeHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.totps[totp.UserID] = totp
+	codes := map[string]bool{}
+	for _, hash := range recoveryCodeHashes {
+		codes[hash] = true
+	}
+	s.recoveryCodes[totp.UserID] = codes
+	return nil
+}
+
+func (s *InMemoryStorage) FindTOTP(ctx context.Context, userID int) (auth.TOTP, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	totp, ok := s.totps[userID]
+	if !ok {
+		return auth.TOTP{}, auth.ErrMFANotEnrolled
+	}
+	return totp, nil
+}
+
+func (s *InMemoryStorage) ConfirmTOTP(ctx context.Context, userID int, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	totp, ok := s.totps[userID]
+	if !ok {
+		return auth.ErrMFANotEnrolled
+	}
+	totp.ConfirmedAt = at
+	s.totps[userID] = totp
+	return nil
+}
+
+func (s *InMemoryStorage) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	totp, ok := s.totps[userID]
+	if !ok || totp.LastUsedStep >= step {
+		return auth.ErrInvalidCredentials
+	}
+	totp.LastUsedStep = step
+	s.totps[userID] = totp
+	return nil
+}
+
+func (s *InMemoryStorage) UseRecoveryCode(ctx context.Context, userID int, hash string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.recoveryCodes[userID][hash] {
+		return auth.ErrInvalidCredentials
+	}
+	delete(s.recoveryCodes[userID], hash)
+	return nil
+}
+
+func (s *InMemoryStorage) DeleteTOTP(ctx context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.totps, userID)
+	delete(s.recoveryCodes, userID)
+	return nil
+}
+
+// PurgeUser doesn't hold the lock while beforeDelete runs, it may use the storage. The user is checked again afterwards
+func (s *InMemoryStorage) PurgeUser(ctx context.Context, ID int, now time.Time, beforeDelete func(ctx context.Context) error) error {
+	due := func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		u, ok := s.users[ID]
+		return ok && !u.DeleteAfter.IsZero() && !u.DeleteAfter.After(now)
+	}
+	if !due() {
+		return auth.ErrUserNotFound
+	}
+	if err := beforeDelete(ctx); err != nil {
+		return err
+	}
+	if !due() {
+		return auth.ErrUserNotFound
+	}
+	return s.DeleteUser(ctx, ID)
+}
+
+func (s *InMemoryStorage) DeleteUser(ctx context.Context, ID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, ID)
+	delete(s.totps, ID)
+	delete(s.recoveryCodes, ID)
+	for hash, token := range s.tokens {
+		if token.UserID == ID {
+			delete(s.tokens, hash)
+		}
+	}
+	events := s.events[:0]
+	for _, e := range s.events {
+		if e.UserID != ID {
+			events = append(events, e)
+		}
+	}
+	s.events = events
+	loginEvents := s.loginEvents[:0]
+	for _, e := range s.loginEvents {
+		if e.UserID != ID {
+			loginEvents = append(loginEvents, e)
+		}
+	}
+	s.loginEvents = loginEvents
+	for id, session := range s.sessions {
+		if session.UserID == ID {
+			delete(s.sessions, id)
+		}
+	}
+	for id, export := range s.exports {
+		if export.UserID == ID {
+			delete(s.exports, id)
+		}
+	}
+	for id, key := range s.apiKeys {
+		if key.UserID == ID {
+			delete(s.apiKeys, id)
+		}
+	}
+	for hash, state := range s.oidcStates {
+		if state.UserID == ID {
+			delete(s.oidcStates, hash)
+		}
+	}
+	identities := s.identities[:0]
+	for _, identity := range s.identities {
+		if identity.UserID != ID {
+			identities = append(identities, identity)
+		}
+	}
+	s.identities = identities
+	return nil
+}
+
+func (s *InMemoryStorage) AppendUserEvent(ctx context.Context, record auth.UserEventRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[record.UserID]; !ok {
+		return auth.ErrUserNotFound
+	}
+	s.events = append(s.events, record)
+	return nil
+}
+
+func (s *InMemoryStorage) ListUserEvents(ctx context.Context, userID int) ([]auth.UserEventRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []auth.UserEventRecord
+	for _, e := range s.events {
+		if e.UserID == userID {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (s *InMemoryStorage) CreateDataExport(ctx context.Context, export auth.DataExport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[export.UserID]; !ok {
+		return auth.ErrUserNotFound
+	}
+	s.exports[export.ID] = export
+	return nil
+}
+
+func (s *InMemoryStorage) ClaimDataExport(ctx context.Context, now, staleBefore time.Time) (auth.DataExport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var claimed *auth.DataExport
+	for _, export := range s.exports {
+		export := export
+		claimable := export.Status == auth.DataExportPending ||
+			export.Status == auth.DataExportBuilding && export.StartedAt.Before(staleBefore)
+		if claimable && (claimed == nil || export.CreatedAt.Before(claimed.CreatedAt)) {
+			claimed = &export
+		}
+	}
+	if claimed == nil {
+		return auth.DataExport{}, auth.ErrDataExportNotFound
+	}
+	claimed.Status = auth.DataExportBuilding
+	claimed.StartedAt = now
+	s.exports[claimed.ID] = *claimed
+	return *claimed, nil
+}
+
+func (s *InMemoryStorage) UpdateDataExport(ctx context.Context, export auth.DataExport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.exports[export.ID]; !ok {
+		return auth.ErrDataExportNotFound
+	}
+	s.exports[export.ID] = export
+	return nil
+}
+
+func (s *InMemoryStorage) FindDataExport(ctx context.Context, ID string) (auth.DataExport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	export, ok := s.exports[ID]
+	if !ok {
+		return auth.DataExport{}, auth.ErrDataExportNotFound
+	}
+	return export, nil
+}
+
+func (s *InMemoryStorage) ListDataExports(ctx context.Context, userID int) ([]auth.DataExport, error) {
+	return s.filterDataExports(func(e auth.DataExport) bool { return e.UserID == userID }, 0), nil
+}
+
+func (s *InMemoryStorage) ExpiredDataExports(ctx context.Context, now time.Time, limit int) ([]auth.DataExport, error) {
+	expired := func(e auth.DataExport) bool { return !e.ExpiresAt.IsZero() && !e.ExpiresAt.After(now) }
+	return s.filterDataExports(expired, limit), nil
+}
+
+// filterDataExports returns up to limit matching exports from the oldest, all of them if limit is 0
+func (s *InMemoryStorage) filterDataExports(match func(auth.DataExport) bool, limit int) []auth.DataExport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var exports []auth.DataExport
+	for _, export := range s.exports {
+		if match(export) {
+			exports = append(exports, export)
+		}
+	}
+	sort.Slice(exports, func(i, j int) bool { return exports[i].CreatedAt.Before(exports[j].CreatedAt) })
+	if limit > 0 && len(exports) > limit {
+		exports = exports[:limit]
+	}
+	return exports
+}
+
+func (s *InMemoryStorage) DeleteDataExport(ctx context.Context, ID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.exports, ID)
+	return nil
+}
@@ -0,0 +1,192 @@
+package test_helpers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+// FakeOIDCProvider is an OpenID Connect provider for the tests of the sign in with providers.
+// It serves the discovery document, the keys and the token endpoint, Authorize stands for the user signing in
+type FakeOIDCProvider struct {
+	Server       *httptest.Server
+	ClientID     string
+	ClientSecret string
+	key          *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]fakeOIDCGrant
+}
+
+// FakeOIDCUser is the user signing in at a FakeOIDCProvider
+type FakeOIDCUser struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+}
+
+type fakeOIDCGrant struct {
+	user          FakeOIDCUser
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+}
+
+// NewFakeOIDCProvider starts a provider that is closed when the test finishes
+func NewFakeOIDCProvider(t testing.TB) *FakeOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	p := &FakeOIDCProvider{
+		ClientID:     "fake-client",
+		ClientSecret: "fake-secret",
+		key:          key,
+		codes:        map[string]fakeOIDCGrant{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Server.Close)
+	return p
+}
+
+// Issuer returns the issuer URL of the provider
+func (p *FakeOIDCProvider) Issuer() string {
+	return p.Server.URL
+}
+
+// Provider returns the auth.OIDCProvider of the fake with the name
+func (p *FakeOIDCProvider) Provider(name string) auth.OIDCProvider {
+	return auth.OIDCProvider{
+		Name:         name,
+		Issuer:       p.Issuer(),
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURL:  "https://twitter.test/oidc/callback",
+	}
+}
+
+// Authorize checks the authorization URL like the provider would, signs the user in,
+// and returns the state and the code the provider redirects back with
+func (p *FakeOIDCProvider) Authorize(t testing.TB, authorizationURL string, user FakeOIDCUser) (state, code string) {
+	u, err := url.Parse(authorizationURL)
+	require.Nil(t, err)
+	require.Equal(t, p.Issuer()+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	query := u.Query()
+	require.Equal(t, "code", query.Get("response_type"))
+	require.Equal(t, p.ClientID, query.Get("client_id"))
+	require.Equal(t, "S256", query.Get("code_challenge_method"))
+	require.Contains(t, query.Get("scope"), "openid")
+	require.NotEmpty(t, query.Get("state"))
+
+	b := make([]byte, 16)
+	_, err = rand.Read(b)
+	require.Nil(t, err)
+	code = fmt.Sprintf("%x", b)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.codes[code] = fakeOIDCGrant{
+		user:          user,
+		redirectURI:   query.Get("redirect_uri"),
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+	}
+	return query.Get("state"), code
+}
+
+func (p *FakeOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeFakeOIDCJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 p.Issuer(),
+		"authorization_endpoint": p.Issuer() + "/authorize",
+		"token_endpoint":         p.Issuer() + "/token",
+		"jwks_uri":               p.Issuer() + "/jwks",
+	})
+}
+
+func (p *FakeOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeFakeOIDCJSON(w, http.StatusOK, auth.JSONWebKeySet{Keys: []auth.JSONWebKey{{
+		KeyType:   "RSA",
+		KeyID:     "fake-key",
+		Algorithm: "RS256",
+		Use:       "sig",
+		N:         base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+		E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+	}}})
+}
+
+// token redeems a code once, if the client credentials, the redirect URI and the PKCE verifier match
+func (p *FakeOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	if id != p.ClientID || secret != p.ClientSecret {
+		writeFakeOIDCJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeFakeOIDCJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	p.mu.Lock()
+	grant, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || grant.redirectURI != r.PostForm.Get("redirect_uri") ||
+		grant.codeChallenge != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+		writeFakeOIDCJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	claims := jwt.MapClaims{
+		"iss":            p.Issuer(),
+		"aud":            p.ClientID,
+		"sub":            grant.user.Subject,
+		"nonce":          grant.nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"email_verified": grant.user.EmailVerified,
+	}
+	if grant.user.Email != "" {
+		claims["email"] = grant.user.Email
+	}
+	if grant.user.PreferredUsername != "" {
+		claims["preferred_username"] = grant.user.PreferredUsername
+	}
+	if grant.user.Name != "" {
+		claims["name"] = grant.user.Name
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = "fake-key"
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		writeFakeOIDCJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeFakeOIDCJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "fake-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+func writeFakeOIDCJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,49 @@
+package test_helpers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/davudsafarli/twitter/auth/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// NewSchema creates a uniquely named schema with all migrations applied, and returns a connection string that uses it.
+// Tests using it don't depend on the state of the database or on each other. The schema is dropped when the test finishes
+func NewSchema(t testing.TB) string {
+	schema := fmt.Sprintf("test_%x", random.Int63())
+	connStr := Config(t).Postgres.ConnStr
+	admin, err := sql.Open("postgres", connStr)
+	require.Nil(t, err)
+	_, err = admin.Exec(fmt.Sprintf(`CREATE SCHEMA %s`, schema))
+	require.Nil(t, err)
+	t.Cleanup(func() {
+		_, err := admin.Exec(fmt.Sprintf(`DROP SCHEMA %s CASCADE`, schema))
+		require.Nil(t, err)
+		require.Nil(t, admin.Close())
+	})
+
+	separator := "?"
+	if strings.Contains(connStr, "?") {
+		separator = "&"
+	}
+	connStr = fmt.Sprintf("%s%ssearch_path=%s", connStr, separator, schema)
+	db, err := sql.Open("postgres", connStr)
+	require.Nil(t, err)
+	defer db.Close()
+	require.Nil(t, storage.Migrate(context.Background(), db, storage.Up))
+	return connStr
+}
+
+// NewPostgres connects to a fresh schema created by NewSchema, and closes the connection when the test finishes
+func NewPostgres(t testing.TB) storage.Postgres {
+	pg, err := storage.NewPostgres(context.Background(), storage.Options{ConnStr: NewSchema(t)})
+	require.Nil(t, err)
+	t.Cleanup(func() {
+		require.Nil(t, pg.Close())
+	})
+	return pg
+}
@@ -0,0 +1,16 @@
+package test_helpers
+
+import (
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+)
+
+// TOTPCode is auth.TOTPCode of a valid secret, so it can be used in expressions
+func TOTPCode(secret string, t time.Time) string {
+	code, err := auth.TOTPCode(secret, t)
+	if err != nil {
+		panic(err)
+	}
+	return code
+}
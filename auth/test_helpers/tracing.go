@@ -0,0 +1,31 @@
+package test_helpers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// NewTracerProvider returns a TracerProvider that synchronously exports the ended spans to the returned in-memory exporter
+func NewTracerProvider(t testing.TB) (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() {
+		require.Nil(t, tp.Shutdown(context.Background()))
+	})
+	return tp, exporter
+}
+
+// FindSpan returns the exported span with the given name
+func FindSpan(t testing.TB, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	for _, s := range spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	require.FailNow(t, "span not found", name)
+	return tracetest.SpanStub{}
+}
@@ -0,0 +1,18 @@
+package test_helpers
+
+import (
+	"github.com/davudsafarli/twitter/auth"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// FastPasswordHasher hashes with the minimum cost, the default hasher is too slow for tests
+var FastPasswordHasher = auth.BcryptHasher{Cost: bcrypt.MinCost}
+
+// NewUsecases returns the Usecases of the in-memory storage, which hash the passwords with FastPasswordHasher.
+// mails may be nil, like the Mailer of the Usecases
+func NewUsecases(events auth.EventProducerConsumer, mails auth.Mailer) auth.Usecases {
+	uc := auth.NewUsecases(NewInMemoryStorage(), events)
+	uc.Mailer = mails
+	uc.PasswordHasher = FastPasswordHasher
+	return uc
+}
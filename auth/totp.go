@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters of RFC 6238. They are the defaults of authenticator apps, so they aren't configurable
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is the number of steps before and after the current one that are accepted, to allow clock drift
+	totpSkew = 1
+	// totpSecretLength is the length of HMAC-SHA1 keys RFC 4226 recommends
+	totpSecretLength = 20
+)
+
+var totpSecretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func newTOTPSecret() ([]byte, error) {
+	secret := make([]byte, totpSecretLength)
+	_, err := rand.Read(secret)
+	return secret, err
+}
+
+// totpStep returns the time step of t
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// totpCode returns the HOTP value (RFC 4226) of the step
+func totpCode(secret []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// TOTPCode returns the code an authenticator app shows at t for the base32 secret of TOTPEnrollment
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := totpSecretEncoding.DecodeString(secret)
+	if err != nil {
+		return "", fmt.Errorf("%w: the secret isn't base32", ErrInvalidInput)
+	}
+	return totpCode(key, totpStep(t)), nil
+}
+
+// matchTOTPStep returns the step of now±totpSkew the code belongs to, false if it doesn't belong to any
+func matchTOTPStep(secret []byte, code string, now time.Time) (int64, bool) {
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// isTOTPCode reports whether the code looks like a TOTP code rather than a recovery code
+func isTOTPCode(code string) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// totpURI returns the otpauth URI authenticator apps enrol with, usually shown as a QR code
+func totpURI(issuer, account string, secret []byte) string {
+	q := url.Values{}
+	q.Set("secret", totpSecretEncoding.EncodeToString(secret))
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: q.Encode(),
+	}
+	return u.String()
+}
+
+const recoveryCodeCount = 10
+
+// newRecoveryCodes returns the codes to show to the user, and their hashes to store
+func newRecoveryCodes() (codes []string, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		// 16 characters, grouped to be easier to write down
+		code := strings.ToLower(totpSecretEncoding.EncodeToString(b))
+		code = code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores the case and the separators, so the codes can be typed as users like.
+// Codes have enough entropy, so a slow hash is not needed
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashOneTimeToken(normalized)
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTP(t *testing.T) {
+	// test vectors of RFC 6238 Appendix B for SHA1, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	for unix, code := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		require.Equal(t, code, totpCode(secret, totpStep(time.Unix(unix, 0))), "time %d", unix)
+	}
+
+	t.Run(`codes of the adjacent steps are accepted`, func(t *testing.T) {
+		now := time.Unix(1111111111, 0)
+		for _, d := range []time.Duration{-totpPeriod * time.Second, 0, totpPeriod * time.Second} {
+			step, ok := matchTOTPStep(secret, totpCode(secret, totpStep(now.Add(d))), now)
+			require.True(t, ok)
+			require.Equal(t, totpStep(now.Add(d)), step)
+		}
+		_, ok := matchTOTPStep(secret, totpCode(secret, totpStep(now.Add(2*totpPeriod*time.Second))), now)
+		require.False(t, ok)
+	})
+
+	t.Run(`#TOTPCode takes the base32 secret of the enrollment`, func(t *testing.T) {
+		code, err := TOTPCode("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", time.Unix(59, 0))
+		require.Nil(t, err)
+		require.Equal(t, "287082", code)
+		_, err = TOTPCode("not base32!", time.Unix(59, 0))
+		require.ErrorIs(t, err, ErrInvalidInput)
+	})
+
+	t.Run(`otpauth URI`, func(t *testing.T) {
+		u, err := url.Parse(totpURI("Twitter", "davud", secret))
+		require.Nil(t, err)
+		require.Equal(t, "otpauth", u.Scheme)
+		require.Equal(t, "totp", u.Host)
+		require.Equal(t, "/Twitter:davud", u.Path)
+		require.Equal(t, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", u.Query().Get("secret"))
+		require.Equal(t, "Twitter", u.Query().Get("issuer"))
+	})
+
+	t.Run(`recovery codes are hashed ignoring case and separators`, func(t *testing.T) {
+		codes, hashes, err := newRecoveryCodes()
+		require.Nil(t, err)
+		require.Len(t, codes, recoveryCodeCount)
+		require.Equal(t, hashes[0], hashRecoveryCode(codes[0]))
+		require.Equal(t, hashes[0], hashRecoveryCode(" "+url.PathEscape(codes[0])[0:4]+string([]rune(codes[0])[5:])))
+	})
+}
@@ -0,0 +1,17 @@
+// Package tracing holds the span helpers shared by the instrumented packages of the auth service
+package tracing
+
+import (
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// EndSpan marks the span as failed if the error is not nil, and ends it.
+// It is meant to be deferred with a pointer to the named error result
+func EndSpan(span trace.Span, err *error) {
+	if *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
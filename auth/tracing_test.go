@@ -0,0 +1,38 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/event_streamer/inmemory"
+	"github.com/davudsafarli/twitter/auth/test_helpers"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+)
+
+func TestUsecasesTracing(t *testing.T) {
+	tp, exporter := test_helpers.NewTracerProvider(t)
+	uc := auth.NewUsecases(test_helpers.NewInMemoryStorage(), inmemory.New())
+	uc.TracerProvider = tp
+
+	user := test_helpers.HopefullyUniqueUser()
+	_, err := uc.SignUpUser(context.Background(), user)
+	require.Nil(t, err)
+	_, err = uc.Login(context.Background(), user.Username, "wrong password")
+	require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 4)
+
+	signup := test_helpers.FindSpan(t, spans, "Usecases.SignUpUser")
+	require.False(t, signup.Parent.IsValid(), "usecase should be the root span")
+	hash := test_helpers.FindSpan(t, spans, "PasswordHasher.Hash")
+	require.Equal(t, signup.SpanContext.SpanID(), hash.Parent.SpanID())
+
+	login := test_helpers.FindSpan(t, spans, "Usecases.Login")
+	require.Equal(t, codes.Error, login.Status.Code)
+	compare := test_helpers.FindSpan(t, spans, "PasswordHasher.Verify")
+	require.Equal(t, login.SpanContext.SpanID(), compare.Parent.SpanID())
+	require.NotEqual(t, signup.SpanContext.TraceID(), login.SpanContext.TraceID())
+}
@@ -1,8 +1,37 @@
 package auth
 
+import "time"
+
 type User struct {
 	ID       int
 	Email    string
 	Username string
 	Password string
+
+	// profile fields
+	DisplayName string
+	Bio         string
+	Location    string
+	Website     string
+	AvatarURL   string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// EmailVerifiedAt is zero until the user verifies their email
+	EmailVerifiedAt time.Time
+
+	// DeactivatedAt is zero unless the user deactivated their account, deactivated users can't log in
+	DeactivatedAt time.Time
+	// DeleteAfter is when the account is deleted for good, zero unless the user scheduled its deletion
+	DeleteAfter time.Time
+
+	// Role decides the permissions of the user on other users, RoleUser by default
+	Role Role
+	// SuspendedAt is zero unless an admin or a moderator suspended the user, suspended users can't log in
+	SuspendedAt      time.Time
+	SuspensionReason string
+
+	// TokenVersion is incremented when the sessions of the user are revoked, tokens of older versions are invalid
+	TokenVersion int `json:"-"`
 }
@@ -0,0 +1,54 @@
+// Command auth is the binary of the auth service.
+// Each functionality is a subcommand, run `auth help` to list them.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"keygen":  {usage: "generate a private key to sign the tokens with", run: runKeygen},
+	"migrate": {usage: "apply or revert the database migrations", run: runMigrate},
+	"purge":   {usage: "delete the accounts whose deletion grace period is over", run: runPurge},
+	"role":    {usage: "assign a role to a user, e.g. to make the first admin", run: runRole},
+	"serve":   {usage: "run the http server of the auth service", run: runServe},
+	"topics":  {usage: "create missing kafka topics and report drift of the existing ones", run: runTopics},
+	"offsets": {usage: "reset the offsets of a consumer group", run: runOffsets},
+	"replay":  {usage: "print the messages of a topic published in a time range, without committing offsets", run: runReplay},
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "help" || os.Args[1] == "-h" {
+		printUsage()
+		return
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+		printUsage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "usage: auth <command> [flags]")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].usage)
+	}
+}
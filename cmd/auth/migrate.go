@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/davudsafarli/twitter/auth/storage"
+	"github.com/davudsafarli/twitter/config"
+)
+
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: auth migrate [flags] up|down|to <version>|version")
+		fs.PrintDefaults()
+	}
+	cfg, err := config.Load(fs, args, config.SectionPostgres)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("missing migrate command")
+	}
+
+	ctx := context.Background()
+	// without the statement timeout, migrations can take longer than the queries of the requests
+	pg, err := storage.NewPostgres(ctx, postgresOptions(cfg.Postgres))
+	if err != nil {
+		return err
+	}
+	defer pg.Close()
+
+	switch fs.Arg(0) {
+	case "up":
+		err = storage.Migrate(ctx, pg.DB(), storage.Up)
+	case "down":
+		err = storage.Migrate(ctx, pg.DB(), storage.Down)
+	case "to":
+		var version int64
+		version, err = strconv.ParseInt(fs.Arg(1), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version %q: %w", fs.Arg(1), err)
+		}
+		err = storage.MigrateTo(ctx, pg.DB(), version)
+	case "version":
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown migrate command %q", fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	version, err := storage.MigrationVersion(ctx, pg.DB())
+	if err != nil {
+		return err
+	}
+	fmt.Println("current version:", version)
+	return nil
+}
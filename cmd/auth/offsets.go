@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth/event_streamer/kafka_sarama"
+	"github.com/davudsafarli/twitter/config"
+)
+
+func runOffsets(args []string) error {
+	fs := flag.NewFlagSet("offsets", flag.ExitOnError)
+	group := fs.String("group", "", "consumer group to reset")
+	topic := fs.String("topic", "", "topic to reset the offsets of")
+	partitions := fs.String("partitions", "", "comma separated list of partitions to reset, all partitions if empty")
+	to := fs.String("to", "", "earliest, latest, an offset, or an RFC3339 timestamp")
+	dryRun := fs.Bool("dry-run", false, "only print the planned changes")
+	cfg, err := config.Load(fs, args, config.SectionKafka)
+	if err != nil {
+		return err
+	}
+	if *group == "" || *topic == "" || *to == "" {
+		fs.Usage()
+		return fmt.Errorf("-group, -topic and -to are required")
+	}
+
+	position, err := parseOffsetPosition(*to)
+	if err != nil {
+		return err
+	}
+	req := kafka_sarama.OffsetReset{
+		GroupID: *group,
+		Topic:   *topic,
+		To:      position,
+		DryRun:  *dryRun,
+	}
+	if *partitions != "" {
+		for _, p := range strings.Split(*partitions, ",") {
+			n, err := strconv.ParseInt(p, 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid partition %q: %w", p, err)
+			}
+			req.Partitions = append(req.Partitions, int32(n))
+		}
+	}
+
+	admin, err := kafka_sarama.NewConsumerGroupAdmin(cfg.Kafka.Brokers)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	changes, err := admin.ResetOffsets(context.Background(), req)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Printf("dry run, resetting %s to %s would change:\n", *group, position)
+	} else {
+		fmt.Printf("reset %s to %s:\n", *group, position)
+	}
+	for _, c := range changes {
+		fmt.Printf("  %s/%d: %d -> %d\n", c.Topic, c.Partition, c.Current, c.New)
+	}
+	return nil
+}
+
+func parseOffsetPosition(s string) (kafka_sarama.OffsetPosition, error) {
+	switch s {
+	case "earliest":
+		return kafka_sarama.ResetToEarliest(), nil
+	case "latest":
+		return kafka_sarama.ResetToLatest(), nil
+	}
+	if offset, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return kafka_sarama.ResetToOffset(offset), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return kafka_sarama.OffsetPosition{}, fmt.Errorf("invalid position %q, expected earliest, latest, an offset or an RFC3339 timestamp", s)
+	}
+	return kafka_sarama.ResetToTimestamp(t), nil
+}
+
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	topic := fs.String("topic", "", "topic to replay, the topic of the user events if empty")
+	from := fs.String("from", "", "RFC3339 timestamp to replay from, beginning of the topic if empty")
+	to := fs.String("to", "", "RFC3339 timestamp to replay until, current end of the topic if empty")
+	unsafeRaw := fs.Bool("unsafe-raw", false, "print the messages unredacted, with the emails of the users and the password hashes of the older signup events")
+	cfg, err := config.Load(fs, args, config.SectionKafka)
+	if err != nil {
+		return err
+	}
+	if *topic == "" {
+		*topic = cfg.Kafka.UsersTopic
+	}
+	var fromT, toT time.Time
+	if *from != "" {
+		if fromT, err = time.Parse(time.RFC3339, *from); err != nil {
+			return err
+		}
+	}
+	if *to != "" {
+		if toT, err = time.Parse(time.RFC3339, *to); err != nil {
+			return err
+		}
+	}
+
+	// replayed messages are printed as json lines, so they can be piped to other tools.
+	// The fields the logs redact are redacted unless -unsafe-raw is set
+	enc := json.NewEncoder(os.Stdout)
+	var encodeErr error
+	options := kafkaOptions(cfg.Kafka, nil)
+	options.UserEventsTopic = *topic
+	err = kafka_sarama.Replay(context.Background(), options, fromT, toT, func(msg kafka_sarama.KafkaMessage) {
+		if encodeErr != nil {
+			return
+		}
+		if *unsafeRaw {
+			encodeErr = enc.Encode(msg)
+			return
+		}
+		var raw json.RawMessage
+		if raw, encodeErr = kafka_sarama.Redacted(msg, nil); encodeErr == nil {
+			encodeErr = enc.Encode(raw)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return encodeErr
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/davudsafarli/twitter/auth"
+)
+
+// oidcProvidersFlag collects the repeated -oidc-provider flags in the form name=path, where path is a JSON file like
+//
+//	{"issuer": "https://accounts.google.com", "client_id": "...", "client_secret": "...",
+//	 "redirect_url": "https://example.com/oidc/callback", "scopes": ["email", "profile"]}
+//
+// The file keeps the client secret out of the command line
+type oidcProvidersFlag []auth.OIDCProvider
+
+func (f *oidcProvidersFlag) String() string {
+	names := make([]string, 0, len(*f))
+	for _, p := range *f {
+		names = append(names, p.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (f *oidcProvidersFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("%q is not in the form name=path", value)
+	}
+	raw, err := ioutil.ReadFile(parts[1])
+	if err != nil {
+		return err
+	}
+	var file struct {
+		Issuer       string   `json:"issuer"`
+		ClientID     string   `json:"client_id"`
+		ClientSecret string   `json:"client_secret"`
+		RedirectURL  string   `json:"redirect_url"`
+		Scopes       []string `json:"scopes"`
+	}
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return fmt.Errorf("invalid provider file %s: %w", parts[1], err)
+	}
+	if file.Issuer == "" || file.ClientID == "" || file.RedirectURL == "" {
+		return fmt.Errorf("provider file %s needs issuer, client_id and redirect_url", parts[1])
+	}
+	*f = append(*f, auth.OIDCProvider{
+		Name:         parts[0],
+		Issuer:       file.Issuer,
+		ClientID:     file.ClientID,
+		ClientSecret: file.ClientSecret,
+		RedirectURL:  file.RedirectURL,
+		Scopes:       file.Scopes,
+	})
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/davudsafarli/twitter/auth/event_streamer/kafka_sarama"
+	"github.com/davudsafarli/twitter/auth/logging"
+	"github.com/davudsafarli/twitter/auth/storage"
+	"github.com/davudsafarli/twitter/config"
+)
+
+// postgresOptions maps the postgres section of the configuration to the options of storage
+func postgresOptions(c config.Postgres) storage.Options {
+	return storage.Options{
+		ConnStr:          c.ConnStr,
+		MaxOpenConns:     c.MaxOpenConns,
+		MaxIdleConns:     c.MaxIdleConns,
+		ConnMaxLifetime:  c.ConnMaxLifetime,
+		StartupTimeout:   c.StartupTimeout,
+		StatementTimeout: c.StatementTimeout,
+	}
+}
+
+// kafkaOptions maps the kafka section of the configuration to the options of kafka_sarama
+func kafkaOptions(c config.Kafka, logger logging.Logger) kafka_sarama.Options {
+	return kafka_sarama.Options{
+		Brokers:                   c.Brokers,
+		UserEventsTopic:           c.UsersTopic,
+		UserEventsConsumerGroupID: c.ConsumerGroup,
+		Logger:                    logger,
+		LogPayloads:               c.LogPayloads,
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/blobstore"
+	"github.com/davudsafarli/twitter/auth/event_streamer/kafka_sarama"
+	"github.com/davudsafarli/twitter/auth/logging"
+	"github.com/davudsafarli/twitter/auth/storage"
+	"github.com/davudsafarli/twitter/config"
+)
+
+// runPurge deletes the accounts whose deletion grace period is over, the expired data exports and login attempts.
+// It is meant to be run periodically, e.g. by cron
+func runPurge(args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	batch := fs.Int("batch", 100, "number of accounts, exports or login attempts deleted per query")
+	blobDir := fs.String("blob-dir", "blobs", "directory the data export archives are stored in")
+	cfg, err := config.Load(fs, args, config.SectionPostgres, config.SectionKafka)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	pg, err := storage.NewPostgres(ctx, postgresOptions(cfg.Postgres))
+	if err != nil {
+		return err
+	}
+	defer pg.Close()
+	k, err := kafka_sarama.NewSarama(kafkaOptions(cfg.Kafka, logging.New(os.Stderr, logging.LevelInfo)))
+	if err != nil {
+		return err
+	}
+	blobs, err := blobstore.NewFilesystem(*blobDir)
+	if err != nil {
+		return err
+	}
+	uc := auth.NewUsecases(pg, &k)
+	uc.DataExports = auth.DataExportOptions{Blobs: blobs}
+
+	for _, p := range []struct {
+		what  string
+		purge func(ctx context.Context, limit int) (int, error)
+	}{
+		{"accounts", uc.PurgeDeletedAccounts},
+		{"data exports", uc.PurgeExpiredDataExports},
+		{"login attempts", func(ctx context.Context, limit int) (int, error) {
+			return pg.PurgeExpiredLoginAttempts(ctx, time.Now(), limit)
+		}},
+	} {
+		total := 0
+		start := time.Now()
+		for {
+			purged, err := p.purge(ctx, *batch)
+			total += purged
+			if err != nil {
+				return fmt.Errorf("purged %d %s before failing: %w", total, p.what, err)
+			}
+			if purged < *batch {
+				break
+			}
+		}
+		fmt.Printf("purged %d %s in %v\n", total, p.what, time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/event_streamer/kafka_sarama"
+	"github.com/davudsafarli/twitter/auth/logging"
+	"github.com/davudsafarli/twitter/auth/storage"
+	"github.com/davudsafarli/twitter/config"
+)
+
+// runRole assigns a role as the operators, e.g. to make the first admin. It is audited like /admin/users/role, which admins use afterwards
+func runRole(args []string) error {
+	fs := flag.NewFlagSet("role", flag.ExitOnError)
+	username := fs.String("user", "", "username of the user")
+	role := fs.String("role", "", "user, moderator or admin")
+	cfg, err := config.Load(fs, args, config.SectionPostgres, config.SectionKafka)
+	if err != nil {
+		return err
+	}
+	if *username == "" || *role == "" {
+		fs.Usage()
+		return fmt.Errorf("-user and -role are required")
+	}
+
+	ctx := context.Background()
+	pg, err := storage.NewPostgres(ctx, postgresOptions(cfg.Postgres))
+	if err != nil {
+		return err
+	}
+	defer pg.Close()
+	k, err := kafka_sarama.NewSarama(kafkaOptions(cfg.Kafka, logging.New(os.Stderr, logging.LevelInfo)))
+	if err != nil {
+		return err
+	}
+	uc := auth.NewUsecases(pg, &k)
+
+	user, err := pg.FindUser(ctx, *username)
+	if err != nil {
+		return err
+	}
+	if err := uc.AssignRoleAsOperator(ctx, user.ID, auth.Role(*role)); err != nil {
+		return err
+	}
+	fmt.Printf("%s is %s now\n", user.Username, *role)
+	return nil
+}
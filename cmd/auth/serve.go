@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/davudsafarli/twitter/auth"
+	"github.com/davudsafarli/twitter/auth/blobstore"
+	"github.com/davudsafarli/twitter/auth/event_streamer/kafka_sarama"
+	"github.com/davudsafarli/twitter/auth/grpc_api"
+	"github.com/davudsafarli/twitter/auth/health"
+	"github.com/davudsafarli/twitter/auth/http_api"
+	"github.com/davudsafarli/twitter/auth/logging"
+	"github.com/davudsafarli/twitter/auth/mailer"
+	"github.com/davudsafarli/twitter/auth/metrics"
+	"github.com/davudsafarli/twitter/auth/storage"
+	"github.com/davudsafarli/twitter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+)
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	migrate := fs.Bool("migrate", false, "apply the pending database migrations before serving")
+	logLevel := fs.String("log-level", "info", "debug, info, warn or error")
+	mailDir := fs.String("mail-dir", "mail", "directory the emails to users are written to")
+	blobDir := fs.String("blob-dir", "blobs", "directory the data export archives are stored in")
+	exportInterval := fs.Duration("export-interval", 30*time.Second, "how often the pending data exports are built")
+	readinessTimeout := fs.Duration("readiness-timeout", health.DefaultTimeout, "timeout of each check of /readyz")
+	otlpEndpoint := fs.String("otlp-endpoint", "", "host:port of the OTLP/gRPC collector the traces are exported to, empty disables the export")
+	var oidcProviders oidcProvidersFlag
+	fs.Var(&oidcProviders, "oidc-provider", "name=path of a JSON file configuring an OIDC provider users can sign in with, can be repeated")
+	cfg, err := config.Load(fs, args, config.SectionServer, config.SectionPostgres, config.SectionKafka, config.SectionJWT, config.SectionMFA)
+	if err != nil {
+		return err
+	}
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		return err
+	}
+	logger := logging.New(os.Stderr, level)
+	tokens, err := cfg.JWT.TokenOptions()
+	if err != nil {
+		return err
+	}
+	mfa, err := cfg.MFA.MFAOptions()
+	if err != nil {
+		return err
+	}
+
+	shutdownTracing, err := installTracing(context.Background(), *otlpEndpoint)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing()
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewGoCollector(), prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	m, err := metrics.New(reg)
+	if err != nil {
+		return err
+	}
+
+	pg, err := storage.NewPostgres(context.Background(), postgresOptions(cfg.Postgres))
+	if err != nil {
+		return err
+	}
+	defer pg.Close()
+	if *migrate {
+		if err := storage.Migrate(context.Background(), pg.DB(), storage.Up); err != nil {
+			return err
+		}
+	}
+	kafkaOpts := kafkaOptions(cfg.Kafka, logger)
+	kafkaOpts.Metrics = m
+	k, err := kafka_sarama.NewSarama(kafkaOpts)
+	if err != nil {
+		return err
+	}
+	mails, err := mailer.NewFile(*mailDir)
+	if err != nil {
+		return err
+	}
+	blobs, err := blobstore.NewFilesystem(*blobDir)
+	if err != nil {
+		return err
+	}
+	uc := auth.NewUsecases(pg, &k)
+	uc.Metrics = m
+	uc.Logger = logger
+	uc.Mailer = mails
+	uc.LoginThrottling = auth.LoginThrottling{Store: pg}
+	uc.Tokens = tokens
+	uc.MFA = mfa
+	uc.DataExports = auth.DataExportOptions{Blobs: blobs}
+	uc.OIDC = auth.OIDCOptions{Providers: oidcProviders}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go buildDataExports(ctx, uc, *exportInterval, logger)
+
+	server := &http.Server{
+		Addr: cfg.Server.HTTPAddr,
+		Handler: http_api.NewHandler(uc, http_api.Options{
+			MetricsHandler: metrics.Handler(reg),
+			ReadinessChecks: []health.Check{
+				{Name: "postgres", Checker: health.CheckerFunc(pg.HealthCheck), Timeout: *readinessTimeout},
+				{Name: "kafka", Checker: health.CheckerFunc(k.HealthCheck), Timeout: *readinessTimeout},
+			},
+		}),
+	}
+	var grpcServer *grpc.Server
+	if cfg.Server.GRPCAddr != "" {
+		grpcServer = grpc_api.NewServer(uc)
+	}
+	return listenAndServe(server, grpcServer, cfg.Server.GRPCAddr, logger)
+}
+
+// buildDataExports builds the pending data exports every interval until ctx is done
+func buildDataExports(ctx context.Context, uc auth.Usecases, interval time.Duration, logger logging.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		built, err := uc.BuildDataExports(ctx, 10)
+		if err != nil {
+			logger.Error("building data exports failed", "err", err)
+		} else if built > 0 {
+			logger.Info("built data exports", "count", built)
+		}
+	}
+}
+
+// listenAndServe runs the servers until SIGINT or SIGTERM, and shuts them down gracefully. grpcServer is optional
+func listenAndServe(server *http.Server, grpcServer *grpc.Server, grpcAddr string, logger logging.Logger) error {
+	errc := make(chan error, 2)
+	go func() {
+		logger.Info("http server is listening", "addr", server.Addr)
+		errc <- server.ListenAndServe()
+	}()
+	if grpcServer != nil {
+		listener, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			return err
+		}
+		go func() {
+			logger.Info("grpc server is listening", "addr", grpcAddr)
+			errc <- grpcServer.Serve(listener)
+		}()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-errc:
+		return err
+	case sig := <-stop:
+		logger.Info("shutting down", "signal", sig)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if grpcServer != nil {
+		// GracefulStop waits for the pending calls without a deadline, so it is cut short with the timeout of Shutdown
+		go func() {
+			<-ctx.Done()
+			grpcServer.Stop()
+		}()
+		defer grpcServer.GracefulStop()
+	}
+	return server.Shutdown(ctx)
+}
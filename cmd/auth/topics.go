@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/davudsafarli/twitter/auth/event_streamer/kafka_sarama"
+	"github.com/davudsafarli/twitter/config"
+)
+
+func runTopics(args []string) error {
+	fs := flag.NewFlagSet("topics", flag.ExitOnError)
+	check := fs.Bool("check", false, "only report missing topics and drift, don't create anything")
+	replicationFactor := fs.Int("replication-factor", 0, "overrides the replication factor of the topic specs")
+	cfg, err := config.Load(fs, args, config.SectionKafka)
+	if err != nil {
+		return err
+	}
+
+	specs := kafka_sarama.DefaultTopicSpecs(cfg.Kafka.UsersTopic)
+	if *replicationFactor > 0 {
+		for i := range specs {
+			specs[i].ReplicationFactor = int16(*replicationFactor)
+		}
+	}
+
+	tm, err := kafka_sarama.NewTopicManager(cfg.Kafka.Brokers)
+	if err != nil {
+		return err
+	}
+	defer tm.Close()
+
+	var statuses []kafka_sarama.TopicStatus
+	if *check {
+		statuses, err = tm.Check(specs)
+	} else {
+		statuses, err = tm.Ensure(specs)
+	}
+	// statuses can be partially filled even if Ensure fails, print them anyway
+	outOfSync := false
+	for _, status := range statuses {
+		switch {
+		case status.Created:
+			fmt.Printf("%s: created\n", status.Spec.Name)
+		case status.Missing:
+			outOfSync = true
+			fmt.Printf("%s: missing\n", status.Spec.Name)
+		case len(status.Drift) > 0:
+			outOfSync = true
+			fmt.Printf("%s: drifted\n", status.Spec.Name)
+			for _, d := range status.Drift {
+				fmt.Printf("  %s\n", d)
+			}
+		default:
+			fmt.Printf("%s: ok\n", status.Spec.Name)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if outOfSync {
+		return fmt.Errorf("some topics are missing or differ from their specs")
+	}
+	return nil
+}
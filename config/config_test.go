@@ -0,0 +1,205 @@
+package config_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/davudsafarli/twitter/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	env := func(vars map[string]string) config.Loader {
+		return config.Loader{LookupEnv: func(key string) (string, bool) {
+			v, ok := vars[key]
+			return v, ok
+		}}
+	}
+	writeFile := func(t *testing.T, name, content string) string {
+		path := filepath.Join(t.TempDir(), name)
+		require.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+	load := func(l config.Loader, args ...string) (config.Config, error) {
+		return l.Load(flag.NewFlagSet("test", flag.ContinueOnError), args,
+			config.SectionServer, config.SectionPostgres, config.SectionKafka, config.SectionJWT, config.SectionMFA)
+	}
+
+	t.Run(`the defaults are valid`, func(t *testing.T) {
+		c, err := load(env(nil))
+		require.Nil(t, err)
+		require.Equal(t, config.Default(), c)
+	})
+
+	t.Run(`the file is overridden by the environment, and the environment by the flags`, func(t *testing.T) {
+		path := writeFile(t, "config.yaml", `
+server:
+  http_addr: ":8001"
+  grpc_addr: ":9001"
+postgres:
+  statement_timeout: 1s
+kafka:
+  brokers: [kafka-1:9092, kafka-2:9092]
+  users_topic: file-users
+jwt:
+  ttl: 1h
+`)
+		c, err := load(env(map[string]string{
+			"TWITTER_CONFIG":            path,
+			"TWITTER_SERVER_GRPC_ADDR":  "",
+			"TWITTER_KAFKA_USERS_TOPIC": "env-users",
+			"TWITTER_JWT_TTL":           "2h",
+		}), "-token-ttl", "3h", "-brokers", "kafka-3:9092")
+		require.Nil(t, err)
+		require.Equal(t, ":8001", c.Server.HTTPAddr)
+		require.Empty(t, c.Server.GRPCAddr)
+		require.Equal(t, time.Second, c.Postgres.StatementTimeout)
+		require.Equal(t, config.Default().Postgres.ConnStr, c.Postgres.ConnStr)
+		require.Equal(t, []string{"kafka-3:9092"}, c.Kafka.Brokers)
+		require.Equal(t, "env-users", c.Kafka.UsersTopic)
+		require.Equal(t, 3*time.Hour, c.JWT.TTL)
+	})
+
+	t.Run(`the pool and the consumer settings follow the same precedence`, func(t *testing.T) {
+		path := writeFile(t, "config.yaml", `
+postgres:
+  max_open_conns: 10
+  max_idle_conns: 5
+  conn_max_lifetime: 10m
+  startup_timeout: 10s
+kafka:
+  consumer_group: file-group
+`)
+		c, err := load(env(map[string]string{
+			"TWITTER_CONFIG":                     path,
+			"TWITTER_POSTGRES_MAX_IDLE_CONNS":    "2",
+			"TWITTER_POSTGRES_CONN_MAX_LIFETIME": "5m",
+			"TWITTER_KAFKA_CONSUMER_GROUP":       "env-group",
+			"TWITTER_KAFKA_LOG_PAYLOADS":         "false",
+		}), "-db-max-idle-conns", "3", "-log-kafka-payloads")
+		require.Nil(t, err)
+		require.Equal(t, 10, c.Postgres.MaxOpenConns)
+		require.Equal(t, 3, c.Postgres.MaxIdleConns)
+		require.Equal(t, 5*time.Minute, c.Postgres.ConnMaxLifetime)
+		require.Equal(t, 10*time.Second, c.Postgres.StartupTimeout)
+		require.Equal(t, "env-group", c.Kafka.ConsumerGroup)
+		require.True(t, c.Kafka.LogPayloads)
+	})
+
+	t.Run(`TOML files are supported, and -config takes precedence over TWITTER_CONFIG`, func(t *testing.T) {
+		path := writeFile(t, "config.toml", `
+[kafka]
+brokers = ["kafka-1:9092"]
+
+[jwt]
+keys = ["2021=/keys/2021.pem@2021-08-01T00:00:00Z"]
+`)
+		c, err := load(env(map[string]string{"TWITTER_CONFIG": "missing.yaml"}), "-config", path)
+		require.Nil(t, err)
+		require.Equal(t, []string{"kafka-1:9092"}, c.Kafka.Brokers)
+		require.Equal(t, []string{"2021=/keys/2021.pem@2021-08-01T00:00:00Z"}, c.JWT.Keys)
+	})
+
+	t.Run(`list flags can be repeated or comma separated`, func(t *testing.T) {
+		c, err := load(env(map[string]string{"TWITTER_KAFKA_BROKERS": "env-1:9092, env-2:9092"}))
+		require.Nil(t, err)
+		require.Equal(t, []string{"env-1:9092", "env-2:9092"}, c.Kafka.Brokers)
+
+		c, err = load(env(nil), "-jwt-key", "a=/a.pem", "-jwt-key", "b=/b.pem,c=/c.pem")
+		require.Nil(t, err)
+		require.Equal(t, []string{"a=/a.pem", "b=/b.pem", "c=/c.pem"}, c.JWT.Keys)
+	})
+
+	t.Run(`the secret files take precedence over the values`, func(t *testing.T) {
+		connStr := writeFile(t, "postgres", "postgres://user:secret@db:5432/twitter\n")
+		secret := writeFile(t, "jwt", "a-secret-of-at-least-thirty-two-bytes")
+		mfaKey := writeFile(t, "mfa", "000102030405060708090a0b0c0d0e0f\n")
+		c, err := load(env(map[string]string{
+			"TWITTER_JWT_SECRET":      "overridden",
+			"TWITTER_JWT_SECRET_FILE": secret,
+		}), "-db-file", connStr, "-mfa-encryption-key-file", mfaKey)
+		require.Nil(t, err)
+		require.Equal(t, "postgres://user:secret@db:5432/twitter", c.Postgres.ConnStr)
+		require.Equal(t, "a-secret-of-at-least-thirty-two-bytes", c.JWT.Secret)
+		mfa, err := c.MFA.MFAOptions()
+		require.Nil(t, err)
+		require.Equal(t, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, mfa.EncryptionKey)
+
+		_, err = load(env(map[string]string{"TWITTER_JWT_SECRET_FILE": filepath.Join(t.TempDir(), "missing")}))
+		require.NotNil(t, err)
+	})
+
+	t.Run(`invalid values and unknown settings are rejected`, func(t *testing.T) {
+		for name, l := range map[string]config.Loader{
+			"address":        env(map[string]string{"TWITTER_SERVER_HTTP_ADDR": "8000"}),
+			"duration":       env(map[string]string{"TWITTER_JWT_TTL": "a day"}),
+			"ttl":            env(map[string]string{"TWITTER_JWT_TTL": "0s"}),
+			"session age":    env(map[string]string{"TWITTER_JWT_MAX_SESSION_AGE": "-1h"}),
+			"brokers":        env(map[string]string{"TWITTER_KAFKA_BROKERS": ""}),
+			"short secret":   env(map[string]string{"TWITTER_JWT_SECRET": "jwt_secret"}),
+			"key":            env(map[string]string{"TWITTER_JWT_KEYS": "no-path"}),
+			"mfa key":        env(map[string]string{"TWITTER_MFA_ENCRYPTION_KEY": "0001"}),
+			"mfa hex":        env(map[string]string{"TWITTER_MFA_ENCRYPTION_KEY": "not-hex"}),
+			"unknown":        env(map[string]string{"TWITTER_CONFIG": writeFile(t, "typo.yaml", "postgres:\n  conn_string: x\n")}),
+			"list in scalar": env(map[string]string{"TWITTER_CONFIG": writeFile(t, "list.yaml", "kafka:\n  users_topic: [a, b]\n")}),
+			"format":         env(map[string]string{"TWITTER_CONFIG": writeFile(t, "config.json", "{}")}),
+			"int":            env(map[string]string{"TWITTER_POSTGRES_MAX_OPEN_CONNS": "many"}),
+			"pool":           env(map[string]string{"TWITTER_POSTGRES_MAX_OPEN_CONNS": "0"}),
+			"idle":           env(map[string]string{"TWITTER_POSTGRES_MAX_IDLE_CONNS": "26"}),
+			"bool":           env(map[string]string{"TWITTER_KAFKA_LOG_PAYLOADS": "sometimes"}),
+			"group":          env(map[string]string{"TWITTER_KAFKA_CONSUMER_GROUP": ""}),
+		} {
+			_, err := load(l)
+			require.NotNil(t, err, name)
+		}
+		_, err := load(env(nil), "-db-statement-timeout", "-1s")
+		require.NotNil(t, err)
+	})
+
+	t.Run(`only the flags of the sections are registered`, func(t *testing.T) {
+		flags := func() *flag.FlagSet {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(ioutil.Discard)
+			return fs
+		}
+		_, err := env(nil).Load(flags(), []string{"-db", "postgres://db"}, config.SectionPostgres)
+		require.Nil(t, err)
+		_, err = env(nil).Load(flags(), []string{"-brokers", "kafka:9092"}, config.SectionPostgres)
+		require.NotNil(t, err)
+	})
+
+	t.Run(`only the sections are validated`, func(t *testing.T) {
+		invalid := env(map[string]string{"TWITTER_KAFKA_BROKERS": "", "TWITTER_JWT_TTL": "0s"})
+		_, err := invalid.Load(flag.NewFlagSet("test", flag.ContinueOnError), nil, config.SectionPostgres)
+		require.Nil(t, err)
+		_, err = invalid.Load(flag.NewFlagSet("test", flag.ContinueOnError), nil, config.SectionPostgres, config.SectionKafka)
+		require.NotNil(t, err)
+		_, err = invalid.FromEnv()
+		require.NotNil(t, err)
+
+		c := config.Default()
+		c.JWT.TTL = 0
+		require.Nil(t, c.Validate(config.SectionServer, config.SectionPostgres))
+		require.NotNil(t, c.Validate())
+	})
+}
+
+func TestJWTTokenOptions(t *testing.T) {
+	_, err := config.Default().JWT.TokenOptions()
+	require.NotNil(t, err, "tokens should not be signed with a random secret")
+
+	jwt := config.Default().JWT
+	jwt.Secret = "a-secret-of-at-least-thirty-two-bytes"
+	options, err := jwt.TokenOptions()
+	require.Nil(t, err)
+	require.Equal(t, []byte(jwt.Secret), options.Secret)
+	require.Equal(t, 24*time.Hour, options.TTL)
+	require.Equal(t, 30*24*time.Hour, options.MaxSessionAge)
+
+	jwt.Keys = []string{"kid=" + filepath.Join(t.TempDir(), "missing.pem")}
+	_, err = jwt.TokenOptions()
+	require.NotNil(t, err)
+}
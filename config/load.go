@@ -0,0 +1,443 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Section is a part of Config. Binaries register the flags of the sections they use
+type Section int
+
+const (
+	SectionServer Section = iota
+	SectionPostgres
+	SectionKafka
+	SectionJWT
+	SectionMFA
+)
+
+var allSections = []Section{SectionServer, SectionPostgres, SectionKafka, SectionJWT, SectionMFA}
+
+// EnvPrefix prefixes the environment variables of the settings, and TWITTER_CONFIG is the file if -config isn't set
+const EnvPrefix = "TWITTER_"
+
+// setting is a value of Config that can be set by every layer
+type setting struct {
+	// key is the name of the setting in the file, e.g. postgres.conn_str.
+	// The environment variable is the upper case key with underscores and EnvPrefix, e.g. TWITTER_POSTGRES_CONN_STR
+	key     string
+	section Section
+	// flag is empty for the secrets, flags are visible in the process list
+	flag  string
+	usage string
+	// list settings are comma separated in the environment and the flags, list flags can be repeated too
+	list bool
+	// isBool flags can be set without a value, e.g. -log-kafka-payloads
+	isBool bool
+	set    func(c *Config, values []string) error
+	get    func(c *Config) string
+}
+
+var settings = []setting{
+	stringSetting("server.http_addr", SectionServer, "addr", "address of the http server",
+		func(c *Config) *string { return &c.Server.HTTPAddr }),
+	stringSetting("server.grpc_addr", SectionServer, "grpc-addr", "address of the grpc server, empty disables it",
+		func(c *Config) *string { return &c.Server.GRPCAddr }),
+	stringSetting("postgres.conn_str", SectionPostgres, "db", "postgres connection string",
+		func(c *Config) *string { return &c.Postgres.ConnStr }),
+	stringSetting("postgres.conn_str_file", SectionPostgres, "db-file", "file containing the postgres connection string, it takes precedence over -db",
+		func(c *Config) *string { return &c.Postgres.ConnStrFile }),
+	durationSetting("postgres.statement_timeout", SectionPostgres, "db-statement-timeout", "postgres statement timeout, 0 disables it",
+		func(c *Config) *time.Duration { return &c.Postgres.StatementTimeout }),
+	intSetting("postgres.max_open_conns", SectionPostgres, "db-max-open-conns", "size of the postgres connection pool",
+		func(c *Config) *int { return &c.Postgres.MaxOpenConns }),
+	intSetting("postgres.max_idle_conns", SectionPostgres, "db-max-idle-conns", "idle postgres connections kept open, 0 keeps -db-max-open-conns of them",
+		func(c *Config) *int { return &c.Postgres.MaxIdleConns }),
+	durationSetting("postgres.conn_max_lifetime", SectionPostgres, "db-conn-max-lifetime", "how long a postgres connection is reused",
+		func(c *Config) *time.Duration { return &c.Postgres.ConnMaxLifetime }),
+	durationSetting("postgres.startup_timeout", SectionPostgres, "db-startup-timeout", "how long to wait for postgres to be reachable at startup",
+		func(c *Config) *time.Duration { return &c.Postgres.StartupTimeout }),
+	listSetting("kafka.brokers", SectionKafka, "brokers", "comma separated list of kafka brokers",
+		func(c *Config) *[]string { return &c.Kafka.Brokers }),
+	stringSetting("kafka.users_topic", SectionKafka, "users-topic", "topic of the user events",
+		func(c *Config) *string { return &c.Kafka.UsersTopic }),
+	stringSetting("kafka.consumer_group", SectionKafka, "consumer-group", "consumer group of the user events",
+		func(c *Config) *string { return &c.Kafka.ConsumerGroup }),
+	boolSetting("kafka.log_payloads", SectionKafka, "log-kafka-payloads", "add the redacted values of the kafka messages to their debug logs",
+		func(c *Config) *bool { return &c.Kafka.LogPayloads }),
+	stringSetting("jwt.secret", SectionJWT, "", "",
+		func(c *Config) *string { return &c.JWT.Secret }),
+	stringSetting("jwt.secret_file", SectionJWT, "jwt-secret-file", "file containing the secret (at least 32 bytes) the tokens are signed with if -jwt-key isn't set",
+		func(c *Config) *string { return &c.JWT.SecretFile }),
+	listSetting("jwt.keys", SectionJWT, "jwt-key", "kid=path[@activeFrom] of a PEM private key that signs the tokens from activeFrom (RFC 3339), can be repeated for rotation",
+		func(c *Config) *[]string { return &c.JWT.Keys }),
+	durationSetting("jwt.ttl", SectionJWT, "token-ttl", "how long the tokens are valid",
+		func(c *Config) *time.Duration { return &c.JWT.TTL }),
+	durationSetting("jwt.max_session_age", SectionJWT, "max-session-age", "how long after the login the tokens can be refreshed",
+		func(c *Config) *time.Duration { return &c.JWT.MaxSessionAge }),
+	stringSetting("mfa.encryption_key", SectionMFA, "", "",
+		func(c *Config) *string { return &c.MFA.EncryptionKey }),
+	stringSetting("mfa.encryption_key_file", SectionMFA, "mfa-encryption-key-file", "file containing the hex encoded AES key (16, 24 or 32 bytes) of the TOTP secrets, TOTP can't be enrolled without it",
+		func(c *Config) *string { return &c.MFA.EncryptionKeyFile }),
+}
+
+func stringSetting(key string, section Section, flag, usage string, field func(c *Config) *string) setting {
+	return setting{
+		key: key, section: section, flag: flag, usage: usage,
+		set: func(c *Config, values []string) error {
+			*field(c) = values[0]
+			return nil
+		},
+		get: func(c *Config) string { return *field(c) },
+	}
+}
+
+func intSetting(key string, section Section, flag, usage string, field func(c *Config) *int) setting {
+	return setting{
+		key: key, section: section, flag: flag, usage: usage,
+		set: func(c *Config, values []string) error {
+			n, err := strconv.Atoi(values[0])
+			if err != nil {
+				return err
+			}
+			*field(c) = n
+			return nil
+		},
+		get: func(c *Config) string { return strconv.Itoa(*field(c)) },
+	}
+}
+
+func boolSetting(key string, section Section, flag, usage string, field func(c *Config) *bool) setting {
+	return setting{
+		key: key, section: section, flag: flag, usage: usage, isBool: true,
+		set: func(c *Config, values []string) error {
+			b, err := strconv.ParseBool(values[0])
+			if err != nil {
+				return err
+			}
+			*field(c) = b
+			return nil
+		},
+		get: func(c *Config) string { return strconv.FormatBool(*field(c)) },
+	}
+}
+
+func durationSetting(key string, section Section, flag, usage string, field func(c *Config) *time.Duration) setting {
+	return setting{
+		key: key, section: section, flag: flag, usage: usage,
+		set: func(c *Config, values []string) error {
+			d, err := time.ParseDuration(values[0])
+			if err != nil {
+				return err
+			}
+			*field(c) = d
+			return nil
+		},
+		get: func(c *Config) string { return field(c).String() },
+	}
+}
+
+func listSetting(key string, section Section, flag, usage string, field func(c *Config) *[]string) setting {
+	return setting{
+		key: key, section: section, flag: flag, usage: usage, list: true,
+		set: func(c *Config, values []string) error {
+			*field(c) = append([]string(nil), values...)
+			return nil
+		},
+		get: func(c *Config) string { return strings.Join(*field(c), ",") },
+	}
+}
+
+func (s setting) envName() string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(s.key, ".", "_", -1))
+}
+
+// Loader loads a Config from the layers described in the package doc
+type Loader struct {
+	// LookupEnv reads the environment variables, os.LookupEnv is used if it is nil
+	LookupEnv func(key string) (string, bool)
+}
+
+func (l Loader) withDefaults() Loader {
+	if l.LookupEnv == nil {
+		l.LookupEnv = os.LookupEnv
+	}
+	return l
+}
+
+// Load registers the -config flag and the flags of the sections to fs, and parses the args with it.
+// Binaries register their own flags to fs before, and read them after Load.
+// The file is the one of -config, or of TWITTER_CONFIG if the flag isn't set. Its format is decided by its extension,
+// .yaml, .yml or .toml, and the settings are nested by the first part of their keys, e.g.
+//
+//	postgres:
+//	  conn_str_file: /run/secrets/postgres
+//	kafka:
+//	  brokers: [kafka-1:9092, kafka-2:9092]
+//
+// The settings, their environment variables and their flags are:
+//
+//	server.http_addr            TWITTER_SERVER_HTTP_ADDR            -addr
+//	server.grpc_addr            TWITTER_SERVER_GRPC_ADDR            -grpc-addr
+//	postgres.conn_str           TWITTER_POSTGRES_CONN_STR           -db
+//	postgres.conn_str_file      TWITTER_POSTGRES_CONN_STR_FILE      -db-file
+//	postgres.statement_timeout  TWITTER_POSTGRES_STATEMENT_TIMEOUT  -db-statement-timeout
+//	postgres.max_open_conns     TWITTER_POSTGRES_MAX_OPEN_CONNS     -db-max-open-conns
+//	postgres.max_idle_conns     TWITTER_POSTGRES_MAX_IDLE_CONNS     -db-max-idle-conns
+//	postgres.conn_max_lifetime  TWITTER_POSTGRES_CONN_MAX_LIFETIME  -db-conn-max-lifetime
+//	postgres.startup_timeout    TWITTER_POSTGRES_STARTUP_TIMEOUT    -db-startup-timeout
+//	kafka.brokers               TWITTER_KAFKA_BROKERS               -brokers
+//	kafka.users_topic           TWITTER_KAFKA_USERS_TOPIC           -users-topic
+//	kafka.consumer_group        TWITTER_KAFKA_CONSUMER_GROUP        -consumer-group
+//	kafka.log_payloads          TWITTER_KAFKA_LOG_PAYLOADS          -log-kafka-payloads
+//	jwt.secret                  TWITTER_JWT_SECRET
+//	jwt.secret_file             TWITTER_JWT_SECRET_FILE             -jwt-secret-file
+//	jwt.keys                    TWITTER_JWT_KEYS                    -jwt-key
+//	jwt.ttl                     TWITTER_JWT_TTL                     -token-ttl
+//	jwt.max_session_age         TWITTER_JWT_MAX_SESSION_AGE         -max-session-age
+//	mfa.encryption_key          TWITTER_MFA_ENCRYPTION_KEY
+//	mfa.encryption_key_file     TWITTER_MFA_ENCRYPTION_KEY_FILE     -mfa-encryption-key-file
+//
+// The secret files are read after all the layers, and the result is validated
+func (l Loader) Load(fs *flag.FlagSet, args []string, sections ...Section) (Config, error) {
+	l = l.withDefaults()
+	defaults := Default()
+	path := fs.String("config", "", "YAML or TOML file of the configuration, "+EnvPrefix+"CONFIG is read if it isn't set")
+	flags := map[string]*flagValue{}
+	for _, s := range settings {
+		if s.flag == "" || !hasSection(sections, s.section) {
+			continue
+		}
+		f := &flagValue{list: s.list, isBool: s.isBool, defValue: s.get(&defaults)}
+		fs.Var(f, s.flag, s.usage)
+		flags[s.key] = f
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	return l.load(*path, sections, func(c *Config) error {
+		for _, s := range settings {
+			f, ok := flags[s.key]
+			if !ok || !f.isSet {
+				continue
+			}
+			if err := s.set(c, f.values); err != nil {
+				return fmt.Errorf("-%s: %w", s.flag, err)
+			}
+		}
+		return nil
+	})
+}
+
+// FromEnv loads the configuration without flags, e.g. for the integration tests
+func (l Loader) FromEnv() (Config, error) {
+	return l.withDefaults().load("", allSections, nil)
+}
+
+// Load is Loader.Load with the real environment
+func Load(fs *flag.FlagSet, args []string, sections ...Section) (Config, error) {
+	return Loader{}.Load(fs, args, sections...)
+}
+
+// FromEnv is Loader.FromEnv with the real environment
+func FromEnv() (Config, error) {
+	return Loader{}.FromEnv()
+}
+
+// load applies the file, the environment and the flags to the defaults, in the order of precedence.
+// Only the sections are validated, the others keep the values of the layers but the binary doesn't use them
+func (l Loader) load(path string, sections []Section, applyFlags func(c *Config) error) (Config, error) {
+	c := Default()
+	if path == "" {
+		path, _ = l.LookupEnv(EnvPrefix + "CONFIG")
+	}
+	if path != "" {
+		if err := applyFile(&c, path); err != nil {
+			return Config{}, err
+		}
+	}
+	for _, s := range settings {
+		value, ok := l.LookupEnv(s.envName())
+		if !ok {
+			continue
+		}
+		values := []string{value}
+		if s.list {
+			values = splitList(value)
+		}
+		if err := s.set(&c, values); err != nil {
+			return Config{}, fmt.Errorf("%s: %w", s.envName(), err)
+		}
+	}
+	if applyFlags != nil {
+		if err := applyFlags(&c); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := readSecretFiles(&c); err != nil {
+		return Config{}, err
+	}
+	return c, c.Validate(sections...)
+}
+
+func hasSection(sections []Section, s Section) bool {
+	for _, section := range sections {
+		if section == s {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma separated list, an empty string is an empty list
+func splitList(s string) []string {
+	values := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// applyFile sets the settings of a YAML or TOML file. Unknown settings are rejected, they are likely typos
+func applyFile(c *Config, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	raw := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		_, err = toml.Decode(string(data), &raw)
+	default:
+		return fmt.Errorf("%s: unknown format, expected .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	values := map[string]interface{}{}
+	flatten("", raw, values)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	// sorted, so the same file fails with the same error
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := applyFileValue(c, key, values[key]); err != nil {
+			return fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+	}
+	return nil
+}
+
+// flatten puts the values of the nested maps to flat with dotted keys
+func flatten(prefix string, nested map[string]interface{}, flat map[string]interface{}) {
+	for key, value := range nested {
+		if m, ok := value.(map[string]interface{}); ok {
+			flatten(prefix+key+".", m, flat)
+			continue
+		}
+		flat[prefix+key] = value
+	}
+}
+
+func applyFileValue(c *Config, key string, value interface{}) error {
+	for _, s := range settings {
+		if s.key != key {
+			continue
+		}
+		var values []string
+		switch v := value.(type) {
+		case nil:
+			values = []string{""}
+			if s.list {
+				values = []string{}
+			}
+		case []interface{}:
+			if !s.list {
+				return fmt.Errorf("expected a single value")
+			}
+			for _, item := range v {
+				values = append(values, fmt.Sprint(item))
+			}
+		default:
+			values = []string{fmt.Sprint(v)}
+			if s.list {
+				values = splitList(values[0])
+			}
+		}
+		return s.set(c, values)
+	}
+	return fmt.Errorf("unknown setting")
+}
+
+// readSecretFiles replaces the secrets with the contents of their files. A trailing newline is dropped, editors add it
+func readSecretFiles(c *Config) error {
+	for _, secret := range []struct {
+		key   string
+		path  string
+		value *string
+	}{
+		{"postgres.conn_str_file", c.Postgres.ConnStrFile, &c.Postgres.ConnStr},
+		{"jwt.secret_file", c.JWT.SecretFile, &c.JWT.Secret},
+		{"mfa.encryption_key_file", c.MFA.EncryptionKeyFile, &c.MFA.EncryptionKey},
+	} {
+		if secret.path == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(secret.path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", secret.key, err)
+		}
+		*secret.value = strings.TrimRight(string(data), "\r\n")
+	}
+	return nil
+}
+
+// flagValue collects the values of a flag, they are applied after the file and the environment
+type flagValue struct {
+	list     bool
+	isBool   bool
+	defValue string
+	values   []string
+	isSet    bool
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	if !f.isSet {
+		return f.defValue
+	}
+	return strings.Join(f.values, ",")
+}
+
+func (f *flagValue) Set(value string) error {
+	if f.list {
+		f.values = append(f.values, splitList(value)...)
+	} else {
+		f.values = []string{value}
+	}
+	f.isSet = true
+	return nil
+}
+
+// IsBoolFlag lets the bool settings be set without a value, see flag.Value
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}